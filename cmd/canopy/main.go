@@ -6,8 +6,11 @@ import (
 	"github.com/nhomble/canopy/internal/cli"
 )
 
+// version is set at build time via -ldflags "-X main.version=...".
+var version = "dev"
+
 func main() {
-	if err := cli.Execute(); err != nil {
+	if err := cli.Execute(version); err != nil {
 		os.Exit(1)
 	}
 }
@@ -1,10 +1,16 @@
 package canopydir
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/nhomble/canopy/internal/schema"
 )
@@ -94,6 +100,20 @@ func (a *CanopyDir) IndexPath() string {
 	return filepath.Join(a.Root, "index.json")
 }
 
+func (a *CanopyDir) OverlayPath() string {
+	return filepath.Join(a.Root, "overlay.json")
+}
+
+func (a *CanopyDir) HistoryDir() string {
+	return filepath.Join(a.Root, "history")
+}
+
+// TokenPath is the access token canopy serve requires for edits and
+// event streams. It is never committed; see LoadOrCreateToken.
+func (a *CanopyDir) TokenPath() string {
+	return filepath.Join(a.Root, "token")
+}
+
 func (a *CanopyDir) PromptPath(name string) string {
 	return filepath.Join(a.Root, "prompts", name)
 }
@@ -102,15 +122,74 @@ func (a *CanopyDir) ComponentPath(id string) string {
 	return filepath.Join(a.Root, "components", id+".json")
 }
 
-// LoadConfig reads and parses the config.json file.
+// RepoRoot returns the directory containing .canopy/.
+func (a *CanopyDir) RepoRoot() string {
+	return filepath.Dir(a.Root)
+}
+
+// LoadIndex reads index.json with overlay.json merged onto it.
+func (a *CanopyDir) LoadIndex() (*schema.ArchIndex, []schema.OverlayResult, error) {
+	return schema.LoadIndexWithOverlay(a.IndexPath(), a.OverlayPath())
+}
+
+// LoadConfig reads and parses the config.json file. Settings missing from
+// the file keep their defaults, so older configs pick up new options.
 func (a *CanopyDir) LoadConfig() (*schema.Config, error) {
 	data, err := os.ReadFile(a.ConfigPath())
 	if err != nil {
 		return nil, fmt.Errorf("reading config: %w", err)
 	}
-	var cfg schema.Config
+	cfg := schema.DefaultConfig(filepath.Base(a.RepoRoot()))
 	if err := json.Unmarshal(data, &cfg); err != nil {
 		return nil, fmt.Errorf("parsing config: %w", err)
 	}
 	return &cfg, nil
 }
+
+// LoadOrCreateToken returns the access token in TokenPath, generating a
+// random one on first use. The file is readable only by its owner and is
+// listed in .canopy/.gitignore so it is not committed with the index.
+func (a *CanopyDir) LoadOrCreateToken() (string, error) {
+	data, err := os.ReadFile(a.TokenPath())
+	if err == nil {
+		if token := strings.TrimSpace(string(data)); token != "" {
+			return token, nil
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("reading token: %w", err)
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generating token: %w", err)
+	}
+	token := hex.EncodeToString(buf)
+	if err := os.WriteFile(a.TokenPath(), []byte(token+"\n"), 0o600); err != nil {
+		return "", fmt.Errorf("writing token: %w", err)
+	}
+	if err := a.ignore("token"); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ignore adds a file to .canopy/.gitignore unless it is listed already.
+func (a *CanopyDir) ignore(name string) error {
+	path := filepath.Join(a.Root, ".gitignore")
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("reading .gitignore: %w", err)
+	}
+	lines := strings.Split(string(data), "\n")
+	if slices.Contains(lines, name) || slices.Contains(lines, "/"+name) {
+		return nil
+	}
+	if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+		data = append(data, '\n')
+	}
+	data = append(data, name+"\n"...)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("writing .gitignore: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/nhomble/canopy/internal/canopydir"
+	"github.com/nhomble/canopy/internal/diff"
+	"github.com/nhomble/canopy/internal/schema"
+	"github.com/spf13/cobra"
+)
+
+var diffFormat string
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <old.json> [new.json]",
+	Short: "Show architectural changes between two index versions",
+	Long: `Diff compares two index.json files and reports components added,
+removed and renamed (matched by code_ref overlap), archetypes that moved
+between components, layer changes, and relationships and flows added or
+removed. When new.json is omitted, the current .canopy/index.json is used.
+
+Use --format markdown to paste the result into a PR description.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		before, err := schema.LoadIndex(args[0])
+		if err != nil {
+			return fmt.Errorf("loading %s: %w", args[0], err)
+		}
+
+		afterPath := ""
+		if len(args) > 1 {
+			afterPath = args[1]
+		} else {
+			ad, err := canopydir.Find(".")
+			if err != nil {
+				return err
+			}
+			afterPath = ad.IndexPath()
+		}
+		after, err := schema.LoadIndex(afterPath)
+		if err != nil {
+			return fmt.Errorf("loading %s: %w", afterPath, err)
+		}
+
+		result := diff.Compare(before, after)
+
+		switch diffFormat {
+		case "text":
+			fmt.Print(result.FormatText())
+		case "markdown":
+			fmt.Print(result.FormatMarkdown())
+		case "json":
+			out, err := result.FormatJSON()
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+		default:
+			return fmt.Errorf("unknown format %q (want text, json, or markdown)", diffFormat)
+		}
+		return nil
+	},
+}
+
+func init() {
+	diffCmd.Flags().StringVar(&diffFormat, "format", "text", "output format: text, json, or markdown")
+	rootCmd.AddCommand(diffCmd)
+}
@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nhomble/canopy/internal/docgen"
+	"github.com/spf13/cobra"
+)
+
+var (
+	docsOut       string
+	docsTemplates string
+)
+
+var docsCmd = &cobra.Command{
+	Use:   "docs",
+	Short: "Generate a Markdown architecture site",
+	Long: `Docs renders .canopy/index.json, with the overlay applied, as a
+Markdown site:
+
+  index.md          patterns, layers, component diagram and flows
+  components/*.md   code refs, archetypes, upstream and downstream
+                    dependencies, and the flows through each component
+  flows/*.md        a sequence diagram and step table per flow
+  entry-points.md   every route and topic with its handler
+
+Diagrams are Mermaid code blocks, which GitHub and most doc sites render.
+Output is deterministic, so the site can be committed and reviewed; pages
+for components and flows that no longer exist are removed.
+
+Pages come from built-in templates (index.md.tmpl, component.md.tmpl,
+flow.md.tmpl, entry-points.md.tmpl). --templates points at a directory
+whose templates replace the built-in ones of the same name. Pages are
+named after component and flow IDs, escaped to safe file names; link to
+them with {{ slug .ID }}.md.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ad, idx, err := loadIndex()
+		if err != nil {
+			return err
+		}
+
+		out := docsOut
+		if out == "" {
+			out = filepath.Join(ad.RepoRoot(), "docs", "architecture")
+		}
+		written, err := docgen.Generate(idx, out, docsTemplates)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Wrote %d pages to %s\n", len(written), out)
+		return nil
+	},
+}
+
+func init() {
+	docsCmd.Flags().StringVarP(&docsOut, "out", "o", "", "output directory (default <repo>/docs/architecture)")
+	docsCmd.Flags().StringVar(&docsTemplates, "templates", "", "directory of templates overriding the built-in ones")
+	rootCmd.AddCommand(docsCmd)
+}
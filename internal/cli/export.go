@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nhomble/canopy/internal/export"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportFormat string
+	exportOpts   export.Options
+	exportOut    string
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Render the architecture index in another format",
+	Long: `Export renders .canopy/index.json, with the overlay applied, for other
+tools. structurizr writes a C4 workspace in Structurizr DSL: projects
+(app-layer components, or directories with their own build manifest)
+become containers, other components become C4 components, archetype
+target services become external systems, and each flow a dynamic view.
+
+backstage writes catalog-info entities: a Component per component and an
+API per controller with routes or provided interface, linked by dependsOn,
+providesApis and consumesApis. Owners come from the "backstage" section of
+.canopy/config.json ("owners" per component ID, else CODEOWNERS, else
+"owner"). With --out, each component gets its own file.
+
+html writes the web UI as a single file that opens without canopy serve:
+the graph is inlined along with the JavaScript libraries, so it works
+offline and can be attached to a review. Filters narrow the inlined graph.
+
+Diagram formats (mermaid, plantuml, dot) draw one of three views:
+
+  components  components grouped by layer, with aggregated dependencies
+  archetypes  archetypes inside their components, with relationships
+  flows       one sequence diagram per flow (a step graph for dot)
+
+Filters narrow the output: --layer and --component select components,
+--flow selects flows and the elements they pass through. Each filter may
+be repeated.
+
+Output goes to stdout, or with --out to one file per document in a
+directory.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ad, idx, err := loadIndex()
+		if err != nil {
+			return err
+		}
+
+		cfg, err := ad.LoadConfig()
+		if err != nil {
+			return err
+		}
+		exportOpts.RepoRoot = ad.RepoRoot()
+		exportOpts.Config = *cfg
+		docs, err := export.Export(idx, exportFormat, exportOpts)
+		if err != nil {
+			return err
+		}
+		if len(docs) == 0 {
+			return fmt.Errorf("nothing to export: no flows match the filters")
+		}
+
+		for _, doc := range docs {
+			for _, warning := range doc.Warnings {
+				fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", doc.Name, warning)
+			}
+		}
+
+		if exportOut == "" {
+			fmt.Print(export.Join(exportFormat, docs))
+			return nil
+		}
+		if err := os.MkdirAll(exportOut, 0755); err != nil {
+			return fmt.Errorf("creating %s: %w", exportOut, err)
+		}
+		for _, doc := range docs {
+			path := filepath.Join(exportOut, doc.Name+export.Extension(exportFormat))
+			if err := os.WriteFile(path, []byte(doc.Content), 0644); err != nil {
+				return fmt.Errorf("writing %s: %w", path, err)
+			}
+			fmt.Fprintf(os.Stderr, "Wrote %s\n", path)
+		}
+		return nil
+	},
+}
+
+func init() {
+	exportCmd.Flags().StringVar(&exportFormat, "format", "mermaid", "output format: "+strings.Join(export.Formats(), ", "))
+	exportCmd.Flags().StringVar(&exportOpts.View, "view", export.ViewComponents, "diagram view: components, archetypes, or flows")
+	exportCmd.Flags().StringSliceVar(&exportOpts.Layers, "layer", nil, "only include components in this layer")
+	exportCmd.Flags().StringSliceVar(&exportOpts.Components, "component", nil, "only include this component")
+	exportCmd.Flags().StringSliceVar(&exportOpts.Flows, "flow", nil, "only include this flow and the elements it passes through")
+	exportCmd.Flags().StringVarP(&exportOut, "out", "o", "", "write one file per document into this directory")
+	rootCmd.AddCommand(exportCmd)
+}
@@ -0,0 +1,154 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nhomble/canopy/internal/canopydir"
+	"github.com/nhomble/canopy/internal/diff"
+	"github.com/nhomble/canopy/internal/history"
+	"github.com/nhomble/canopy/internal/schema"
+	"github.com/spf13/cobra"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List, inspect, and restore index.json snapshots",
+	Long: `Every import, and every edit made through canopy serve, snapshots
+index.json into .canopy/history/. Use these commands to inspect past
+versions and roll back a bad analysis run.
+
+The retention policy is set in .canopy/config.json. Edit snapshots are
+counted separately, so edits never push out the snapshots of imports:
+
+  "history": {"max_snapshots": 20, "max_edit_snapshots": 50, "max_age_days": 90}`,
+}
+
+var historyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List snapshots, newest first",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		_, store, err := openHistory()
+		if err != nil {
+			return err
+		}
+
+		metas, err := store.List()
+		if err != nil {
+			return err
+		}
+		if len(metas) == 0 {
+			fmt.Fprintln(os.Stderr, "No snapshots yet.")
+			return nil
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tCREATED\tSOURCE\tCOMMIT\tCOMPONENTS\tARCHETYPES\tRELATIONSHIPS\tFLOWS")
+		for _, m := range metas {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\n",
+				m.ID, m.CreatedAt.Local().Format(time.DateTime), m.Source, shortCommit(m.GitCommit),
+				m.Components, m.Archetypes, m.Relationships, m.Flows)
+		}
+		return tw.Flush()
+	},
+}
+
+var historyShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "Show a snapshot's metadata and how it differs from the current index",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ad, store, err := openHistory()
+		if err != nil {
+			return err
+		}
+
+		meta, err := store.Get(args[0])
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("ID:             %s\n", meta.ID)
+		fmt.Printf("Created:        %s\n", meta.CreatedAt.Local().Format(time.DateTime))
+		fmt.Printf("Source:         %s\n", meta.Source)
+		if meta.GitCommit != "" {
+			fmt.Printf("Git commit:     %s\n", meta.GitCommit)
+		}
+		fmt.Printf("Schema version: %d\n", meta.SchemaVersion)
+		fmt.Printf("Components:     %d\n", meta.Components)
+		fmt.Printf("Archetypes:     %d\n", meta.Archetypes)
+		fmt.Printf("Relationships:  %d\n", meta.Relationships)
+		fmt.Printf("Flows:          %d\n", meta.Flows)
+
+		snap, err := schema.LoadIndex(store.IndexPath(meta.ID))
+		if err != nil {
+			return err
+		}
+		current, err := schema.LoadIndex(ad.IndexPath())
+		if errors.Is(err, os.ErrNotExist) {
+			return nil // nothing to compare against
+		}
+		if err != nil {
+			return fmt.Errorf("loading current index: %w", err)
+		}
+		fmt.Println("\nChanges from this snapshot to the current index:")
+		fmt.Print(diff.Compare(snap, current).FormatText())
+		return nil
+	},
+}
+
+var historyRestoreCmd = &cobra.Command{
+	Use:   "restore <id>",
+	Short: "Replace index.json with a snapshot (the current index is snapshotted first)",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ad, store, err := openHistory()
+		if err != nil {
+			return err
+		}
+
+		if err := store.Restore(args[0], ad.IndexPath(), history.GitCommit(ad.RepoRoot())); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Restored %s from snapshot %s\n", ad.IndexPath(), args[0])
+		return nil
+	},
+}
+
+// openHistory finds the .canopy directory and its snapshot store.
+func openHistory() (*canopydir.CanopyDir, *history.Store, error) {
+	ad, err := canopydir.Find(".")
+	if err != nil {
+		return nil, nil, err
+	}
+	store, err := historyStore(ad)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ad, store, nil
+}
+
+// historyStore returns the snapshot store configured by config.json.
+func historyStore(ad *canopydir.CanopyDir) (*history.Store, error) {
+	cfg, err := ad.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return history.NewStore(ad.HistoryDir(), history.ConfigRetention(cfg.History)), nil
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
+
+func init() {
+	historyCmd.AddCommand(historyListCmd, historyShowCmd, historyRestoreCmd)
+	rootCmd.AddCommand(historyCmd)
+}
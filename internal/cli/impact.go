@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/nhomble/canopy/internal/server"
+	"github.com/spf13/cobra"
+)
+
+var (
+	impactIDs    []string
+	impactDepth  int
+	impactFormat string
+)
+
+var impactCmd = &cobra.Command{
+	Use:   "impact [file]...",
+	Short: "Show what could break when files or elements change",
+	Long: `Impact walks relationships upstream from changed files and lists
+everything that depends on them: the dependent archetypes, their
+components, the flows they take part in, and the entry points (routes,
+consumed topics) whose requests eventually reach the change.
+
+A file that is an archetype changes that archetype; any other file in a
+component changes the whole component. Paths are taken relative to the
+current directory, so a diff can be piped in from the repository root:
+
+  git diff --name-only main | xargs canopy impact
+
+Use --id to start from components or archetypes instead of files, and
+--depth to stop after that many relationships (0 = no limit).
+
+It reads the index directly and does not need a running server; the
+server offers the same analysis at GET /impact?file=|id=.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 && len(impactIDs) == 0 {
+			return fmt.Errorf("give changed files or --id")
+		}
+		if len(args) > 0 && len(impactIDs) > 0 {
+			return fmt.Errorf("give either changed files or --id, not both")
+		}
+		if impactDepth < 0 {
+			return fmt.Errorf("--depth must not be negative")
+		}
+		ad, idx, err := loadIndex()
+		if err != nil {
+			return err
+		}
+
+		var res *server.ImpactResult
+		if len(impactIDs) > 0 {
+			if res, err = idx.Impact(impactIDs, impactDepth); err != nil {
+				return err
+			}
+		} else {
+			files := make([]string, len(args))
+			for i, arg := range args {
+				files[i] = repoRelative(ad.RepoRoot(), arg)
+			}
+			res = idx.ImpactOfFiles(files, impactDepth)
+		}
+
+		switch impactFormat {
+		case "json":
+			return writeJSON(res)
+		case "text":
+		default:
+			return fmt.Errorf("unknown format %q (want text or json)", impactFormat)
+		}
+		printImpact(res)
+		return nil
+	},
+}
+
+// repoRelative turns a path given on the command line into the
+// repository-relative form the index uses.
+func repoRelative(repoRoot, file string) string {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return file
+	}
+	rel, err := filepath.Rel(repoRoot, abs)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return file
+	}
+	return filepath.ToSlash(rel)
+}
+
+func printImpact(res *server.ImpactResult) {
+	for _, f := range res.Unmatched {
+		fmt.Fprintf(os.Stderr, "Warning: %s is in no component\n", f)
+	}
+	if len(res.Changed) == 0 {
+		fmt.Fprintln(os.Stderr, "No changed elements.")
+		return
+	}
+
+	fmt.Println("Changed:")
+	for _, c := range res.Changed {
+		line := fmt.Sprintf("  %s %s", c.Kind, c.ID)
+		if c.File != "" {
+			line += " (" + c.File + ")"
+		}
+		fmt.Println(line)
+	}
+
+	fmt.Printf("\nDependents (%d):\n", len(res.Dependents))
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, d := range res.Dependents {
+		comp := d.Component
+		if comp == "" {
+			comp = "-"
+		}
+		fmt.Fprintf(tw, "  %d\t%s\t%s\t--%s--> %s\n", d.Depth, d.ID, comp, d.Type, d.Via)
+	}
+	tw.Flush()
+
+	fmt.Printf("\nEntry points (%d):\n", len(res.EntryPoints))
+	for _, e := range res.EntryPoints {
+		reaches := append(append([]string{}, e.Routes...), e.Topics...)
+		if len(reaches) == 0 && e.Type != "" {
+			reaches = []string{e.Type}
+		}
+		fmt.Printf("  %s [%s] %s\n", handlerLabel(e.Handler), e.Component, strings.Join(reaches, ", "))
+	}
+
+	fmt.Printf("\nComponents: %s\n", strings.Join(res.Components, ", "))
+	flows := make([]string, len(res.Flows))
+	for i, f := range res.Flows {
+		flows[i] = f.ID
+	}
+	fmt.Printf("Flows: %s\n", strings.Join(flows, ", "))
+	if res.Truncated {
+		fmt.Println("\nStopped at --depth; more dependents exist.")
+	}
+}
+
+func init() {
+	impactCmd.Flags().StringSliceVar(&impactIDs, "id", nil, "start from this component or archetype ID instead of files")
+	impactCmd.Flags().IntVar(&impactDepth, "depth", 0, "maximum relationships to follow upstream (0 = no limit)")
+	impactCmd.Flags().StringVar(&impactFormat, "format", "text", "output format: text or json")
+	rootCmd.AddCommand(impactCmd)
+}
@@ -1,12 +1,12 @@
 package cli
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 
 	"github.com/nhomble/canopy/internal/canopydir"
+	"github.com/nhomble/canopy/internal/history"
 	"github.com/nhomble/canopy/internal/schema"
 	"github.com/spf13/cobra"
 )
@@ -21,7 +21,9 @@ the expected schema, and saves it to .canopy/index.json.
 
 The input can be a file path or piped via stdin. The tool handles
 messy LLM output: markdown code fences, surrounding commentary,
-and trailing commas are automatically cleaned up.`,
+and trailing commas are automatically cleaned up.
+
+Every import is snapshotted into .canopy/history/ (see 'canopy history').`,
 	Args: cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Read input
@@ -51,13 +53,13 @@ and trailing commas are automatically cleaned up.`,
 		}
 
 		// Parse into ArchIndex
-		var idx schema.ArchIndex
-		if err := json.Unmarshal(jsonData, &idx); err != nil {
+		idx, err := schema.ParseIndex(jsonData)
+		if err != nil {
 			return fmt.Errorf("parsing JSON: %w", err)
 		}
 
 		// Validate
-		result := schema.ValidateIndex(&idx)
+		result := schema.ValidateIndex(idx)
 		if !result.Valid {
 			fmt.Fprint(os.Stderr, result.FormatResult())
 			return fmt.Errorf("validation failed with %d errors", len(result.Errors))
@@ -77,17 +79,40 @@ and trailing commas are automatically cleaned up.`,
 
 		// Check if index already exists
 		indexPath := ad.IndexPath()
-		if !importForce {
-			if _, err := os.Stat(indexPath); err == nil {
-				return fmt.Errorf("index already exists at %s (use --force to overwrite)", indexPath)
+		_, statErr := os.Stat(indexPath)
+		exists := statErr == nil
+		if exists && !importForce {
+			return fmt.Errorf("index already exists at %s (use --force to overwrite)", indexPath)
+		}
+
+		store, err := historyStore(ad)
+		if err != nil {
+			return err
+		}
+		commit := history.GitCommit(ad.RepoRoot())
+
+		// Keep the index being replaced, in case it was curated by hand
+		// since the last import.
+		if exists {
+			if _, err := store.Snapshot(indexPath, "pre-import", commit); err != nil {
+				return fmt.Errorf("snapshotting existing index: %w", err)
 			}
 		}
 
 		// Save
-		if err := schema.SaveIndex(indexPath, &idx); err != nil {
+		if err := schema.SaveIndex(indexPath, idx); err != nil {
 			return err
 		}
 
+		source := "stdin"
+		if len(args) > 0 {
+			source = args[0]
+		}
+		snap, err := store.Snapshot(indexPath, source, commit)
+		if err != nil {
+			return fmt.Errorf("snapshotting index: %w", err)
+		}
+
 		// Print summary
 		archetypeCount := 0
 		for _, a := range idx.Archetypes {
@@ -98,6 +123,7 @@ and trailing commas are automatically cleaned up.`,
 		fmt.Fprintf(os.Stderr, "  Archetypes:    %d\n", archetypeCount)
 		fmt.Fprintf(os.Stderr, "  Relationships: %d\n", len(idx.Relationships))
 		fmt.Fprintf(os.Stderr, "  Flows:         %d\n", len(idx.Flows))
+		fmt.Fprintf(os.Stderr, "  Snapshot:      %s\n", snap.ID)
 
 		return nil
 	},
@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/nhomble/canopy/internal/server"
+	"github.com/spf13/cobra"
+)
+
+var (
+	metricsLayers []string
+	metricsFormat string
+	metricsStrict bool
+)
+
+var metricsCmd = &cobra.Command{
+	Use:   "metrics",
+	Short: "Report coupling, instability, cycles and layer violations",
+	Long: `Metrics computes architecture metrics over the component dependency
+graph in .canopy/index.json, with the overlay applied:
+
+  fan-in (Ca)    components that depend on this one
+  fan-out (Ce)   components this one depends on
+  instability    Ce / (Ca + Ce), from 0 (stable) to 1 (unstable)
+  abstractness   share of abstract archetypes (the provided interface and
+                 ports), for components that declare a provided interface
+  distance       |abstractness + instability - 1|, distance from the
+                 main sequence
+
+It also lists dependency cycles, found as strongly connected components,
+and layer violations: dependencies from an inner layer to an outer one.
+--layers gives the layer order, innermost first. It defaults to the
+layers of the index's patterns, as their definitions list them, or to
+` + strings.Join(server.DefaultLayerOrder, ",") + ` when no pattern defines
+layers. Layers not listed are never in violation.
+
+With --strict, metrics exits non-zero when it finds a cycle or a layer
+violation, for use in CI. The server offers the same report at
+GET /metrics/architecture, and the web UI colors components by any metric.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		_, idx, err := loadIndex()
+		if err != nil {
+			return err
+		}
+		m := idx.ArchitectureMetrics(metricsLayers)
+
+		switch metricsFormat {
+		case "json":
+			if err := writeJSON(m); err != nil {
+				return err
+			}
+		case "text":
+			printMetrics(m)
+		default:
+			return fmt.Errorf("unknown format %q (want text or json)", metricsFormat)
+		}
+
+		if metricsStrict && (len(m.Cycles) > 0 || len(m.LayerViolations) > 0) {
+			return fmt.Errorf("%d dependency cycles, %d layer violations", len(m.Cycles), len(m.LayerViolations))
+		}
+		return nil
+	},
+}
+
+func printMetrics(m *server.ArchitectureMetrics) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "COMPONENT\tLAYER\tFAN-IN\tFAN-OUT\tINSTABILITY\tABSTRACTNESS\tDISTANCE\tVIOLATIONS\tCYCLE")
+	for _, c := range m.Components {
+		cycle := "-"
+		if c.Cycle > 0 {
+			cycle = fmt.Sprintf("#%d", c.Cycle)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\t%s\t%d\t%s\n", c.ID, c.Layer, c.FanIn, c.FanOut,
+			formatMetric(c.Instability), formatMetric(c.Abstractness), formatMetric(c.Distance), c.LayerViolations, cycle)
+	}
+	tw.Flush()
+
+	fmt.Printf("\nDependency cycles: %d\n", len(m.Cycles))
+	for i, cycle := range m.Cycles {
+		fmt.Printf("  #%d %s\n", i+1, strings.Join(cycle.Components, ", "))
+		for _, e := range cycle.Edges {
+			fmt.Printf("       %s -> %s (%s)\n", e.From, e.To, strings.Join(e.Types, ", "))
+		}
+	}
+
+	fmt.Printf("\nLayer violations: %d\n", len(m.LayerViolations))
+	for _, v := range m.LayerViolations {
+		fmt.Printf("  %s (%s) -> %s (%s), %d relationships\n", v.From, v.FromLayer, v.To, v.ToLayer, v.Count)
+	}
+}
+
+func formatMetric(v *float64) string {
+	if v == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f", *v)
+}
+
+func init() {
+	metricsCmd.Flags().StringSliceVar(&metricsLayers, "layers", nil, "layer order, innermost first (default: the layers of the index's patterns)")
+	metricsCmd.Flags().StringVar(&metricsFormat, "format", "text", "output format: text or json")
+	metricsCmd.Flags().BoolVar(&metricsStrict, "strict", false, "exit non-zero on dependency cycles or layer violations")
+	rootCmd.AddCommand(metricsCmd)
+}
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/nhomble/canopy/internal/canopydir"
+	"github.com/nhomble/canopy/internal/schema"
+	"github.com/spf13/cobra"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade .canopy/index.json to the current schema version in place",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ad, err := canopydir.Find(".")
+		if err != nil {
+			return err
+		}
+
+		indexPath := ad.IndexPath()
+		data, err := os.ReadFile(indexPath)
+		if err != nil {
+			return fmt.Errorf("reading index: %w", err)
+		}
+
+		migrated, from, err := schema.Migrate(data)
+		if err != nil {
+			return err
+		}
+		if from == schema.CurrentSchemaVersion {
+			fmt.Fprintf(os.Stderr, "%s is already at schema version %d\n", indexPath, from)
+			return nil
+		}
+
+		idx, err := schema.ParseIndex(migrated)
+		if err != nil {
+			return err
+		}
+		if err := schema.SaveIndex(indexPath, idx); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Migrated %s from schema version %d to %d\n",
+			indexPath, from, schema.CurrentSchemaVersion)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+}
@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/nhomble/canopy/internal/canopydir"
+	"github.com/spf13/cobra"
+)
+
+var overlayCmd = &cobra.Command{
+	Use:   "overlay",
+	Short: "Show how .canopy/overlay.json attaches to the current index",
+	Long: `The overlay holds hand-written corrections and annotations that survive
+re-analysis. Entries are keyed by element ID:
+
+  {
+    "elements": {
+      "customer-service": {
+        "match": {"code_refs": ["Customer/**"]},
+        "override": {"layer": "core"},
+        "annotations": {"owner": "team-crm", "wiki": "https://wiki/crm"}
+      },
+      "legacy-adapter": {"match": {"file": "src/legacy.go"}, "suppress": true}
+    }
+  }
+
+When no element has the entry's ID (for example after a re-import renamed
+it), components are matched by code_refs overlap and archetypes by file.
+This command reports where each entry landed, so orphaned annotations can
+be fixed.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ad, err := canopydir.Find(".")
+		if err != nil {
+			return err
+		}
+
+		_, results, err := ad.LoadIndex()
+		if err != nil {
+			return err
+		}
+		if len(results) == 0 {
+			fmt.Fprintf(os.Stderr, "No overlay entries in %s\n", ad.OverlayPath())
+			return nil
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "KEY\tKIND\tTARGET\tMATCHED BY\tWARNING")
+		orphans := 0
+		for _, res := range results {
+			if res.Target == "" {
+				orphans++
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", res.Key, res.Kind, res.Target, res.MatchedBy, res.Warning)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+
+		if orphans > 0 {
+			return fmt.Errorf("%d overlay entries match no element", orphans)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(overlayCmd)
+}
@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var ownersCmd = &cobra.Command{
+	Use:   "owners [component|file]",
+	Short: "Show CODEOWNERS ownership of components or files",
+	Long: `Resolves the repository's CODEOWNERS file (.github/CODEOWNERS, CODEOWNERS
+or docs/CODEOWNERS) against the index.
+
+With no argument, every component is listed with its owners and components
+whose files span several teams are flagged. An argument is treated as a
+component ID when one exists, otherwise as a repo-relative file path.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ad, idx, err := loadIndex()
+		if err != nil {
+			return err
+		}
+		if !idx.HasOwners() {
+			return fmt.Errorf("no CODEOWNERS file found in %s", ad.RepoRoot())
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		switch {
+		case len(args) == 0:
+			fmt.Fprintln(tw, "COMPONENT\tOWNERS\tMULTI-TEAM")
+			for _, comp := range idx.Raw.Components {
+				o := idx.Ownership(comp.ID)
+				multi := ""
+				if o.MultiTeam {
+					multi = "yes"
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\n", comp.ID, formatOwners(o.Owners), multi)
+			}
+		case idx.Ownership(args[0]) != nil:
+			o := idx.Ownership(args[0])
+			fmt.Fprintf(tw, "Component:\t%s\n", args[0])
+			fmt.Fprintf(tw, "Owners:\t%s\n", formatOwners(o.Owners))
+			if o.MultiTeam {
+				fmt.Fprintln(tw, "Multi-team:\tyes")
+				files := make([]string, 0, len(o.Files))
+				for file := range o.Files {
+					files = append(files, file)
+				}
+				sort.Strings(files)
+				for _, file := range files {
+					fmt.Fprintf(tw, "  %s\t%s\n", file, formatOwners(o.Files[file]))
+				}
+			}
+		default:
+			file := filepath.ToSlash(args[0])
+			fmt.Fprintf(tw, "File:\t%s\n", file)
+			fmt.Fprintf(tw, "Owners:\t%s\n", formatOwners(idx.FileOwners(file)))
+			if comp := idx.FindComponent(file); comp != nil {
+				fmt.Fprintf(tw, "Component:\t%s (%s)\n", comp.ID, formatOwners(idx.Ownership(comp.ID).Owners))
+			}
+		}
+		return tw.Flush()
+	},
+}
+
+func formatOwners(owners []string) string {
+	if len(owners) == 0 {
+		return "(unowned)"
+	}
+	return strings.Join(owners, " ")
+}
+
+func init() {
+	rootCmd.AddCommand(ownersCmd)
+}
@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nhomble/canopy/internal/server"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pathLimit  int
+	pathDepth  int
+	pathFormat string
+)
+
+var pathCmd = &cobra.Command{
+	Use:   "path <from> <to>",
+	Short: "Show how one component or archetype depends on another",
+	Long: `Path follows relationships from one element to another and prints the
+shortest dependency path, then every simple path of at most --depth
+relationships, shortest first, up to --limit of them:
+
+  canopy path order-service customer-service
+
+Either end may be a component or an archetype ID. A component stands for
+its archetypes: paths start at any of them and stop at the first archetype
+of the target component they reach.
+
+It reads the index directly and does not need a running server; the
+server offers the same query at GET /path?from=&to=, and the web UI
+highlights it.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if pathLimit < 1 || pathDepth < 1 {
+			return fmt.Errorf("--limit and --depth must be at least 1")
+		}
+		_, idx, err := loadIndex()
+		if err != nil {
+			return err
+		}
+		res, err := idx.Paths(args[0], args[1], pathLimit, pathDepth)
+		if err != nil {
+			return err
+		}
+
+		switch pathFormat {
+		case "json":
+			return writeJSON(res)
+		case "text":
+		default:
+			return fmt.Errorf("unknown format %q (want text or json)", pathFormat)
+		}
+
+		if res.Shortest == nil {
+			fmt.Fprintf(os.Stderr, "%s does not depend on %s.\n", args[0], args[1])
+			return nil
+		}
+		fmt.Printf("Shortest (%d hops):\n", len(res.Shortest.Relationships))
+		printDependencyPath(*res.Shortest)
+		if len(res.Paths) > 0 {
+			fmt.Printf("\nAll paths up to %d hops (%d", pathDepth, len(res.Paths))
+			if res.Truncated {
+				fmt.Printf(", limited to --limit")
+			}
+			fmt.Println("):")
+			for i, p := range res.Paths {
+				fmt.Printf("%3d. %s\n", i+1, pathSummary(p))
+			}
+		}
+		return nil
+	},
+}
+
+// printDependencyPath prints one node per line with the relationship and
+// any component boundary crossed to reach it.
+func printDependencyPath(p server.DependencyPath) {
+	for i, node := range p.Nodes {
+		comp := p.Components[i]
+		if comp == "" {
+			comp = "-"
+		}
+		if i == 0 {
+			fmt.Printf("  %s [%s]\n", node, comp)
+			continue
+		}
+		rel := p.Relationships[i-1]
+		label := rel.Type
+		if rel.Protocol != "" {
+			label += ", " + rel.Protocol
+		}
+		fmt.Printf("    --%s--> %s [%s]\n", label, node, comp)
+	}
+}
+
+// pathSummary renders a path on one line, naming each component once as
+// the path enters it.
+func pathSummary(p server.DependencyPath) string {
+	parts := make([]string, len(p.Nodes))
+	for i, node := range p.Nodes {
+		parts[i] = node
+		if comp := p.Components[i]; comp != "" && comp != node && (i == 0 || p.Components[i-1] != comp) {
+			parts[i] = comp + ":" + node
+		}
+	}
+	return strings.Join(parts, " -> ")
+}
+
+func init() {
+	pathCmd.Flags().IntVarP(&pathLimit, "limit", "n", server.DefaultPathLimit, "maximum number of paths to list")
+	pathCmd.Flags().IntVar(&pathDepth, "depth", server.DefaultPathDepth, "maximum relationships per path")
+	pathCmd.Flags().StringVar(&pathFormat, "format", "text", "output format: text or json")
+	rootCmd.AddCommand(pathCmd)
+}
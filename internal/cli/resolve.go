@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/nhomble/canopy/internal/server"
+	"github.com/spf13/cobra"
+)
+
+var (
+	routeFormat string
+	topicFormat string
+)
+
+var routeCmd = &cobra.Command{
+	Use:   "route [METHOD] <path>",
+	Short: "Find the handler for an HTTP request",
+	Long: `Route matches a concrete request path against the routes declared on
+archetypes and prints the handlers, most specific match first, with their
+component and the flows they take part in:
+
+  canopy route GET /v1/orders/42
+
+Route templates may use {id}, {id:regex}, :id, * and ** or {rest...}.
+Without a method, routes for every method match. The query string is
+ignored.
+
+It reads the index directly and does not need a running server; the
+server offers the same lookup at GET /routes/resolve?method=&path=.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		method, path := "", args[0]
+		if len(args) == 2 {
+			method, path = args[0], args[1]
+		}
+		_, idx, err := loadIndex()
+		if err != nil {
+			return err
+		}
+
+		matches := idx.ResolveRoute(method, path)
+		switch routeFormat {
+		case "json":
+			if matches == nil {
+				matches = []server.RouteMatch{}
+			}
+			return writeJSON(matches)
+		case "text":
+		default:
+			return fmt.Errorf("unknown format %q (want text or json)", routeFormat)
+		}
+
+		if len(matches) == 0 {
+			fmt.Fprintf(os.Stderr, "No route matches %s.\n", strings.TrimSpace(strings.ToUpper(method)+" "+path))
+			return nil
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ROUTE\tHANDLER\tCOMPONENT\tFLOWS\tFILE")
+		for _, m := range matches {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", m.Route, handlerLabel(m.Handler), componentLabel(m.Component), flowLabels(m.Flows), m.Handler.File)
+		}
+		return tw.Flush()
+	},
+}
+
+var topicCmd = &cobra.Command{
+	Use:   "topic <name>",
+	Short: "List the producers and consumers of a message topic",
+	Long: `Topic lists the archetypes that declare a message topic, either by name
+or by a pattern that matches it (orders.*, orders/+, orders.#), with their
+component and the flows they take part in.
+
+The index does not record which side of a topic an archetype is on, so the
+role is inferred from its relationships ("publishes", "consumes"), then its
+category (publishers, listeners), then whether it is an entry point; it is
+"unknown" when none of these tell.
+
+It reads the index directly and does not need a running server; the
+server offers the same lookup at GET /topics/{name}.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		_, idx, err := loadIndex()
+		if err != nil {
+			return err
+		}
+
+		handlers := idx.TopicHandlers(args[0])
+		switch topicFormat {
+		case "json":
+			if handlers == nil {
+				handlers = []server.TopicHandler{}
+			}
+			return writeJSON(handlers)
+		case "text":
+		default:
+			return fmt.Errorf("unknown format %q (want text or json)", topicFormat)
+		}
+
+		if len(handlers) == 0 {
+			fmt.Fprintf(os.Stderr, "No archetype declares topic %s.\n", args[0])
+			return nil
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ROLE\tTOPIC\tHANDLER\tCOMPONENT\tFLOWS\tFILE")
+		for _, h := range handlers {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", h.Role, h.Topic, handlerLabel(h.Handler), componentLabel(h.Component), flowLabels(h.Flows), h.Handler.File)
+		}
+		return tw.Flush()
+	},
+}
+
+func handlerLabel(h server.HandlerRef) string {
+	if h.Symbol != "" {
+		return h.Symbol
+	}
+	return h.ID
+}
+
+func componentLabel(c *server.ComponentRef) string {
+	if c == nil {
+		return "-"
+	}
+	return c.ID
+}
+
+func flowLabels(flows []server.FlowSummary) string {
+	if len(flows) == 0 {
+		return "-"
+	}
+	ids := make([]string, len(flows))
+	for i, f := range flows {
+		ids[i] = f.ID
+	}
+	return strings.Join(ids, ",")
+}
+
+func init() {
+	routeCmd.Flags().StringVar(&routeFormat, "format", "text", "output format: text or json")
+	topicCmd.Flags().StringVar(&topicFormat, "format", "text", "output format: text or json")
+	rootCmd.AddCommand(routeCmd)
+	rootCmd.AddCommand(topicCmd)
+}
@@ -55,7 +55,7 @@ var restartCmd = &cobra.Command{
 		}
 
 		log.Printf("Starting server on %s", addr)
-		return server.Run(ad.IndexPath(), serveHost, port)
+		return server.Run(ad.IndexPath(), serveHost, port, serveOrigins)
 	},
 }
 
@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"github.com/nhomble/canopy/internal/server"
 	"github.com/spf13/cobra"
 )
 
@@ -24,6 +25,10 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&archDir, "canopy-dir", ".canopy", "path to .canopy directory")
 }
 
-func Execute() error {
+// Execute runs the root command. version is reported by --version and
+// the server's /version endpoint.
+func Execute(version string) error {
+	rootCmd.Version = version
+	server.Version = version
 	return rootCmd.Execute()
 }
@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/nhomble/canopy/internal/server"
+	"github.com/spf13/cobra"
+)
+
+var (
+	searchKinds  []string
+	searchLimit  int
+	searchFormat string
+)
+
+var searchCmd = &cobra.Command{
+	Use:   "search <query>...",
+	Short: "Search the index for components, archetypes, routes and more",
+	Long: `Search ranks elements of .canopy/index.json, with the overlay applied,
+against a free-text query: component names and IDs, archetype symbols,
+purposes and technologies, routes, topics, entities, provided symbols and
+flow names. Matching tolerates typos and abbreviations, so
+"where is login handled" and "custmer ctrl" both find something useful.
+
+It reads the index directly and does not need a running server; the
+server offers the same search at GET /search?q=.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		for _, kind := range searchKinds {
+			if !slices.Contains(server.SearchKinds, kind) {
+				return fmt.Errorf("unknown kind %q (want %s)", kind, strings.Join(server.SearchKinds, ", "))
+			}
+		}
+
+		_, idx, err := loadIndex()
+		if err != nil {
+			return err
+		}
+
+		results := idx.Search(strings.Join(args, " "), searchKinds, searchLimit)
+		switch searchFormat {
+		case "json":
+			if results == nil {
+				results = []server.SearchResult{}
+			}
+			return writeJSON(results)
+		case "text":
+		default:
+			return fmt.Errorf("unknown format %q (want text or json)", searchFormat)
+		}
+
+		if len(results) == 0 {
+			fmt.Fprintln(os.Stderr, "No matches.")
+			return nil
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "KIND\tMATCH\tID\tCOMPONENT\tFILE")
+		for _, r := range results {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Kind, r.Label, r.ID, r.Component, r.File)
+		}
+		return tw.Flush()
+	},
+}
+
+func init() {
+	searchCmd.Flags().StringSliceVar(&searchKinds, "kind", nil, "only return this kind of result: "+strings.Join(server.SearchKinds, ", "))
+	searchCmd.Flags().IntVarP(&searchLimit, "limit", "n", 20, "maximum number of results (0 = all)")
+	searchCmd.Flags().StringVar(&searchFormat, "format", "text", "output format: text or json")
+	rootCmd.AddCommand(searchCmd)
+}
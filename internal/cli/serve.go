@@ -10,14 +10,76 @@ import (
 )
 
 var (
-	servePort int
-	serveHost string
+	servePort      int
+	serveHost      string
+	serveWorkspace string
+	serveOrigins   []string
 )
 
 var serveCmd = &cobra.Command{
 	Use:   "serve",
 	Short: "Start the local HTTP server for architectural queries",
+	Long: `Serve answers architectural queries and hosts the web UI. It watches
+.canopy/index.json, overlay.json, component sub-indexes and CODEOWNERS,
+and reloads when any of them changes, so a new canopy import shows up
+without a restart.
+
+GET /events streams server events (SSE) by topic: cursor (the editor's
+file), selection (the element clicked in the web UI), index-reloaded and
+annotation-changed and index-edited. Pick topics with ?topics=cursor,selection. The last
+cursor and selection are replayed on connect, and a client reconnecting
+with Last-Event-ID receives the events it missed.
+
+The web UI and editors can also change index.json: PATCH /components/{id},
+POST and DELETE /relationships, PUT /flows/{id} and
+PUT /archetypes/{id}/category. Each edit is validated, snapshotted into
+.canopy/history and saved, and can be reverted with POST /undo and
+POST /redo while index.json is not changed by anything else. Fields that
+overlay.json overrides keep their overlay values: an edit touching only
+such fields is refused with 409, and otherwise the response carries a
+warning naming them.
+
+Edits, other non-GET requests and the event streams need the access token
+in .canopy/token, generated on first start and kept out of git by
+.canopy/.gitignore. Send it as "Authorization: Bearer <token>"; the web UI
+gets it as a cookie when opened on this machine, or from elsewhere by
+opening /?token=<token> once. Browsers may call the server only from its
+own pages and the origins in server.allowed_origins in config.json or
+--allow-origin.
+
+With --workspace, serve loads the indexes of several repositories listed
+in a YAML file and serves them together:
+
+  name: shop
+  repos:
+    - id: orders
+      path: ../orders            # relative to the workspace file
+    - id: customers
+      path: ../customers
+      services: [customer-api]   # names used in target_service
+      component: customer-service
+
+The root serves a system graph merging all repositories, with element IDs
+namespaced as <repo>:<id>. Archetypes' target_service and relationships to
+elements of other repositories become cross-repo relationships. Each
+repository keeps its own endpoints under /repos/<id>/ (point an editor
+plugin's base_url there), and GET /repos lists the repositories and every
+cross-repo link, resolved or not. The system graph is read-only: edit a
+repository under /repos/<id>/ and the system graph follows.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if serveWorkspace != "" {
+			port := servePort
+			if port == 0 {
+				abs, err := filepath.Abs(serveWorkspace)
+				if err != nil {
+					return err
+				}
+				port = server.DeterministicPort(filepath.Dir(abs))
+				log.Printf("Auto-assigned port %d for %s", port, abs)
+			}
+			return server.RunWorkspace(serveWorkspace, serveHost, port, serveOrigins)
+		}
+
 		ad, err := canopydir.Find(".")
 		if err != nil {
 			return err
@@ -30,7 +92,7 @@ var serveCmd = &cobra.Command{
 			log.Printf("Auto-assigned port %d for %s", port, repoRoot)
 		}
 
-		return server.Run(ad.IndexPath(), serveHost, port)
+		return server.Run(ad.IndexPath(), serveHost, port, serveOrigins)
 	},
 }
 
@@ -38,5 +100,8 @@ func init() {
 	serveCmd.Flags().IntVarP(&servePort, "port", "p", 0,
 		"port to listen on (0 = auto-assign from repo path)")
 	serveCmd.Flags().StringVar(&serveHost, "host", "127.0.0.1", "host to bind to")
+	serveCmd.Flags().StringSliceVar(&serveOrigins, "allow-origin", nil,
+		"web origin allowed to call the server from a browser (repeatable; adds to server.allowed_origins in config.json)")
+	serveCmd.Flags().StringVar(&serveWorkspace, "workspace", "", "serve the repositories listed in this workspace file")
 	rootCmd.AddCommand(serveCmd)
 }
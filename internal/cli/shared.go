@@ -0,0 +1,30 @@
+package cli
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/nhomble/canopy/internal/canopydir"
+	"github.com/nhomble/canopy/internal/server"
+)
+
+// loadIndex finds the .canopy directory and loads its index with the
+// overlay applied, for the commands that query it.
+func loadIndex() (*canopydir.CanopyDir, *server.ArchiveIndex, error) {
+	ad, err := canopydir.Find(".")
+	if err != nil {
+		return nil, nil, err
+	}
+	idx, err := server.LoadIndex(ad.IndexPath())
+	if err != nil {
+		return nil, nil, err
+	}
+	return ad, idx, nil
+}
+
+// writeJSON prints v to stdout as indented JSON, for --format json.
+func writeJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -2,28 +2,67 @@ package cli
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 
 	"github.com/nhomble/canopy/internal/canopydir"
 	"github.com/nhomble/canopy/internal/schema"
 	"github.com/spf13/cobra"
 )
 
+var validateFormat string
+
 var validateCmd = &cobra.Command{
 	Use:   "validate",
 	Short: "Validate the existing .canopy/index.json",
+	Long: `Validate checks .canopy/index.json for structural and semantic problems.
+
+Use --format json or --format sarif to produce machine-readable output
+for CI gates and code-scanning dashboards. The command exits non-zero
+when validation fails, regardless of format.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ad, err := canopydir.Find(".")
 		if err != nil {
 			return err
 		}
 
-		idx, err := schema.LoadIndex(ad.IndexPath())
+		indexPath := ad.IndexPath()
+		data, err := os.ReadFile(indexPath)
+		if err != nil {
+			return fmt.Errorf("reading index: %w", err)
+		}
+
+		idx, err := schema.LoadIndex(indexPath)
 		if err != nil {
 			return err
 		}
 
 		result := schema.ValidateIndex(idx)
-		fmt.Print(result.FormatResult())
+		result.Locate(data)
+
+		switch validateFormat {
+		case "text":
+			fmt.Print(result.FormatResult())
+		case "json":
+			out, err := result.FormatJSON()
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+		case "sarif":
+			// SARIF locations are relative to the repository root.
+			uri, err := filepath.Rel(filepath.Dir(ad.Root), indexPath)
+			if err != nil {
+				uri = indexPath
+			}
+			out, err := result.FormatSARIF(filepath.ToSlash(uri))
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+		default:
+			return fmt.Errorf("unknown format %q (want text, json, or sarif)", validateFormat)
+		}
 
 		if !result.Valid {
 			return fmt.Errorf("validation failed")
@@ -33,5 +72,6 @@ var validateCmd = &cobra.Command{
 }
 
 func init() {
+	validateCmd.Flags().StringVar(&validateFormat, "format", "text", "output format: text, json, or sarif")
 	rootCmd.AddCommand(validateCmd)
 }
@@ -0,0 +1,314 @@
+package diff
+
+import (
+	"slices"
+	"sort"
+
+	"github.com/nhomble/canopy/internal/schema"
+)
+
+// renameThreshold is the minimum code_ref overlap (Jaccard similarity) for an
+// unmatched removed/added component pair to be reported as a rename.
+const renameThreshold = 0.5
+
+// Result is the architectural difference between two index versions.
+type Result struct {
+	ComponentsAdded      []ComponentRef        `json:"components_added"`
+	ComponentsRemoved    []ComponentRef        `json:"components_removed"`
+	ComponentsRenamed    []ComponentRename     `json:"components_renamed"`
+	LayerChanges         []LayerChange         `json:"layer_changes"`
+	ArchetypesAdded      []ArchetypeRef        `json:"archetypes_added"`
+	ArchetypesRemoved    []ArchetypeRef        `json:"archetypes_removed"`
+	ArchetypesMoved      []ArchetypeMove       `json:"archetypes_moved"`
+	RelationshipsAdded   []schema.Relationship `json:"relationships_added"`
+	RelationshipsRemoved []schema.Relationship `json:"relationships_removed"`
+	FlowsAdded           []FlowRef             `json:"flows_added"`
+	FlowsRemoved         []FlowRef             `json:"flows_removed"`
+	FlowsChanged         []FlowRef             `json:"flows_changed"`
+}
+
+type ComponentRef struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Layer string `json:"layer"`
+}
+
+type ComponentRename struct {
+	From    ComponentRef `json:"from"`
+	To      ComponentRef `json:"to"`
+	Overlap float64      `json:"overlap"`
+}
+
+type LayerChange struct {
+	Component string `json:"component"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+}
+
+type ArchetypeRef struct {
+	ID        string `json:"id"`
+	Category  string `json:"category"`
+	Component string `json:"component,omitempty"`
+}
+
+type ArchetypeMove struct {
+	ID   string `json:"id"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type FlowRef struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Empty reports whether the two versions are architecturally identical.
+func (r *Result) Empty() bool {
+	return len(r.ComponentsAdded) == 0 && len(r.ComponentsRemoved) == 0 &&
+		len(r.ComponentsRenamed) == 0 && len(r.LayerChanges) == 0 &&
+		len(r.ArchetypesAdded) == 0 && len(r.ArchetypesRemoved) == 0 &&
+		len(r.ArchetypesMoved) == 0 && len(r.RelationshipsAdded) == 0 &&
+		len(r.RelationshipsRemoved) == 0 && len(r.FlowsAdded) == 0 &&
+		len(r.FlowsRemoved) == 0 && len(r.FlowsChanged) == 0
+}
+
+// Compare computes the semantic difference from before to after. Components are
+// matched by ID, and leftover pairs whose code_refs overlap are treated as
+// renames so their archetypes and relationships do not show up as churn.
+func Compare(before, after *schema.ArchIndex) *Result {
+	r := &Result{
+		ComponentsAdded:      []ComponentRef{},
+		ComponentsRemoved:    []ComponentRef{},
+		ComponentsRenamed:    []ComponentRename{},
+		LayerChanges:         []LayerChange{},
+		ArchetypesAdded:      []ArchetypeRef{},
+		ArchetypesRemoved:    []ArchetypeRef{},
+		ArchetypesMoved:      []ArchetypeMove{},
+		RelationshipsAdded:   []schema.Relationship{},
+		RelationshipsRemoved: []schema.Relationship{},
+		FlowsAdded:           []FlowRef{},
+		FlowsRemoved:         []FlowRef{},
+		FlowsChanged:         []FlowRef{},
+	}
+	oldIdx := schema.NewComponentResolver(before)
+	newIdx := schema.NewComponentResolver(after)
+
+	// renamed maps old component IDs to their new IDs.
+	renamed := make(map[string]string)
+	r.compareComponents(before, after, renamed)
+
+	translate := func(id string) string {
+		if to, ok := renamed[id]; ok {
+			return to
+		}
+		return id
+	}
+
+	r.compareArchetypes(before, after, oldIdx, newIdx, translate)
+	r.compareRelationships(before, after, translate)
+	r.compareFlows(before, after, translate)
+	return r
+}
+
+func (r *Result) compareComponents(before, after *schema.ArchIndex, renamed map[string]string) {
+	newByID := make(map[string]*schema.Component, len(after.Components))
+	for i := range after.Components {
+		newByID[after.Components[i].ID] = &after.Components[i]
+	}
+	oldByID := make(map[string]*schema.Component, len(before.Components))
+	for i := range before.Components {
+		oldByID[before.Components[i].ID] = &before.Components[i]
+	}
+
+	var removed, added []*schema.Component
+	for i := range before.Components {
+		oc := &before.Components[i]
+		nc, ok := newByID[oc.ID]
+		if !ok {
+			removed = append(removed, oc)
+			continue
+		}
+		if oc.Layer != nc.Layer {
+			r.LayerChanges = append(r.LayerChanges, LayerChange{Component: nc.ID, From: oc.Layer, To: nc.Layer})
+		}
+	}
+	for i := range after.Components {
+		if _, ok := oldByID[after.Components[i].ID]; !ok {
+			added = append(added, &after.Components[i])
+		}
+	}
+
+	// Greedily pair removed and added components by best code_ref overlap.
+	type candidate struct {
+		from, to *schema.Component
+		overlap  float64
+	}
+	var candidates []candidate
+	for _, oc := range removed {
+		for _, nc := range added {
+			if ov := schema.CodeRefOverlap(oc.CodeRefs, nc.CodeRefs); ov >= renameThreshold {
+				candidates = append(candidates, candidate{oc, nc, ov})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].overlap > candidates[j].overlap })
+
+	pairedTo := make(map[string]bool)
+	for _, c := range candidates {
+		if _, done := renamed[c.from.ID]; done || pairedTo[c.to.ID] {
+			continue
+		}
+		renamed[c.from.ID] = c.to.ID
+		pairedTo[c.to.ID] = true
+		r.ComponentsRenamed = append(r.ComponentsRenamed, ComponentRename{
+			From: componentRef(c.from), To: componentRef(c.to), Overlap: c.overlap,
+		})
+		if c.from.Layer != c.to.Layer {
+			r.LayerChanges = append(r.LayerChanges, LayerChange{Component: c.to.ID, From: c.from.Layer, To: c.to.Layer})
+		}
+	}
+
+	for _, oc := range removed {
+		if _, ok := renamed[oc.ID]; !ok {
+			r.ComponentsRemoved = append(r.ComponentsRemoved, componentRef(oc))
+		}
+	}
+	for _, nc := range added {
+		if !pairedTo[nc.ID] {
+			r.ComponentsAdded = append(r.ComponentsAdded, componentRef(nc))
+		}
+	}
+
+	sort.Slice(r.ComponentsRenamed, func(i, j int) bool { return r.ComponentsRenamed[i].From.ID < r.ComponentsRenamed[j].From.ID })
+	sort.Slice(r.LayerChanges, func(i, j int) bool { return r.LayerChanges[i].Component < r.LayerChanges[j].Component })
+}
+
+func (r *Result) compareArchetypes(before, after *schema.ArchIndex, oldIdx, newIdx *schema.ComponentResolver, translate func(string) string) {
+	oldArchs := archetypesByID(before)
+	newArchs := archetypesByID(after)
+
+	for id, category := range oldArchs {
+		if _, ok := newArchs[id]; !ok {
+			r.ArchetypesRemoved = append(r.ArchetypesRemoved, ArchetypeRef{
+				ID: id, Category: category, Component: oldIdx.ComponentOf(id),
+			})
+		}
+	}
+	for id, category := range newArchs {
+		if _, ok := oldArchs[id]; !ok {
+			r.ArchetypesAdded = append(r.ArchetypesAdded, ArchetypeRef{
+				ID: id, Category: category, Component: newIdx.ComponentOf(id),
+			})
+			continue
+		}
+		from := translate(oldIdx.ComponentOf(id))
+		to := newIdx.ComponentOf(id)
+		if from != to {
+			r.ArchetypesMoved = append(r.ArchetypesMoved, ArchetypeMove{ID: id, From: from, To: to})
+		}
+	}
+
+	sort.Slice(r.ArchetypesRemoved, func(i, j int) bool { return r.ArchetypesRemoved[i].ID < r.ArchetypesRemoved[j].ID })
+	sort.Slice(r.ArchetypesAdded, func(i, j int) bool { return r.ArchetypesAdded[i].ID < r.ArchetypesAdded[j].ID })
+	sort.Slice(r.ArchetypesMoved, func(i, j int) bool { return r.ArchetypesMoved[i].ID < r.ArchetypesMoved[j].ID })
+}
+
+func (r *Result) compareRelationships(before, after *schema.ArchIndex, translate func(string) string) {
+	type relKey struct{ from, to, typ string }
+
+	oldRels := make(map[relKey]schema.Relationship)
+	for _, rel := range before.Relationships {
+		oldRels[relKey{translate(rel.From), translate(rel.To), rel.Type}] = rel
+	}
+	newRels := make(map[relKey]schema.Relationship)
+	for _, rel := range after.Relationships {
+		newRels[relKey{rel.From, rel.To, rel.Type}] = rel
+	}
+
+	for k, rel := range oldRels {
+		if _, ok := newRels[k]; !ok {
+			r.RelationshipsRemoved = append(r.RelationshipsRemoved, rel)
+		}
+	}
+	for k, rel := range newRels {
+		if _, ok := oldRels[k]; !ok {
+			r.RelationshipsAdded = append(r.RelationshipsAdded, rel)
+		}
+	}
+
+	sortRelationships(r.RelationshipsRemoved)
+	sortRelationships(r.RelationshipsAdded)
+}
+
+func (r *Result) compareFlows(before, after *schema.ArchIndex, translate func(string) string) {
+	oldFlows := make(map[string]schema.Flow, len(before.Flows))
+	for _, f := range before.Flows {
+		oldFlows[f.ID] = f
+	}
+	newFlows := make(map[string]schema.Flow, len(after.Flows))
+	for _, f := range after.Flows {
+		newFlows[f.ID] = f
+	}
+
+	for id, f := range oldFlows {
+		if _, ok := newFlows[id]; !ok {
+			r.FlowsRemoved = append(r.FlowsRemoved, FlowRef{ID: f.ID, Name: f.Name})
+		}
+	}
+	for id, nf := range newFlows {
+		of, ok := oldFlows[id]
+		if !ok {
+			r.FlowsAdded = append(r.FlowsAdded, FlowRef{ID: nf.ID, Name: nf.Name})
+			continue
+		}
+		if !sameFlowGraph(of.Normalized(), nf.Normalized(), translate) {
+			r.FlowsChanged = append(r.FlowsChanged, FlowRef{ID: nf.ID, Name: nf.Name})
+		}
+	}
+
+	sort.Slice(r.FlowsRemoved, func(i, j int) bool { return r.FlowsRemoved[i].ID < r.FlowsRemoved[j].ID })
+	sort.Slice(r.FlowsAdded, func(i, j int) bool { return r.FlowsAdded[i].ID < r.FlowsAdded[j].ID })
+	sort.Slice(r.FlowsChanged, func(i, j int) bool { return r.FlowsChanged[i].ID < r.FlowsChanged[j].ID })
+}
+
+// sameFlowGraph compares two flow graphs after translating renamed
+// element IDs in the old one.
+func sameFlowGraph(before, after *schema.FlowGraph, translate func(string) string) bool {
+	if len(before.Steps) != len(after.Steps) || !slices.Equal(before.Transitions, after.Transitions) {
+		return false
+	}
+	for i, step := range before.Steps {
+		step.Ref = translate(step.Ref)
+		if step != after.Steps[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func componentRef(c *schema.Component) ComponentRef {
+	return ComponentRef{ID: c.ID, Name: c.Name, Layer: c.Layer}
+}
+
+// archetypesByID maps every archetype ID to its category.
+func archetypesByID(idx *schema.ArchIndex) map[string]string {
+	m := make(map[string]string)
+	for category, archetypes := range idx.Archetypes {
+		for _, arch := range archetypes {
+			m[arch.ID] = category
+		}
+	}
+	return m
+}
+
+func sortRelationships(rels []schema.Relationship) {
+	sort.Slice(rels, func(i, j int) bool {
+		if rels[i].From != rels[j].From {
+			return rels[i].From < rels[j].From
+		}
+		if rels[i].To != rels[j].To {
+			return rels[i].To < rels[j].To
+		}
+		return rels[i].Type < rels[j].Type
+	})
+}
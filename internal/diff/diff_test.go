@@ -0,0 +1,102 @@
+package diff
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/nhomble/canopy/internal/schema"
+)
+
+func baseIndex() *schema.ArchIndex {
+	return &schema.ArchIndex{
+		RepoID: "test",
+		Components: []schema.Component{
+			{ID: "orders", Name: "Orders", Layer: "core", CodeRefs: []string{"orders/**"}},
+			{ID: "billing", Name: "Billing", Layer: "core", CodeRefs: []string{"billing/**"}},
+			{ID: "legacy", Name: "Legacy", Layer: "adapters", CodeRefs: []string{"legacy/**"}},
+		},
+		Archetypes: map[string][]schema.Archetype{
+			"services": {
+				{ID: "order-svc", File: "orders/svc.go"},
+				{ID: "invoice-svc", File: "billing/invoice.go"},
+			},
+		},
+		Relationships: []schema.Relationship{
+			{From: "order-svc", To: "invoice-svc", Type: "calls"},
+		},
+		Flows: []schema.Flow{
+			{ID: "checkout", Name: "Checkout", Steps: []string{"order-svc", "invoice-svc"}},
+		},
+	}
+}
+
+func TestCompareIdentical(t *testing.T) {
+	r := Compare(baseIndex(), baseIndex())
+	if !r.Empty() {
+		t.Fatalf("expected no changes, got:\n%s", r.FormatText())
+	}
+}
+
+func TestCompareEmptyListsEncodeAsArrays(t *testing.T) {
+	data, err := json.Marshal(Compare(baseIndex(), baseIndex()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "null") {
+		t.Fatalf("expected empty arrays, got %s", data)
+	}
+}
+
+func TestCompareDetectsChanges(t *testing.T) {
+	before := baseIndex()
+	after := baseIndex()
+
+	// Rename billing → payments, keeping its code_refs.
+	after.Components[1].ID = "payments"
+	after.Components[1].Name = "Payments"
+	// Change a layer, drop a component, add one.
+	after.Components[0].Layer = "application"
+	after.Components = append(after.Components[:2], schema.Component{
+		ID: "shipping", Name: "Shipping", Layer: "core", CodeRefs: []string{"shipping/**"},
+	})
+	// Move order-svc into shipping; add a new archetype.
+	after.Archetypes["services"][0].File = "shipping/svc.go"
+	after.Archetypes["services"] = append(after.Archetypes["services"],
+		schema.Archetype{ID: "label-svc", File: "shipping/label.go"})
+	after.Relationships = append(after.Relationships,
+		schema.Relationship{From: "order-svc", To: "label-svc", Type: "calls"})
+	after.Flows = append(after.Flows, schema.Flow{ID: "ship", Name: "Ship", Steps: []string{"label-svc"}})
+
+	r := Compare(before, after)
+
+	if len(r.ComponentsRenamed) != 1 || r.ComponentsRenamed[0].From.ID != "billing" || r.ComponentsRenamed[0].To.ID != "payments" {
+		t.Fatalf("expected billing → payments rename, got %+v", r.ComponentsRenamed)
+	}
+	if len(r.ComponentsRemoved) != 1 || r.ComponentsRemoved[0].ID != "legacy" {
+		t.Fatalf("expected legacy removed, got %+v", r.ComponentsRemoved)
+	}
+	if len(r.ComponentsAdded) != 1 || r.ComponentsAdded[0].ID != "shipping" {
+		t.Fatalf("expected shipping added, got %+v", r.ComponentsAdded)
+	}
+	if len(r.LayerChanges) != 1 || r.LayerChanges[0].To != "application" {
+		t.Fatalf("expected orders layer change, got %+v", r.LayerChanges)
+	}
+	if len(r.ArchetypesMoved) != 1 || r.ArchetypesMoved[0].From != "orders" || r.ArchetypesMoved[0].To != "shipping" {
+		t.Fatalf("expected order-svc moved orders → shipping, got %+v", r.ArchetypesMoved)
+	}
+	if len(r.ArchetypesAdded) != 1 || r.ArchetypesAdded[0].ID != "label-svc" {
+		t.Fatalf("expected label-svc added, got %+v", r.ArchetypesAdded)
+	}
+	if len(r.RelationshipsAdded) != 1 || len(r.RelationshipsRemoved) != 0 {
+		t.Fatalf("expected 1 relationship added, got +%d -%d", len(r.RelationshipsAdded), len(r.RelationshipsRemoved))
+	}
+	if len(r.FlowsAdded) != 1 || len(r.FlowsChanged) != 0 {
+		t.Fatalf("expected 1 flow added and none changed, got %+v / %+v", r.FlowsAdded, r.FlowsChanged)
+	}
+
+	md := r.FormatMarkdown()
+	if !strings.Contains(md, "### Components renamed") || !strings.Contains(md, "`billing`") {
+		t.Fatalf("unexpected markdown:\n%s", md)
+	}
+}
@@ -0,0 +1,122 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/nhomble/canopy/internal/schema"
+)
+
+// section is one titled group of change lines, shared by the text and
+// Markdown renderers so both list changes in the same order.
+type section struct {
+	title string
+	lines []string
+}
+
+// sections renders every non-empty change group. code formats identifiers,
+// letting Markdown wrap them in backticks while text leaves them bare.
+func (r *Result) sections(code func(string) string) []section {
+	var s []section
+	add := func(title string, lines []string) {
+		if len(lines) > 0 {
+			s = append(s, section{title, lines})
+		}
+	}
+
+	component := func(c ComponentRef) string {
+		return fmt.Sprintf("%s (%s) [%s]", code(c.ID), c.Name, c.Layer)
+	}
+	archetype := func(a ArchetypeRef) string {
+		if a.Component != "" {
+			return fmt.Sprintf("%s [%s] in %s", code(a.ID), a.Category, code(a.Component))
+		}
+		return fmt.Sprintf("%s [%s]", code(a.ID), a.Category)
+	}
+	relationship := func(rel schema.Relationship) string {
+		line := fmt.Sprintf("%s -%s-> %s", code(rel.From), rel.Type, code(rel.To))
+		if rel.Flow != "" {
+			line += fmt.Sprintf(" (flow %s)", code(rel.Flow))
+		}
+		return line
+	}
+	flow := func(f FlowRef) string {
+		return fmt.Sprintf("%s (%s)", code(f.ID), f.Name)
+	}
+	orNone := func(id string) string {
+		if id == "" {
+			return "(none)"
+		}
+		return code(id)
+	}
+
+	add("Components added", mapLines(r.ComponentsAdded, component))
+	add("Components removed", mapLines(r.ComponentsRemoved, component))
+	add("Components renamed", mapLines(r.ComponentsRenamed, func(c ComponentRename) string {
+		return fmt.Sprintf("%s -> %s (%.0f%% code_ref overlap)", code(c.From.ID), code(c.To.ID), c.Overlap*100)
+	}))
+	add("Layer changes", mapLines(r.LayerChanges, func(c LayerChange) string {
+		return fmt.Sprintf("%s: %s -> %s", code(c.Component), c.From, c.To)
+	}))
+	add("Archetypes added", mapLines(r.ArchetypesAdded, archetype))
+	add("Archetypes removed", mapLines(r.ArchetypesRemoved, archetype))
+	add("Archetypes moved", mapLines(r.ArchetypesMoved, func(m ArchetypeMove) string {
+		return fmt.Sprintf("%s: %s -> %s", code(m.ID), orNone(m.From), orNone(m.To))
+	}))
+	add("Relationships added", mapLines(r.RelationshipsAdded, relationship))
+	add("Relationships removed", mapLines(r.RelationshipsRemoved, relationship))
+	add("Flows added", mapLines(r.FlowsAdded, flow))
+	add("Flows removed", mapLines(r.FlowsRemoved, flow))
+	add("Flows changed", mapLines(r.FlowsChanged, flow))
+	return s
+}
+
+// FormatText renders the diff as plain text for terminals.
+func (r *Result) FormatText() string {
+	if r.Empty() {
+		return "No architectural changes.\n"
+	}
+	var sb strings.Builder
+	for i, s := range r.sections(func(id string) string { return id }) {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(&sb, "%s:\n", s.title)
+		for _, line := range s.lines {
+			fmt.Fprintf(&sb, "  %s\n", line)
+		}
+	}
+	return sb.String()
+}
+
+// FormatMarkdown renders the diff as Markdown suitable for a PR description.
+func (r *Result) FormatMarkdown() string {
+	var sb strings.Builder
+	sb.WriteString("## Architecture changes\n\n")
+	if r.Empty() {
+		sb.WriteString("No architectural changes.\n")
+		return sb.String()
+	}
+	for _, s := range r.sections(func(id string) string { return "`" + id + "`" }) {
+		fmt.Fprintf(&sb, "### %s\n\n", s.title)
+		for _, line := range s.lines {
+			fmt.Fprintf(&sb, "- %s\n", line)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// FormatJSON renders the diff as an indented JSON document.
+func (r *Result) FormatJSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+func mapLines[T any](items []T, f func(T) string) []string {
+	lines := make([]string, 0, len(items))
+	for _, item := range items {
+		lines = append(lines, f(item))
+	}
+	return lines
+}
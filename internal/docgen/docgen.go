@@ -0,0 +1,417 @@
+// Package docgen renders the architecture index as a browsable Markdown
+// site. Pages come from text/templates that teams can override, and the
+// output is deterministic so it can be committed and diffed.
+package docgen
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/nhomble/canopy/internal/export"
+	"github.com/nhomble/canopy/internal/schema"
+	"github.com/nhomble/canopy/internal/server"
+)
+
+//go:embed templates/*.md.tmpl
+var templateFS embed.FS
+
+// Template names. A custom template directory may override any of them.
+const (
+	IndexTemplate       = "index.md.tmpl"
+	ComponentTemplate   = "component.md.tmpl"
+	FlowTemplate        = "flow.md.tmpl"
+	EntryPointsTemplate = "entry-points.md.tmpl"
+)
+
+// Site is the data behind every page.
+type Site struct {
+	RepoID           string
+	Patterns         []string
+	Layers           []Layer
+	Components       []*ComponentPage
+	Flows            []*FlowPage
+	Routes           []EntryPoint
+	Topics           []EntryPoint
+	ComponentDiagram string // Mermaid source
+}
+
+// Page is passed to each template. Root is the relative path from the page
+// to the site root ("" or "../"), for building links.
+type Page struct {
+	Root      string
+	Site      *Site
+	Component *ComponentPage
+	Flow      *FlowPage
+}
+
+type Layer struct {
+	Name       string
+	Components []ComponentRef
+}
+
+type ComponentRef struct {
+	ID    string
+	Name  string
+	Layer string
+}
+
+type ComponentPage struct {
+	ComponentRef
+	Description string
+	Tags        []string
+	Links       []schema.Link
+	Owners      []string
+	CodeRefs    []string
+	Archetypes  []Archetype
+	Upstream    []Dependency
+	Downstream  []Dependency
+	Flows       []FlowRef
+}
+
+type Archetype struct {
+	ID         string
+	Category   string
+	Symbol     string
+	File       string
+	Technology string
+	Purpose    string
+}
+
+// Dependency is an aggregated edge to or from another component.
+type Dependency struct {
+	Component ComponentRef
+	Types     []string
+	Protocols []string
+}
+
+type FlowRef struct {
+	ID   string
+	Name string
+}
+
+type FlowPage struct {
+	FlowRef
+	Pattern  string
+	Diagram  string // Mermaid source
+	Steps    []FlowStep
+	Branches []schema.FlowTransition
+}
+
+type FlowStep struct {
+	ID        string
+	Ref       string
+	Label     string
+	Parallel  string
+	Component *ComponentRef // component the step runs in, if known
+}
+
+// EntryPoint is a route or topic handled by an archetype.
+type EntryPoint struct {
+	Value     string
+	Type      string // entry_point_type
+	Archetype Archetype
+	Component *ComponentRef
+}
+
+// Generate writes the site into outDir. Templates in templateDir, if set,
+// replace the built-in ones of the same name. Stale component and flow
+// pages from earlier runs are removed. It returns the written paths.
+func Generate(idx *server.ArchiveIndex, outDir, templateDir string) ([]string, error) {
+	tmpl, err := loadTemplates(templateDir)
+	if err != nil {
+		return nil, err
+	}
+	site, err := buildSite(idx)
+	if err != nil {
+		return nil, err
+	}
+
+	pages := map[string]pageSpec{
+		"index.md":        {IndexTemplate, Page{Site: site}},
+		"entry-points.md": {EntryPointsTemplate, Page{Site: site}},
+	}
+	for _, comp := range site.Components {
+		pages[filepath.Join("components", schema.FileSlug(comp.ID)+".md")] = pageSpec{ComponentTemplate, Page{Root: "../", Site: site, Component: comp}}
+	}
+	for _, flow := range site.Flows {
+		pages[filepath.Join("flows", schema.FileSlug(flow.ID)+".md")] = pageSpec{FlowTemplate, Page{Root: "../", Site: site, Flow: flow}}
+	}
+
+	for _, dir := range []string{"components", "flows"} {
+		if err := removeStale(filepath.Join(outDir, dir), func(name string) bool {
+			_, ok := pages[filepath.Join(dir, name)]
+			return ok
+		}); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Join(outDir, dir), 0755); err != nil {
+			return nil, fmt.Errorf("creating %s: %w", dir, err)
+		}
+	}
+
+	paths := make([]string, 0, len(pages))
+	for rel := range pages {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+
+	written := make([]string, 0, len(paths))
+	for _, rel := range paths {
+		p := pages[rel]
+		var buf bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&buf, p.template, p.page); err != nil {
+			return nil, fmt.Errorf("rendering %s: %w", rel, err)
+		}
+		// Templates leave blank lines behind optional sections; end every
+		// page with exactly one newline.
+		content := append(bytes.TrimRight(buf.Bytes(), "\n"), '\n')
+		path := filepath.Join(outDir, rel)
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			return nil, fmt.Errorf("writing %s: %w", path, err)
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
+
+// pageSpec pairs an output page with the template that renders it.
+type pageSpec struct {
+	template string
+	page     Page
+}
+
+// loadTemplates parses the built-in templates, then any overrides.
+func loadTemplates(templateDir string) (*template.Template, error) {
+	tmpl := template.New("docs").Funcs(funcs)
+	if _, err := tmpl.ParseFS(templateFS, "templates/*.md.tmpl"); err != nil {
+		return nil, fmt.Errorf("parsing built-in templates: %w", err)
+	}
+	if templateDir == "" {
+		return tmpl, nil
+	}
+	overrides, err := filepath.Glob(filepath.Join(templateDir, "*.md.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	if len(overrides) == 0 {
+		return nil, fmt.Errorf("no *.md.tmpl templates in %s", templateDir)
+	}
+	if _, err := tmpl.ParseFiles(overrides...); err != nil {
+		return nil, fmt.Errorf("parsing templates in %s: %w", templateDir, err)
+	}
+	return tmpl, nil
+}
+
+// removeStale deletes generated pages in dir that keep does not accept.
+func removeStale(dir string, keep func(name string) bool) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".md" || keep(e.Name()) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+			return fmt.Errorf("removing stale page: %w", err)
+		}
+	}
+	return nil
+}
+
+var funcs = template.FuncMap{
+	"join": strings.Join,
+	// slug is the file name of a component or flow page, without ".md".
+	"slug": schema.FileSlug,
+	// cell makes a value safe inside a Markdown table cell.
+	"cell": func(s string) string {
+		return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+	},
+	// code wraps a value in backticks, choosing a fence the value can't close.
+	"code": func(s string) string {
+		fence := "`"
+		for strings.Contains(s, fence) {
+			fence += "`"
+		}
+		if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+			return fence + " " + s + " " + fence
+		}
+		return fence + s + fence
+	},
+}
+
+// buildSite collects page data from the index, sorted for stable output.
+func buildSite(idx *server.ArchiveIndex) (*Site, error) {
+	payload := idx.BuildGraphPayload()
+	site := &Site{RepoID: idx.Raw.RepoID, Patterns: idx.Raw.Patterns}
+
+	refs := make(map[string]ComponentRef, len(payload.Components))
+	for _, comp := range payload.Components {
+		refs[comp.ID] = ComponentRef{ID: comp.ID, Name: comp.Name, Layer: comp.Layer}
+	}
+
+	byLayer := make(map[string][]ComponentRef)
+	for _, comp := range idx.Raw.Components {
+		byLayer[comp.Layer] = append(byLayer[comp.Layer], refs[comp.ID])
+	}
+	for name, comps := range byLayer {
+		sort.Slice(comps, func(i, j int) bool { return comps[i].ID < comps[j].ID })
+		site.Layers = append(site.Layers, Layer{Name: name, Components: comps})
+	}
+	sort.Slice(site.Layers, func(i, j int) bool { return site.Layers[i].Name < site.Layers[j].Name })
+
+	pages := make(map[string]*ComponentPage)
+	for _, comp := range idx.Raw.Components {
+		page := &ComponentPage{
+			ComponentRef: refs[comp.ID],
+			Description:  comp.Description,
+			Tags:         comp.Tags,
+			Links:        comp.Links,
+			CodeRefs:     comp.CodeRefs,
+		}
+		if o := idx.Ownership(comp.ID); o != nil {
+			page.Owners = o.Owners
+		}
+		pages[comp.ID] = page
+		site.Components = append(site.Components, page)
+	}
+	sort.Slice(site.Components, func(i, j int) bool { return site.Components[i].ID < site.Components[j].ID })
+
+	for _, comp := range payload.Components {
+		for _, arch := range comp.Archetypes {
+			pages[comp.ID].Archetypes = append(pages[comp.ID].Archetypes, Archetype{
+				ID: arch.ID, Category: arch.Category, Symbol: arch.Symbol,
+				File: arch.File, Technology: arch.Technology, Purpose: arch.Purpose,
+			})
+		}
+	}
+	for _, page := range pages {
+		sort.Slice(page.Archetypes, func(i, j int) bool {
+			a, b := page.Archetypes[i], page.Archetypes[j]
+			if a.Category != b.Category {
+				return a.Category < b.Category
+			}
+			return a.ID < b.ID
+		})
+	}
+
+	for _, e := range payload.ComponentEdges {
+		from, to := pages[e.From], pages[e.To]
+		if from == nil || to == nil {
+			continue
+		}
+		from.Downstream = append(from.Downstream, Dependency{Component: to.ComponentRef, Types: e.Types, Protocols: e.Protocols})
+		to.Upstream = append(to.Upstream, Dependency{Component: from.ComponentRef, Types: e.Types, Protocols: e.Protocols})
+	}
+	for _, page := range pages {
+		sortDependencies(page.Upstream)
+		sortDependencies(page.Downstream)
+	}
+
+	for i := range idx.Raw.Flows {
+		flow := &idx.Raw.Flows[i]
+		page, err := flowPage(idx, flow, refs)
+		if err != nil {
+			return nil, err
+		}
+		site.Flows = append(site.Flows, page)
+
+		through := make(map[string]bool)
+		for _, step := range page.Steps {
+			if step.Component != nil && !through[step.Component.ID] {
+				through[step.Component.ID] = true
+				pages[step.Component.ID].Flows = append(pages[step.Component.ID].Flows, page.FlowRef)
+			}
+		}
+	}
+	sort.Slice(site.Flows, func(i, j int) bool { return site.Flows[i].ID < site.Flows[j].ID })
+	for _, page := range pages {
+		sort.Slice(page.Flows, func(i, j int) bool { return page.Flows[i].ID < page.Flows[j].ID })
+	}
+
+	site.Routes, site.Topics = entryPoints(idx, refs)
+
+	diagram, err := export.Export(idx, "mermaid", export.Options{})
+	if err != nil {
+		return nil, err
+	}
+	site.ComponentDiagram = diagram[0].Content
+	return site, nil
+}
+
+func flowPage(idx *server.ArchiveIndex, flow *schema.Flow, refs map[string]ComponentRef) (*FlowPage, error) {
+	page := &FlowPage{FlowRef: FlowRef{ID: flow.ID, Name: flow.Name}, Pattern: flow.Pattern}
+	g := flow.Normalized()
+	for _, step := range g.Steps {
+		s := FlowStep{ID: step.ID, Ref: step.Ref, Label: step.Label, Parallel: step.Parallel}
+		compID := step.Ref
+		if _, ok := refs[compID]; !ok {
+			compID = idx.ComponentOf(step.Ref)
+		}
+		if ref, ok := refs[compID]; ok {
+			s.Component = &ref
+		}
+		page.Steps = append(page.Steps, s)
+	}
+	for _, t := range g.Transitions {
+		if t.Label != "" || t.Condition != "" {
+			page.Branches = append(page.Branches, t)
+		}
+	}
+
+	docs, err := export.Export(idx, "mermaid", export.Options{View: export.ViewFlows, Flows: []string{flow.ID}})
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) > 0 {
+		page.Diagram = docs[0].Content
+	}
+	return page, nil
+}
+
+// entryPoints lists every route and topic handled by an archetype.
+func entryPoints(idx *server.ArchiveIndex, refs map[string]ComponentRef) (routes, topics []EntryPoint) {
+	for category, archetypes := range idx.Raw.Archetypes {
+		for _, arch := range archetypes {
+			a := Archetype{
+				ID: arch.ID, Category: category, Symbol: arch.Symbol,
+				File: arch.File, Technology: arch.Technology, Purpose: arch.Purpose,
+			}
+			var comp *ComponentRef
+			if ref, ok := refs[idx.ComponentOf(arch.ID)]; ok {
+				comp = &ref
+			}
+			for _, route := range arch.Routes {
+				routes = append(routes, EntryPoint{Value: route, Type: arch.EntryPointType, Archetype: a, Component: comp})
+			}
+			for _, topic := range arch.Topics {
+				topics = append(topics, EntryPoint{Value: topic, Type: arch.EntryPointType, Archetype: a, Component: comp})
+			}
+		}
+	}
+	sortEntryPoints(routes)
+	sortEntryPoints(topics)
+	return routes, topics
+}
+
+func sortEntryPoints(eps []EntryPoint) {
+	sort.Slice(eps, func(i, j int) bool {
+		if eps[i].Value != eps[j].Value {
+			return eps[i].Value < eps[j].Value
+		}
+		return eps[i].Archetype.ID < eps[j].Archetype.ID
+	})
+}
+
+func sortDependencies(deps []Dependency) {
+	sort.Slice(deps, func(i, j int) bool { return deps[i].Component.ID < deps[j].Component.ID })
+}
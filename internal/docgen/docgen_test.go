@@ -0,0 +1,167 @@
+package docgen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nhomble/canopy/internal/schema"
+	"github.com/nhomble/canopy/internal/server"
+)
+
+func testIndex() *server.ArchiveIndex {
+	return server.NewIndex(&schema.ArchIndex{
+		RepoID:   "shop",
+		Patterns: []string{"Hexagonal"},
+		Components: []schema.Component{
+			{ID: "api", Name: "API", Layer: "edge", CodeRefs: []string{"api/**"}, Description: "Public HTTP API"},
+			{ID: "billing", Name: "Billing", Layer: "core", CodeRefs: []string{"billing/**"}},
+		},
+		Archetypes: map[string][]schema.Archetype{
+			"controllers": {{ID: "checkout-ctl", File: "api/checkout.go", Symbol: "CheckoutController",
+				EntryPointType: "http", Routes: []string{"POST /checkout"}}},
+			"consumers": {{ID: "refunds", File: "billing/refunds.go", EntryPointType: "kafka", Topics: []string{"refunds"}}},
+			"services":  {{ID: "charge-svc", File: "billing/charge.go", Symbol: "ChargeService", Purpose: "Charges a|card"}},
+		},
+		Relationships: []schema.Relationship{
+			{From: "checkout-ctl", To: "charge-svc", Type: "calls", Protocol: "grpc"},
+		},
+		Flows: []schema.Flow{
+			{ID: "checkout", Name: "Checkout", Graph: &schema.FlowGraph{
+				Steps: []schema.FlowStep{
+					{ID: "in", Ref: "checkout-ctl"},
+					{ID: "charge", Ref: "charge-svc"},
+				},
+				Transitions: []schema.FlowTransition{{From: "in", To: "charge", Condition: "cart not empty"}},
+			}},
+		},
+	})
+}
+
+func read(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func assertContains(t *testing.T, out string, wants ...string) {
+	t.Helper()
+	for _, want := range wants {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	out := t.TempDir()
+	written, err := Generate(testIndex(), out, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 5 {
+		t.Errorf("expected 5 pages, got %v", written)
+	}
+
+	assertContains(t, read(t, filepath.Join(out, "index.md")),
+		"# shop architecture",
+		"- Hexagonal",
+		"### core\n\n- [Billing](components/billing.md)",
+		"```mermaid\n",
+		"api -->|\"calls [grpc]\"| billing",
+		"- [Checkout](flows/checkout.md)",
+	)
+	assertContains(t, read(t, filepath.Join(out, "components", "billing.md")),
+		"[Overview](../index.md) / layer **core**",
+		"- `billing/**`",
+		`| services | ChargeService | `+"`billing/charge.go`"+` | Charges a\|card |`,
+		"## Upstream\n\n- [API](../components/api.md): calls (grpc)",
+		"- [Checkout](../flows/checkout.md)",
+	)
+	assertContains(t, read(t, filepath.Join(out, "components", "api.md")),
+		"Public HTTP API",
+		"## Downstream\n\n- [Billing](../components/billing.md)",
+	)
+	assertContains(t, read(t, filepath.Join(out, "flows", "checkout.md")),
+		"sequenceDiagram",
+		"| charge | `charge-svc` | [Billing](../components/billing.md) |",
+		"- in → charge (when cart not empty)",
+	)
+	assertContains(t, read(t, filepath.Join(out, "entry-points.md")),
+		"| `POST /checkout` | http | CheckoutController (`api/checkout.go`) | [API](components/api.md) |",
+		"| `refunds` | kafka | refunds (`billing/refunds.go`) | [Billing](components/billing.md) |",
+	)
+}
+
+func TestGenerateIsDeterministicAndPrunes(t *testing.T) {
+	out := t.TempDir()
+	if _, err := Generate(testIndex(), out, ""); err != nil {
+		t.Fatal(err)
+	}
+	first := read(t, filepath.Join(out, "components", "billing.md"))
+
+	stale := filepath.Join(out, "flows", "removed.md")
+	os.WriteFile(stale, []byte("old"), 0644)
+	if _, err := Generate(testIndex(), out, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, filepath.Join(out, "components", "billing.md")); got != first {
+		t.Errorf("output changed between runs:\n%s\n---\n%s", first, got)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Error("expected the stale flow page to be removed")
+	}
+}
+
+func TestGenerateKeepsUnsafeIDsInsideOut(t *testing.T) {
+	idx := server.NewIndex(&schema.ArchIndex{
+		RepoID: "shop",
+		Components: []schema.Component{
+			{ID: "../escape", Name: "Escape", Layer: "core", CodeRefs: []string{"escape/**"}},
+		},
+		Archetypes: map[string][]schema.Archetype{
+			"services": {{ID: "svc", File: "escape/svc.go"}},
+		},
+		Flows: []schema.Flow{{ID: "team/checkout", Name: "Checkout", Steps: []string{"svc"}}},
+	})
+	out := filepath.Join(t.TempDir(), "site")
+	written, err := Generate(idx, out, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range written {
+		if rel, err := filepath.Rel(out, path); err != nil || strings.HasPrefix(rel, "..") {
+			t.Errorf("wrote %s outside %s", path, out)
+		}
+	}
+
+	assertContains(t, read(t, filepath.Join(out, "index.md")),
+		"- [Escape](components/~2e.~2fescape.md)",
+		"- [Checkout](flows/team~2fcheckout.md)",
+	)
+	assertContains(t, read(t, filepath.Join(out, "flows", "team~2fcheckout.md")),
+		"[Escape](../components/~2e.~2fescape.md)",
+	)
+}
+
+func TestTemplateOverride(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, FlowTemplate), []byte("flow {{ .Flow.ID }} in {{ .Site.RepoID }}\n"), 0644)
+
+	out := t.TempDir()
+	if _, err := Generate(testIndex(), out, dir); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, filepath.Join(out, "flows", "checkout.md")); got != "flow checkout in shop\n" {
+		t.Errorf("override not used: %q", got)
+	}
+	assertContains(t, read(t, filepath.Join(out, "index.md")), "# shop architecture")
+
+	if _, err := Generate(testIndex(), out, t.TempDir()); err == nil {
+		t.Error("expected an error for a template directory without templates")
+	}
+}
@@ -0,0 +1,367 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/nhomble/canopy/internal/schema"
+	"github.com/nhomble/canopy/internal/server"
+)
+
+func init() {
+	register("backstage", exporter{
+		ext:       ".yaml",
+		separator: "---\n",
+		render:    renderBackstage,
+	})
+}
+
+// Backstage catalog entity shapes, limited to the fields canopy fills in.
+
+type bsEntity struct {
+	APIVersion string     `yaml:"apiVersion"`
+	Kind       string     `yaml:"kind"`
+	Metadata   bsMetadata `yaml:"metadata"`
+	Spec       any        `yaml:"spec"`
+}
+
+type bsMetadata struct {
+	Name        string            `yaml:"name"`
+	Title       string            `yaml:"title,omitempty"`
+	Description string            `yaml:"description,omitempty"`
+	Tags        []string          `yaml:"tags,omitempty"`
+	Links       []bsLink          `yaml:"links,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+type bsLink struct {
+	URL   string `yaml:"url"`
+	Title string `yaml:"title,omitempty"`
+	Type  string `yaml:"type,omitempty"`
+}
+
+type bsComponentSpec struct {
+	Type         string   `yaml:"type"`
+	Lifecycle    string   `yaml:"lifecycle"`
+	Owner        string   `yaml:"owner"`
+	System       string   `yaml:"system,omitempty"`
+	ProvidesAPIs []string `yaml:"providesApis,omitempty"`
+	ConsumesAPIs []string `yaml:"consumesApis,omitempty"`
+	DependsOn    []string `yaml:"dependsOn,omitempty"`
+}
+
+type bsAPISpec struct {
+	Type       string `yaml:"type"`
+	Lifecycle  string `yaml:"lifecycle"`
+	Owner      string `yaml:"owner"`
+	System     string `yaml:"system,omitempty"`
+	Definition string `yaml:"definition"`
+}
+
+const (
+	backstageAPIVersion = "backstage.io/v1alpha1"
+	layerAnnotation     = "canopy.dev/layer"
+	defaultLifecycle    = "production"
+	unknownOwner        = "unknown"
+)
+
+// bsAPI is an API entity derived from a component's archetypes or its
+// provided interface.
+type bsAPI struct {
+	name       string
+	title      string
+	apiType    string
+	definition string
+	archetype  string // archetype the API was derived from, if any
+}
+
+// renderBackstage emits one document per component: its Component entity
+// followed by the API entities it provides.
+func renderBackstage(idx *server.ArchiveIndex, sel *selection, opts Options) ([]Document, error) {
+	cfg := opts.Config.Backstage
+	lifecycle := cfg.Lifecycle
+	if lifecycle == "" {
+		lifecycle = defaultLifecycle
+	}
+
+	names := newIdentifiers(func(r rune) bool { return alnum(r) || r == '-' || r == '.' })
+	apis := componentAPIs(idx, sel, names)
+	apiByArchetype := make(map[string]string)
+	for _, list := range apis {
+		for _, api := range list {
+			if api.archetype != "" {
+				apiByArchetype[api.archetype] = api.name
+			}
+		}
+	}
+
+	dependsOn := make(map[string][]string)
+	consumes := make(map[string][]string)
+	for _, rel := range idx.Raw.Relationships {
+		from, to := componentFor(idx, rel.From), componentFor(idx, rel.To)
+		if from == "" || to == "" || from == to || !sel.components[from] || !sel.components[to] {
+			continue
+		}
+		if api, ok := apiByArchetype[rel.To]; ok {
+			consumes[from] = appendUnique(consumes[from], "api:"+api)
+		} else {
+			dependsOn[from] = appendUnique(dependsOn[from], "component:"+names.get(to))
+		}
+	}
+
+	var docs []Document
+	for _, comp := range idx.Raw.Components {
+		if !sel.components[comp.ID] {
+			continue
+		}
+		owner := backstageOwner(idx, opts.Config, comp.ID)
+
+		spec := bsComponentSpec{
+			Type:         componentType(comp, apis[comp.ID]),
+			Lifecycle:    lifecycle,
+			Owner:        owner,
+			System:       cfg.System,
+			ConsumesAPIs: sorted(consumes[comp.ID]),
+			DependsOn:    sorted(dependsOn[comp.ID]),
+		}
+		for _, api := range apis[comp.ID] {
+			spec.ProvidesAPIs = append(spec.ProvidesAPIs, "api:"+api.name)
+		}
+		entities := []bsEntity{{
+			APIVersion: backstageAPIVersion,
+			Kind:       "Component",
+			Metadata: bsMetadata{
+				Name:        names.get(comp.ID),
+				Title:       comp.Name,
+				Description: comp.Description,
+				Tags:        backstageTags(append([]string{comp.Layer}, comp.Tags...)),
+				Links:       backstageLinks(comp.Links),
+				Annotations: map[string]string{layerAnnotation: comp.Layer},
+			},
+			Spec: spec,
+		}}
+		for _, api := range apis[comp.ID] {
+			entities = append(entities, bsEntity{
+				APIVersion: backstageAPIVersion,
+				Kind:       "API",
+				Metadata:   bsMetadata{Name: api.name, Title: api.title},
+				Spec: bsAPISpec{
+					Type:       api.apiType,
+					Lifecycle:  lifecycle,
+					Owner:      owner,
+					System:     cfg.System,
+					Definition: api.definition,
+				},
+			})
+		}
+
+		var buf bytes.Buffer
+		for i, entity := range entities {
+			if i > 0 {
+				buf.WriteString("---\n")
+			}
+			enc := yaml.NewEncoder(&buf)
+			enc.SetIndent(2)
+			if err := enc.Encode(entity); err != nil {
+				return nil, fmt.Errorf("encoding %s %s: %w", entity.Kind, entity.Metadata.Name, err)
+			}
+			enc.Close()
+		}
+		docs = append(docs, Document{Name: names.get(comp.ID), Content: buf.String()})
+	}
+	return docs, nil
+}
+
+// componentAPIs derives API entities for each selected component: one per
+// controller archetype with routes, and one for Provides.Interface.
+func componentAPIs(idx *server.ArchiveIndex, sel *selection, names *identifiers) map[string][]bsAPI {
+	apis := make(map[string][]bsAPI)
+
+	categories := make([]string, 0, len(idx.Raw.Archetypes))
+	for category := range idx.Raw.Archetypes {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		for _, arch := range idx.Raw.Archetypes[category] {
+			compID := idx.ComponentOf(arch.ID)
+			if !sel.components[compID] || len(arch.Routes) == 0 || !isController(category, arch) {
+				continue
+			}
+			apiType, definition := routeDefinition(archetypeLabel(arch.Symbol, arch.ID), arch)
+			apis[compID] = append(apis[compID], bsAPI{
+				name:       names.named("api:"+arch.ID, arch.ID),
+				title:      archetypeLabel(arch.Symbol, arch.ID),
+				apiType:    apiType,
+				definition: definition,
+				archetype:  arch.ID,
+			})
+		}
+	}
+
+	for _, comp := range idx.Raw.Components {
+		if !sel.components[comp.ID] || comp.Provides == nil || comp.Provides.Interface == "" {
+			continue
+		}
+		definition := comp.Provides.Interface + "\n"
+		for _, symbol := range comp.Provides.Symbols {
+			definition += "  " + symbol + "\n"
+		}
+		apis[comp.ID] = append(apis[comp.ID], bsAPI{
+			name:       names.named("api:"+comp.ID, comp.ID+"-interface"),
+			title:      comp.Provides.Interface,
+			apiType:    "library",
+			definition: definition,
+		})
+	}
+	return apis
+}
+
+func isController(category string, arch schema.Archetype) bool {
+	return strings.HasPrefix(strings.ToLower(category), "controller") || arch.EntryPointType != ""
+}
+
+var routeMethod = regexp.MustCompile(`^(GET|POST|PUT|PATCH|DELETE|HEAD|OPTIONS)\s+(\S+)$`)
+
+// routeDefinition builds an API definition from archetype routes: a minimal
+// OpenAPI document for HTTP routes, a plain listing for anything else.
+func routeDefinition(title string, arch schema.Archetype) (apiType, definition string) {
+	switch arch.EntryPointType {
+	case "grpc", "graphql":
+		return arch.EntryPointType, strings.Join(arch.Routes, "\n") + "\n"
+	}
+
+	paths := make(map[string]map[string]any)
+	for _, route := range arch.Routes {
+		method, path := "", route
+		if m := routeMethod.FindStringSubmatch(route); m != nil {
+			method, path = strings.ToLower(m[1]), m[2]
+		}
+		path = openAPIPath(path)
+		if paths[path] == nil {
+			paths[path] = make(map[string]any)
+		}
+		if method != "" {
+			paths[path][method] = map[string]any{
+				"responses": map[string]any{"default": map[string]any{"description": "See " + title}},
+			}
+		}
+	}
+	doc := map[string]any{
+		"openapi": "3.0.0",
+		"info":    map[string]any{"title": title, "version": "1.0.0"},
+		"paths":   paths,
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return "openapi", strings.Join(arch.Routes, "\n") + "\n"
+	}
+	return "openapi", buf.String()
+}
+
+var pathParam = regexp.MustCompile(`:([A-Za-z_][A-Za-z0-9_]*)`)
+
+// openAPIPath rewrites Express-style parameters (/users/:id) as OpenAPI
+// templates (/users/{id}).
+func openAPIPath(path string) string {
+	return pathParam.ReplaceAllString(path, "{$1}")
+}
+
+// backstageOwner resolves an owner: the component's entry in config, then
+// its CODEOWNERS owners, then the configured default.
+func backstageOwner(idx *server.ArchiveIndex, cfg schema.Config, compID string) string {
+	if owner := cfg.Backstage.Owners[compID]; owner != "" {
+		return owner
+	}
+	if o := idx.Ownership(compID); o != nil && len(o.Owners) > 0 {
+		if ref := ownerRef(o.Owners[0]); ref != "" {
+			return ref
+		}
+	}
+	if cfg.Backstage.Owner != "" {
+		return cfg.Backstage.Owner
+	}
+	return unknownOwner
+}
+
+// ownerRef turns a CODEOWNERS owner into a Backstage entity reference:
+// @org/team becomes group:team and @user becomes user:user. Email owners
+// have no catalog equivalent.
+func ownerRef(owner string) string {
+	if !strings.HasPrefix(owner, "@") {
+		return ""
+	}
+	owner = strings.TrimPrefix(owner, "@")
+	if _, team, ok := strings.Cut(owner, "/"); ok {
+		return "group:" + team
+	}
+	return "user:" + owner
+}
+
+// componentType calls deployable projects and components serving routes
+// services, and everything else a library.
+func componentType(comp schema.Component, apis []bsAPI) string {
+	if comp.Layer == appLayer || slices.ContainsFunc(apis, func(api bsAPI) bool { return api.archetype != "" }) {
+		return "service"
+	}
+	return "library"
+}
+
+var tagInvalid = regexp.MustCompile(`[^a-z0-9:+#]+`)
+
+// backstageTags lowercases tags and joins words with dashes, as the
+// catalog requires.
+func backstageTags(tags []string) []string {
+	var out []string
+	for _, tag := range tags {
+		tag = strings.Trim(tagInvalid.ReplaceAllString(strings.ToLower(tag), "-"), "-")
+		if tag != "" {
+			out = appendUnique(out, tag)
+		}
+	}
+	return out
+}
+
+func backstageLinks(links []schema.Link) []bsLink {
+	out := make([]bsLink, 0, len(links))
+	for _, link := range links {
+		title := link.Title
+		if title == "" {
+			title = link.Type
+		}
+		out = append(out, bsLink{URL: link.URL, Title: title, Type: link.Type})
+	}
+	return out
+}
+
+// componentFor maps a component or archetype ID to its component ID.
+func componentFor(idx *server.ArchiveIndex, id string) string {
+	for _, comp := range idx.Raw.Components {
+		if comp.ID == id {
+			return id
+		}
+	}
+	return idx.ComponentOf(id)
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, existing := range list {
+		if existing == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+func sorted(list []string) []string {
+	sort.Strings(list)
+	return list
+}
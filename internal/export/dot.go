@@ -0,0 +1,72 @@
+package export
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nhomble/canopy/internal/server"
+)
+
+func init() {
+	register("dot", exporter{
+		ext:       ".dot",
+		separator: "\n",
+		render: func(idx *server.ArchiveIndex, sel *selection, opts Options) ([]Document, error) {
+			return renderViews(idx, sel, opts, dotGraph, nil), nil
+		},
+	})
+}
+
+// dotGraph renders a diagram for Graphviz. Groups become clusters; edges to
+// a group attach to its first node and clip at the cluster border.
+func dotGraph(d *diagram) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", dotQuote(d.title))
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  compound=true;\n")
+	b.WriteString("  node [shape=box, style=rounded];\n")
+
+	anchors := make(map[string]string) // group ID → node ID edges attach to
+	for _, g := range d.groups {
+		anchors[g.id] = g.nodes[0].id
+		fmt.Fprintf(&b, "  subgraph %s {\n", dotQuote("cluster_"+g.id))
+		fmt.Fprintf(&b, "    label=%s;\n", dotQuote(g.label))
+		for _, n := range g.nodes {
+			fmt.Fprintf(&b, "    %s [label=%s];\n", dotQuote(n.id), dotQuote(n.label))
+		}
+		b.WriteString("  }\n")
+	}
+	for _, n := range d.nodes {
+		fmt.Fprintf(&b, "  %s [label=%s];\n", dotQuote(n.id), dotQuote(n.label))
+	}
+
+	for _, e := range d.edges {
+		var attrs []string
+		from, to := e.from, e.to
+		if anchor, ok := anchors[from]; ok {
+			from = anchor
+			attrs = append(attrs, "ltail="+dotQuote("cluster_"+e.from))
+		}
+		if anchor, ok := anchors[to]; ok {
+			to = anchor
+			attrs = append(attrs, "lhead="+dotQuote("cluster_"+e.to))
+		}
+		if e.label != "" {
+			attrs = append(attrs, "label="+dotQuote(e.label))
+		}
+		if e.async {
+			attrs = append(attrs, "style=dashed")
+		}
+		fmt.Fprintf(&b, "  %s -> %s", dotQuote(from), dotQuote(to))
+		if len(attrs) > 0 {
+			fmt.Fprintf(&b, " [%s]", strings.Join(attrs, ", "))
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
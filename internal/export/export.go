@@ -0,0 +1,202 @@
+// Package export renders an architecture index in formats other tools
+// understand: diagram languages, architecture models and catalogs.
+package export
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/nhomble/canopy/internal/schema"
+	"github.com/nhomble/canopy/internal/server"
+)
+
+// Views select which diagram a diagram format renders.
+const (
+	ViewComponents = "components"
+	ViewArchetypes = "archetypes"
+	ViewFlows      = "flows"
+)
+
+// Options control what an exporter includes.
+type Options struct {
+	View       string   // ViewComponents (default), ViewArchetypes or ViewFlows
+	Layers     []string // only components in these layers
+	Components []string // only these components
+	Flows      []string // only elements on these flows, and only these flows
+
+	// RepoRoot, when set, lets model exporters detect separately built
+	// projects by their manifests (go.mod, package.json, ...).
+	RepoRoot string
+	// Config supplies settings the index lacks, such as catalog owners.
+	Config schema.Config
+}
+
+// Document is one rendered output file.
+type Document struct {
+	Name    string // file name without extension, e.g. "components" or "flow-checkout"
+	Content string
+	// Warnings report where the document falls short of what its format
+	// promises, such as an HTML page loading libraries from a CDN.
+	Warnings []string
+}
+
+// exporter renders an index in one format.
+type exporter struct {
+	ext       string // file extension, including the dot
+	separator string // placed between documents written to a single stream
+	render    func(idx *server.ArchiveIndex, sel *selection, opts Options) ([]Document, error)
+}
+
+var exporters = map[string]exporter{}
+
+func register(format string, e exporter) {
+	if _, dup := exporters[format]; dup {
+		panic("export: duplicate format " + format)
+	}
+	exporters[format] = e
+}
+
+// Formats returns the supported format names, sorted.
+func Formats() []string {
+	names := make([]string, 0, len(exporters))
+	for name := range exporters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Extension returns the file extension used for a format.
+func Extension(format string) string {
+	return exporters[format].ext
+}
+
+// Export renders the index in the given format.
+func Export(idx *server.ArchiveIndex, format string, opts Options) ([]Document, error) {
+	e, ok := exporters[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q (want %s)", format, strings.Join(Formats(), ", "))
+	}
+	if opts.View == "" {
+		opts.View = ViewComponents
+	}
+	switch opts.View {
+	case ViewComponents, ViewArchetypes, ViewFlows:
+	default:
+		return nil, fmt.Errorf("unknown view %q (want %s, %s, or %s)", opts.View, ViewComponents, ViewArchetypes, ViewFlows)
+	}
+	sel, err := newSelection(idx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return e.render(idx, sel, opts)
+}
+
+// Join concatenates documents for output to a single stream.
+func Join(format string, docs []Document) string {
+	parts := make([]string, len(docs))
+	for i, doc := range docs {
+		parts[i] = doc.Content
+	}
+	return strings.Join(parts, exporters[format].separator)
+}
+
+// selection is the subset of the index that passes the Options filters.
+type selection struct {
+	components map[string]bool
+	archetypes map[string]bool
+	flows      []schema.Flow
+}
+
+func newSelection(idx *server.ArchiveIndex, opts Options) (*selection, error) {
+	layers := make(map[string]bool)
+	for _, comp := range idx.Raw.Components {
+		layers[comp.Layer] = true
+	}
+	for _, layer := range opts.Layers {
+		if !layers[layer] {
+			return nil, fmt.Errorf("unknown layer: %s", layer)
+		}
+	}
+	for _, id := range opts.Components {
+		if !slices.ContainsFunc(idx.Raw.Components, func(c schema.Component) bool { return c.ID == id }) {
+			return nil, fmt.Errorf("unknown component: %s", id)
+		}
+	}
+
+	sel := &selection{
+		components: make(map[string]bool),
+		archetypes: make(map[string]bool),
+	}
+
+	onFlows := make(map[string]bool) // element IDs visited by the selected flows
+	for _, flow := range idx.Raw.Flows {
+		if len(opts.Flows) > 0 && !slices.Contains(opts.Flows, flow.ID) {
+			continue
+		}
+		sel.flows = append(sel.flows, flow)
+		for _, ref := range flow.Refs() {
+			onFlows[ref] = true
+		}
+	}
+	for _, id := range opts.Flows {
+		if !slices.ContainsFunc(sel.flows, func(f schema.Flow) bool { return f.ID == id }) {
+			return nil, fmt.Errorf("unknown flow: %s", id)
+		}
+	}
+
+	for _, comp := range idx.Raw.Components {
+		if len(opts.Layers) > 0 && !slices.Contains(opts.Layers, comp.Layer) {
+			continue
+		}
+		if len(opts.Components) > 0 && !slices.Contains(opts.Components, comp.ID) {
+			continue
+		}
+		sel.components[comp.ID] = true
+	}
+	for _, archetypes := range idx.Raw.Archetypes {
+		for _, arch := range archetypes {
+			if !sel.components[idx.ComponentOf(arch.ID)] {
+				continue
+			}
+			if len(opts.Flows) > 0 && !onFlows[arch.ID] {
+				continue
+			}
+			sel.archetypes[arch.ID] = true
+		}
+	}
+
+	if len(opts.Flows) > 0 {
+		// Keep only components a selected flow passes through.
+		for id := range sel.components {
+			touched := onFlows[id]
+			for archID := range sel.archetypes {
+				if idx.ComponentOf(archID) == id {
+					touched = true
+				}
+			}
+			if !touched {
+				delete(sel.components, id)
+			}
+		}
+	}
+
+	if len(opts.Layers) > 0 || len(opts.Components) > 0 {
+		// Keep only flows that pass through a selected element.
+		flows := sel.flows[:0]
+		for _, flow := range sel.flows {
+			if slices.ContainsFunc(flow.Refs(), sel.has) {
+				flows = append(flows, flow)
+			}
+		}
+		sel.flows = flows
+	}
+	return sel, nil
+}
+
+// has reports whether a component or archetype ID is selected.
+func (s *selection) has(id string) bool {
+	return s.components[id] || s.archetypes[id]
+}
@@ -0,0 +1,328 @@
+package export
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nhomble/canopy/internal/schema"
+	"github.com/nhomble/canopy/internal/server"
+)
+
+func testIndex() *server.ArchiveIndex {
+	return server.NewIndex(&schema.ArchIndex{
+		RepoID: "shop",
+		Components: []schema.Component{
+			{ID: "api", Name: "API", Layer: "edge", CodeRefs: []string{"api/**"}},
+			{ID: "billing", Name: "Billing", Layer: "core", CodeRefs: []string{"billing/**"}},
+			{ID: "mailer", Name: "Mailer", Layer: "infra", CodeRefs: []string{"mail/**"}},
+		},
+		Archetypes: map[string][]schema.Archetype{
+			"controllers": {{ID: "checkout-ctl", File: "api/checkout.go", Symbol: "CheckoutController"}},
+			"services":    {{ID: "charge-svc", File: "billing/charge.go", Symbol: "ChargeService"}},
+			"adapters":    {{ID: "smtp", File: "mail/smtp.go"}},
+		},
+		Relationships: []schema.Relationship{
+			{From: "checkout-ctl", To: "charge-svc", Type: "calls", Protocol: "grpc", Flow: "checkout"},
+			{From: "charge-svc", To: "smtp", Type: "produces", Mode: schema.ModeAsync},
+		},
+		Flows: []schema.Flow{
+			{ID: "checkout", Name: "Checkout", Graph: &schema.FlowGraph{
+				Steps: []schema.FlowStep{
+					{ID: "in", Ref: "checkout-ctl"},
+					{ID: "charge", Ref: "charge-svc", Parallel: "fanout"},
+					{ID: "mail", Ref: "smtp", Parallel: "fanout"},
+					{ID: "fail", Ref: "checkout-ctl", Label: "return 402"},
+				},
+				Transitions: []schema.FlowTransition{
+					{From: "in", To: "charge"},
+					{From: "in", To: "mail"},
+					{From: "charge", To: "fail", Label: "on error", Condition: "card declined"},
+				},
+			}},
+			{ID: "receipt", Name: "Receipt", Steps: []string{"charge-svc", "smtp"}},
+		},
+	})
+}
+
+func render(t *testing.T, format string, opts Options) string {
+	t.Helper()
+	docs, err := Export(testIndex(), format, opts)
+	if err != nil {
+		t.Fatalf("Export(%s): %v", format, err)
+	}
+	return Join(format, docs)
+}
+
+func assertContains(t *testing.T, out string, wants ...string) {
+	t.Helper()
+	for _, want := range wants {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestMermaidComponents(t *testing.T) {
+	out := render(t, "mermaid", Options{})
+	assertContains(t, out,
+		"flowchart LR",
+		`subgraph layer_core["core"]`,
+		`billing["Billing"]`,
+		`api -->|"calls [grpc]"| billing`,
+		`billing -.->|"produces"| mailer`,
+	)
+}
+
+func TestMermaidFlowSequence(t *testing.T) {
+	out := render(t, "mermaid", Options{View: ViewFlows, Flows: []string{"checkout"}})
+	assertContains(t, out,
+		"sequenceDiagram",
+		"participant checkout_ctl as CheckoutController",
+		"  par\n    checkout_ctl->>charge_svc: calls\n  and\n    checkout_ctl->>smtp: smtp\n  end\n",
+		"  opt card declined\n    charge_svc->>checkout_ctl: on error return 402\n  end\n",
+	)
+	if strings.Contains(out, "Receipt") {
+		t.Error("expected only the checkout flow")
+	}
+}
+
+func TestMermaidFlowSequenceLabelsEveryMessage(t *testing.T) {
+	idx, err := server.LoadIndex("../../testdata/golden/index.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs, err := Export(idx, "mermaid", Options{View: ViewFlows, Flows: []string{"create-customer"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := docs[0].Content
+	assertContains(t, out, "password_encode_adapter->>create_customer_adapter: create-customer-adapter\n")
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasSuffix(line, ": ") {
+			t.Errorf("expected every message to have a label, got %q", line)
+		}
+	}
+}
+
+func TestFlowDocumentNamesAreSafe(t *testing.T) {
+	idx := testIndex()
+	idx.Raw.Flows[0].ID = "../checkout"
+	docs, err := Export(server.NewIndex(idx.Raw), "mermaid", Options{View: ViewFlows})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if docs[0].Name != "flow-~2e.~2fcheckout" {
+		t.Errorf("expected a slugged flow file name, got %q", docs[0].Name)
+	}
+}
+
+func TestPlantUMLArchetypes(t *testing.T) {
+	out := render(t, "plantuml", Options{View: ViewArchetypes})
+	assertContains(t, out,
+		"@startuml",
+		`package "Billing" as billing {`,
+		`component "ChargeService" as charge_svc`,
+		"charge_svc ..> smtp : produces",
+		"@enduml",
+	)
+}
+
+func TestDOTFlowGraph(t *testing.T) {
+	out := render(t, "dot", Options{View: ViewFlows, Flows: []string{"checkout"}})
+	assertContains(t, out,
+		`subgraph "cluster_checkout-par-fanout"`,
+		`"checkout-fail" [label="CheckoutController: return 402"]`,
+		`"checkout-charge" -> "checkout-fail" [label="on error: card declined"]`,
+	)
+}
+
+func TestFilters(t *testing.T) {
+	out := render(t, "mermaid", Options{Layers: []string{"core", "infra"}})
+	if strings.Contains(out, "API") {
+		t.Errorf("layer filter kept the edge layer:\n%s", out)
+	}
+	assertContains(t, out, "billing -.->")
+
+	out = render(t, "mermaid", Options{View: ViewArchetypes, Flows: []string{"receipt"}})
+	if strings.Contains(out, "CheckoutController") {
+		t.Errorf("flow filter kept an element off the flow:\n%s", out)
+	}
+
+	docs, err := Export(testIndex(), "mermaid", Options{View: ViewFlows, Components: []string{"api"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || docs[0].Name != "flow-checkout" {
+		t.Errorf("expected only flows through api, got %+v", docs)
+	}
+
+	for _, opts := range []Options{
+		{Layers: []string{"nope"}},
+		{Components: []string{"nope"}},
+		{Flows: []string{"nope"}},
+		{View: "nope"},
+	} {
+		if _, err := Export(testIndex(), "mermaid", opts); err == nil {
+			t.Errorf("expected error for %+v", opts)
+		}
+	}
+	if _, err := Export(testIndex(), "visio", Options{}); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestStructurizrWorkspace(t *testing.T) {
+	idx := testIndex()
+	idx.Raw.Components = append(idx.Raw.Components,
+		schema.Component{ID: "admin", Name: "Admin UI", Layer: "app", CodeRefs: []string{"admin/**"}},
+		schema.Component{ID: "worker", Name: "Worker", Layer: "jobs", CodeRefs: []string{"worker/**"},
+			Links: []schema.Link{{Type: "runbook", URL: "https://runbooks/worker"}}},
+	)
+	idx.Raw.Archetypes["adapters"][0].TargetService = "SendGrid"
+	idx.Raw.Relationships = append(idx.Raw.Relationships,
+		schema.Relationship{From: "admin", To: "api", Type: "calls", Protocol: "http"},
+		schema.Relationship{From: "worker", To: "billing", Type: "calls"},
+	)
+	idx = server.NewIndex(idx.Raw)
+
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "worker"), 0755)
+	os.WriteFile(filepath.Join(root, "worker", "go.mod"), []byte("module worker\n"), 0644)
+
+	docs, err := Export(idx, "structurizr", Options{RepoRoot: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := docs[0].Content
+	assertContains(t, out,
+		`shop = softwareSystem "shop" {`,
+		`admin = container "Admin UI" "" "" "app"`,
+		`worker = container "Worker" "" "" "jobs" {`,
+		`url "https://runbooks/worker"`,
+		`main = container "shop" "" "" "" {`,
+		`billing = component "Billing" "" "" "core"`,
+		`SendGrid = softwareSystem "SendGrid" "" "External"`,
+		`mailer -> SendGrid "uses" "" "uses"`,
+		`api -> billing "calls" "grpc" "calls"`,
+		`billing -> mailer "produces" "" "produces,async"`,
+		`admin -> api "calls" "http" "calls"`,
+		`dynamic main "flow-checkout" "Checkout" {`,
+		`billing -> api "on error: card declined"`,
+	)
+	if strings.Count(out, "{") != strings.Count(out, "}") {
+		t.Errorf("unbalanced braces:\n%s", out)
+	}
+}
+
+func TestBackstageCatalog(t *testing.T) {
+	idx := testIndex()
+	idx.Raw.Archetypes["controllers"][0].Routes = []string{"POST /checkout", "/orders/:id"}
+	idx.Raw.Components[1].Provides = &schema.Provides{Interface: "Charger", Symbols: []string{"Charge"}}
+	idx.Raw.Components[1].Tags = []string{"Payments Core"}
+	idx = server.NewIndex(idx.Raw)
+
+	cfg := schema.DefaultConfig("shop")
+	cfg.Backstage = schema.BackstageConfig{
+		Owner:  "group:platform",
+		Owners: map[string]string{"billing": "group:payments"},
+		System: "shop",
+	}
+	docs, err := Export(idx, "backstage", Options{Config: cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 3 || docs[0].Name != "api" {
+		t.Fatalf("expected one document per component, got %d", len(docs))
+	}
+
+	api := docs[0].Content
+	assertContains(t, api,
+		"kind: Component\nmetadata:\n  name: api\n  title: API\n",
+		"  type: service\n",
+		"  owner: group:platform\n",
+		"  system: shop\n",
+		"  providesApis:\n    - api:checkout-ctl\n",
+		"  dependsOn:\n    - component:billing\n",
+		"kind: API\nmetadata:\n  name: checkout-ctl\n",
+		"      /checkout:\n        post:\n",
+		"      /orders/{id}: {}\n",
+	)
+
+	billing := docs[1].Content
+	assertContains(t, billing,
+		"    - payments-core\n",
+		"  type: library\n",
+		"  owner: group:payments\n",
+		"  name: billing-interface\n",
+	)
+
+	all := Join("backstage", docs)
+	if got := strings.Count(all, "apiVersion: backstage.io/v1alpha1"); got != 5 {
+		t.Errorf("expected 5 entities in the multi-document output, got %d", got)
+	}
+}
+
+func TestOwnerRef(t *testing.T) {
+	tests := map[string]string{
+		"@acme/payments":    "group:payments",
+		"@alice":            "user:alice",
+		"alice@example.com": "",
+	}
+	for owner, want := range tests {
+		if got := ownerRef(owner); got != want {
+			t.Errorf("ownerRef(%q) = %q, want %q", owner, got, want)
+		}
+	}
+}
+
+func TestHTMLSelection(t *testing.T) {
+	out := render(t, "html", Options{Flows: []string{"receipt"}})
+	assertContains(t, out,
+		"<!DOCTYPE html>",
+		`window.CANOPY_GRAPH = {"repo_id":"shop"`,
+		`"id":"charge-svc"`,
+	)
+	graph := out[strings.Index(out, "window.CANOPY_GRAPH"):]
+	graph = graph[:strings.Index(graph, "</script>")]
+	for _, unwanted := range []string{`"id":"api"`, `"id":"checkout"`} {
+		if strings.Contains(graph, unwanted) {
+			t.Errorf("expected %s filtered out of the payload:\n%s", unwanted, graph)
+		}
+	}
+
+	docs, err := Export(testIndex(), "html", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := server.MissingVendorScripts()
+	if warned := len(docs[0].Warnings) > 0; warned != (len(missing) > 0) {
+		t.Errorf("expected a warning exactly when scripts are missing (%v), got %v", missing, docs[0].Warnings)
+	}
+}
+
+func TestStructurizrGoldenDynamicViews(t *testing.T) {
+	idx, err := server.LoadIndex("../../testdata/golden/index.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs, err := Export(idx, "structurizr", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := docs[0].Content
+	for _, flow := range idx.Raw.Flows {
+		assertContains(t, out, `"flow-`+flow.ID+`"`)
+	}
+	assertContains(t, out,
+		`dynamic main "flow-create-customer" "`,
+		`description "Runs within customer-service: customer-controller -> create-customer-service -> password-encode-adapter -> create-customer-adapter"`,
+	)
+	if len(docs[0].Warnings) != len(idx.Raw.Flows) {
+		t.Errorf("expected a warning per flow within one component, got %q", docs[0].Warnings)
+	}
+	if strings.Count(out, "{") != strings.Count(out, "}") {
+		t.Errorf("unbalanced braces:\n%s", out)
+	}
+}
@@ -0,0 +1,69 @@
+package export
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nhomble/canopy/internal/schema"
+	"github.com/nhomble/canopy/internal/server"
+)
+
+func init() {
+	register("html", exporter{
+		ext:    ".html",
+		render: renderHTML,
+	})
+}
+
+// renderHTML writes the web UI as one self-contained page, with the graph
+// payload narrowed to the selection. Views apply in the page itself. A
+// build without the vendored libraries yields a page that loads them from
+// unpkg.com, with a warning saying so.
+func renderHTML(idx *server.ArchiveIndex, sel *selection, opts Options) ([]Document, error) {
+	payload := selectPayload(idx.BuildGraphPayload(), sel)
+	html, missing, err := server.StandaloneHTML(payload)
+	if err != nil {
+		return nil, err
+	}
+	doc := Document{Name: "canopy", Content: string(html)}
+	if len(missing) > 0 {
+		doc.Warnings = append(doc.Warnings, fmt.Sprintf("%s not vendored in this build (run make vendor-js); the page is not self-contained and loads them from unpkg.com", strings.Join(missing, ", ")))
+	}
+	return []Document{doc}, nil
+}
+
+func selectPayload(p *server.GraphPayload, sel *selection) *server.GraphPayload {
+	// Empty lists stay empty arrays in JSON; the UI iterates them directly.
+	out := &server.GraphPayload{
+		RepoID:         p.RepoID,
+		Patterns:       p.Patterns,
+		Components:     []server.GraphComponent{},
+		Relationships:  []schema.Relationship{},
+		ComponentEdges: []server.ComponentEdge{},
+		Flows:          append([]schema.Flow{}, sel.flows...),
+	}
+	for _, comp := range p.Components {
+		if !sel.components[comp.ID] {
+			continue
+		}
+		archetypes := comp.Archetypes[:0:0]
+		for _, arch := range comp.Archetypes {
+			if sel.archetypes[arch.ID] {
+				archetypes = append(archetypes, arch)
+			}
+		}
+		comp.Archetypes = archetypes
+		out.Components = append(out.Components, comp)
+	}
+	for _, rel := range p.Relationships {
+		if sel.has(rel.From) && sel.has(rel.To) {
+			out.Relationships = append(out.Relationships, rel)
+		}
+	}
+	for _, e := range p.ComponentEdges {
+		if sel.components[e.From] && sel.components[e.To] {
+			out.ComponentEdges = append(out.ComponentEdges, e)
+		}
+	}
+	return out
+}
@@ -0,0 +1,98 @@
+package export
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nhomble/canopy/internal/server"
+)
+
+func init() {
+	register("mermaid", exporter{
+		ext:       ".mmd",
+		separator: "\n",
+		render: func(idx *server.ArchiveIndex, sel *selection, opts Options) ([]Document, error) {
+			return renderViews(idx, sel, opts, mermaidGraph, mermaidSequence), nil
+		},
+	})
+}
+
+func mermaidGraph(d *diagram) string {
+	ids := newIdentifiers(alnum)
+	var b strings.Builder
+	mermaidTitle(&b, d.title)
+	b.WriteString("flowchart LR\n")
+	for _, g := range d.groups {
+		fmt.Fprintf(&b, "  subgraph %s[\"%s\"]\n", ids.get(g.id), mermaidText(g.label))
+		for _, n := range g.nodes {
+			fmt.Fprintf(&b, "    %s[\"%s\"]\n", ids.get(n.id), mermaidText(n.label))
+		}
+		b.WriteString("  end\n")
+	}
+	for _, n := range d.nodes {
+		fmt.Fprintf(&b, "  %s[\"%s\"]\n", ids.get(n.id), mermaidText(n.label))
+	}
+	for _, e := range d.edges {
+		arrow := "-->"
+		if e.async {
+			arrow = "-.->"
+		}
+		if e.label != "" {
+			arrow += fmt.Sprintf("|\"%s\"|", mermaidText(e.label))
+		}
+		fmt.Fprintf(&b, "  %s %s %s\n", ids.get(e.from), arrow, ids.get(e.to))
+	}
+	return b.String()
+}
+
+func mermaidSequence(s *sequence) string {
+	ids := newIdentifiers(alnum)
+	var b strings.Builder
+	mermaidTitle(&b, s.title)
+	b.WriteString("sequenceDiagram\n")
+	for _, p := range s.participants {
+		fmt.Fprintf(&b, "  participant %s as %s\n", ids.get(p.id), mermaidText(p.label))
+	}
+	for _, block := range messageBlocks(s.messages) {
+		indent := "  "
+		if len(block) > 1 {
+			b.WriteString("  par\n")
+			indent = "    "
+		}
+		for i, m := range block {
+			if i > 0 {
+				b.WriteString("  and\n")
+			}
+			msgIndent := indent
+			if m.condition != "" {
+				fmt.Fprintf(&b, "%sopt %s\n", indent, mermaidText(m.condition))
+				msgIndent += "  "
+			}
+			arrow := "->>"
+			if m.async {
+				arrow = "-)"
+			}
+			fmt.Fprintf(&b, "%s%s%s%s: %s\n", msgIndent, ids.get(m.from), arrow, ids.get(m.to), mermaidText(m.label))
+			if m.condition != "" {
+				fmt.Fprintf(&b, "%send\n", indent)
+			}
+		}
+		if len(block) > 1 {
+			b.WriteString("  end\n")
+		}
+	}
+	return b.String()
+}
+
+func mermaidTitle(b *strings.Builder, title string) {
+	if title != "" {
+		fmt.Fprintf(b, "---\ntitle: %s\n---\n", mermaidText(title))
+	}
+}
+
+// mermaidText escapes characters that end a label or statement.
+var mermaidText = strings.NewReplacer(
+	`"`, "#quot;",
+	";", "#59;",
+	"\n", " ",
+).Replace
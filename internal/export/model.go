@@ -0,0 +1,375 @@
+package export
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nhomble/canopy/internal/schema"
+	"github.com/nhomble/canopy/internal/server"
+)
+
+// diagram is a format-neutral box-and-arrow graph. Each renderer turns it
+// into its own syntax.
+type diagram struct {
+	title  string
+	groups []group
+	nodes  []node // nodes outside any group
+	edges  []edge
+}
+
+// group is a labelled cluster of nodes (a layer, component or parallel
+// group). Edges may point at a group as a whole.
+type group struct {
+	id    string
+	label string
+	nodes []node
+}
+
+type node struct {
+	id    string
+	label string
+}
+
+type edge struct {
+	from, to string
+	label    string
+	async    bool
+}
+
+// sequence is a format-neutral sequence diagram for one flow.
+type sequence struct {
+	title        string
+	participants []node
+	messages     []message
+}
+
+type message struct {
+	from, to  string
+	label     string
+	async     bool
+	condition string // wraps the message in an opt block
+	parallel  string // consecutive messages sharing a group run in parallel
+}
+
+// componentDiagram draws selected components grouped by layer, connected
+// by the aggregated component edges of the /graph payload.
+func componentDiagram(idx *server.ArchiveIndex, sel *selection) *diagram {
+	payload := idx.BuildGraphPayload()
+	d := &diagram{title: idx.Raw.RepoID}
+
+	byLayer := make(map[string]*group)
+	var layers []string
+	for _, comp := range payload.Components {
+		if !sel.components[comp.ID] {
+			continue
+		}
+		g, ok := byLayer[comp.Layer]
+		if !ok {
+			g = &group{id: "layer-" + comp.Layer, label: comp.Layer}
+			byLayer[comp.Layer] = g
+			layers = append(layers, comp.Layer)
+		}
+		g.nodes = append(g.nodes, node{id: comp.ID, label: comp.Name})
+	}
+	for _, layer := range layers {
+		d.groups = append(d.groups, *byLayer[layer])
+	}
+
+	for _, e := range payload.ComponentEdges {
+		if !sel.components[e.From] || !sel.components[e.To] {
+			continue
+		}
+		label := strings.Join(e.Types, ", ")
+		if len(e.Protocols) > 0 {
+			label += " [" + strings.Join(e.Protocols, ", ") + "]"
+		}
+		d.edges = append(d.edges, edge{
+			from:  e.From,
+			to:    e.To,
+			label: label,
+			async: len(e.Modes) == 1 && e.Modes[0] == schema.ModeAsync,
+		})
+	}
+	sortEdges(d.edges)
+	return d
+}
+
+// archetypeDiagram draws selected archetypes inside their components,
+// connected by the raw relationships. Components without selected
+// archetypes are drawn as plain nodes.
+func archetypeDiagram(idx *server.ArchiveIndex, sel *selection) *diagram {
+	payload := idx.BuildGraphPayload()
+	d := &diagram{title: idx.Raw.RepoID}
+
+	for _, comp := range payload.Components {
+		if !sel.components[comp.ID] {
+			continue
+		}
+		g := group{id: comp.ID, label: comp.Name}
+		for _, arch := range comp.Archetypes {
+			if sel.archetypes[arch.ID] {
+				g.nodes = append(g.nodes, node{id: arch.ID, label: archetypeLabel(arch.Symbol, arch.ID)})
+			}
+		}
+		if len(g.nodes) == 0 {
+			d.nodes = append(d.nodes, node{id: comp.ID, label: comp.Name})
+			continue
+		}
+		sort.Slice(g.nodes, func(i, j int) bool { return g.nodes[i].id < g.nodes[j].id })
+		d.groups = append(d.groups, g)
+	}
+
+	for _, rel := range idx.Raw.Relationships {
+		if !sel.has(rel.From) || !sel.has(rel.To) {
+			continue
+		}
+		d.edges = append(d.edges, relationshipEdge(rel))
+	}
+	sortEdges(d.edges)
+	return d
+}
+
+// flowDiagram draws a flow as a graph of its steps, for formats without
+// sequence diagrams. Parallel groups become clusters.
+func flowDiagram(idx *server.ArchiveIndex, flow *schema.Flow) *diagram {
+	g := flow.Normalized()
+	d := &diagram{title: flow.Name}
+
+	byParallel := make(map[string]*group)
+	var parallels []string
+	for _, step := range g.Steps {
+		n := node{id: flow.ID + "-" + step.ID, label: stepLabel(idx, step)}
+		if step.Parallel == "" {
+			d.nodes = append(d.nodes, n)
+			continue
+		}
+		pg, ok := byParallel[step.Parallel]
+		if !ok {
+			pg = &group{id: flow.ID + "-par-" + step.Parallel, label: "parallel: " + step.Parallel}
+			byParallel[step.Parallel] = pg
+			parallels = append(parallels, step.Parallel)
+		}
+		pg.nodes = append(pg.nodes, n)
+	}
+	for _, p := range parallels {
+		d.groups = append(d.groups, *byParallel[p])
+	}
+
+	for _, t := range g.Transitions {
+		from, to := g.Step(t.From), g.Step(t.To)
+		if from == nil || to == nil {
+			continue
+		}
+		label := transitionLabel(t)
+		rel := flowRelationship(idx, flow.ID, from.Ref, to.Ref)
+		if label == "" && rel != nil {
+			label = rel.Type
+		}
+		d.edges = append(d.edges, edge{
+			from:  flow.ID + "-" + t.From,
+			to:    flow.ID + "-" + t.To,
+			label: label,
+			async: rel != nil && rel.Mode == schema.ModeAsync,
+		})
+	}
+	return d
+}
+
+// flowSequence builds a sequence diagram from a flow's transitions, taking
+// message labels from the relationships tagged with the flow.
+func flowSequence(idx *server.ArchiveIndex, flow *schema.Flow) *sequence {
+	g := flow.Normalized()
+	s := &sequence{title: flow.Name}
+	for _, ref := range flow.Refs() {
+		s.participants = append(s.participants, node{id: ref, label: elementLabel(idx, ref)})
+	}
+
+	for _, t := range g.Transitions {
+		from, to := g.Step(t.From), g.Step(t.To)
+		if from == nil || to == nil {
+			continue
+		}
+		m := message{from: from.Ref, to: to.Ref, condition: t.Condition, parallel: to.Parallel}
+		rel := flowRelationship(idx, flow.ID, from.Ref, to.Ref)
+		switch {
+		case to.Label != "":
+			m.label = to.Label
+		case rel != nil && rel.Description != "":
+			m.label = rel.Description
+		case rel != nil:
+			m.label = rel.Type
+		default:
+			m.label = to.Ref
+		}
+		if t.Label != "" {
+			m.label = strings.TrimSpace(t.Label + " " + m.label)
+		}
+		if rel != nil {
+			m.async = rel.Mode == schema.ModeAsync
+		}
+		s.messages = append(s.messages, m)
+	}
+	return s
+}
+
+// flowRelationship finds the relationship behind a flow transition,
+// preferring one tagged with the flow.
+func flowRelationship(idx *server.ArchiveIndex, flowID, from, to string) *schema.Relationship {
+	var fallback *schema.Relationship
+	for i, rel := range idx.Raw.Relationships {
+		if rel.From != from || rel.To != to {
+			continue
+		}
+		if rel.Flow == flowID {
+			return &idx.Raw.Relationships[i]
+		}
+		if fallback == nil {
+			fallback = &idx.Raw.Relationships[i]
+		}
+	}
+	return fallback
+}
+
+func relationshipEdge(rel schema.Relationship) edge {
+	label := rel.Type
+	if rel.Protocol != "" {
+		label += " [" + rel.Protocol + "]"
+	}
+	return edge{from: rel.From, to: rel.To, label: label, async: rel.Mode == schema.ModeAsync}
+}
+
+func transitionLabel(t schema.FlowTransition) string {
+	switch {
+	case t.Label != "" && t.Condition != "":
+		return t.Label + ": " + t.Condition
+	case t.Condition != "":
+		return t.Condition
+	}
+	return t.Label
+}
+
+func stepLabel(idx *server.ArchiveIndex, step schema.FlowStep) string {
+	if step.Label != "" {
+		return elementLabel(idx, step.Ref) + ": " + step.Label
+	}
+	return elementLabel(idx, step.Ref)
+}
+
+// elementLabel returns a display name for a component or archetype ID.
+func elementLabel(idx *server.ArchiveIndex, id string) string {
+	for _, comp := range idx.Raw.Components {
+		if comp.ID == id {
+			return comp.Name
+		}
+	}
+	for _, archetypes := range idx.Raw.Archetypes {
+		for _, arch := range archetypes {
+			if arch.ID == id {
+				return archetypeLabel(arch.Symbol, arch.ID)
+			}
+		}
+	}
+	return id
+}
+
+func archetypeLabel(symbol, id string) string {
+	if symbol != "" {
+		return symbol
+	}
+	return id
+}
+
+func sortEdges(edges []edge) {
+	sort.SliceStable(edges, func(i, j int) bool {
+		if edges[i].from != edges[j].from {
+			return edges[i].from < edges[j].from
+		}
+		return edges[i].to < edges[j].to
+	})
+}
+
+// identifiers maps element IDs to identifiers that are valid in a diagram
+// language, keeping them unique after sanitizing.
+type identifiers struct {
+	valid func(r rune) bool
+	byID  map[string]string
+	used  map[string]bool
+}
+
+func newIdentifiers(valid func(r rune) bool) *identifiers {
+	return &identifiers{valid: valid, byID: make(map[string]string), used: make(map[string]bool)}
+}
+
+func (ids *identifiers) get(id string) string {
+	return ids.named(id, id)
+}
+
+// named is get with a preferred identifier other than the ID itself.
+func (ids *identifiers) named(id, name string) string {
+	if s, ok := ids.byID[id]; ok {
+		return s
+	}
+	s := strings.Map(func(r rune) rune {
+		if ids.valid(r) {
+			return r
+		}
+		return '_'
+	}, name)
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		s = "_" + s
+	}
+	base := s
+	for n := 2; ids.used[s]; n++ {
+		s = base + "_" + strconv.Itoa(n)
+	}
+	ids.byID[id] = s
+	ids.used[s] = true
+	return s
+}
+
+func alnum(r rune) bool {
+	return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
+
+// renderViews renders the view selected in opts with the given renderers.
+// Flows use renderSequence when the format has sequence diagrams and
+// otherwise fall back to renderGraph on a flow diagram.
+func renderViews(idx *server.ArchiveIndex, sel *selection, opts Options,
+	renderGraph func(*diagram) string, renderSequence func(*sequence) string) []Document {
+	switch opts.View {
+	case ViewArchetypes:
+		return []Document{{Name: ViewArchetypes, Content: renderGraph(archetypeDiagram(idx, sel))}}
+	case ViewFlows:
+		docs := make([]Document, 0, len(sel.flows))
+		for i := range sel.flows {
+			flow := &sel.flows[i]
+			var content string
+			if renderSequence != nil {
+				content = renderSequence(flowSequence(idx, flow))
+			} else {
+				content = renderGraph(flowDiagram(idx, flow))
+			}
+			docs = append(docs, Document{Name: "flow-" + schema.FileSlug(flow.ID), Content: content})
+		}
+		return docs
+	}
+	return []Document{{Name: ViewComponents, Content: renderGraph(componentDiagram(idx, sel))}}
+}
+
+// messageBlocks splits messages into runs: consecutive messages sharing a
+// parallel group form one run, every other message is a run of its own.
+func messageBlocks(messages []message) [][]message {
+	var blocks [][]message
+	for i := 0; i < len(messages); {
+		j := i + 1
+		if p := messages[i].parallel; p != "" {
+			for j < len(messages) && messages[j].parallel == p {
+				j++
+			}
+		}
+		blocks = append(blocks, messages[i:j])
+		i = j
+	}
+	return blocks
+}
@@ -0,0 +1,105 @@
+package export
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nhomble/canopy/internal/server"
+)
+
+func init() {
+	register("plantuml", exporter{
+		ext:       ".puml",
+		separator: "\n",
+		render: func(idx *server.ArchiveIndex, sel *selection, opts Options) ([]Document, error) {
+			return renderViews(idx, sel, opts, plantumlGraph, plantumlSequence), nil
+		},
+	})
+}
+
+func plantumlGraph(d *diagram) string {
+	ids := newIdentifiers(alnum)
+	var b strings.Builder
+	b.WriteString("@startuml\n")
+	plantumlTitle(&b, d.title)
+	b.WriteString("left to right direction\n")
+	for _, g := range d.groups {
+		fmt.Fprintf(&b, "package \"%s\" as %s {\n", plantumlText(g.label), ids.get(g.id))
+		for _, n := range g.nodes {
+			fmt.Fprintf(&b, "  component \"%s\" as %s\n", plantumlText(n.label), ids.get(n.id))
+		}
+		b.WriteString("}\n")
+	}
+	for _, n := range d.nodes {
+		fmt.Fprintf(&b, "component \"%s\" as %s\n", plantumlText(n.label), ids.get(n.id))
+	}
+	for _, e := range d.edges {
+		arrow := "-->"
+		if e.async {
+			arrow = "..>"
+		}
+		fmt.Fprintf(&b, "%s %s %s", ids.get(e.from), arrow, ids.get(e.to))
+		if e.label != "" {
+			fmt.Fprintf(&b, " : %s", plantumlText(e.label))
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("@enduml\n")
+	return b.String()
+}
+
+func plantumlSequence(s *sequence) string {
+	ids := newIdentifiers(alnum)
+	var b strings.Builder
+	b.WriteString("@startuml\n")
+	plantumlTitle(&b, s.title)
+	for _, p := range s.participants {
+		fmt.Fprintf(&b, "participant \"%s\" as %s\n", plantumlText(p.label), ids.get(p.id))
+	}
+	for _, block := range messageBlocks(s.messages) {
+		indent := ""
+		if len(block) > 1 {
+			b.WriteString("par\n")
+			indent = "  "
+		}
+		for i, m := range block {
+			if i > 0 {
+				b.WriteString("else\n")
+			}
+			msgIndent := indent
+			if m.condition != "" {
+				fmt.Fprintf(&b, "%sopt %s\n", indent, plantumlText(m.condition))
+				msgIndent += "  "
+			}
+			arrow := "->"
+			if m.async {
+				arrow = "->>"
+			}
+			fmt.Fprintf(&b, "%s%s %s %s", msgIndent, ids.get(m.from), arrow, ids.get(m.to))
+			if m.label != "" {
+				fmt.Fprintf(&b, " : %s", plantumlText(m.label))
+			}
+			b.WriteString("\n")
+			if m.condition != "" {
+				fmt.Fprintf(&b, "%send\n", indent)
+			}
+		}
+		if len(block) > 1 {
+			b.WriteString("end\n")
+		}
+	}
+	b.WriteString("@enduml\n")
+	return b.String()
+}
+
+func plantumlTitle(b *strings.Builder, title string) {
+	if title != "" {
+		fmt.Fprintf(b, "title %s\n", plantumlText(title))
+	}
+}
+
+// plantumlText keeps labels on one line and inside their quotes.
+var plantumlText = strings.NewReplacer(
+	`"`, "'",
+	"\n", " ",
+).Replace
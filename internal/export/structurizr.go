@@ -0,0 +1,481 @@
+package export
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/nhomble/canopy/internal/schema"
+	"github.com/nhomble/canopy/internal/server"
+)
+
+func init() {
+	register("structurizr", exporter{
+		ext:       ".dsl",
+		separator: "\n",
+		render: func(idx *server.ArchiveIndex, sel *selection, opts Options) ([]Document, error) {
+			content, warnings := structurizrWorkspace(idx, sel, opts)
+			return []Document{{Name: "workspace", Content: content, Warnings: warnings}}, nil
+		},
+	})
+}
+
+// appLayer is the layer analysis assigns to self-contained projects.
+const appLayer = "app"
+
+// manifests mark the root of a separately built project.
+var manifests = []string{
+	"go.mod", "package.json", "pom.xml", "build.gradle", "build.gradle.kts",
+	"Cargo.toml", "pyproject.toml", "setup.py", "Gemfile", "composer.json",
+	"mix.exs", "Package.swift",
+}
+
+// c4Model is the ArchIndex mapped onto C4: one software system for the
+// repository, containers for projects, components inside them, and
+// external systems for archetype target services.
+type c4Model struct {
+	ids         *identifiers
+	system      string
+	containers  []*c4Container
+	containerOf map[string]string // component ID → container ID; containers map to themselves
+	externals   []string          // target service names, sorted
+	rels        []c4Relationship
+	relSeen     map[[3]string]bool
+}
+
+type c4Container struct {
+	comp       *schema.Component // nil for the implicit main container
+	id         string
+	name       string
+	components []*schema.Component
+}
+
+type c4Relationship struct {
+	from, to    string // element IDs, or "ext:" + service name
+	description string
+	technology  string
+	tags        []string
+}
+
+// structurizrWorkspace renders the workspace DSL and warns about flows
+// whose dynamic views show no interactions.
+func structurizrWorkspace(idx *server.ArchiveIndex, sel *selection, opts Options) (string, []string) {
+	m := buildC4Model(idx, sel, opts.RepoRoot)
+	w := &dslWriter{}
+
+	w.line("workspace %s %s {", dslString(idx.Raw.RepoID), dslString("Generated by canopy from .canopy/index.json"))
+	w.line("model {")
+	w.line("%s = softwareSystem %s {", m.ids.get(m.system), dslString(idx.Raw.RepoID))
+	for _, c := range m.containers {
+		m.writeContainer(w, c)
+	}
+	w.line("}")
+	for _, name := range m.externals {
+		w.line("%s = softwareSystem %s %s %s", m.ids.get("ext:"+name), dslString(name), dslString(""), dslString("External"))
+	}
+	for _, rel := range m.rels {
+		w.line("%s -> %s %s %s %s", m.ids.get(rel.from), m.ids.get(rel.to),
+			dslString(rel.description), dslString(rel.technology), dslString(strings.Join(rel.tags, ",")))
+	}
+	w.line("}")
+
+	w.line("views {")
+	w.line("systemContext %s %s {", m.ids.get(m.system), dslString("context"))
+	w.line("include *")
+	w.line("autoLayout")
+	w.line("}")
+	w.line("container %s %s {", m.ids.get(m.system), dslString("containers"))
+	w.line("include *")
+	w.line("autoLayout")
+	w.line("}")
+	for _, c := range m.containers {
+		if len(c.components) == 0 {
+			continue
+		}
+		w.line("component %s %s {", m.ids.get(c.id), dslString("components-"+m.ids.get(c.id)))
+		w.line("include *")
+		w.line("autoLayout")
+		w.line("}")
+	}
+	var warnings []string
+	for i := range sel.flows {
+		if warning := m.writeDynamicView(w, idx, &sel.flows[i]); warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+	w.line("styles {")
+	w.line("element %s {", dslString("External"))
+	w.line("background #999999")
+	w.line("color #ffffff")
+	w.line("}")
+	w.line("}")
+	w.line("}")
+	w.line("}")
+	return w.String(), warnings
+}
+
+func buildC4Model(idx *server.ArchiveIndex, sel *selection, repoRoot string) *c4Model {
+	m := &c4Model{
+		ids:         newIdentifiers(func(r rune) bool { return alnum(r) || r == '-' }),
+		system:      "system:" + idx.Raw.RepoID,
+		containerOf: make(map[string]string),
+		relSeen:     make(map[[3]string]bool),
+	}
+	m.ids.named(m.system, idx.Raw.RepoID)
+
+	// Projects become containers.
+	for i := range idx.Raw.Components {
+		comp := &idx.Raw.Components[i]
+		if sel.components[comp.ID] && isProject(comp, repoRoot) {
+			m.containers = append(m.containers, &c4Container{comp: comp, id: comp.ID, name: comp.Name})
+			m.containerOf[comp.ID] = comp.ID
+		}
+	}
+	// Everything else is a component of the project containing its code,
+	// or of an implicit container for the main project.
+	var main *c4Container
+	for i := range idx.Raw.Components {
+		comp := &idx.Raw.Components[i]
+		if !sel.components[comp.ID] || m.containerOf[comp.ID] != "" {
+			continue
+		}
+		c := m.enclosingContainer(comp)
+		if c == nil {
+			if main == nil {
+				main = &c4Container{id: "container:main", name: idx.Raw.RepoID}
+				m.ids.named(main.id, "main")
+				m.containers = append(m.containers, main)
+			}
+			c = main
+		}
+		c.components = append(c.components, comp)
+		m.containerOf[comp.ID] = c.id
+	}
+
+	// Relationships are lifted from archetypes to their components.
+	for _, rel := range idx.Raw.Relationships {
+		from, to := m.lift(idx, rel.From), m.lift(idx, rel.To)
+		if from == "" || to == "" || m.nested(from, to) {
+			continue
+		}
+		description := rel.Description
+		if description == "" {
+			description = rel.Type
+		}
+		tags := []string{rel.Type}
+		if rel.Mode != "" {
+			tags = append(tags, rel.Mode)
+		}
+		m.addRelationship(c4Relationship{from: from, to: to, description: description, technology: rel.Protocol, tags: tags})
+	}
+
+	// Target services are external systems used by the archetype's component.
+	externals := make(map[string]bool)
+	for _, archetypes := range idx.Raw.Archetypes {
+		for _, arch := range archetypes {
+			from := m.lift(idx, arch.ID)
+			if arch.TargetService == "" || from == "" {
+				continue
+			}
+			if !externals[arch.TargetService] {
+				externals[arch.TargetService] = true
+				m.ids.named("ext:"+arch.TargetService, arch.TargetService)
+			}
+			m.addRelationship(c4Relationship{from: from, to: "ext:" + arch.TargetService, description: "uses", tags: []string{"uses"}})
+		}
+	}
+	for name := range externals {
+		m.externals = append(m.externals, name)
+	}
+	sort.Strings(m.externals)
+
+	// Dynamic views may only show relationships that exist in the model.
+	for _, flow := range sel.flows {
+		for _, step := range m.flowSteps(idx, &flow) {
+			if !m.hasRelationship(step.from, step.to) && !m.hasRelationship(step.to, step.from) {
+				m.addRelationship(c4Relationship{from: step.from, to: step.to, description: step.description, tags: []string{"flow"}})
+			}
+		}
+	}
+
+	sort.SliceStable(m.rels, func(i, j int) bool {
+		a, b := m.rels[i], m.rels[j]
+		if a.from != b.from {
+			return a.from < b.from
+		}
+		return a.to < b.to
+	})
+	return m
+}
+
+// isProject reports whether a component is a separately built project:
+// an app-layer component, or one whose code_refs root holds a build manifest.
+func isProject(comp *schema.Component, repoRoot string) bool {
+	if comp.Layer == appLayer {
+		return true
+	}
+	if repoRoot == "" {
+		return false
+	}
+	for _, ref := range comp.CodeRefs {
+		dir := literalDir(ref)
+		if dir == "" {
+			continue // the repository root is the main project itself
+		}
+		for _, manifest := range manifests {
+			if _, err := os.Stat(filepath.Join(repoRoot, dir, manifest)); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// literalDir returns the directory part of a code_ref before any glob.
+func literalDir(ref string) string {
+	if i := strings.IndexAny(ref, "*?[{"); i >= 0 {
+		ref = ref[:i]
+	}
+	if i := strings.LastIndex(ref, "/"); i >= 0 {
+		return ref[:i]
+	}
+	return ""
+}
+
+// enclosingContainer returns the project container whose code contains
+// all of a component's code_refs.
+func (m *c4Model) enclosingContainer(comp *schema.Component) *c4Container {
+	for _, c := range m.containers {
+		if c.comp == nil {
+			continue
+		}
+		inside := len(comp.CodeRefs) > 0
+		for _, ref := range comp.CodeRefs {
+			if !slices.ContainsFunc(c.comp.CodeRefs, func(outer string) bool {
+				dir := literalDir(outer)
+				return dir != "" && strings.HasPrefix(ref, dir+"/")
+			}) {
+				inside = false
+			}
+		}
+		if inside {
+			return c
+		}
+	}
+	return nil
+}
+
+// lift maps a component or archetype ID to the selected model element.
+func (m *c4Model) lift(idx *server.ArchiveIndex, id string) string {
+	if _, ok := m.containerOf[id]; ok {
+		return id
+	}
+	if comp := idx.ComponentOf(id); comp != "" {
+		if _, ok := m.containerOf[comp]; ok {
+			return comp
+		}
+	}
+	return ""
+}
+
+// nested reports whether one element is the other or contains it;
+// Structurizr rejects relationships between parents and children.
+func (m *c4Model) nested(a, b string) bool {
+	return a == b || m.containerOf[a] == b || m.containerOf[b] == a
+}
+
+func (m *c4Model) addRelationship(rel c4Relationship) {
+	key := [3]string{rel.from, rel.to, rel.description}
+	if m.relSeen[key] {
+		return
+	}
+	m.relSeen[key] = true
+	m.rels = append(m.rels, rel)
+}
+
+func (m *c4Model) hasRelationship(from, to string) bool {
+	for _, rel := range m.rels {
+		if rel.from == from && rel.to == to {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *c4Model) writeContainer(w *dslWriter, c *c4Container) {
+	var links []schema.Link
+	description, tags := "", ""
+	if c.comp != nil {
+		links = c.comp.Links
+		description = c.comp.Description
+		tags = elementTags(c.comp)
+	}
+	decl := fmt.Sprintf("%s = container %s %s %s %s", m.ids.get(c.id), dslString(c.name), dslString(description), dslString(""), dslString(tags))
+	if len(links) == 0 && len(c.components) == 0 {
+		w.line("%s", decl)
+		return
+	}
+	w.line("%s {", decl)
+	writeLinks(w, links)
+	for _, comp := range c.components {
+		decl := fmt.Sprintf("%s = component %s %s %s %s", m.ids.get(comp.ID), dslString(comp.Name), dslString(comp.Description), dslString(""), dslString(elementTags(comp)))
+		if len(comp.Links) == 0 {
+			w.line("%s", decl)
+			continue
+		}
+		w.line("%s {", decl)
+		writeLinks(w, comp.Links)
+		w.line("}")
+	}
+	w.line("}")
+}
+
+// elementTags tags an element with its layer and index tags.
+func elementTags(comp *schema.Component) string {
+	return strings.Join(append([]string{comp.Layer}, comp.Tags...), ",")
+}
+
+// writeLinks sets the element URL to its first link and records every
+// link as a property.
+func writeLinks(w *dslWriter, links []schema.Link) {
+	if len(links) == 0 {
+		return
+	}
+	w.line("url %s", dslString(links[0].URL))
+	w.line("properties {")
+	for _, link := range links {
+		w.line("%s %s", dslString(link.Type), dslString(link.URL))
+	}
+	w.line("}")
+}
+
+// flowStep is one interaction of a dynamic view.
+type flowStep struct {
+	from, to    string
+	description string
+}
+
+// flowSteps lifts a flow's transitions to model elements, dropping steps
+// that stay within one element.
+func (m *c4Model) flowSteps(idx *server.ArchiveIndex, flow *schema.Flow) []flowStep {
+	g := flow.Normalized()
+	var steps []flowStep
+	for _, t := range g.Transitions {
+		from, to := g.Step(t.From), g.Step(t.To)
+		if from == nil || to == nil {
+			continue
+		}
+		a, b := m.lift(idx, from.Ref), m.lift(idx, to.Ref)
+		if a == "" || b == "" || m.nested(a, b) {
+			continue
+		}
+		description := transitionLabel(t)
+		if description == "" {
+			if rel := flowRelationship(idx, flow.ID, from.Ref, to.Ref); rel != nil {
+				description = rel.Type
+			}
+		}
+		if description == "" {
+			description = to.Label
+		}
+		steps = append(steps, flowStep{from: a, to: b, description: description})
+	}
+	return steps
+}
+
+// writeDynamicView renders a flow scoped to a container when all of its
+// components share one, and otherwise at container level in the system.
+// It returns a warning when the view can show no interactions.
+func (m *c4Model) writeDynamicView(w *dslWriter, idx *server.ArchiveIndex, flow *schema.Flow) string {
+	steps := m.flowSteps(idx, flow)
+	if len(steps) == 0 {
+		return m.writeInternalDynamicView(w, idx, flow)
+	}
+
+	scope := ""
+	for _, s := range steps {
+		for _, id := range []string{s.from, s.to} {
+			c := m.containerOf[id]
+			if c == id || (scope != "" && scope != c) {
+				scope = m.system
+			} else if scope == "" {
+				scope = c
+			}
+		}
+	}
+	if scope == m.system {
+		var lifted []flowStep
+		for _, s := range steps {
+			a, b := m.containerOf[s.from], m.containerOf[s.to]
+			if a != b {
+				lifted = append(lifted, flowStep{from: a, to: b, description: s.description})
+			}
+		}
+		steps = lifted
+	}
+
+	w.line("dynamic %s %s %s {", m.ids.get(scope), dslString("flow-"+m.ids.get(flow.ID)), dslString(flow.Name))
+	for _, s := range steps {
+		w.line("%s -> %s %s", m.ids.get(s.from), m.ids.get(s.to), dslString(s.description))
+	}
+	w.line("autoLayout")
+	w.line("}")
+	return ""
+}
+
+// writeInternalDynamicView renders a flow whose steps all stay within one
+// element. C4 has nothing finer than a component, so the view is scoped to
+// the element's container and lists the steps in its description.
+func (m *c4Model) writeInternalDynamicView(w *dslWriter, idx *server.ArchiveIndex, flow *schema.Flow) string {
+	g := flow.Normalized()
+	element := ""
+	var refs []string
+	for _, step := range g.Steps {
+		if id := m.lift(idx, step.Ref); id != "" {
+			if element == "" || m.containerOf[id] == element {
+				element = id
+			}
+			refs = append(refs, step.Ref)
+		}
+	}
+	if element == "" {
+		return fmt.Sprintf("flow %q skipped: none of its steps are in the selected components", flow.ID)
+	}
+
+	scope := m.containerOf[element]
+	if scope == element {
+		scope = m.system
+	}
+	w.line("dynamic %s %s %s {", m.ids.get(scope), dslString("flow-"+m.ids.get(flow.ID)), dslString(flow.Name))
+	w.line("description %s", dslString(fmt.Sprintf("Runs within %s: %s", m.ids.get(element), strings.Join(refs, " -> "))))
+	w.line("autoLayout")
+	w.line("}")
+	return fmt.Sprintf("flow %q stays within %s; its dynamic view lists the steps in its description but shows no interactions", flow.ID, element)
+}
+
+// dslWriter indents lines by brace depth.
+type dslWriter struct {
+	strings.Builder
+	depth int
+}
+
+func (w *dslWriter) line(format string, args ...any) {
+	text := fmt.Sprintf(format, args...)
+	if strings.HasPrefix(text, "}") {
+		w.depth--
+	}
+	w.WriteString(strings.Repeat("    ", w.depth))
+	w.WriteString(text)
+	w.WriteString("\n")
+	if strings.HasSuffix(text, "{") {
+		w.depth++
+	}
+}
+
+// dslString quotes a Structurizr DSL string.
+func dslString(s string) string {
+	return `"` + strings.NewReplacer(`"`, "'", "\n", " ").Replace(s) + `"`
+}
@@ -0,0 +1,270 @@
+package history
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nhomble/canopy/internal/schema"
+)
+
+// idFormat produces sortable, filesystem-safe snapshot IDs.
+const idFormat = "20060102T150405Z"
+
+// Meta describes one snapshot of index.json.
+type Meta struct {
+	ID            string    `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	Source        string    `json:"source"`
+	GitCommit     string    `json:"git_commit,omitempty"`
+	SchemaVersion int       `json:"schema_version"`
+	Components    int       `json:"components"`
+	Archetypes    int       `json:"archetypes"`
+	Relationships int       `json:"relationships"`
+	Flows         int       `json:"flows"`
+	Checksum      string    `json:"checksum"` // sha256 of the snapshot's index.json
+	// Edit marks snapshots taken by the server's edit API, which count
+	// against Retention.MaxEditSnapshots rather than MaxSnapshots.
+	Edit bool `json:"edit,omitempty"`
+}
+
+// Retention bounds how many snapshots are kept. Zero values mean unlimited.
+// Edit snapshots are counted apart from the others, so a burst of edits
+// does not push out the snapshots taken on import.
+type Retention struct {
+	MaxSnapshots     int
+	MaxEditSnapshots int
+	MaxAge           time.Duration
+}
+
+// ConfigRetention returns the retention policy set by the history section
+// of config.json.
+func ConfigRetention(cfg schema.HistoryConfig) Retention {
+	return Retention{
+		MaxSnapshots:     cfg.MaxSnapshots,
+		MaxEditSnapshots: cfg.MaxEditSnapshots,
+		MaxAge:           time.Duration(cfg.MaxAgeDays) * 24 * time.Hour,
+	}
+}
+
+// Store manages timestamped index snapshots under .canopy/history/. Each
+// snapshot is a directory holding index.json and meta.json.
+type Store struct {
+	Dir       string
+	Retention Retention
+
+	now func() time.Time
+}
+
+// NewStore returns a Store rooted at dir.
+func NewStore(dir string, retention Retention) *Store {
+	return &Store{Dir: dir, Retention: retention, now: time.Now}
+}
+
+// Snapshot copies the index at indexPath into the history. If its content
+// matches the newest snapshot, that snapshot is returned instead of writing
+// a duplicate. Old snapshots are pruned according to the retention policy.
+func (s *Store) Snapshot(indexPath, source, gitCommit string) (*Meta, error) {
+	return s.snapshot(indexPath, source, gitCommit, false)
+}
+
+// SnapshotEdit is Snapshot for a change made through the edit API.
+func (s *Store) SnapshotEdit(indexPath, source, gitCommit string) (*Meta, error) {
+	return s.snapshot(indexPath, source, gitCommit, true)
+}
+
+func (s *Store) snapshot(indexPath, source, gitCommit string, edit bool) (*Meta, error) {
+	data, err := os.ReadFile(indexPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading index: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+
+	metas, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	if len(metas) > 0 && metas[0].Checksum == checksum {
+		return &metas[0], nil
+	}
+
+	idx, err := schema.ParseIndex(data)
+	if err != nil {
+		return nil, err
+	}
+	archetypes := 0
+	for _, a := range idx.Archetypes {
+		archetypes += len(a)
+	}
+
+	now := s.now().UTC()
+	meta := Meta{
+		ID:            s.uniqueID(now),
+		CreatedAt:     now,
+		Source:        source,
+		GitCommit:     gitCommit,
+		SchemaVersion: idx.SchemaVersion,
+		Components:    len(idx.Components),
+		Archetypes:    archetypes,
+		Relationships: len(idx.Relationships),
+		Flows:         len(idx.Flows),
+		Checksum:      checksum,
+		Edit:          edit,
+	}
+
+	dir := filepath.Join(s.Dir, meta.ID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating snapshot directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "index.json"), data, 0o644); err != nil {
+		return nil, fmt.Errorf("writing snapshot: %w", err)
+	}
+	metaData, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshaling snapshot metadata: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "meta.json"), metaData, 0o644); err != nil {
+		return nil, fmt.Errorf("writing snapshot metadata: %w", err)
+	}
+
+	if err := s.prune(); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// List returns all snapshots, newest first.
+func (s *Store) List() ([]Meta, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading history: %w", err)
+	}
+
+	var metas []Meta
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		meta, err := s.Get(entry.Name())
+		if err != nil {
+			continue // skip partial or foreign directories
+		}
+		metas = append(metas, *meta)
+	}
+	sort.Slice(metas, func(i, j int) bool { return metas[i].ID > metas[j].ID })
+	return metas, nil
+}
+
+// Get returns the metadata of a single snapshot.
+func (s *Store) Get(id string) (*Meta, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || id == "." || id == ".." {
+		return nil, fmt.Errorf("invalid snapshot id: %q", id)
+	}
+	data, err := os.ReadFile(filepath.Join(s.Dir, id, "meta.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("snapshot not found: %s", id)
+		}
+		return nil, fmt.Errorf("reading snapshot metadata: %w", err)
+	}
+	var meta Meta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("parsing snapshot metadata: %w", err)
+	}
+	return &meta, nil
+}
+
+// IndexPath returns the path of a snapshot's index.json.
+func (s *Store) IndexPath(id string) string {
+	return filepath.Join(s.Dir, id, "index.json")
+}
+
+// Restore replaces the index at indexPath with the given snapshot. The
+// current index is snapshotted first so a restore can itself be undone.
+func (s *Store) Restore(id, indexPath, gitCommit string) error {
+	if _, err := s.Get(id); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(s.IndexPath(id))
+	if err != nil {
+		return fmt.Errorf("reading snapshot: %w", err)
+	}
+
+	if _, err := os.Stat(indexPath); err == nil {
+		if _, err := s.Snapshot(indexPath, "pre-restore", gitCommit); err != nil {
+			return fmt.Errorf("snapshotting current index: %w", err)
+		}
+	}
+
+	if err := os.WriteFile(indexPath, data, 0o644); err != nil {
+		return fmt.Errorf("writing index: %w", err)
+	}
+	return nil
+}
+
+// uniqueID returns a timestamp ID, suffixed if a snapshot already exists
+// for the same second.
+func (s *Store) uniqueID(t time.Time) string {
+	base := t.Format(idFormat)
+	id := base
+	for n := 2; ; n++ {
+		if _, err := os.Stat(filepath.Join(s.Dir, id)); os.IsNotExist(err) {
+			return id
+		}
+		id = fmt.Sprintf("%s-%d", base, n)
+	}
+}
+
+// prune removes snapshots beyond the retention policy, oldest first. The
+// newest snapshot is always kept.
+func (s *Store) prune() error {
+	metas, err := s.List()
+	if err != nil {
+		return err
+	}
+	cutoff := time.Time{}
+	if s.Retention.MaxAge > 0 {
+		cutoff = s.now().Add(-s.Retention.MaxAge)
+	}
+	kept := map[bool]int{} // snapshots seen so far, by Edit
+	for i, meta := range metas {
+		n := kept[meta.Edit]
+		kept[meta.Edit]++
+		if i == 0 {
+			continue
+		}
+		limit := s.Retention.MaxSnapshots
+		if meta.Edit {
+			limit = s.Retention.MaxEditSnapshots
+		}
+		tooMany := limit > 0 && n >= limit
+		tooOld := !cutoff.IsZero() && meta.CreatedAt.Before(cutoff)
+		if tooMany || tooOld {
+			if err := os.RemoveAll(filepath.Join(s.Dir, meta.ID)); err != nil {
+				return fmt.Errorf("pruning snapshot %s: %w", meta.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// GitCommit returns the HEAD commit of the repository containing dir, or ""
+// if it is not a git checkout.
+func GitCommit(dir string) string {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,141 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nhomble/canopy/internal/schema"
+)
+
+func writeIndex(t *testing.T, path, repoID string) {
+	t.Helper()
+	data := `{"schema_version": 1, "repo_id": "` + repoID + `", "components": [{"id": "a", "name": "A", "layer": "core", "code_refs": ["a/**"]}]}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("writing index: %v", err)
+	}
+}
+
+// testStore returns a store whose clock advances one second per snapshot.
+func testStore(t *testing.T, retention Retention) *Store {
+	t.Helper()
+	s := NewStore(filepath.Join(t.TempDir(), "history"), retention)
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+	return s
+}
+
+func TestSnapshotDeduplicates(t *testing.T) {
+	s := testStore(t, Retention{})
+	indexPath := filepath.Join(t.TempDir(), "index.json")
+	writeIndex(t, indexPath, "one")
+
+	first, err := s.Snapshot(indexPath, "test", "")
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	second, err := s.Snapshot(indexPath, "test", "")
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	if first.ID != second.ID {
+		t.Fatalf("identical content should reuse snapshot %s, got %s", first.ID, second.ID)
+	}
+	if first.Components != 1 {
+		t.Fatalf("expected 1 component in metadata, got %d", first.Components)
+	}
+}
+
+func TestSnapshotRetention(t *testing.T) {
+	s := testStore(t, Retention{MaxSnapshots: 2})
+	indexPath := filepath.Join(t.TempDir(), "index.json")
+
+	for _, id := range []string{"one", "two", "three"} {
+		writeIndex(t, indexPath, id)
+		if _, err := s.Snapshot(indexPath, id, ""); err != nil {
+			t.Fatalf("snapshot %s: %v", id, err)
+		}
+	}
+
+	metas, err := s.List()
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(metas) != 2 {
+		t.Fatalf("expected 2 snapshots after pruning, got %d", len(metas))
+	}
+	if metas[0].Source != "three" || metas[1].Source != "two" {
+		t.Fatalf("expected newest snapshots kept, got %s, %s", metas[0].Source, metas[1].Source)
+	}
+}
+
+func TestEditSnapshotsKeepImports(t *testing.T) {
+	s := testStore(t, Retention{MaxSnapshots: 2, MaxEditSnapshots: 2})
+	indexPath := filepath.Join(t.TempDir(), "index.json")
+
+	writeIndex(t, indexPath, "imported")
+	if _, err := s.Snapshot(indexPath, "import", ""); err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	for _, id := range []string{"one", "two", "three"} {
+		writeIndex(t, indexPath, id)
+		if _, err := s.SnapshotEdit(indexPath, "edit: "+id, ""); err != nil {
+			t.Fatalf("snapshot %s: %v", id, err)
+		}
+	}
+
+	metas, err := s.List()
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	var sources []string
+	for _, m := range metas {
+		sources = append(sources, m.Source)
+	}
+	if got := strings.Join(sources, ","); got != "edit: three,edit: two,import" {
+		t.Fatalf("expected the two newest edits and the import, got %s", got)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	s := testStore(t, Retention{})
+	indexPath := filepath.Join(t.TempDir(), "index.json")
+
+	writeIndex(t, indexPath, "good")
+	good, err := s.Snapshot(indexPath, "import", "")
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	writeIndex(t, indexPath, "bad")
+
+	if err := s.Restore(good.ID, indexPath, ""); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+
+	data, _ := os.ReadFile(indexPath)
+	if !strings.Contains(string(data), `"good"`) {
+		t.Fatalf("expected restored index, got %s", data)
+	}
+
+	metas, _ := s.List()
+	if len(metas) != 2 || metas[0].Source != "pre-restore" {
+		t.Fatalf("expected the replaced index to be snapshotted, got %+v", metas)
+	}
+
+	if err := s.Restore("../escape", indexPath, ""); err == nil {
+		t.Fatal("expected error for invalid snapshot id")
+	}
+}
+
+func TestConfigRetention(t *testing.T) {
+	got := ConfigRetention(schema.HistoryConfig{MaxSnapshots: 20, MaxEditSnapshots: 50, MaxAgeDays: 2})
+	want := Retention{MaxSnapshots: 20, MaxEditSnapshots: 50, MaxAge: 48 * time.Hour}
+	if got != want {
+		t.Errorf("ConfigRetention = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,170 @@
+package owners
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// Locations are the paths GitHub reads CODEOWNERS from, in precedence order.
+var Locations = []string{
+	".github/CODEOWNERS",
+	"CODEOWNERS",
+	"docs/CODEOWNERS",
+}
+
+// Rule is a single CODEOWNERS line.
+type Rule struct {
+	Pattern string
+	Owners  []string
+	Line    int
+
+	globs []string // doublestar patterns equivalent to Pattern
+}
+
+// Ruleset is a parsed CODEOWNERS file. As on GitHub, the last matching
+// rule wins.
+type Ruleset struct {
+	Path  string
+	Rules []Rule
+}
+
+// Load reads the first CODEOWNERS file found under repoRoot. It returns
+// nil without error when the repository has none.
+func Load(repoRoot string) (*Ruleset, error) {
+	for _, loc := range Locations {
+		path := filepath.Join(repoRoot, loc)
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("opening %s: %w", loc, err)
+		}
+		rs, err := Parse(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", loc, err)
+		}
+		rs.Path = loc
+		return rs, nil
+	}
+	return nil, nil
+}
+
+// Parse reads CODEOWNERS rules. Blank lines and comments are skipped; a
+// pattern with no owners is kept, since it clears ownership for its paths.
+func Parse(r io.Reader) (*Ruleset, error) {
+	rs := &Ruleset{}
+	sc := bufio.NewScanner(r)
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		line := sc.Text()
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		rule := Rule{
+			Pattern: fields[0],
+			Owners:  fields[1:],
+			Line:    lineNo,
+			globs:   toGlobs(fields[0]),
+		}
+		rs.Rules = append(rs.Rules, rule)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// Match returns the owners of a repo-relative path, or nil if no rule
+// covers it.
+func (rs *Ruleset) Match(path string) []string {
+	path = strings.TrimPrefix(filepath.ToSlash(path), "/")
+	for i := len(rs.Rules) - 1; i >= 0; i-- {
+		for _, g := range rs.Rules[i].globs {
+			if ok, _ := doublestar.Match(g, path); ok {
+				return rs.Rules[i].Owners
+			}
+		}
+	}
+	return nil
+}
+
+// toGlobs translates a gitignore-style CODEOWNERS pattern to doublestar
+// patterns. A literal path, or any pattern ending in a slash, matches the
+// path itself and everything beneath it; a wildcard pattern such as docs/*
+// matches only what it names, not deeper files. Patterns without an inner
+// slash match at any depth.
+func toGlobs(pattern string) []string {
+	anchored := strings.HasPrefix(pattern, "/")
+	dir := strings.HasSuffix(pattern, "/")
+	p := strings.Trim(pattern, "/")
+	if p == "" || p == "*" || p == "**" {
+		return []string{"**"}
+	}
+	if !anchored && !strings.Contains(p, "/") {
+		p = "**/" + p
+	}
+	if dir || !strings.ContainsAny(p, "*?[") {
+		return []string{p, p + "/**"}
+	}
+	return []string{p}
+}
+
+// Ownership summarizes who owns a component's files.
+type Ownership struct {
+	// Owners is the union of owners across the component's files.
+	Owners []string `json:"owners"`
+	// Files lists owners per file, recorded only when rules disagree.
+	Files map[string][]string `json:"files,omitempty"`
+	// MultiTeam is set when the files span more than one set of owners.
+	MultiTeam bool `json:"multi_team,omitempty"`
+}
+
+// Resolve computes the ownership of a set of repo-relative files.
+func (rs *Ruleset) Resolve(files []string) *Ownership {
+	o := &Ownership{}
+	union := make(map[string]bool)
+	sets := make(map[string]bool) // distinct owner lists, "" for unowned
+	teams := 0
+	perFile := make(map[string][]string, len(files))
+	for _, f := range files {
+		owners := rs.Match(f)
+		perFile[f] = owners
+		key := strings.Join(owners, " ")
+		if !sets[key] {
+			sets[key] = true
+			if key != "" {
+				teams++
+			}
+		}
+		for _, owner := range owners {
+			union[owner] = true
+		}
+	}
+
+	o.Owners = make([]string, 0, len(union))
+	for owner := range union {
+		o.Owners = append(o.Owners, owner)
+	}
+	sort.Strings(o.Owners)
+
+	if len(sets) > 1 {
+		o.Files = perFile
+	}
+	o.MultiTeam = teams > 1
+	return o
+}
@@ -0,0 +1,134 @@
+package owners
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const sample = `# Default owners
+*       @org/platform
+
+/internal/billing/   @org/billing @alice
+*.md                 @org/docs
+docs/**              @org/docs
+/cmd/tool            # no owners: unassign
+`
+
+func TestParse(t *testing.T) {
+	rs, err := Parse(strings.NewReader(sample))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(rs.Rules) != 5 {
+		t.Fatalf("expected 5 rules, got %d", len(rs.Rules))
+	}
+	if rs.Rules[1].Pattern != "/internal/billing/" || rs.Rules[1].Line != 4 {
+		t.Errorf("unexpected rule: %+v", rs.Rules[1])
+	}
+	if !reflect.DeepEqual(rs.Rules[1].Owners, []string{"@org/billing", "@alice"}) {
+		t.Errorf("unexpected owners: %v", rs.Rules[1].Owners)
+	}
+}
+
+func TestMatchLastRuleWins(t *testing.T) {
+	rs, err := Parse(strings.NewReader(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		file string
+		want []string
+	}{
+		{"main.go", []string{"@org/platform"}},
+		{"internal/billing/invoice.go", []string{"@org/billing", "@alice"}},
+		{"internal/billing/README.md", []string{"@org/docs"}},
+		{"pkg/internal/billing/x.go", []string{"@org/platform"}},
+		{"docs/guide/intro.txt", []string{"@org/docs"}},
+		{"cmd/tool/main.go", nil},
+	}
+	for _, tt := range tests {
+		got := rs.Match(tt.file)
+		if len(got) == 0 && len(tt.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Match(%q) = %v, want %v", tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestMatchWildcardStaysAtItsLevel(t *testing.T) {
+	rs, err := Parse(strings.NewReader("*  @org/platform\n/docs/*  @org/docs\napps/*/  @org/apps\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		file string
+		want []string
+	}{
+		{"docs/getting-started.md", []string{"@org/docs"}},
+		// GitHub: docs/* does not match files in subdirectories of docs.
+		{"docs/build-app/troubleshooting.md", []string{"@org/platform"}},
+		// A trailing slash matches the directories and their contents.
+		{"apps/web/src/main.ts", []string{"@org/apps"}},
+	}
+	for _, tt := range tests {
+		if got := rs.Match(tt.file); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Match(%q) = %v, want %v", tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestResolveMultiTeam(t *testing.T) {
+	rs, err := Parse(strings.NewReader(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	single := rs.Resolve([]string{"internal/billing/a.go", "internal/billing/b.go"})
+	if single.MultiTeam || single.Files != nil {
+		t.Errorf("expected single-team ownership, got %+v", single)
+	}
+	if !reflect.DeepEqual(single.Owners, []string{"@alice", "@org/billing"}) {
+		t.Errorf("unexpected owners: %v", single.Owners)
+	}
+
+	mixed := rs.Resolve([]string{"internal/billing/a.go", "internal/api/b.go"})
+	if !mixed.MultiTeam {
+		t.Error("expected multi-team ownership")
+	}
+	if len(mixed.Files) != 2 {
+		t.Errorf("expected per-file owners, got %v", mixed.Files)
+	}
+
+	partial := rs.Resolve([]string{"cmd/tool/main.go", "main.go"})
+	if partial.MultiTeam {
+		t.Error("unowned files should not count as a team")
+	}
+	if partial.Files == nil {
+		t.Error("expected per-file owners when some files are unowned")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	rs, err := Load(dir)
+	if err != nil || rs != nil {
+		t.Fatalf("expected nil ruleset without CODEOWNERS, got %v, %v", rs, err)
+	}
+
+	os.MkdirAll(filepath.Join(dir, ".github"), 0755)
+	os.WriteFile(filepath.Join(dir, "CODEOWNERS"), []byte("* @root\n"), 0644)
+	os.WriteFile(filepath.Join(dir, ".github", "CODEOWNERS"), []byte("* @github\n"), 0644)
+
+	rs, err = Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.Path != ".github/CODEOWNERS" {
+		t.Errorf("expected .github/CODEOWNERS to take precedence, got %s", rs.Path)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"embed"
 	"fmt"
 	"io/fs"
+	"slices"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -16,7 +18,7 @@ type PatternDef struct {
 	Name         string                  `yaml:"name"`
 	Aliases      []string                `yaml:"aliases"`
 	Description  string                  `yaml:"description"`
-	Layers       []LayerDef              `yaml:"layers"`
+	Layers       []LayerDef              `yaml:"layers"` // innermost first
 	Archetypes   map[string]ArchetypeDef `yaml:"archetypes"`
 	FlowPatterns []string                `yaml:"flow_patterns"`
 	AntiPatterns []string                `yaml:"anti_patterns"`
@@ -79,3 +81,29 @@ func LoadAll() ([]PatternDef, error) {
 
 	return patterns, nil
 }
+
+// LayerOrder returns the layers of the named patterns, innermost first, in
+// the order their definitions list them. Names match a definition's name or
+// one of its aliases, ignoring case; unknown names are skipped.
+func LayerOrder(names []string) ([]string, error) {
+	defs, err := LoadAll()
+	if err != nil {
+		return nil, err
+	}
+	var order []string
+	for _, name := range names {
+		for _, def := range defs {
+			if !strings.EqualFold(def.Name, name) && !slices.ContainsFunc(def.Aliases, func(a string) bool {
+				return strings.EqualFold(a, name)
+			}) {
+				continue
+			}
+			for _, layer := range def.Layers {
+				if !slices.Contains(order, layer.ID) {
+					order = append(order, layer.ID)
+				}
+			}
+		}
+	}
+	return order, nil
+}
@@ -1,6 +1,7 @@
 package patterns
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -156,3 +157,16 @@ func TestLoadAll(t *testing.T) {
 		}
 	})
 }
+
+func TestLayerOrder(t *testing.T) {
+	order, err := LayerOrder([]string{"ports and adapters", "Unknown", "Hexagonal Architecture"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(order, ","); got != "core,ports,adapters" {
+		t.Errorf("LayerOrder = %s, want core,ports,adapters", got)
+	}
+	if order, _ := LayerOrder([]string{"Unknown"}); order != nil {
+		t.Errorf("expected no layers for an unknown pattern, got %v", order)
+	}
+}
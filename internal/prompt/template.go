@@ -42,6 +42,8 @@ var exampleOutput = `{
       "name": "User Domain",
       "layer": "core",
       "code_refs": ["src/domain/user/"],
+      "description": "User lifecycle and registration rules.",
+      "tags": ["identity"],
       "provides": {
         "interface": "UserService",
         "symbols": ["User", "UserService", "CreateUserUseCase"]
@@ -100,9 +102,9 @@ var exampleOutput = `{
     ]
   },
   "relationships": [
-    {"from": "user-controller", "to": "user-service", "type": "calls", "flow": "create-user"},
-    {"from": "user-service", "to": "user-repo", "type": "calls", "flow": "create-user"},
-    {"from": "admin-dashboard", "to": "user-api", "type": "calls"}
+    {"from": "user-controller", "to": "user-service", "type": "calls", "flow": "create-user", "protocol": "in-process", "mode": "sync"},
+    {"from": "user-service", "to": "user-repo", "type": "calls", "flow": "create-user", "protocol": "sql", "mode": "sync"},
+    {"from": "admin-dashboard", "to": "user-api", "type": "calls", "protocol": "http", "mode": "sync", "description": "Manage user accounts"}
   ],
   "flows": [
     {
@@ -0,0 +1,58 @@
+package schema
+
+import "strconv"
+
+// Normalized returns the flow in graph form. Flat flows become a linear
+// chain whose step IDs are the 1-based step positions.
+func (f *Flow) Normalized() *FlowGraph {
+	if f.Graph != nil {
+		return f.Graph
+	}
+	g := &FlowGraph{Steps: make([]FlowStep, len(f.Steps))}
+	for i, ref := range f.Steps {
+		g.Steps[i] = FlowStep{ID: strconv.Itoa(i + 1), Ref: ref}
+		if i > 0 {
+			g.Transitions = append(g.Transitions, FlowTransition{From: g.Steps[i-1].ID, To: g.Steps[i].ID})
+		}
+	}
+	return g
+}
+
+// Refs returns the element IDs a flow visits, each once, in step order.
+func (f *Flow) Refs() []string {
+	steps := f.Normalized().Steps
+	seen := make(map[string]bool, len(steps))
+	refs := make([]string, 0, len(steps))
+	for _, step := range steps {
+		if !seen[step.Ref] {
+			seen[step.Ref] = true
+			refs = append(refs, step.Ref)
+		}
+	}
+	return refs
+}
+
+// Step returns the step with the given ID, or nil.
+func (g *FlowGraph) Step(id string) *FlowStep {
+	for i := range g.Steps {
+		if g.Steps[i].ID == id {
+			return &g.Steps[i]
+		}
+	}
+	return nil
+}
+
+// EntrySteps returns the IDs of steps with no incoming transition.
+func (g *FlowGraph) EntrySteps() []string {
+	incoming := make(map[string]bool, len(g.Transitions))
+	for _, t := range g.Transitions {
+		incoming[t.To] = true
+	}
+	var entries []string
+	for _, step := range g.Steps {
+		if !incoming[step.ID] {
+			entries = append(entries, step.ID)
+		}
+	}
+	return entries
+}
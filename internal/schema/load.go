@@ -7,21 +7,35 @@ import (
 	"strings"
 )
 
-// LoadIndex reads and parses an ArchIndex from a JSON file.
+// LoadIndex reads and parses an ArchIndex from a JSON file, upgrading it to
+// the current schema version.
 func LoadIndex(path string) (*ArchIndex, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("reading index: %w", err)
 	}
+	return ParseIndex(data)
+}
+
+// ParseIndex migrates a raw index document to the current schema version
+// and decodes it.
+func ParseIndex(data []byte) (*ArchIndex, error) {
+	migrated, from, err := Migrate(data)
+	if err != nil {
+		return nil, err
+	}
 	var idx ArchIndex
-	if err := json.Unmarshal(data, &idx); err != nil {
+	if err := json.Unmarshal(migrated, &idx); err != nil {
 		return nil, fmt.Errorf("parsing index: %w", err)
 	}
+	idx.SourceSchemaVersion = from
 	return &idx, nil
 }
 
-// SaveIndex writes an ArchIndex to a JSON file with indentation.
+// SaveIndex writes an ArchIndex to a JSON file with indentation, stamped
+// with the current schema version.
 func SaveIndex(path string, idx *ArchIndex) error {
+	idx.SchemaVersion = CurrentSchemaVersion
 	data, err := json.MarshalIndent(idx, "", "  ")
 	if err != nil {
 		return fmt.Errorf("marshaling index: %w", err)
@@ -0,0 +1,109 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// LocatePaths maps each value in a JSON document to the 1-based line it
+// starts on, keyed by the same paths ValidateIndex reports
+// (e.g., "components[0].id", "archetypes.controllers[1]"). Malformed input
+// yields whatever was located before the error.
+func LocatePaths(data []byte) map[string]int {
+	var newlines []int
+	for i, b := range data {
+		if b == '\n' {
+			newlines = append(newlines, i)
+		}
+	}
+	lineAt := func(offset int64) int {
+		return sort.SearchInts(newlines, int(offset)) + 1
+	}
+
+	type frame struct {
+		path    string
+		array   bool
+		next    int    // next array index
+		key     string // path of the pending object member
+		wantKey bool
+	}
+
+	lines := make(map[string]int)
+	var stack []*frame
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		// InputOffset points just past the token; step back onto it.
+		offset := dec.InputOffset() - 1
+
+		var top *frame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+
+		if d, ok := tok.(json.Delim); ok && (d == '}' || d == ']') {
+			stack = stack[:len(stack)-1]
+			if len(stack) > 0 && !stack[len(stack)-1].array {
+				stack[len(stack)-1].wantKey = true
+			}
+			continue
+		}
+
+		if top != nil && !top.array && top.wantKey {
+			key, _ := tok.(string)
+			top.key = joinPath(top.path, key)
+			top.wantKey = false
+			lines[top.key] = lineAt(offset)
+			continue
+		}
+
+		var path string
+		if top != nil {
+			if top.array {
+				path = fmt.Sprintf("%s[%d]", top.path, top.next)
+				top.next++
+				lines[path] = lineAt(offset)
+			} else {
+				path = top.key
+			}
+		}
+
+		if d, ok := tok.(json.Delim); ok {
+			stack = append(stack, &frame{path: path, array: d == '[', wantKey: d == '{'})
+			continue
+		}
+		if top != nil && !top.array {
+			top.wantKey = true
+		}
+	}
+	return lines
+}
+
+// lookupLine returns the line of path, falling back to its nearest located
+// ancestor (a missing "components[0].name" resolves to "components[0]").
+func lookupLine(lines map[string]int, path string) int {
+	for path != "" {
+		if line, ok := lines[path]; ok {
+			return line
+		}
+		cut := strings.LastIndexAny(path, ".[")
+		if cut < 0 {
+			break
+		}
+		path = path[:cut]
+	}
+	return 0
+}
+
+func joinPath(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
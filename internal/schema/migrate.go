@@ -0,0 +1,85 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Migration upgrades a raw index document from one schema version to the
+// next. Apply receives the decoded JSON object and edits it in place.
+type Migration struct {
+	From        int
+	Description string
+	Apply       func(doc map[string]any) error
+}
+
+// migrations is the registry of upgrades, keyed by the version they start from.
+var migrations = map[int]Migration{}
+
+// RegisterMigration adds a migration to the registry. It panics on a
+// duplicate source version, since that is a programming error.
+func RegisterMigration(m Migration) {
+	if _, exists := migrations[m.From]; exists {
+		panic(fmt.Sprintf("schema: duplicate migration from version %d", m.From))
+	}
+	migrations[m.From] = m
+}
+
+func init() {
+	RegisterMigration(Migration{
+		From:        0,
+		Description: "stamp unversioned documents with schema_version",
+		Apply:       func(doc map[string]any) error { return nil },
+	})
+}
+
+// Migrate upgrades a raw index document to CurrentSchemaVersion. It returns
+// the upgraded document and the version it started from. Documents written by
+// a newer canopy are rejected rather than silently truncated.
+func Migrate(data []byte) ([]byte, int, error) {
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, 0, fmt.Errorf("parsing index: %w", err)
+	}
+
+	from, err := documentVersion(doc)
+	if err != nil {
+		return nil, 0, err
+	}
+	if from > CurrentSchemaVersion {
+		return nil, from, fmt.Errorf("index schema version %d is newer than supported version %d (upgrade canopy)",
+			from, CurrentSchemaVersion)
+	}
+	if from == CurrentSchemaVersion {
+		return data, from, nil
+	}
+
+	for v := from; v < CurrentSchemaVersion; v++ {
+		m, ok := migrations[v]
+		if !ok {
+			return nil, from, fmt.Errorf("no migration registered from schema version %d", v)
+		}
+		if err := m.Apply(doc); err != nil {
+			return nil, from, fmt.Errorf("migrating from schema version %d: %w", v, err)
+		}
+		doc["schema_version"] = v + 1
+	}
+
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return nil, from, fmt.Errorf("marshaling migrated index: %w", err)
+	}
+	return out, from, nil
+}
+
+func documentVersion(doc map[string]any) (int, error) {
+	raw, ok := doc["schema_version"]
+	if !ok || raw == nil {
+		return 0, nil
+	}
+	v, ok := raw.(float64)
+	if !ok || v < 0 || v != float64(int(v)) {
+		return 0, fmt.Errorf("invalid schema_version: %v", raw)
+	}
+	return int(v), nil
+}
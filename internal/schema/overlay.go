@@ -0,0 +1,389 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Overlay holds human-maintained corrections and annotations stored in
+// .canopy/overlay.json. It is merged onto the LLM-generated index at load
+// time, so re-importing an analysis does not wipe curated knowledge.
+type Overlay struct {
+	// Elements maps a component, archetype, or flow ID to its overlay entry.
+	Elements map[string]OverlayEntry `json:"elements"`
+}
+
+// OverlayEntry annotates or corrects a single element.
+type OverlayEntry struct {
+	// Match locates the element when its ID changed between analyses.
+	Match *OverlayMatch `json:"match,omitempty"`
+	// Override replaces schema fields such as name or layer.
+	Override map[string]any `json:"override,omitempty"`
+	// Annotations adds free-form fields: owners, notes, wiki links.
+	Annotations map[string]any `json:"annotations,omitempty"`
+	// Suppress removes the element (and references to it) from the index.
+	Suppress bool `json:"suppress,omitempty"`
+}
+
+// OverlayMatch is the fallback used when no element has the entry's ID.
+// Components are matched by code_refs overlap, archetypes by file (and
+// symbol, when several archetypes share a file).
+type OverlayMatch struct {
+	CodeRefs []string `json:"code_refs,omitempty"`
+	File     string   `json:"file,omitempty"`
+	Symbol   string   `json:"symbol,omitempty"`
+}
+
+// OverlayResult records how one overlay entry was applied.
+type OverlayResult struct {
+	Key       string `json:"key"`
+	Kind      string `json:"kind,omitempty"`   // component, archetype, or flow
+	Target    string `json:"target,omitempty"` // ID of the matched element
+	MatchedBy string `json:"matched_by"`       // id, code_refs, file, or none
+	Warning   string `json:"warning,omitempty"`
+}
+
+// LoadOverlay reads an overlay file. A missing file yields an empty overlay.
+func LoadOverlay(path string) (*Overlay, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Overlay{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading overlay: %w", err)
+	}
+	var ov Overlay
+	if err := json.Unmarshal(data, &ov); err != nil {
+		return nil, fmt.Errorf("parsing overlay: %w", err)
+	}
+	return &ov, nil
+}
+
+// LoadIndexWithOverlay loads an index and merges the overlay at overlayPath
+// onto it.
+func LoadIndexWithOverlay(indexPath, overlayPath string) (*ArchIndex, []OverlayResult, error) {
+	idx, err := LoadIndex(indexPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	ov, err := LoadOverlay(overlayPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return idx, ApplyOverlay(idx, ov), nil
+}
+
+// ApplyOverlay merges the overlay onto idx in place and reports, per entry
+// in key order, which element it attached to.
+func ApplyOverlay(idx *ArchIndex, ov *Overlay) []OverlayResult {
+	keys := make([]string, 0, len(ov.Elements))
+	for key := range ov.Elements {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	suppressed := make(map[string]bool)
+	results := make([]OverlayResult, 0, len(keys))
+	for _, key := range keys {
+		entry := ov.Elements[key]
+		res := OverlayResult{Key: key, MatchedBy: "none"}
+
+		matched, err := applyToComponent(idx, key, entry, &res)
+		if !matched {
+			matched, err = applyToArchetype(idx, key, entry, &res)
+		}
+		if !matched {
+			matched, err = applyToFlow(idx, key, entry, &res)
+		}
+		if !matched {
+			if res.Warning == "" {
+				res.Warning = "no matching element"
+			}
+		} else if err != nil {
+			res.Warning = err.Error()
+		}
+		if entry.Suppress && res.Target != "" {
+			suppressed[res.Target] = true
+		}
+		results = append(results, res)
+	}
+
+	if len(suppressed) > 0 {
+		removeSuppressed(idx, suppressed)
+	}
+	return results
+}
+
+func applyToComponent(idx *ArchIndex, key string, entry OverlayEntry, res *OverlayResult) (bool, error) {
+	target := -1
+	for i := range idx.Components {
+		if idx.Components[i].ID == key {
+			target = i
+			res.MatchedBy = "id"
+			break
+		}
+	}
+	if target < 0 && entry.Match != nil && len(entry.Match.CodeRefs) > 0 {
+		best := 0.0
+		var tied []string
+		for i := range idx.Components {
+			ov := CodeRefOverlap(entry.Match.CodeRefs, idx.Components[i].CodeRefs)
+			switch {
+			case ov < minOverlayOverlap || ov < best:
+			case ov == best:
+				tied = append(tied, idx.Components[i].ID)
+			default:
+				best, target = ov, i
+				tied = []string{idx.Components[i].ID}
+			}
+		}
+		if len(tied) > 1 {
+			res.Warning = "code_refs match several components equally: " + strings.Join(tied, ", ")
+			return false, nil
+		}
+		if target >= 0 {
+			res.MatchedBy = "code_refs"
+		}
+	}
+	if target < 0 {
+		return false, nil
+	}
+
+	comp := &idx.Components[target]
+	res.Kind, res.Target = "component", comp.ID
+	comp.Annotations = mergeAnnotations(comp.Annotations, entry.Annotations)
+	return true, applyOverride(comp, entry.Override)
+}
+
+func applyToArchetype(idx *ArchIndex, key string, entry OverlayEntry, res *OverlayResult) (bool, error) {
+	var target *Archetype
+	for category := range idx.Archetypes {
+		for i := range idx.Archetypes[category] {
+			if idx.Archetypes[category][i].ID == key {
+				target = &idx.Archetypes[category][i]
+				res.MatchedBy = "id"
+			}
+		}
+	}
+	if target == nil && entry.Match != nil && entry.Match.File != "" {
+		for category := range idx.Archetypes {
+			for i := range idx.Archetypes[category] {
+				arch := &idx.Archetypes[category][i]
+				if arch.File != entry.Match.File {
+					continue
+				}
+				if entry.Match.Symbol != "" && arch.Symbol != entry.Match.Symbol {
+					continue
+				}
+				if target == nil || arch.ID < target.ID {
+					target = arch // lowest ID wins, so ties resolve deterministically
+				}
+			}
+		}
+		if target != nil {
+			res.MatchedBy = "file"
+		}
+	}
+	if target == nil {
+		return false, nil
+	}
+
+	res.Kind, res.Target = "archetype", target.ID
+	target.Annotations = mergeAnnotations(target.Annotations, entry.Annotations)
+	return true, applyOverride(target, entry.Override)
+}
+
+func applyToFlow(idx *ArchIndex, key string, entry OverlayEntry, res *OverlayResult) (bool, error) {
+	for i := range idx.Flows {
+		flow := &idx.Flows[i]
+		if flow.ID != key {
+			continue
+		}
+		res.Kind, res.Target, res.MatchedBy = "flow", flow.ID, "id"
+		flow.Annotations = mergeAnnotations(flow.Annotations, entry.Annotations)
+		return true, applyOverride(flow, entry.Override)
+	}
+	return false, nil
+}
+
+// applyOverride replaces fields of elem (a pointer to a schema struct) by
+// their JSON names. The id field cannot be overridden, since other elements
+// reference it.
+func applyOverride(elem any, override map[string]any) error {
+	if len(override) == 0 {
+		return nil
+	}
+	if _, ok := override["id"]; ok {
+		return fmt.Errorf("override of id is not allowed")
+	}
+
+	data, err := json.Marshal(elem)
+	if err != nil {
+		return err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for k, v := range override {
+		fields[k] = v
+	}
+	merged, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(merged, elem); err != nil {
+		return fmt.Errorf("invalid override: %w", err)
+	}
+	return nil
+}
+
+func mergeAnnotations(base, extra map[string]any) map[string]any {
+	if len(extra) == 0 {
+		return base
+	}
+	if base == nil {
+		base = make(map[string]any, len(extra))
+	}
+	for k, v := range extra {
+		base[k] = v
+	}
+	return base
+}
+
+// removeSuppressed drops suppressed elements along with the relationships
+// and flow steps that reference them.
+func removeSuppressed(idx *ArchIndex, suppressed map[string]bool) {
+	comps := idx.Components[:0]
+	for _, c := range idx.Components {
+		if !suppressed[c.ID] {
+			comps = append(comps, c)
+		}
+	}
+	idx.Components = comps
+
+	for category, archetypes := range idx.Archetypes {
+		kept := archetypes[:0]
+		for _, a := range archetypes {
+			if !suppressed[a.ID] {
+				kept = append(kept, a)
+			}
+		}
+		idx.Archetypes[category] = kept
+	}
+
+	rels := idx.Relationships[:0]
+	for _, r := range idx.Relationships {
+		if !suppressed[r.From] && !suppressed[r.To] {
+			rels = append(rels, r)
+		}
+	}
+	idx.Relationships = rels
+
+	flows := idx.Flows[:0]
+	for _, f := range idx.Flows {
+		if suppressed[f.ID] {
+			continue
+		}
+		steps := f.Steps[:0]
+		for _, s := range f.Steps {
+			if !suppressed[s] {
+				steps = append(steps, s)
+			}
+		}
+		f.Steps = steps
+		if f.Graph != nil {
+			f.Graph = removeSuppressedSteps(f.Graph, suppressed)
+		}
+		flows = append(flows, f)
+	}
+	idx.Flows = flows
+}
+
+// removeSuppressedSteps drops graph steps whose element is suppressed and
+// reconnects their predecessors to their successors, as removing an element
+// from a flat step list does.
+func removeSuppressedSteps(g *FlowGraph, suppressed map[string]bool) *FlowGraph {
+	dropped := make(map[string]bool)
+	out := &FlowGraph{}
+	for _, step := range g.Steps {
+		if suppressed[step.Ref] {
+			dropped[step.ID] = true
+		} else {
+			out.Steps = append(out.Steps, step)
+		}
+	}
+	if len(dropped) == 0 {
+		return g
+	}
+
+	transitions := g.Transitions
+	for _, step := range g.Steps {
+		if !dropped[step.ID] {
+			continue
+		}
+		id := step.ID
+		var in, outgoing, rest []FlowTransition
+		for _, t := range transitions {
+			switch {
+			case t.To == id && t.From != id:
+				in = append(in, t)
+			case t.From == id && t.To != id:
+				outgoing = append(outgoing, t)
+			case t.From != id && t.To != id:
+				rest = append(rest, t)
+			}
+		}
+		for _, i := range in {
+			for _, o := range outgoing {
+				bridged := o
+				bridged.From = i.From
+				if bridged.Condition == "" {
+					bridged.Condition = i.Condition
+				}
+				if bridged.Label == "" {
+					bridged.Label = i.Label
+				}
+				rest = append(rest, bridged)
+			}
+		}
+		transitions = rest
+	}
+	out.Transitions = transitions
+	return out
+}
+
+// minOverlayOverlap is the code_ref overlap an overlay entry's match needs
+// to attach to a component whose ID changed. A single shared glob among
+// several is not enough.
+const minOverlayOverlap = 0.5
+
+// CodeRefOverlap returns the Jaccard similarity of two code_ref sets, used to
+// recognize the same component across analyses when its ID changes.
+// Duplicate refs count once.
+func CodeRefOverlap(a, b []string) float64 {
+	set := make(map[string]bool, len(a))
+	for _, ref := range a {
+		set[ref] = true
+	}
+	seen := make(map[string]bool, len(b))
+	shared, union := 0, len(set)
+	for _, ref := range b {
+		if seen[ref] {
+			continue
+		}
+		seen[ref] = true
+		if set[ref] {
+			shared++
+		} else {
+			union++
+		}
+	}
+	if union == 0 {
+		return 0
+	}
+	return float64(shared) / float64(union)
+}
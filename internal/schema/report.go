@@ -0,0 +1,175 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Locate fills in the Line of every problem using the raw index document
+// the result was produced from.
+func (r *ValidationResult) Locate(data []byte) {
+	lines := LocatePaths(data)
+	for i := range r.Errors {
+		r.Errors[i].Line = lookupLine(lines, r.Errors[i].Path)
+	}
+	for i := range r.Warnings {
+		r.Warnings[i].Line = lookupLine(lines, r.Warnings[i].Path)
+	}
+}
+
+// jsonReport is the document produced by FormatJSON.
+type jsonReport struct {
+	Valid    bool                `json:"valid"`
+	Errors   int                 `json:"errors"`
+	Warnings int                 `json:"warnings"`
+	Problems []ValidationProblem `json:"problems"`
+}
+
+// FormatJSON returns the validation result as an indented JSON document.
+func (r *ValidationResult) FormatJSON() ([]byte, error) {
+	return json.MarshalIndent(jsonReport{
+		Valid:    r.Valid,
+		Errors:   len(r.Errors),
+		Warnings: len(r.Warnings),
+		Problems: r.Problems(),
+	}, "", "  ")
+}
+
+// ruleDescriptions documents each problem code for SARIF consumers.
+var ruleDescriptions = map[string]string{
+	CodeRequired:     "A required field is missing or empty.",
+	CodeDuplicateID:  "An id is used by more than one element.",
+	CodeInvalidGlob:  "A code_ref is not a valid glob pattern.",
+	CodeUnknownRef:   "A relationship or flow step references an id that does not exist.",
+	CodeInvalidValue: "A field has a value outside its allowed set or format.",
+}
+
+// SARIF 2.1.0 document types, limited to the fields canopy emits.
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID           string          `json:"ruleId"`
+	Level            string          `json:"level"`
+	Message          sarifMessage    `json:"message"`
+	Locations        []sarifLocation `json:"locations"`
+	RelatedLocations []sarifLocation `json:"relatedLocations,omitempty"`
+}
+
+type sarifLocation struct {
+	ID               int                    `json:"id,omitempty"`
+	PhysicalLocation sarifPhysicalLocation  `json:"physicalLocation"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations,omitempty"`
+	Message          *sarifMessage          `json:"message,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+type sarifLogicalLocation struct {
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+}
+
+// FormatSARIF returns the validation result as a SARIF 2.1.0 log. indexURI
+// is the repo-relative path of the validated index.json; problems tied to a
+// source file carry it as a related location.
+func (r *ValidationResult) FormatSARIF(indexURI string) ([]byte, error) {
+	problems := r.Problems()
+
+	codes := make(map[string]bool)
+	results := make([]sarifResult, 0, len(problems))
+	for _, p := range problems {
+		codes[p.Code] = true
+
+		loc := sarifLocation{
+			PhysicalLocation: sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{URI: indexURI},
+			},
+		}
+		if p.Line > 0 {
+			loc.PhysicalLocation.Region = &sarifRegion{StartLine: p.Line}
+		}
+		if p.Path != "" {
+			loc.LogicalLocations = []sarifLogicalLocation{{FullyQualifiedName: p.Path}}
+		}
+
+		res := sarifResult{
+			RuleID:    p.Code,
+			Level:     string(p.Severity),
+			Message:   sarifMessage{Text: p.String()},
+			Locations: []sarifLocation{loc},
+		}
+		if p.File != "" {
+			res.RelatedLocations = []sarifLocation{{
+				ID: 1,
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: p.File},
+				},
+				Message: &sarifMessage{Text: "source file"},
+			}}
+		}
+		results = append(results, res)
+	}
+
+	rules := make([]sarifRule, 0, len(codes))
+	for code := range codes {
+		rules = append(rules, sarifRule{ID: code, ShortDescription: sarifMessage{Text: ruleDescriptions[code]}})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+
+	data, err := json.MarshalIndent(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "canopy",
+				InformationURI: "https://github.com/nhomble/canopy",
+				Rules:          rules,
+			}},
+			Results: results,
+		}},
+	}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshaling SARIF: %w", err)
+	}
+	return data, nil
+}
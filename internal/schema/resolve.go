@@ -0,0 +1,72 @@
+package schema
+
+import (
+	"path/filepath"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// ComponentResolver maps files and archetypes to the component whose
+// code_refs match them. It matches linearly; the server compiles the same
+// rules into a faster matcher for hot paths.
+type ComponentResolver struct {
+	refs        []resolverRef
+	archetypeOf map[string]string // archetype ID → component ID
+}
+
+type resolverRef struct {
+	pattern     string
+	componentID string
+	specificity int
+}
+
+// NewComponentResolver indexes the code_refs and archetypes of idx.
+func NewComponentResolver(idx *ArchIndex) *ComponentResolver {
+	r := &ComponentResolver{archetypeOf: make(map[string]string)}
+	for _, comp := range idx.Components {
+		for _, pattern := range comp.CodeRefs {
+			r.refs = append(r.refs, resolverRef{pattern: pattern, componentID: comp.ID, specificity: CodeRefSpecificity(pattern)})
+		}
+	}
+	for _, archetypes := range idx.Archetypes {
+		for _, arch := range archetypes {
+			if comp := r.ComponentForFile(arch.File); comp != "" {
+				r.archetypeOf[arch.ID] = comp
+			}
+		}
+	}
+	return r
+}
+
+// ComponentForFile returns the ID of the component owning a file: the one
+// with the most specific matching code_ref, the first on a tie. It returns
+// "" when no code_ref matches.
+func (r *ComponentResolver) ComponentForFile(file string) string {
+	file = filepath.ToSlash(file)
+	best, bestSpecificity := "", -1
+	for _, ref := range r.refs {
+		if ok, err := doublestar.Match(ref.pattern, file); err != nil || !ok {
+			continue
+		}
+		if ref.specificity > bestSpecificity {
+			best, bestSpecificity = ref.componentID, ref.specificity
+		}
+	}
+	return best
+}
+
+// ComponentOf returns the ID of the component owning an archetype, or "".
+func (r *ComponentResolver) ComponentOf(archetypeID string) string {
+	return r.archetypeOf[archetypeID]
+}
+
+// CodeRefSpecificity ranks code_refs matching the same file: the length of
+// the pattern before its first glob character.
+func CodeRefSpecificity(pattern string) int {
+	for i, c := range pattern {
+		if c == '*' || c == '?' || c == '[' || c == '{' {
+			return i
+		}
+	}
+	return len(pattern)
+}
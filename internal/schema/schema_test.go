@@ -1,7 +1,9 @@
 package schema
 
 import (
+	"encoding/json"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -124,3 +126,432 @@ func TestSaveAndLoadRoundTrip(t *testing.T) {
 	// Cleanup
 	os.Remove(tmpFile)
 }
+
+func TestValidateProblemCodes(t *testing.T) {
+	idx := &ArchIndex{
+		RepoID: "test",
+		Components: []Component{
+			{ID: "comp1", Name: "A", Layer: "core", CodeRefs: []string{"a/**"}},
+		},
+		Archetypes: map[string][]Archetype{
+			"services": {{ID: "svc", File: "a/svc.go"}},
+		},
+		Relationships: []Relationship{
+			{From: "svc", To: "missing", Type: "calls"},
+		},
+	}
+	result := ValidateIndex(idx)
+	if !result.Valid {
+		t.Fatalf("unknown references should only warn:\n%s", result.FormatResult())
+	}
+	if len(result.Warnings) != 1 {
+		t.Fatalf("expected 1 warning, got %d", len(result.Warnings))
+	}
+	w := result.Warnings[0]
+	if w.Code != CodeUnknownRef || w.Severity != SeverityWarning {
+		t.Fatalf("unexpected warning: %+v", w)
+	}
+	if w.Path != "relationships[0].to" {
+		t.Fatalf("expected path relationships[0].to, got %s", w.Path)
+	}
+	if w.File != "a/svc.go" {
+		t.Fatalf("expected file a/svc.go, got %s", w.File)
+	}
+}
+
+func TestValidateMetadata(t *testing.T) {
+	idx := &ArchIndex{
+		RepoID: "test",
+		Components: []Component{
+			{
+				ID: "comp1", Name: "A", Layer: "core", CodeRefs: []string{"a/**"},
+				Description: "Handles A",
+				Tags:        []string{"payments", " "},
+				Links: []Link{
+					{Type: "runbook", URL: "https://wiki.example.com/a"},
+					{Type: "docs", URL: "docs/a.md"},
+				},
+			},
+			{ID: "comp2", Name: "B", Layer: "core", CodeRefs: []string{"b/**"}},
+		},
+		Relationships: []Relationship{
+			{From: "comp1", To: "comp2", Type: "calls", Protocol: "grpc", Mode: ModeSync},
+			{From: "comp1", To: "comp2", Type: "produces", Protocol: "kafak", Mode: "fire-and-forget"},
+		},
+	}
+	result := ValidateIndex(idx)
+
+	var errPaths, warnPaths []string
+	for _, p := range result.Errors {
+		if p.Code != CodeInvalidValue {
+			t.Errorf("unexpected error: %+v", p)
+		}
+		errPaths = append(errPaths, p.Path)
+	}
+	for _, p := range result.Warnings {
+		warnPaths = append(warnPaths, p.Path)
+	}
+	wantErrs := []string{"components[0].tags[1]", "components[0].links[1].url", "relationships[1].mode"}
+	if strings.Join(errPaths, ",") != strings.Join(wantErrs, ",") {
+		t.Errorf("errors = %v, want %v", errPaths, wantErrs)
+	}
+	if strings.Join(warnPaths, ",") != "relationships[1].protocol" {
+		t.Errorf("warnings = %v, want [relationships[1].protocol]", warnPaths)
+	}
+}
+
+func TestValidateFlowGraph(t *testing.T) {
+	idx := &ArchIndex{
+		RepoID: "test",
+		Components: []Component{
+			{ID: "api", Name: "API", Layer: "edge", CodeRefs: []string{"api/**"}},
+			{ID: "billing", Name: "Billing", Layer: "core", CodeRefs: []string{"billing/**"}},
+			{ID: "mailer", Name: "Mailer", Layer: "infra", CodeRefs: []string{"mail/**"}},
+		},
+		Flows: []Flow{
+			{ID: "checkout", Name: "Checkout", Graph: &FlowGraph{
+				Steps: []FlowStep{
+					{ID: "receive", Ref: "api"},
+					{ID: "charge", Ref: "billing", Parallel: "fanout"},
+					{ID: "notify", Ref: "mailer", Parallel: "fanout"},
+					{ID: "reject", Ref: "api", Label: "return 402"},
+				},
+				Transitions: []FlowTransition{
+					{From: "receive", To: "charge"},
+					{From: "receive", To: "notify"},
+					{From: "charge", To: "reject", Label: "on error", Condition: "card declined"},
+				},
+			}},
+			{ID: "broken", Name: "Broken", Steps: []string{"api"}, Graph: &FlowGraph{
+				Steps: []FlowStep{{ID: "a", Ref: "api"}},
+			}},
+			{ID: "dangling", Name: "Dangling", Graph: &FlowGraph{
+				Steps:       []FlowStep{{ID: "a", Ref: "api"}, {ID: "a", Ref: "ghost"}},
+				Transitions: []FlowTransition{{From: "a", To: "missing"}},
+			}},
+		},
+	}
+	result := ValidateIndex(idx)
+
+	var errPaths, warnPaths []string
+	for _, p := range result.Errors {
+		errPaths = append(errPaths, p.Path)
+	}
+	for _, p := range result.Warnings {
+		warnPaths = append(warnPaths, p.Path)
+	}
+	wantErrs := "flows[1].graph,flows[2].graph.steps[1].id,flows[2].graph.transitions[0].to"
+	if strings.Join(errPaths, ",") != wantErrs {
+		t.Errorf("errors = %v, want %s", errPaths, wantErrs)
+	}
+	if strings.Join(warnPaths, ",") != "flows[2].graph.steps[1].ref" {
+		t.Errorf("warnings = %v", warnPaths)
+	}
+
+	checkout := idx.Flows[0]
+	if got := strings.Join(checkout.Refs(), ","); got != "api,billing,mailer" {
+		t.Errorf("Refs() = %s, want api,billing,mailer", got)
+	}
+	if got := checkout.Graph.EntrySteps(); len(got) != 1 || got[0] != "receive" {
+		t.Errorf("EntrySteps() = %v, want [receive]", got)
+	}
+}
+
+func TestFlowNormalizedFlat(t *testing.T) {
+	f := Flow{ID: "f", Name: "F", Steps: []string{"a", "b", "a"}}
+	g := f.Normalized()
+	if len(g.Steps) != 3 || len(g.Transitions) != 2 {
+		t.Fatalf("unexpected graph: %+v", g)
+	}
+	if g.Transitions[1] != (FlowTransition{From: "2", To: "3"}) {
+		t.Errorf("unexpected transition: %+v", g.Transitions[1])
+	}
+	if got := strings.Join(f.Refs(), ","); got != "a,b" {
+		t.Errorf("Refs() = %s, want a,b", got)
+	}
+}
+
+func TestRemoveSuppressedStepsBridges(t *testing.T) {
+	g := &FlowGraph{
+		Steps: []FlowStep{{ID: "a", Ref: "api"}, {ID: "b", Ref: "legacy"}, {ID: "c", Ref: "db"}},
+		Transitions: []FlowTransition{
+			{From: "a", To: "b", Condition: "cache miss"},
+			{From: "b", To: "c"},
+		},
+	}
+	got := removeSuppressedSteps(g, map[string]bool{"legacy": true})
+	if len(got.Steps) != 2 {
+		t.Fatalf("expected 2 steps, got %+v", got.Steps)
+	}
+	want := []FlowTransition{{From: "a", To: "c", Condition: "cache miss"}}
+	if len(got.Transitions) != 1 || got.Transitions[0] != want[0] {
+		t.Errorf("transitions = %+v, want %+v", got.Transitions, want)
+	}
+}
+
+func TestLocatePaths(t *testing.T) {
+	data := []byte(`{
+  "repo_id": "test",
+  "components": [
+    {
+      "id": "comp1",
+      "code_refs": ["a/**"]
+    }
+  ],
+  "archetypes": {
+    "services": [
+      {"id": "svc", "file": "a/svc.go"}
+    ]
+  }
+}`)
+	lines := LocatePaths(data)
+	tests := map[string]int{
+		"repo_id":                   2,
+		"components[0]":             4,
+		"components[0].id":          5,
+		"components[0].code_refs":   6,
+		"archetypes.services[0]":    11,
+		"archetypes.services[0].id": 11,
+	}
+	for path, want := range tests {
+		if got := lines[path]; got != want {
+			t.Errorf("line for %s = %d, want %d", path, got, want)
+		}
+	}
+	if got := lookupLine(lines, "components[0].name"); got != 4 {
+		t.Errorf("missing field should resolve to its parent line, got %d", got)
+	}
+}
+
+func TestFormatSARIF(t *testing.T) {
+	idx := &ArchIndex{
+		RepoID: "test",
+		Components: []Component{
+			{ID: "comp1", Name: "A", Layer: "core", CodeRefs: []string{"a/**"}},
+			{ID: "comp1", Name: "B", Layer: "core", CodeRefs: []string{"b/**"}},
+		},
+	}
+	result := ValidateIndex(idx)
+	data, err := result.FormatSARIF(".canopy/index.json")
+	if err != nil {
+		t.Fatalf("FormatSARIF: %v", err)
+	}
+
+	var log sarifLog
+	if err := json.Unmarshal(data, &log); err != nil {
+		t.Fatalf("unmarshal SARIF: %v", err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("unexpected SARIF envelope: %+v", log)
+	}
+	results := log.Runs[0].Results
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(results))
+	}
+	if results[0].RuleID != CodeDuplicateID || results[0].Level != "error" {
+		t.Fatalf("unexpected result: %+v", results[0])
+	}
+	if uri := results[0].Locations[0].PhysicalLocation.ArtifactLocation.URI; uri != ".canopy/index.json" {
+		t.Fatalf("expected index.json location, got %s", uri)
+	}
+}
+
+func TestMigrateUnversioned(t *testing.T) {
+	data, from, err := Migrate([]byte(`{"repo_id": "test", "components": []}`))
+	if err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if from != 0 {
+		t.Fatalf("expected source version 0, got %d", from)
+	}
+
+	var idx ArchIndex
+	if err := json.Unmarshal(data, &idx); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if idx.SchemaVersion != CurrentSchemaVersion {
+		t.Fatalf("expected schema_version %d, got %d", CurrentSchemaVersion, idx.SchemaVersion)
+	}
+	if idx.RepoID != "test" {
+		t.Fatalf("repo_id lost during migration: %q", idx.RepoID)
+	}
+}
+
+func TestParseIndexRecordsSourceVersion(t *testing.T) {
+	idx, err := ParseIndex([]byte(`{"repo_id": "test", "components": []}`))
+	if err != nil {
+		t.Fatalf("ParseIndex: %v", err)
+	}
+	if idx.SourceSchemaVersion != 0 || idx.SchemaVersion != CurrentSchemaVersion {
+		t.Fatalf("expected source version 0 migrated to %d, got %d and %d",
+			CurrentSchemaVersion, idx.SourceSchemaVersion, idx.SchemaVersion)
+	}
+}
+
+func TestMigrateRejectsNewerVersion(t *testing.T) {
+	_, _, err := Migrate([]byte(`{"schema_version": 999, "repo_id": "test"}`))
+	if err == nil {
+		t.Fatal("expected error for newer schema version")
+	}
+}
+
+func TestLoadGoldenIndexIsMigrated(t *testing.T) {
+	idx, err := LoadIndex("../../testdata/golden/index.json")
+	if err != nil {
+		t.Fatalf("loading golden index: %v", err)
+	}
+	if idx.SchemaVersion != CurrentSchemaVersion {
+		t.Fatalf("expected schema_version %d, got %d", CurrentSchemaVersion, idx.SchemaVersion)
+	}
+}
+
+func TestApplyOverlay(t *testing.T) {
+	idx := &ArchIndex{
+		RepoID: "test",
+		Components: []Component{
+			{ID: "crm", Name: "CRM", Layer: "bounded-context", CodeRefs: []string{"Customer/**"}},
+			{ID: "legacy", Name: "Legacy", Layer: "adapters", CodeRefs: []string{"legacy/**"}},
+		},
+		Archetypes: map[string][]Archetype{
+			"controllers": {{ID: "customer-ctl", File: "Customer/Controller.java", Symbol: "CustomerController"}},
+			"adapters":    {{ID: "legacy-db", File: "legacy/db.go"}},
+		},
+		Relationships: []Relationship{
+			{From: "customer-ctl", To: "legacy-db", Type: "calls"},
+		},
+		Flows: []Flow{
+			{ID: "signup", Name: "Signup", Steps: []string{"customer-ctl", "legacy-db"}},
+		},
+	}
+	ov := &Overlay{Elements: map[string]OverlayEntry{
+		// Renamed since the overlay was written; found via code_refs.
+		"customer-service": {
+			Match:       &OverlayMatch{CodeRefs: []string{"Customer/**"}},
+			Override:    map[string]any{"layer": "core"},
+			Annotations: map[string]any{"owner": "team-crm"},
+		},
+		"old-controller-id": {
+			Match:       &OverlayMatch{File: "Customer/Controller.java"},
+			Annotations: map[string]any{"wiki": "https://wiki/crm"},
+		},
+		"legacy-db": {Suppress: true},
+		"gone":      {Annotations: map[string]any{"note": "orphan"}},
+	}}
+
+	results := ApplyOverlay(idx, ov)
+
+	byKey := make(map[string]OverlayResult)
+	for _, r := range results {
+		byKey[r.Key] = r
+	}
+	if r := byKey["customer-service"]; r.Target != "crm" || r.MatchedBy != "code_refs" {
+		t.Fatalf("expected code_refs match onto crm, got %+v", r)
+	}
+	if r := byKey["old-controller-id"]; r.Target != "customer-ctl" || r.MatchedBy != "file" {
+		t.Fatalf("expected file match onto customer-ctl, got %+v", r)
+	}
+	if r := byKey["gone"]; r.Target != "" || r.Warning == "" {
+		t.Fatalf("expected orphan warning, got %+v", r)
+	}
+
+	if idx.Components[0].Layer != "core" {
+		t.Fatalf("expected layer override, got %s", idx.Components[0].Layer)
+	}
+	if idx.Components[0].Annotations["owner"] != "team-crm" {
+		t.Fatalf("expected owner annotation, got %v", idx.Components[0].Annotations)
+	}
+	if len(idx.Archetypes["adapters"]) != 0 {
+		t.Fatal("expected suppressed archetype to be removed")
+	}
+	if len(idx.Relationships) != 0 {
+		t.Fatal("expected relationships to suppressed element to be removed")
+	}
+	if len(idx.Flows[0].Steps) != 1 {
+		t.Fatalf("expected suppressed flow step to be removed, got %v", idx.Flows[0].Steps)
+	}
+}
+
+func TestApplyOverlayCodeRefMatchThreshold(t *testing.T) {
+	idx := &ArchIndex{
+		RepoID: "test",
+		Components: []Component{
+			{ID: "orders", CodeRefs: []string{"orders/**", "shared/**"}},
+			{ID: "billing", CodeRefs: []string{"billing/**", "shared/**"}},
+			{ID: "web", CodeRefs: []string{"web/**", "assets/**", "shared/**"}},
+		},
+	}
+	ov := &Overlay{Elements: map[string]OverlayEntry{
+		// Equally close to orders and billing.
+		"ambiguous": {Match: &OverlayMatch{CodeRefs: []string{"shared/**"}}, Annotations: map[string]any{"x": 1}},
+		// Shares only one glob of several with web.
+		"weak": {Match: &OverlayMatch{CodeRefs: []string{"web/**", "cli/**", "docs/**", "tools/**"}}, Annotations: map[string]any{"x": 1}},
+	}}
+
+	for _, r := range ApplyOverlay(idx, ov) {
+		if r.Target != "" {
+			t.Errorf("%s: expected no match, got %s", r.Key, r.Target)
+		}
+		if r.Key == "ambiguous" && !strings.Contains(r.Warning, "orders, billing") {
+			t.Errorf("expected an ambiguity warning naming both components, got %q", r.Warning)
+		}
+		if r.Key == "weak" && r.Warning != "no matching element" {
+			t.Errorf("expected no match for a weak overlap, got %q", r.Warning)
+		}
+	}
+}
+
+func TestCodeRefOverlapIgnoresDuplicates(t *testing.T) {
+	if ov := CodeRefOverlap([]string{"x"}, []string{"x", "x"}); ov != 1 {
+		t.Fatalf("expected overlap 1, got %v", ov)
+	}
+}
+
+func TestApplyOverlayRejectsIDOverride(t *testing.T) {
+	idx := &ArchIndex{
+		Components: []Component{{ID: "a", Name: "A", Layer: "core", CodeRefs: []string{"a/**"}}},
+	}
+	results := ApplyOverlay(idx, &Overlay{Elements: map[string]OverlayEntry{
+		"a": {Override: map[string]any{"id": "b"}},
+	}})
+	if results[0].Warning == "" || idx.Components[0].ID != "a" {
+		t.Fatalf("expected id override to be rejected, got %+v", results[0])
+	}
+}
+
+func TestComponentResolver(t *testing.T) {
+	r := NewComponentResolver(&ArchIndex{
+		Components: []Component{
+			{ID: "orders", CodeRefs: []string{"src/**"}},
+			{ID: "orders-api", CodeRefs: []string{"src/api/**"}},
+		},
+		Archetypes: map[string][]Archetype{
+			"controllers": {{ID: "order-ctl", File: "src/api/orders.go"}},
+			"scripts":     {{ID: "deploy", File: "deploy.sh"}},
+		},
+	})
+	if got := r.ComponentForFile("src/model.go"); got != "orders" {
+		t.Errorf("expected orders, got %q", got)
+	}
+	if got := r.ComponentOf("order-ctl"); got != "orders-api" {
+		t.Errorf("expected the most specific code_ref to win, got %q", got)
+	}
+	if got := r.ComponentOf("deploy"); got != "" {
+		t.Errorf("expected no component for an unmatched file, got %q", got)
+	}
+}
+
+func TestFileSlug(t *testing.T) {
+	tests := map[string]string{
+		"order-service": "order-service",
+		"api_v2.1":      "api_v2.1",
+		"team/checkout": "team~2fcheckout",
+		"../etc":        "~2e.~2fetc",
+		"a~2fb":         "a~7e2fb",
+		"":              "",
+	}
+	for id, want := range tests {
+		if got := FileSlug(id); got != want {
+			t.Errorf("FileSlug(%q) = %q, want %q", id, got, want)
+		}
+	}
+}
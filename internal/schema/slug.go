@@ -0,0 +1,25 @@
+package schema
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FileSlug turns an element ID into a safe file name: letters, digits, '-',
+// '_' and inner dots are kept, every other byte (including '/' and a
+// leading '.') becomes "~" and its hex code. Distinct IDs get distinct
+// slugs, and IDs made of the usual characters are returned unchanged.
+func FileSlug(id string) string {
+	var b strings.Builder
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.' && i > 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "~%02x", c)
+		}
+	}
+	return b.String()
+}
@@ -1,14 +1,23 @@
 package schema
 
+// CurrentSchemaVersion is the index.json schema version this build reads and
+// writes. Older documents are upgraded on load by the migration registry.
+const CurrentSchemaVersion = 1
+
 // ArchIndex is the root data structure stored in .canopy/index.json.
 // It represents the full architectural analysis of a codebase.
 type ArchIndex struct {
-	RepoID        string                `json:"repo_id"`
-	Patterns      []string              `json:"patterns"`
-	Components    []Component           `json:"components"`
+	SchemaVersion int                    `json:"schema_version"`
+	RepoID        string                 `json:"repo_id"`
+	Patterns      []string               `json:"patterns"`
+	Components    []Component            `json:"components"`
 	Archetypes    map[string][]Archetype `json:"archetypes"`
-	Relationships []Relationship        `json:"relationships"`
-	Flows         []Flow                `json:"flows,omitempty"`
+	Relationships []Relationship         `json:"relationships"`
+	Flows         []Flow                 `json:"flows,omitempty"`
+
+	// SourceSchemaVersion is the schema version the document had on disk,
+	// before ParseIndex migrated it. It is not written back.
+	SourceSchemaVersion int `json:"-"`
 }
 
 // Component represents a logical grouping of code (e.g., a microservice,
@@ -21,6 +30,12 @@ type Component struct {
 	Provides       *Provides `json:"provides,omitempty"`
 	NestedAnalysis string    `json:"nested_analysis,omitempty"`
 	Analyzed       bool      `json:"analyzed"`
+	Description    string    `json:"description,omitempty"`
+	Tags           []string  `json:"tags,omitempty"`
+	Links          []Link    `json:"links,omitempty"`
+
+	// Annotations holds fields added by the overlay; never written by analysis.
+	Annotations map[string]any `json:"annotations,omitempty"`
 }
 
 // Provides describes what a component exports (interfaces, symbols).
@@ -29,6 +44,14 @@ type Provides struct {
 	Symbols   []string `json:"symbols,omitempty"`
 }
 
+// Link points from a component to an external resource such as a runbook,
+// dashboard or design doc.
+type Link struct {
+	Type  string `json:"type"` // e.g., "runbook", "dashboard", "docs"
+	URL   string `json:"url"`
+	Title string `json:"title,omitempty"`
+}
+
 // Archetype represents a specific code element classified by its architectural
 // role (e.g., controller, repository, middleware, service).
 type Archetype struct {
@@ -44,31 +67,103 @@ type Archetype struct {
 	Purpose        string   `json:"purpose,omitempty"`
 	Entity         string   `json:"entity,omitempty"`
 	TargetService  string   `json:"target_service,omitempty"`
+
+	// Annotations are overlay-only, as on Component.
+	Annotations map[string]any `json:"annotations,omitempty"`
 }
 
 // Relationship describes a dependency or interaction between two
 // components or archetypes.
 type Relationship struct {
-	From string `json:"from"`
-	To   string `json:"to"`
-	Type string `json:"type"`
-	Flow string `json:"flow,omitempty"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Type        string `json:"type"`
+	Flow        string `json:"flow,omitempty"`
+	Protocol    string `json:"protocol,omitempty"` // e.g., "http", "grpc", "sql", "kafka"
+	Mode        string `json:"mode,omitempty"`     // ModeSync or ModeAsync
+	Description string `json:"description,omitempty"`
 }
 
+// Relationship modes.
+const (
+	ModeSync  = "sync"
+	ModeAsync = "async"
+)
+
 // Flow describes a path that a request or data takes through the system.
+// Linear flows list element IDs in Steps; flows with branches, error paths
+// or parallel work use Graph instead.
 type Flow struct {
-	ID      string   `json:"id"`
-	Name    string   `json:"name"`
-	Steps   []string `json:"steps"`
-	Pattern string   `json:"pattern,omitempty"`
+	ID      string     `json:"id"`
+	Name    string     `json:"name"`
+	Steps   []string   `json:"steps,omitempty"`
+	Graph   *FlowGraph `json:"graph,omitempty"`
+	Pattern string     `json:"pattern,omitempty"`
+
+	// Annotations are overlay-only, as on Component.
+	Annotations map[string]any `json:"annotations,omitempty"`
+}
+
+// FlowGraph is the branching form of a flow. Steps run in the order given
+// by Transitions; steps with no incoming transition are entry points.
+type FlowGraph struct {
+	Steps       []FlowStep       `json:"steps"`
+	Transitions []FlowTransition `json:"transitions,omitempty"`
+}
+
+// FlowStep is a node in a flow graph. The same element may appear in
+// several steps, e.g. a service called on both the happy and error path.
+type FlowStep struct {
+	ID    string `json:"id"`  // unique within the flow
+	Ref   string `json:"ref"` // component or archetype ID
+	Label string `json:"label,omitempty"`
+	// Parallel names a group of steps that run concurrently.
+	Parallel string `json:"parallel,omitempty"`
+}
+
+// FlowTransition connects two steps of a flow graph.
+type FlowTransition struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Label     string `json:"label,omitempty"`     // e.g., "on error", "async"
+	Condition string `json:"condition,omitempty"` // e.g., "payment declined"
 }
 
 // Config represents the user configuration stored in .canopy/config.json.
 type Config struct {
-	Version         string   `json:"version"`
-	RepoID          string   `json:"repo_id"`
-	IgnorePatterns  []string `json:"ignore_patterns"`
-	MaxFileSizeBytes int64   `json:"max_file_size_bytes"`
+	Version          string          `json:"version"`
+	RepoID           string          `json:"repo_id"`
+	IgnorePatterns   []string        `json:"ignore_patterns"`
+	MaxFileSizeBytes int64           `json:"max_file_size_bytes"`
+	History          HistoryConfig   `json:"history"`
+	Backstage        BackstageConfig `json:"backstage"`
+	Server           ServerConfig    `json:"server"`
+}
+
+// ServerConfig configures canopy serve.
+type ServerConfig struct {
+	// AllowedOrigins lists the web origins, such as
+	// "http://localhost:3000", allowed to call the server from a browser
+	// besides the server's own pages.
+	AllowedOrigins []string `json:"allowed_origins,omitempty"`
+}
+
+// BackstageConfig supplies catalog fields the index does not know, for
+// canopy export --format backstage.
+type BackstageConfig struct {
+	Owner     string            `json:"owner,omitempty"`     // default owner, e.g. "group:platform"
+	Owners    map[string]string `json:"owners,omitempty"`    // component ID → owner
+	System    string            `json:"system,omitempty"`    // system every entity belongs to
+	Lifecycle string            `json:"lifecycle,omitempty"` // defaults to "production"
+}
+
+// HistoryConfig sets the retention policy for .canopy/history/ snapshots.
+// Snapshots of edits made through canopy serve are limited separately from
+// the rest. Zero values mean unlimited.
+type HistoryConfig struct {
+	MaxSnapshots     int `json:"max_snapshots"`
+	MaxEditSnapshots int `json:"max_edit_snapshots"`
+	MaxAgeDays       int `json:"max_age_days,omitempty"`
 }
 
 // DefaultConfig returns sensible defaults for a new project.
@@ -81,5 +176,6 @@ func DefaultConfig(repoID string) Config {
 			"__pycache__", ".venv", "target", ".idea", ".vscode",
 		},
 		MaxFileSizeBytes: 1 << 20, // 1MB
+		History:          HistoryConfig{MaxSnapshots: 20, MaxEditSnapshots: 50},
 	}
 }
@@ -2,29 +2,68 @@ package schema
 
 import (
 	"fmt"
+	"net/url"
+	"slices"
 	"strings"
 
 	"github.com/bmatcuk/doublestar/v4"
 )
 
+// Severity classifies how serious a validation problem is.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Problem codes are stable identifiers for each kind of validation problem,
+// suitable for filtering in CI and as SARIF rule IDs.
+const (
+	CodeRequired     = "required"
+	CodeDuplicateID  = "duplicate-id"
+	CodeInvalidGlob  = "invalid-glob"
+	CodeUnknownRef   = "unknown-reference"
+	CodeInvalidValue = "invalid-value"
+)
+
+// KnownProtocols are the relationship protocols canopy recognizes. Others
+// are accepted with a warning, since they are often typos.
+var KnownProtocols = []string{
+	"http", "grpc", "graphql", "websocket", "sql", "kafka", "amqp", "sqs",
+	"sns", "pubsub", "redis", "smtp", "file", "in-process",
+}
+
 // ValidationResult holds the outcome of validating an ArchIndex.
 type ValidationResult struct {
 	Valid    bool
-	Errors   []ValidationError
-	Warnings []string
+	Errors   []ValidationProblem
+	Warnings []ValidationProblem
 }
 
-// ValidationError describes a specific validation failure.
-type ValidationError struct {
-	Path    string // e.g., "components[0].id"
-	Message string
+// ValidationProblem describes a specific validation finding.
+type ValidationProblem struct {
+	Path     string   `json:"path"` // e.g., "components[0].id"
+	Code     string   `json:"code"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+	File     string   `json:"file,omitempty"` // source file the problem concerns, if any
+	Line     int      `json:"line,omitempty"` // line in index.json, set by Locate
 }
 
-func (e ValidationError) String() string {
-	if e.Path != "" {
-		return fmt.Sprintf("%s: %s", e.Path, e.Message)
+func (p ValidationProblem) String() string {
+	if p.Path != "" {
+		return fmt.Sprintf("%s: %s", p.Path, p.Message)
 	}
-	return e.Message
+	return p.Message
+}
+
+// Problems returns all errors followed by all warnings.
+func (r *ValidationResult) Problems() []ValidationProblem {
+	all := make([]ValidationProblem, 0, len(r.Errors)+len(r.Warnings))
+	all = append(all, r.Errors...)
+	all = append(all, r.Warnings...)
+	return all
 }
 
 // ValidateIndex performs structural and semantic validation on an ArchIndex.
@@ -33,10 +72,10 @@ func ValidateIndex(idx *ArchIndex) *ValidationResult {
 
 	// Required fields
 	if idx.RepoID == "" {
-		result.addError("repo_id", "repo_id is required")
+		result.addError("repo_id", CodeRequired, "repo_id is required", "")
 	}
 	if len(idx.Components) == 0 {
-		result.addError("components", "at least one component is required")
+		result.addError("components", CodeRequired, "at least one component is required", "")
 	}
 
 	// Collect all known IDs
@@ -46,25 +85,42 @@ func ValidateIndex(idx *ArchIndex) *ValidationResult {
 	for i, comp := range idx.Components {
 		prefix := fmt.Sprintf("components[%d]", i)
 		if comp.ID == "" {
-			result.addError(prefix+".id", "component id is required")
+			result.addError(prefix+".id", CodeRequired, "component id is required", "")
 		} else if ids[comp.ID] {
-			result.addError(prefix+".id", fmt.Sprintf("duplicate id: %s", comp.ID))
+			result.addError(prefix+".id", CodeDuplicateID, fmt.Sprintf("duplicate id: %s", comp.ID), "")
 		} else {
 			ids[comp.ID] = true
 		}
 		if comp.Name == "" {
-			result.addError(prefix+".name", "component name is required")
+			result.addError(prefix+".name", CodeRequired, "component name is required", "")
 		}
 		if comp.Layer == "" {
-			result.addError(prefix+".layer", "component layer is required")
+			result.addError(prefix+".layer", CodeRequired, "component layer is required", "")
 		}
 		if len(comp.CodeRefs) == 0 {
-			result.addError(prefix+".code_refs", "at least one code_ref is required")
+			result.addError(prefix+".code_refs", CodeRequired, "at least one code_ref is required", "")
 		}
 		for j, ref := range comp.CodeRefs {
 			if !isValidGlob(ref) {
-				result.addError(fmt.Sprintf("%s.code_refs[%d]", prefix, j),
-					fmt.Sprintf("invalid glob pattern: %s", ref))
+				result.addError(fmt.Sprintf("%s.code_refs[%d]", prefix, j), CodeInvalidGlob,
+					fmt.Sprintf("invalid glob pattern: %s", ref), "")
+			}
+		}
+		for j, tag := range comp.Tags {
+			if strings.TrimSpace(tag) == "" {
+				result.addError(fmt.Sprintf("%s.tags[%d]", prefix, j), CodeInvalidValue, "tag must not be empty", "")
+			}
+		}
+		for j, link := range comp.Links {
+			linkPath := fmt.Sprintf("%s.links[%d]", prefix, j)
+			if link.Type == "" {
+				result.addError(linkPath+".type", CodeRequired, "link type is required", "")
+			}
+			if link.URL == "" {
+				result.addError(linkPath+".url", CodeRequired, "link url is required", "")
+			} else if !isAbsoluteURL(link.URL) {
+				result.addError(linkPath+".url", CodeInvalidValue,
+					fmt.Sprintf("link url must be absolute: %s", link.URL), "")
 			}
 		}
 	}
@@ -74,33 +130,49 @@ func ValidateIndex(idx *ArchIndex) *ValidationResult {
 		for i, arch := range archetypes {
 			prefix := fmt.Sprintf("archetypes.%s[%d]", category, i)
 			if arch.ID == "" {
-				result.addError(prefix+".id", "archetype id is required")
+				result.addError(prefix+".id", CodeRequired, "archetype id is required", arch.File)
 			} else if ids[arch.ID] {
-				result.addError(prefix+".id", fmt.Sprintf("duplicate id: %s", arch.ID))
+				result.addError(prefix+".id", CodeDuplicateID, fmt.Sprintf("duplicate id: %s", arch.ID), arch.File)
 			} else {
 				ids[arch.ID] = true
 			}
 			if arch.File == "" {
-				result.addError(prefix+".file", "archetype file is required")
+				result.addError(prefix+".file", CodeRequired, "archetype file is required", "")
 			}
 		}
 	}
 
+	// Source files for archetype IDs, so reference problems can point at code.
+	files := make(map[string]string)
+	for _, archetypes := range idx.Archetypes {
+		for _, arch := range archetypes {
+			files[arch.ID] = arch.File
+		}
+	}
+
 	// Validate relationships reference valid IDs
 	for i, rel := range idx.Relationships {
 		prefix := fmt.Sprintf("relationships[%d]", i)
 		if rel.From == "" {
-			result.addError(prefix+".from", "from is required")
+			result.addError(prefix+".from", CodeRequired, "from is required", "")
 		} else if !ids[rel.From] {
-			result.addWarning(fmt.Sprintf("%s.from: references unknown id: %s", prefix, rel.From))
+			result.addWarning(prefix+".from", CodeUnknownRef, fmt.Sprintf("references unknown id: %s", rel.From), files[rel.To])
 		}
 		if rel.To == "" {
-			result.addError(prefix+".to", "to is required")
+			result.addError(prefix+".to", CodeRequired, "to is required", "")
 		} else if !ids[rel.To] {
-			result.addWarning(fmt.Sprintf("%s.to: references unknown id: %s", prefix, rel.To))
+			result.addWarning(prefix+".to", CodeUnknownRef, fmt.Sprintf("references unknown id: %s", rel.To), files[rel.From])
 		}
 		if rel.Type == "" {
-			result.addError(prefix+".type", "relationship type is required")
+			result.addError(prefix+".type", CodeRequired, "relationship type is required", "")
+		}
+		if rel.Mode != "" && rel.Mode != ModeSync && rel.Mode != ModeAsync {
+			result.addError(prefix+".mode", CodeInvalidValue,
+				fmt.Sprintf("mode must be %q or %q, got %q", ModeSync, ModeAsync, rel.Mode), files[rel.From])
+		}
+		if rel.Protocol != "" && !slices.Contains(KnownProtocols, rel.Protocol) {
+			result.addWarning(prefix+".protocol", CodeInvalidValue,
+				fmt.Sprintf("unknown protocol: %s", rel.Protocol), files[rel.From])
 		}
 	}
 
@@ -108,17 +180,23 @@ func ValidateIndex(idx *ArchIndex) *ValidationResult {
 	for i, flow := range idx.Flows {
 		prefix := fmt.Sprintf("flows[%d]", i)
 		if flow.ID == "" {
-			result.addError(prefix+".id", "flow id is required")
+			result.addError(prefix+".id", CodeRequired, "flow id is required", "")
 		}
 		if flow.Name == "" {
-			result.addError(prefix+".name", "flow name is required")
+			result.addError(prefix+".name", CodeRequired, "flow name is required", "")
 		}
-		if len(flow.Steps) == 0 {
-			result.addError(prefix+".steps", "at least one step is required")
+		switch {
+		case flow.Graph != nil && len(flow.Steps) > 0:
+			result.addError(prefix+".graph", CodeInvalidValue, "flow has both steps and graph; use one form", "")
+		case flow.Graph != nil:
+			validateFlowGraph(result, prefix+".graph", flow.Graph, ids, files)
+		case len(flow.Steps) == 0:
+			result.addError(prefix+".steps", CodeRequired, "at least one step is required", "")
 		}
 		for j, step := range flow.Steps {
 			if !ids[step] {
-				result.addWarning(fmt.Sprintf("%s.steps[%d]: references unknown id: %s", prefix, j, step))
+				result.addWarning(fmt.Sprintf("%s.steps[%d]", prefix, j), CodeUnknownRef,
+					fmt.Sprintf("references unknown id: %s", step), "")
 			}
 		}
 	}
@@ -126,13 +204,71 @@ func ValidateIndex(idx *ArchIndex) *ValidationResult {
 	return result
 }
 
-func (r *ValidationResult) addError(path, msg string) {
+// validateFlowGraph checks step IDs, element references and transitions of
+// a branching flow.
+func validateFlowGraph(result *ValidationResult, prefix string, g *FlowGraph, ids map[string]bool, files map[string]string) {
+	if len(g.Steps) == 0 {
+		result.addError(prefix+".steps", CodeRequired, "at least one step is required", "")
+		return
+	}
+
+	stepIDs := make(map[string]bool, len(g.Steps))
+	parallel := make(map[string]int)
+	for j, step := range g.Steps {
+		stepPath := fmt.Sprintf("%s.steps[%d]", prefix, j)
+		if step.ID == "" {
+			result.addError(stepPath+".id", CodeRequired, "step id is required", "")
+		} else if stepIDs[step.ID] {
+			result.addError(stepPath+".id", CodeDuplicateID, fmt.Sprintf("duplicate step id: %s", step.ID), "")
+		} else {
+			stepIDs[step.ID] = true
+		}
+		if step.Ref == "" {
+			result.addError(stepPath+".ref", CodeRequired, "step ref is required", "")
+		} else if !ids[step.Ref] {
+			result.addWarning(stepPath+".ref", CodeUnknownRef,
+				fmt.Sprintf("references unknown id: %s", step.Ref), "")
+		}
+		if step.Parallel != "" {
+			parallel[step.Parallel]++
+		}
+	}
+	for j, step := range g.Steps {
+		if step.Parallel != "" && parallel[step.Parallel] == 1 {
+			result.addWarning(fmt.Sprintf("%s.steps[%d].parallel", prefix, j), CodeInvalidValue,
+				fmt.Sprintf("parallel group %s has only one step", step.Parallel), files[step.Ref])
+		}
+	}
+
+	for j, t := range g.Transitions {
+		tPath := fmt.Sprintf("%s.transitions[%d]", prefix, j)
+		for _, end := range []struct{ field, id string }{{"from", t.From}, {"to", t.To}} {
+			if end.id == "" {
+				result.addError(tPath+"."+end.field, CodeRequired, end.field+" is required", "")
+			} else if !stepIDs[end.id] {
+				result.addError(tPath+"."+end.field, CodeUnknownRef,
+					fmt.Sprintf("references unknown step: %s", end.id), "")
+			}
+		}
+	}
+
+	if len(g.EntrySteps()) == 0 {
+		result.addWarning(prefix+".transitions", CodeInvalidValue,
+			"every step has an incoming transition, so the flow has no entry point", "")
+	}
+}
+
+func (r *ValidationResult) addError(path, code, msg, file string) {
 	r.Valid = false
-	r.Errors = append(r.Errors, ValidationError{Path: path, Message: msg})
+	r.Errors = append(r.Errors, ValidationProblem{
+		Path: path, Code: code, Severity: SeverityError, Message: msg, File: file,
+	})
 }
 
-func (r *ValidationResult) addWarning(msg string) {
-	r.Warnings = append(r.Warnings, msg)
+func (r *ValidationResult) addWarning(path, code, msg, file string) {
+	r.Warnings = append(r.Warnings, ValidationProblem{
+		Path: path, Code: code, Severity: SeverityWarning, Message: msg, File: file,
+	})
 }
 
 // FormatResult returns a human-readable string of the validation result.
@@ -152,6 +288,11 @@ func (r *ValidationResult) FormatResult() string {
 	return sb.String()
 }
 
+func isAbsoluteURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
 func isValidGlob(pattern string) bool {
 	_, err := doublestar.Match(pattern, "test")
 	return err == nil
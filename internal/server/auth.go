@@ -0,0 +1,167 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/nhomble/canopy/internal/canopydir"
+)
+
+// TokenCookie is the cookie the web UI sends the access token in. Serving
+// the UI sets it for a browser on the same machine, or for one that opens
+// the UI with ?token=.
+const TokenCookie = "canopy_token"
+
+// streamPaths are the SSE endpoints. They only read, but they reveal what
+// is open in the editor, so they need the token too.
+var streamPaths = []string{"/events", "/cursor/stream"}
+
+// repoAccess returns the access token of the repository whose index is at
+// indexPath, generating it on first use, and the origins its config.json
+// allows.
+func repoAccess(indexPath string) (token string, origins []string, err error) {
+	ad := &canopydir.CanopyDir{Root: filepath.Dir(indexPath)}
+	token, err = ad.LoadOrCreateToken()
+	if err != nil {
+		return "", nil, err
+	}
+	if cfg, err := ad.LoadConfig(); err == nil {
+		origins = cfg.Server.AllowedOrigins
+	}
+	return token, origins, nil
+}
+
+// requireToken guards the endpoints of next that change state or stream
+// events: every method but GET and HEAD, and the SSE streams. Any of tokens
+// is accepted, as a bearer token or in TokenCookie.
+func requireToken(tokens []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/" && len(tokens) > 0 {
+			if t := r.URL.Query().Get("token"); t != "" {
+				if validToken(tokens, t) {
+					setTokenCookie(w, t)
+					// Drop the token from the address bar and history.
+					http.Redirect(w, r, "./", http.StatusSeeOther)
+					return
+				}
+			} else if localRequest(r) {
+				setTokenCookie(w, tokens[0])
+			}
+		}
+		if needsToken(r) && !validToken(tokens, requestToken(r)...) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="canopy"`)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or invalid token; send the token in .canopy/token as a bearer token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func needsToken(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return true
+	}
+	return slices.Contains(streamPaths, r.URL.Path)
+}
+
+// requestToken returns the bearer token and every TokenCookie sent. A
+// workspace page may hold cookies for the root and for its repository.
+func requestToken(r *http.Request) []string {
+	var sent []string
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			sent = append(sent, strings.TrimSpace(token))
+		}
+	}
+	for _, c := range r.CookiesNamed(TokenCookie) {
+		sent = append(sent, c.Value)
+	}
+	return sent
+}
+
+func validToken(tokens []string, sent ...string) bool {
+	for _, s := range sent {
+		for _, t := range tokens {
+			if s != "" && subtle.ConstantTimeCompare([]byte(s), []byte(t)) == 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setTokenCookie leaves the cookie's path to the browser, which scopes it
+// to the directory of the page, so each workspace repository keeps its own.
+func setTokenCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     TokenCookie,
+		Value:    token,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// localRequest reports whether r comes from this machine and names it as
+// localhost or a loopback address. A rebound DNS name pointing here does
+// not count.
+func localRequest(r *http.Request) bool {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil || !isLoopback(remote) {
+		return false
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(r.Host); err == nil {
+		host = h
+	}
+	return host == "localhost" || isLoopback(host)
+}
+
+func isLoopback(host string) bool {
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+// corsMiddleware lets the server's own pages and the allowed origins call
+// it from a browser. Other origins may still send plain reads, whose
+// responses the browser keeps from the calling page, but anything else
+// is refused.
+func corsMiddleware(origins []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		if !allowedOrigin(r, origin, origins) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "origin not allowed: " + origin})
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func allowedOrigin(r *http.Request, origin string, origins []string) bool {
+	if origin == "http://"+r.Host || origin == "https://"+r.Host {
+		return true
+	}
+	return slices.ContainsFunc(origins, func(o string) bool {
+		return strings.TrimSuffix(o, "/") == origin
+	})
+}
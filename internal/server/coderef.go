@@ -0,0 +1,442 @@
+package server
+
+import (
+	"container/list"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/nhomble/canopy/internal/schema"
+)
+
+// componentCacheSize bounds the file → component results FindComponent
+// remembers per index.
+const componentCacheSize = 8192
+
+// maxBraceExpansions bounds how many patterns one code_ref's {a,b}
+// alternatives expand to before it is matched with doublestar instead.
+const maxBraceExpansions = 256
+
+// codeRefMatcher finds the code_refs matching a file without trying every
+// pattern. Patterns are split into path segments; their leading literal
+// segments form a trie, and the rest are compiled once. A lookup walks the
+// trie along the file's directories and only tries the patterns hanging
+// off the nodes it visits. The result is the same as matching every
+// pattern with doublestar.Match: the most specific match wins, the first
+// listed among equals.
+type codeRefMatcher struct {
+	root     *trieNode
+	fallback []compiledRef // patterns too complex to compile, tried on every lookup
+	all      []compiledRef // every valid pattern, for paths with empty segments
+}
+
+type trieNode struct {
+	children map[string]*trieNode
+	refs     []compiledRef // patterns whose literal segments end here
+}
+
+type compiledRef struct {
+	entry       *codeRefEntry
+	order       int // position among all code_refs, to break ties
+	specificity int
+	segs        []globSegment // segments after the literal prefix; nil for fallback
+}
+
+// globSegment matches one path segment, or any number of them for **.
+type globSegment struct {
+	kind         segmentKind
+	text         string // literal, prefix or suffix
+	regex        *regexp.Regexp
+	trailingStar bool // ends in an unescaped * after other characters
+}
+
+type segmentKind int
+
+const (
+	segLiteral segmentKind = iota
+	segStar                // * alone: any one segment
+	segPrefix              // text*
+	segSuffix              // *text
+	segRegex
+	segDoubleStar // ** alone: zero or more segments
+)
+
+func newCodeRefMatcher(entries []codeRefEntry) *codeRefMatcher {
+	m := &codeRefMatcher{root: &trieNode{}}
+	for i := range entries {
+		e := &entries[i]
+		if !doublestar.ValidatePattern(e.Pattern) {
+			continue // never matches, as doublestar.Match returns an error
+		}
+		ref := compiledRef{entry: e, order: i, specificity: schema.CodeRefSpecificity(e.Pattern)}
+		m.all = append(m.all, ref)
+		// doublestar matches {**,b} differently from its expansions at the
+		// end of a path, so alternatives with stars are left to it.
+		alternatives, ok := expandBraces(e.Pattern, maxBraceExpansions)
+		if !ok || (len(alternatives) > 1 && starInBraces(e.Pattern)) {
+			m.fallback = append(m.fallback, ref)
+			continue
+		}
+		var compiled [][]globSegment
+		for _, alt := range alternatives {
+			segs, ok := compileSegments(alt)
+			if !ok {
+				compiled = nil
+				break
+			}
+			compiled = append(compiled, segs)
+		}
+		if compiled == nil {
+			m.fallback = append(m.fallback, ref)
+			continue
+		}
+		for _, segs := range compiled {
+			node := m.root
+			for len(segs) > 0 && segs[0].kind == segLiteral {
+				node = node.child(segs[0].text)
+				segs = segs[1:]
+			}
+			r := ref
+			r.segs = segs
+			node.refs = append(node.refs, r)
+		}
+	}
+	return m
+}
+
+func (n *trieNode) child(seg string) *trieNode {
+	if n.children == nil {
+		n.children = make(map[string]*trieNode)
+	}
+	c := n.children[seg]
+	if c == nil {
+		c = &trieNode{}
+		n.children[seg] = c
+	}
+	return c
+}
+
+// lookup returns the most specific code_ref entry matching filePath, or
+// nil if none does.
+func (m *codeRefMatcher) lookup(filePath string) *codeRefEntry {
+	var best *compiledRef
+	better := func(r *compiledRef) bool {
+		return best == nil || r.specificity > best.specificity ||
+			(r.specificity == best.specificity && r.order < best.order)
+	}
+
+	// doublestar gives empty segments (a//b, a/) meanings of their own;
+	// such paths never come from the index, so they just take the slow way.
+	if filePath == "" || strings.HasPrefix(filePath, "/") || strings.HasSuffix(filePath, "/") || strings.Contains(filePath, "//") {
+		for i := range m.all {
+			r := &m.all[i]
+			if better(r) {
+				if ok, _ := doublestar.Match(r.entry.Pattern, filePath); ok {
+					best = r
+				}
+			}
+		}
+		if best == nil {
+			return nil
+		}
+		return best.entry
+	}
+
+	segs := strings.Split(filePath, "/")
+	node := m.root
+	for depth := 0; node != nil; depth++ {
+		for i := range node.refs {
+			r := &node.refs[i]
+			if better(r) && matchSegments(r.segs, segs[depth:]) {
+				best = r
+			}
+		}
+		if depth == len(segs) {
+			break
+		}
+		node = node.children[segs[depth]]
+	}
+	for i := range m.fallback {
+		r := &m.fallback[i]
+		if better(r) {
+			if ok, _ := doublestar.Match(r.entry.Pattern, filePath); ok {
+				best = r
+			}
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return best.entry
+}
+
+// matchSegments matches path segments against compiled pattern segments.
+func matchSegments(pattern []globSegment, segs []string) bool {
+	for len(pattern) > 0 {
+		p := pattern[0]
+		if p.kind == segDoubleStar {
+			rest := pattern[1:]
+			if len(rest) == 0 {
+				return true
+			}
+			for i := 0; i <= len(segs); i++ {
+				if matchSegments(rest, segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 || !p.match(segs[0]) {
+			return false
+		}
+		if p.kind == segPrefix && len(segs) == 1 && len(pattern) > 1 && len(segs[0]) == len(p.text) {
+			return false // see compileSegments
+		}
+		pattern, segs = pattern[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
+func (g globSegment) match(seg string) bool {
+	switch g.kind {
+	case segLiteral:
+		return seg == g.text
+	case segStar:
+		return true
+	case segPrefix:
+		return strings.HasPrefix(seg, g.text)
+	case segSuffix:
+		return strings.HasSuffix(seg, g.text)
+	default:
+		return g.regex.MatchString(seg)
+	}
+}
+
+// compileSegments compiles a brace-free pattern segment by segment. ok is
+// false for constructs left to doublestar: an escaped /, and empty
+// segments and **/**, which doublestar treats specially.
+func compileSegments(pattern string) (segs []globSegment, ok bool) {
+	if strings.Contains(pattern, `\/`) {
+		return nil, false
+	}
+	for _, part := range strings.Split(pattern, "/") {
+		seg, ok := compileSegment(part)
+		if !ok || part == "" || (seg.kind == segDoubleStar && len(segs) > 0 && segs[len(segs)-1].kind == segDoubleStar) {
+			return nil, false
+		}
+		segs = append(segs, seg)
+	}
+	// doublestar only matches a trailing * against at least one character
+	// if the path goes on, so a* followed by more segments does not match a
+	// path ending in a. Prefix segments (see match) model that; for other
+	// segments ending in * it depends on how doublestar backtracks.
+	for _, seg := range segs[:len(segs)-1] {
+		if seg.kind == segRegex && seg.trailingStar {
+			return nil, false
+		}
+	}
+	return segs, true
+}
+
+func compileSegment(part string) (globSegment, bool) {
+	if part == "**" {
+		return globSegment{kind: segDoubleStar}, true
+	}
+	meta := strings.IndexAny(part, `*?[\`)
+	switch {
+	case meta < 0:
+		return globSegment{kind: segLiteral, text: part}, true
+	case strings.Trim(part, "*") == "":
+		return globSegment{kind: segStar}, true
+	case !strings.ContainsAny(part[1:], `*?[\`) && part[0] == '*':
+		return globSegment{kind: segSuffix, text: part[1:]}, true
+	case meta == len(part)-1 && part[meta] == '*':
+		return globSegment{kind: segPrefix, text: part[:meta]}, true
+	}
+
+	trailingStar := strings.HasSuffix(part, "*") && !strings.HasSuffix(part, `\*`)
+	var re strings.Builder
+	re.WriteString(`(?s)^`)
+	for i := 0; i < len(part); i++ {
+		switch c := part[i]; c {
+		case '*':
+			re.WriteString(`[^/]*`)
+		case '?':
+			re.WriteString(`.`)
+		case '\\':
+			if i+1 < len(part) {
+				i++
+				re.WriteString(regexp.QuoteMeta(part[i : i+1]))
+			}
+		case '[':
+			end := strings.IndexByte(part[i+1:], ']')
+			if end <= 0 {
+				return globSegment{}, false
+			}
+			class := part[i+1 : i+1+end]
+			if strings.ContainsAny(class, `\[`) {
+				return globSegment{}, false
+			}
+			re.WriteByte('[')
+			if class[0] == '!' || class[0] == '^' {
+				re.WriteByte('^')
+				class = class[1:]
+			}
+			for _, r := range class {
+				if r == '-' {
+					re.WriteRune(r)
+				} else {
+					re.WriteString(regexp.QuoteMeta(string(r)))
+				}
+			}
+			re.WriteByte(']')
+			i += end + 1
+		default:
+			re.WriteString(regexp.QuoteMeta(part[i : i+1]))
+		}
+	}
+	re.WriteString(`$`)
+	compiled, err := regexp.Compile(re.String())
+	if err != nil {
+		return globSegment{}, false
+	}
+	return globSegment{kind: segRegex, regex: compiled, trailingStar: trailingStar}, true
+}
+
+// expandBraces expands {a,b} alternatives, nested ones included, into the
+// patterns they stand for. ok is false when there would be more than
+// limit of them.
+func expandBraces(pattern string, limit int) ([]string, bool) {
+	open := -1
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '[':
+			if end := strings.IndexByte(pattern[i+1:], ']'); end >= 0 {
+				i += end + 1
+			}
+		case '{':
+			open = i
+		}
+		if open >= 0 {
+			break
+		}
+	}
+	if open < 0 {
+		return []string{pattern}, true
+	}
+
+	var alts []string
+	depth, start, close := 0, open+1, -1
+	for i := open; i < len(pattern) && close < 0; i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				alts = append(alts, pattern[start:i])
+				close = i
+			}
+		case ',':
+			if depth == 1 {
+				alts = append(alts, pattern[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if close < 0 {
+		return nil, false
+	}
+
+	var out []string
+	for _, alt := range alts {
+		expanded, ok := expandBraces(pattern[:open]+alt+pattern[close+1:], limit-len(out))
+		if !ok {
+			return nil, false
+		}
+		out = append(out, expanded...)
+		if len(out) > limit {
+			return nil, false
+		}
+	}
+	return out, true
+}
+
+// starInBraces reports whether a * appears inside {} alternatives.
+func starInBraces(pattern string) bool {
+	depth := 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case '*':
+			if depth > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lruCache is a fixed-size, least recently used cache safe for concurrent
+// use.
+type lruCache[K comparable, V any] struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // front is most recently used
+	items map[K]*list.Element
+}
+
+type lruItem[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+func newLRUCache[K comparable, V any](size int) *lruCache[K, V] {
+	return &lruCache[K, V]{size: size, order: list.New(), items: make(map[K]*list.Element)}
+}
+
+func (c *lruCache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.order.MoveToFront(el)
+		return el.Value.(*lruItem[K, V]).value, true
+	}
+	var zero V
+	return zero, false
+}
+
+func (c *lruCache[K, V]) Add(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value.(*lruItem[K, V]).value = value
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruItem[K, V]{key: key, value: value})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruItem[K, V]).key)
+	}
+}
+
+// findComponentUncached matches filePath against every code_ref through
+// the compiled matcher.
+func (idx *ArchiveIndex) findComponentUncached(filePath string) *schema.Component {
+	if e := idx.codeRefs.lookup(filePath); e != nil {
+		return e.Component
+	}
+	return nil
+}
@@ -1,121 +1,120 @@
 package server
 
 import (
-	"encoding/json"
-	"fmt"
 	"net/http"
-	"sync"
+	"reflect"
 )
 
-// CursorState tracks the editor's current file and broadcasts changes via SSE.
-type CursorState struct {
-	mu      sync.Mutex
-	file    string
-	clients map[chan string]struct{}
-	idx     *ArchiveIndex
-}
-
-// CursorEvent is the JSON payload sent over SSE.
-type CursorEvent struct {
-	File        string `json:"file"`
-	ComponentID string `json:"component_id,omitempty"`
-	ArchetypeID string `json:"archetype_id,omitempty"`
-}
-
-// NewCursorState creates a CursorState backed by the given index for resolving IDs.
-func NewCursorState(idx *ArchiveIndex) *CursorState {
-	return &CursorState{
-		clients: make(map[chan string]struct{}),
-		idx:     idx,
+// handleCursorPut handles PUT /cursor?file=<path>, publishing the resolved
+// file on the cursor topic.
+func handleCursorPut(st *Store, bus *EventBus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		file := r.URL.Query().Get("file")
+		if file == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "file parameter is required"})
+			return
+		}
+		Publish(bus, TopicCursor, resolveCursor(st.Index(), file))
+		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
-// Set updates the current file and broadcasts the resolved event to all SSE clients.
-func (cs *CursorState) Set(file string) {
+func resolveCursor(idx *ArchiveIndex, file string) CursorEvent {
 	file = NormalizePath(file)
-
 	ev := CursorEvent{File: file}
-
-	if comp := cs.idx.FindComponent(file); comp != nil {
+	if comp := idx.FindComponent(file); comp != nil {
 		ev.ComponentID = comp.ID
 	}
-	if arch := cs.idx.FindArchetype(file); arch != nil {
+	if arch := idx.FindArchetype(file); arch != nil {
 		ev.ArchetypeID = arch.Archetype.ID
 	}
-
-	data, _ := json.Marshal(ev)
-	msg := string(data)
-
-	cs.mu.Lock()
-	cs.file = file
-	for ch := range cs.clients {
-		select {
-		case ch <- msg:
-		default:
-			// Drop if client is slow
-		}
-	}
-	cs.mu.Unlock()
+	return ev
 }
 
-// Subscribe returns a channel that receives SSE messages.
-func (cs *CursorState) Subscribe() chan string {
-	ch := make(chan string, 8)
-	cs.mu.Lock()
-	cs.clients[ch] = struct{}{}
-	cs.mu.Unlock()
-	return ch
-}
-
-// Unsubscribe removes a client channel and closes it.
-func (cs *CursorState) Unsubscribe(ch chan string) {
-	cs.mu.Lock()
-	delete(cs.clients, ch)
-	cs.mu.Unlock()
-	close(ch)
+// handleCursorStream handles GET /cursor/stream (SSE), the original
+// editor-sync stream: cursor events, sent unnamed, and index reloads.
+func handleCursorStream(bus *EventBus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		streamEvents(w, r, bus, []string{TopicCursor.Name, TopicIndexReloaded.Name}, true)
+	}
 }
 
-// handleCursorPut handles PUT /cursor?file=<path>
-func handleCursorPut(cs *CursorState) http.HandlerFunc {
+// handleSelectionPut handles PUT /selection?id=<component or archetype ID>,
+// publishing the element selected in the web UI so editors can follow it.
+func handleSelectionPut(st *Store, bus *EventBus) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		file := r.URL.Query().Get("file")
-		if file == "" {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "file parameter is required"})
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "id parameter is required"})
+			return
+		}
+		idx := st.Index()
+		ev := SelectionEvent{ID: id}
+		if comp := idx.componentByID[id]; comp != nil {
+			ev.Kind = "component"
+			ev.ComponentID = comp.ID
+		} else if arch := idx.archetypeByID[id]; arch != nil {
+			ev.Kind = "archetype"
+			ev.File = arch.Archetype.File
+			ev.ComponentID = idx.ComponentOf(id)
+		} else {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown component or archetype: " + id})
 			return
 		}
-		cs.Set(file)
+		Publish(bus, TopicSelection, ev)
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
-// handleCursorStream handles GET /cursor/stream (SSE)
-func handleCursorStream(cs *CursorState) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		flusher, ok := w.(http.Flusher)
-		if !ok {
-			http.Error(w, "streaming not supported", http.StatusInternalServerError)
-			return
-		}
-
-		w.Header().Set("Content-Type", "text/event-stream")
-		w.Header().Set("Cache-Control", "no-cache")
-		w.Header().Set("Connection", "keep-alive")
-
-		ch := cs.Subscribe()
-		defer cs.Unsubscribe(ch)
+// PublishReload announces a reloaded index, followed by an
+// annotation-changed event for each element whose annotations differ from
+// the previous index.
+func PublishReload(bus *EventBus, prev, idx *ArchiveIndex) {
+	Publish(bus, TopicIndexReloaded, IndexReloadedEvent{
+		Components:    len(idx.Raw.Components),
+		Relationships: len(idx.Raw.Relationships),
+		Flows:         len(idx.Raw.Flows),
+	})
+	for _, ev := range annotationChanges(prev, idx) {
+		Publish(bus, TopicAnnotationChanged, ev)
+	}
+}
 
-		ctx := r.Context()
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case msg, ok := <-ch:
-				if !ok {
-					return
-				}
-				fmt.Fprintf(w, "data: %s\n\n", msg)
-				flusher.Flush()
+func annotationChanges(prev, idx *ArchiveIndex) []AnnotationChangedEvent {
+	var changes []AnnotationChangedEvent
+	for _, comp := range idx.Raw.Components {
+		var before map[string]any
+		if old := prev.componentByID[comp.ID]; old != nil {
+			before = old.Annotations
+		}
+		if !sameAnnotations(before, comp.Annotations) {
+			changes = append(changes, AnnotationChangedEvent{ID: comp.ID, Kind: "component", Annotations: nonNil(comp.Annotations)})
+		}
+	}
+	for _, category := range sortedCategories(idx.Raw.Archetypes) {
+		for _, arch := range idx.Raw.Archetypes[category] {
+			var before map[string]any
+			if old := prev.archetypeByID[arch.ID]; old != nil {
+				before = old.Archetype.Annotations
+			}
+			if !sameAnnotations(before, arch.Annotations) {
+				changes = append(changes, AnnotationChangedEvent{ID: arch.ID, Kind: "archetype", Annotations: nonNil(arch.Annotations)})
 			}
 		}
 	}
+	return changes
+}
+
+func nonNil(m map[string]any) map[string]any {
+	if m == nil {
+		return map[string]any{}
+	}
+	return m
+}
+
+func sameAnnotations(a, b map[string]any) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
 }
@@ -0,0 +1,642 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/nhomble/canopy/internal/canopydir"
+	"github.com/nhomble/canopy/internal/history"
+	"github.com/nhomble/canopy/internal/schema"
+)
+
+// maxUndo is how many edits can be undone.
+const maxUndo = 50
+
+var (
+	// ErrReadOnly is returned for edits to an index not loaded from a
+	// file, such as a workspace's system index.
+	ErrReadOnly = errors.New("index was not loaded from a file and cannot be edited")
+	// ErrInvalidEdit is returned for a malformed edit.
+	ErrInvalidEdit = errors.New("invalid edit")
+	// ErrEditConflict is returned for an edit that clashes with the index,
+	// such as a duplicate relationship, or an undo after index.json was
+	// changed by something else.
+	ErrEditConflict = errors.New("edit conflict")
+)
+
+// ValidationError is returned for an edit that would add validation errors
+// to the index. Errors the index already had do not count.
+type ValidationError struct {
+	Problems []schema.ValidationProblem
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("edit would make the index invalid: %s", e.Problems[0])
+}
+
+// Editor changes index.json on behalf of the web UI and editors. Each edit
+// is applied to index.json as saved, without the overlay, validated with
+// schema.ValidateIndex, snapshotted into the history and saved; the store
+// then reloads and the change is published on the event bus. An edit whose
+// every change overlay.json overrides is refused, since it would not show;
+// one partly overridden is saved with a warning. Edits can be undone and
+// redone while index.json is not changed by anything else.
+type Editor struct {
+	st      *Store
+	bus     *EventBus
+	history *history.Store // nil for an in-memory index
+
+	mu   sync.Mutex
+	undo []editStep // oldest first
+	redo []editStep
+}
+
+// editStep is one applied edit: index.json before and after it.
+type editStep struct {
+	summary       string
+	before, after []byte
+}
+
+// EditResult describes an applied edit and what can be undone next.
+type EditResult struct {
+	Summary  string `json:"summary,omitempty"`
+	Changed  bool   `json:"changed"`
+	Snapshot string `json:"snapshot,omitempty"` // history snapshot ID
+	// Warning names the changed fields overlay.json overrides, which keep
+	// their overlay values in what is served.
+	Warning string `json:"warning,omitempty"`
+	EditState
+}
+
+// EditState lists the edits that can be undone and redone, most recent
+// first.
+type EditState struct {
+	Undo []string `json:"undo"`
+	Redo []string `json:"redo"`
+}
+
+// NewEditor returns an editor for st, publishing changes on bus.
+// Snapshots go to the history directory next to index.json, with the
+// retention configured in config.json.
+func NewEditor(st *Store, bus *EventBus) *Editor {
+	e := &Editor{st: st, bus: bus}
+	if st.path != "" {
+		ad := &canopydir.CanopyDir{Root: filepath.Dir(st.path)}
+		cfg, err := ad.LoadConfig()
+		if err != nil {
+			defaults := schema.DefaultConfig(filepath.Base(ad.RepoRoot()))
+			cfg = &defaults
+		}
+		e.history = history.NewStore(ad.HistoryDir(), history.ConfigRetention(cfg.History))
+	}
+	return e
+}
+
+// Apply edits index.json with fn. An edit that changes nothing is not
+// saved and cannot be undone.
+func (e *Editor) Apply(summary string, fn func(raw *schema.ArchIndex) error) (*EditResult, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	res := &EditResult{Summary: summary}
+	prev, idx, err := e.st.Edit(func(indexPath string) error {
+		before, err := os.ReadFile(indexPath)
+		if err != nil {
+			return fmt.Errorf("reading index: %w", err)
+		}
+		raw, err := schema.ParseIndex(before)
+		if err != nil {
+			return err
+		}
+		baseline := schema.ValidateIndex(raw)
+		original, _ := json.Marshal(raw)
+		if err := fn(raw); err != nil {
+			return err
+		}
+		if edited, _ := json.Marshal(raw); bytes.Equal(original, edited) {
+			return errUnchanged
+		}
+		if problems := newProblems(baseline, schema.ValidateIndex(raw)); len(problems) > 0 {
+			return &ValidationError{Problems: problems}
+		}
+		shadowed, visible, err := overlayShadowed(indexPath, before, raw)
+		if err != nil {
+			return err
+		}
+		if len(shadowed) > 0 && !visible {
+			return fmt.Errorf("%w: overlay.json overrides %s; change it there instead", ErrEditConflict, strings.Join(shadowed, ", "))
+		}
+		if len(shadowed) > 0 {
+			res.Warning = "overlay.json overrides " + strings.Join(shadowed, ", ") + "; those changes are saved but not shown"
+		}
+
+		// Only a state that never reached the history, such as a change
+		// made by hand, needs a snapshot of its own; otherwise the newest
+		// snapshot already holds it and the edit takes just one.
+		e.snapshot(indexPath, "pre-edit")
+		if err := schema.SaveIndex(indexPath, raw); err != nil {
+			return err
+		}
+		after, err := os.ReadFile(indexPath)
+		if err != nil {
+			return fmt.Errorf("reading index: %w", err)
+		}
+		res.Snapshot = e.snapshot(indexPath, "edit: "+summary)
+		e.undo = append(e.undo, editStep{summary: summary, before: before, after: after})
+		if len(e.undo) > maxUndo {
+			e.undo = e.undo[1:]
+		}
+		e.redo = nil
+		return nil
+	})
+	if errors.Is(err, errUnchanged) {
+		res.EditState = e.state()
+		return res, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	res.Changed = true
+	res.EditState = e.state()
+	e.publish(prev, idx, "edit", summary)
+	return res, nil
+}
+
+// errUnchanged stops Apply from saving an edit that changes nothing.
+var errUnchanged = errors.New("unchanged")
+
+// Undo restores index.json to before the last edit.
+func (e *Editor) Undo() (*EditResult, error) {
+	return e.step("undo")
+}
+
+// Redo reapplies the last undone edit.
+func (e *Editor) Redo() (*EditResult, error) {
+	return e.step("redo")
+}
+
+func (e *Editor) step(action string) (*EditResult, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	from, to := &e.undo, &e.redo
+	if action == "redo" {
+		from, to = to, from
+	}
+	if len(*from) == 0 {
+		return nil, fmt.Errorf("%w: nothing to %s", ErrEditConflict, action)
+	}
+	step := (*from)[len(*from)-1]
+	expect, write := step.after, step.before
+	if action == "redo" {
+		expect, write = write, expect
+	}
+
+	res := &EditResult{Summary: step.summary, Changed: true}
+	prev, idx, err := e.st.Edit(func(indexPath string) error {
+		current, err := os.ReadFile(indexPath)
+		if err != nil {
+			return fmt.Errorf("reading index: %w", err)
+		}
+		if !bytes.Equal(current, expect) {
+			e.undo, e.redo = nil, nil
+			return fmt.Errorf("%w: index.json changed since the edit; undo history cleared", ErrEditConflict)
+		}
+		if err := os.WriteFile(indexPath, write, 0o644); err != nil {
+			return fmt.Errorf("writing index: %w", err)
+		}
+		res.Snapshot = e.snapshot(indexPath, action+": "+step.summary)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	*from = (*from)[:len(*from)-1]
+	*to = append(*to, step)
+	res.EditState = e.state()
+	e.publish(prev, idx, action, step.summary)
+	return res, nil
+}
+
+// State lists what can be undone and redone.
+func (e *Editor) State() EditState {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.state()
+}
+
+func (e *Editor) state() EditState {
+	s := EditState{Undo: []string{}, Redo: []string{}}
+	for i := len(e.undo) - 1; i >= 0; i-- {
+		s.Undo = append(s.Undo, e.undo[i].summary)
+	}
+	for i := len(e.redo) - 1; i >= 0; i-- {
+		s.Redo = append(s.Redo, e.redo[i].summary)
+	}
+	return s
+}
+
+// snapshot records index.json in the history as an edit snapshot, unless
+// the newest snapshot has the same content, and returns the snapshot ID.
+// A failed snapshot is logged; the edit goes ahead.
+func (e *Editor) snapshot(indexPath, source string) string {
+	if e.history == nil {
+		return ""
+	}
+	meta, err := e.history.SnapshotEdit(indexPath, source, history.GitCommit(filepath.Dir(filepath.Dir(indexPath))))
+	if err != nil {
+		log.Printf("history: %v", err)
+		return ""
+	}
+	return meta.ID
+}
+
+func (e *Editor) publish(prev, idx *ArchiveIndex, action, summary string) {
+	state := e.state()
+	Publish(e.bus, TopicIndexEdited, IndexEditedEvent{
+		Action:  action,
+		Summary: summary,
+		CanUndo: len(state.Undo) > 0,
+		CanRedo: len(state.Redo) > 0,
+	})
+	PublishReload(e.bus, prev, idx)
+}
+
+// overlayShadowed compares an edit of index.json, from before to edited,
+// with what it changes once overlay.json is merged on top. It returns the
+// changed fields the overlay hides, as "component api: layer", and whether
+// any change remains visible.
+func overlayShadowed(indexPath string, before []byte, edited *schema.ArchIndex) (shadowed []string, visible bool, err error) {
+	ov, err := schema.LoadOverlay(filepath.Join(filepath.Dir(indexPath), "overlay.json"))
+	if err != nil || len(ov.Elements) == 0 {
+		return nil, true, err
+	}
+	after, err := json.Marshal(edited)
+	if err != nil {
+		return nil, false, err
+	}
+	merged := func(data []byte) (map[string]string, error) {
+		idx, err := schema.ParseIndex(data)
+		if err != nil {
+			return nil, err
+		}
+		schema.ApplyOverlay(idx, ov)
+		return elementFields(idx), nil
+	}
+	rawBefore, err := schema.ParseIndex(before)
+	if err != nil {
+		return nil, false, err
+	}
+	mergedBefore, err := merged(before)
+	if err != nil {
+		return nil, false, err
+	}
+	mergedAfter, err := merged(after)
+	if err != nil {
+		return nil, false, err
+	}
+	seen := changedFields(mergedBefore, mergedAfter)
+	for _, field := range changedFields(elementFields(rawBefore), elementFields(edited)) {
+		if slices.Contains(seen, field) {
+			visible = true
+		} else {
+			shadowed = append(shadowed, field)
+		}
+	}
+	return shadowed, visible, nil
+}
+
+// elementFields flattens the elements of idx to their JSON fields, keyed
+// as "component api: layer"; relationships are compared as a whole.
+func elementFields(idx *schema.ArchIndex) map[string]string {
+	fields := make(map[string]string)
+	add := func(key string, elem any) {
+		data, _ := json.Marshal(elem)
+		var m map[string]json.RawMessage
+		json.Unmarshal(data, &m)
+		for name, value := range m {
+			fields[key+": "+name] = string(value)
+		}
+	}
+	for _, c := range idx.Components {
+		add("component "+c.ID, c)
+	}
+	for category, list := range idx.Archetypes {
+		for _, a := range list {
+			add("archetype "+a.ID, a)
+			fields["archetype "+a.ID+": category"] = category
+		}
+	}
+	for _, f := range idx.Flows {
+		add("flow "+f.ID, f)
+	}
+	rels, _ := json.Marshal(idx.Relationships)
+	fields["relationships"] = string(rels)
+	return fields
+}
+
+// changedFields returns the keys whose values differ between a and b,
+// sorted.
+func changedFields(a, b map[string]string) []string {
+	var changed []string
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			changed = append(changed, k)
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			changed = append(changed, k)
+		}
+	}
+	slices.Sort(changed)
+	return changed
+}
+
+// newProblems returns the errors in after that were not in before.
+func newProblems(before, after *schema.ValidationResult) []schema.ValidationProblem {
+	seen := make(map[string]int)
+	for _, p := range before.Errors {
+		seen[p.Code+"\x00"+p.Message]++
+	}
+	var added []schema.ValidationProblem
+	for _, p := range after.Errors {
+		key := p.Code + "\x00" + p.Message
+		if seen[key] > 0 {
+			seen[key]--
+			continue
+		}
+		added = append(added, p)
+	}
+	return added
+}
+
+// ComponentPatch lists the component fields PATCH /components/{id}
+// changes; fields left out stay as they are.
+type ComponentPatch struct {
+	Name        *string        `json:"name"`
+	Layer       *string        `json:"layer"`
+	Description *string        `json:"description"`
+	Tags        *[]string      `json:"tags"`
+	CodeRefs    *[]string      `json:"code_refs"`
+	Links       *[]schema.Link `json:"links"`
+}
+
+// PatchComponent changes the given fields of a component.
+func PatchComponent(id string, patch ComponentPatch) func(*schema.ArchIndex) error {
+	return func(raw *schema.ArchIndex) error {
+		i := slices.IndexFunc(raw.Components, func(c schema.Component) bool { return c.ID == id })
+		if i < 0 {
+			return fmt.Errorf("%w: %s", ErrUnknownElement, id)
+		}
+		comp := &raw.Components[i]
+		if patch.Name != nil {
+			comp.Name = *patch.Name
+		}
+		if patch.Layer != nil {
+			comp.Layer = *patch.Layer
+		}
+		if patch.Description != nil {
+			comp.Description = *patch.Description
+		}
+		if patch.Tags != nil {
+			comp.Tags = *patch.Tags
+		}
+		if patch.CodeRefs != nil {
+			comp.CodeRefs = *patch.CodeRefs
+		}
+		if patch.Links != nil {
+			comp.Links = *patch.Links
+		}
+		return nil
+	}
+}
+
+// AddRelationship adds a relationship between two existing elements,
+// unless one with the same ends and type exists.
+func AddRelationship(rel schema.Relationship) func(*schema.ArchIndex) error {
+	return func(raw *schema.ArchIndex) error {
+		if rel.From == "" || rel.To == "" || rel.Type == "" {
+			return fmt.Errorf("%w: from, to and type are required", ErrInvalidEdit)
+		}
+		for _, id := range []string{rel.From, rel.To} {
+			if !hasElement(raw, id) {
+				return fmt.Errorf("%w: %s", ErrUnknownElement, id)
+			}
+		}
+		for _, r := range raw.Relationships {
+			if r.From == rel.From && r.To == rel.To && r.Type == rel.Type {
+				return fmt.Errorf("%w: %s -> %s (%s) already exists", ErrEditConflict, rel.From, rel.To, rel.Type)
+			}
+		}
+		raw.Relationships = append(raw.Relationships, rel)
+		return nil
+	}
+}
+
+// hasElement reports whether id is a component or archetype.
+func hasElement(raw *schema.ArchIndex, id string) bool {
+	if slices.ContainsFunc(raw.Components, func(c schema.Component) bool { return c.ID == id }) {
+		return true
+	}
+	for _, list := range raw.Archetypes {
+		if slices.ContainsFunc(list, func(a schema.Archetype) bool { return a.ID == id }) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteRelationships removes the relationships between two elements, or
+// only those of the given type.
+func DeleteRelationships(from, to, relType string) func(*schema.ArchIndex) error {
+	return func(raw *schema.ArchIndex) error {
+		n := len(raw.Relationships)
+		raw.Relationships = slices.DeleteFunc(raw.Relationships, func(r schema.Relationship) bool {
+			return r.From == from && r.To == to && (relType == "" || r.Type == relType)
+		})
+		if len(raw.Relationships) == n {
+			return fmt.Errorf("%w: no relationship %s -> %s", ErrUnknownElement, from, to)
+		}
+		return nil
+	}
+}
+
+// PutFlow replaces the flow with flow's ID, or adds it.
+func PutFlow(flow schema.Flow) func(*schema.ArchIndex) error {
+	return func(raw *schema.ArchIndex) error {
+		if len(flow.Steps) == 0 && flow.Graph == nil {
+			return fmt.Errorf("%w: a flow needs steps or a graph", ErrInvalidEdit)
+		}
+		if i := slices.IndexFunc(raw.Flows, func(f schema.Flow) bool { return f.ID == flow.ID }); i >= 0 {
+			raw.Flows[i] = flow
+		} else {
+			raw.Flows = append(raw.Flows, flow)
+		}
+		return nil
+	}
+}
+
+// MoveArchetype moves an archetype to another category.
+func MoveArchetype(id, category string) func(*schema.ArchIndex) error {
+	return func(raw *schema.ArchIndex) error {
+		if category == "" {
+			return fmt.Errorf("%w: category is required", ErrInvalidEdit)
+		}
+		for from, list := range raw.Archetypes {
+			i := slices.IndexFunc(list, func(a schema.Archetype) bool { return a.ID == id })
+			if i < 0 {
+				continue
+			}
+			if from == category {
+				return nil
+			}
+			arch := list[i]
+			raw.Archetypes[from] = slices.Delete(list, i, i+1)
+			if len(raw.Archetypes[from]) == 0 {
+				delete(raw.Archetypes, from)
+			}
+			raw.Archetypes[category] = append(raw.Archetypes[category], arch)
+			return nil
+		}
+		return fmt.Errorf("%w: %s", ErrUnknownElement, id)
+	}
+}
+
+// Edit handlers.
+
+func handleComponentPatch(ed *Editor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		var patch ComponentPatch
+		if !decodeEdit(w, r, &patch) {
+			return
+		}
+		writeEditResult(w, http.StatusOK, ed, "update component "+id, PatchComponent(id, patch))
+	}
+}
+
+func handleRelationshipPost(ed *Editor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rel schema.Relationship
+		if !decodeEdit(w, r, &rel) {
+			return
+		}
+		summary := fmt.Sprintf("add relationship %s -> %s (%s)", rel.From, rel.To, rel.Type)
+		writeEditResult(w, http.StatusCreated, ed, summary, AddRelationship(rel))
+	}
+}
+
+func handleRelationshipDelete(ed *Editor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		from, to, relType := q.Get("from"), q.Get("to"), q.Get("type")
+		if from == "" || to == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "from and to parameters are required"})
+			return
+		}
+		summary := fmt.Sprintf("delete relationship %s -> %s", from, to)
+		if relType != "" {
+			summary += " (" + relType + ")"
+		}
+		writeEditResult(w, http.StatusOK, ed, summary, DeleteRelationships(from, to, relType))
+	}
+}
+
+func handleFlowPut(ed *Editor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		var flow schema.Flow
+		if !decodeEdit(w, r, &flow) {
+			return
+		}
+		if flow.ID != "" && flow.ID != id {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("flow id %q does not match the URL", flow.ID)})
+			return
+		}
+		flow.ID = id
+		writeEditResult(w, http.StatusOK, ed, "put flow "+id, PutFlow(flow))
+	}
+}
+
+func handleArchetypeMove(ed *Editor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		var body struct {
+			Category string `json:"category"`
+		}
+		if !decodeEdit(w, r, &body) {
+			return
+		}
+		writeEditResult(w, http.StatusOK, ed, "move archetype "+id+" to "+body.Category, MoveArchetype(id, body.Category))
+	}
+}
+
+func handleUndo(ed *Editor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res, err := ed.Undo()
+		writeEditOutcome(w, http.StatusOK, res, err)
+	}
+}
+
+func handleRedo(ed *Editor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res, err := ed.Redo()
+		writeEditOutcome(w, http.StatusOK, res, err)
+	}
+}
+
+func handleEdits(ed *Editor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, ed.State())
+	}
+}
+
+// decodeEdit decodes a JSON request body, rejecting unknown fields so a
+// misspelt field is not silently ignored.
+func decodeEdit(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body: " + err.Error()})
+		return false
+	}
+	return true
+}
+
+func writeEditResult(w http.ResponseWriter, status int, ed *Editor, summary string, fn func(*schema.ArchIndex) error) {
+	res, err := ed.Apply(summary, fn)
+	if err == nil && !res.Changed {
+		status = http.StatusOK
+	}
+	writeEditOutcome(w, status, res, err)
+}
+
+func writeEditOutcome(w http.ResponseWriter, status int, res *EditResult, err error) {
+	var invalid *ValidationError
+	switch {
+	case err == nil:
+		writeJSON(w, status, res)
+	case errors.As(err, &invalid):
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"error": err.Error(), "problems": invalid.Problems})
+	case errors.Is(err, ErrUnknownElement):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrInvalidEdit):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrEditConflict):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrReadOnly):
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": err.Error()})
+	default:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+}
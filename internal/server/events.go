@@ -0,0 +1,283 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Topic names an event type on the bus. The type parameter is the payload
+// type, so publishers cannot send the wrong shape on a topic.
+type Topic[T any] struct {
+	Name string
+	// Sticky topics keep their last event and replay it to new
+	// subscribers, so a fresh client starts from the current state.
+	Sticky bool
+}
+
+// Event topics.
+var (
+	TopicCursor            = Topic[CursorEvent]{Name: "cursor", Sticky: true}
+	TopicSelection         = Topic[SelectionEvent]{Name: "selection", Sticky: true}
+	TopicIndexReloaded     = Topic[IndexReloadedEvent]{Name: "index-reloaded"}
+	TopicAnnotationChanged = Topic[AnnotationChangedEvent]{Name: "annotation-changed"}
+	TopicIndexEdited       = Topic[IndexEditedEvent]{Name: "index-edited"}
+)
+
+// Topics lists every topic name, for /events?topics= validation.
+var Topics = []string{
+	TopicCursor.Name,
+	TopicSelection.Name,
+	TopicIndexReloaded.Name,
+	TopicAnnotationChanged.Name,
+	TopicIndexEdited.Name,
+}
+
+// CursorEvent is the file open in the editor.
+type CursorEvent struct {
+	File        string `json:"file"`
+	ComponentID string `json:"component_id,omitempty"`
+	ArchetypeID string `json:"archetype_id,omitempty"`
+}
+
+// SelectionEvent is the element selected in the web UI.
+type SelectionEvent struct {
+	ID          string `json:"id"`
+	Kind        string `json:"kind"` // "component" or "archetype"
+	File        string `json:"file,omitempty"`
+	ComponentID string `json:"component_id,omitempty"`
+}
+
+// IndexReloadedEvent is sent after the server swaps in a reloaded index.
+type IndexReloadedEvent struct {
+	Components    int `json:"components"`
+	Relationships int `json:"relationships"`
+	Flows         int `json:"flows"`
+}
+
+// IndexEditedEvent is sent after an edit through the API is saved, undone
+// or redone. An index-reloaded event follows with the new counts.
+type IndexEditedEvent struct {
+	Action  string `json:"action"` // "edit", "undo" or "redo"
+	Summary string `json:"summary"`
+	CanUndo bool   `json:"can_undo"`
+	CanRedo bool   `json:"can_redo"`
+}
+
+// AnnotationChangedEvent is sent when a reload changes an element's
+// annotations. Annotations is the new set, empty if they were removed.
+type AnnotationChangedEvent struct {
+	ID          string         `json:"id"`
+	Kind        string         `json:"kind"` // "component" or "archetype"
+	Annotations map[string]any `json:"annotations"`
+}
+
+// Event is one published message. IDs increase across all topics and are
+// sent as the SSE id, so a reconnecting client can resume with
+// Last-Event-ID.
+type Event struct {
+	ID    uint64
+	Topic string
+	Data  json.RawMessage
+}
+
+const (
+	// eventHistory is how many recent events are kept for resuming.
+	eventHistory = 256
+	// subscriberBuffer is how many events a subscriber may fall behind
+	// before it is disconnected.
+	subscriberBuffer = 64
+)
+
+// EventBus fans published events out to SSE subscribers. A subscriber that
+// falls too far behind is disconnected rather than silently skipped; its
+// client reconnects with Last-Event-ID and catches up from history.
+type EventBus struct {
+	mu      sync.Mutex
+	nextID  uint64
+	sticky  map[string]Event // topic → last event, for sticky topics
+	history []Event          // most recent events, oldest first
+	subs    map[*Subscription]struct{}
+}
+
+// Subscription receives events for a set of topics on C. C is closed when
+// the subscriber is removed or falls behind.
+type Subscription struct {
+	C      chan Event
+	topics map[string]bool
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		nextID: 1,
+		sticky: make(map[string]Event),
+		subs:   make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish sends v to every subscriber of the topic.
+func Publish[T any](b *EventBus, topic Topic[T], v T) Event {
+	data, _ := json.Marshal(v)
+	return b.publish(topic.Name, topic.Sticky, data)
+}
+
+func (b *EventBus) publish(topic string, sticky bool, data json.RawMessage) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ev := Event{ID: b.nextID, Topic: topic, Data: data}
+	b.nextID++
+	if sticky {
+		b.sticky[topic] = ev
+	}
+	b.history = append(b.history, ev)
+	if len(b.history) > eventHistory {
+		b.history = slices.Delete(b.history, 0, len(b.history)-eventHistory)
+	}
+
+	for sub := range b.subs {
+		if !sub.topics[topic] {
+			continue
+		}
+		select {
+		case sub.C <- ev:
+		default:
+			delete(b.subs, sub)
+			close(sub.C)
+		}
+	}
+	return ev
+}
+
+// Subscribe registers a subscriber for topics (all topics if empty). When
+// resume is set, events after lastID are replayed from history; otherwise,
+// or if history no longer reaches back that far, the last event of each
+// sticky topic is replayed.
+func (b *EventBus) Subscribe(topics []string, lastID uint64, resume bool) *Subscription {
+	if len(topics) == 0 {
+		topics = Topics
+	}
+	sub := &Subscription{
+		C:      make(chan Event, subscriberBuffer+eventHistory),
+		topics: make(map[string]bool, len(topics)),
+	}
+	for _, t := range topics {
+		sub.topics[t] = true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, ev := range b.replay(sub, lastID, resume) {
+		sub.C <- ev
+	}
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+// replay picks the events a new subscriber starts with. The caller holds
+// b.mu.
+func (b *EventBus) replay(sub *Subscription, lastID uint64, resume bool) []Event {
+	var events []Event
+	// History covers lastID if it still holds the event right after it.
+	if resume && lastID < b.nextID && (len(b.history) == 0 || b.history[0].ID <= lastID+1) {
+		for _, ev := range b.history {
+			if ev.ID > lastID && sub.topics[ev.Topic] {
+				events = append(events, ev)
+			}
+		}
+		return events
+	}
+	for topic, ev := range b.sticky {
+		if sub.topics[topic] {
+			events = append(events, ev)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	return events
+}
+
+// Unsubscribe removes a subscriber and closes its channel, unless the bus
+// already dropped it.
+func (b *EventBus) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.C)
+	}
+}
+
+// Last returns the last event on a sticky topic.
+func (b *EventBus) Last(topic string) (Event, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ev, ok := b.sticky[topic]
+	return ev, ok
+}
+
+// handleEvents handles GET /events?topics=a,b (SSE). Each event carries
+// its topic as the SSE event name and an id for Last-Event-ID resume.
+func handleEvents(bus *EventBus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var topics []string
+		if q := r.URL.Query().Get("topics"); q != "" {
+			for _, t := range strings.Split(q, ",") {
+				t = strings.TrimSpace(t)
+				if !slices.Contains(Topics, t) {
+					writeJSON(w, http.StatusBadRequest, map[string]string{
+						"error": fmt.Sprintf("unknown topic %q (want %s)", t, strings.Join(Topics, ", ")),
+					})
+					return
+				}
+				topics = append(topics, t)
+			}
+		}
+		streamEvents(w, r, bus, topics, false)
+	}
+}
+
+// streamEvents writes subscribed events as SSE until the client goes away
+// or falls behind. legacyCursor sends cursor events unnamed, as the
+// original /cursor/stream did.
+func streamEvents(w http.ResponseWriter, r *http.Request, bus *EventBus, topics []string, legacyCursor bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	lastID, err := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+	resume := err == nil
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	sub := bus.Subscribe(topics, lastID, resume)
+	defer bus.Unsubscribe(sub)
+
+	ctx := r.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "id: %d\n", ev.ID)
+			if !legacyCursor || ev.Topic != TopicCursor.Name {
+				fmt.Fprintf(w, "event: %s\n", ev.Topic)
+			}
+			fmt.Fprintf(w, "data: %s\n\n", ev.Data)
+			flusher.Flush()
+		}
+	}
+}
@@ -2,29 +2,72 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nhomble/canopy/internal/owners"
+	"github.com/nhomble/canopy/internal/schema"
 )
 
+// Version is the canopy build version reported by /version.
+var Version = "dev"
+
+// capabilities lists the optional features this server supports, so
+// clients can adapt to older or newer servers via /version.
+var capabilities = []string{
+	"graph",
+	"context",
+	"context-batch",
+	"cursor-stream",
+	"owners",
+	"index-reload",
+	"events",
+	"search",
+	"resolve",
+	"path",
+	"impact",
+	"metrics",
+	"edit",
+}
+
 // Response types
 
 type ContextResponse struct {
-	Component     *ComponentSummary  `json:"component,omitempty"`
-	Layer         string             `json:"layer,omitempty"`
-	Archetype     *ArchetypeSummary  `json:"archetype,omitempty"`
-	Flows         []FlowSummary      `json:"flows,omitempty"`
-	ZoomAvailable bool               `json:"zoom_available"`
-	ZoomAnalyzed  bool               `json:"zoom_analyzed"`
+	Component     *ComponentSummary `json:"component,omitempty"`
+	Layer         string            `json:"layer,omitempty"`
+	Archetype     *ArchetypeSummary `json:"archetype,omitempty"`
+	Flows         []FlowSummary     `json:"flows,omitempty"`
+	ZoomAvailable bool              `json:"zoom_available"`
+	ZoomAnalyzed  bool              `json:"zoom_analyzed"`
+	FileOwners    []string          `json:"file_owners,omitempty"`
 }
 
 type ComponentSummary struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Annotations map[string]any    `json:"annotations,omitempty"`
+	Ownership   *owners.Ownership `json:"ownership,omitempty"`
+}
+
+// ComponentDetail is a component as listed by /components, with its
+// resolved CODEOWNERS ownership.
+type ComponentDetail struct {
+	schema.Component
+	Ownership *owners.Ownership `json:"ownership,omitempty"`
 }
 
 type ArchetypeSummary struct {
-	Category   string `json:"category"`
-	ID         string `json:"id"`
-	Technology string `json:"technology,omitempty"`
+	Category    string         `json:"category"`
+	ID          string         `json:"id"`
+	Symbol      string         `json:"symbol,omitempty"`
+	Technology  string         `json:"technology,omitempty"`
+	Annotations map[string]any `json:"annotations,omitempty"`
 }
 
 type FlowSummary struct {
@@ -32,24 +75,63 @@ type FlowSummary struct {
 	Name string `json:"name"`
 }
 
+// TopicResponse groups the archetypes declaring a topic by inferred role.
+type TopicResponse struct {
+	Topic     string         `json:"topic"`
+	Producers []TopicHandler `json:"producers"`
+	Consumers []TopicHandler `json:"consumers"`
+	Unknown   []TopicHandler `json:"unknown,omitempty"` // role could not be inferred
+}
+
+type VersionResponse struct {
+	Version            string   `json:"version"`
+	SchemaVersion      int      `json:"schema_version"`
+	IndexSchemaVersion int      `json:"index_schema_version"` // as on disk, before migration
+	Capabilities       []string `json:"capabilities"`
+}
+
 // SetupRoutes registers all HTTP handlers on the given mux.
-func SetupRoutes(mux *http.ServeMux, idx *ArchiveIndex, cs *CursorState) {
+func SetupRoutes(mux *http.ServeMux, st *Store, bus *EventBus) {
 	mux.HandleFunc("GET /{$}", handleUI())
 	mux.HandleFunc("GET /favicon.png", handleFavicon())
+	mux.HandleFunc("GET /vendor/{file}", handleVendor())
 	mux.HandleFunc("GET /health", handleHealth)
-	mux.HandleFunc("GET /graph", handleGraph(idx))
-	mux.HandleFunc("GET /context", handleContext(idx))
-	mux.HandleFunc("GET /components", handleComponents(idx))
-	mux.HandleFunc("GET /archetypes/{category}", handleArchetypes(idx))
-	mux.HandleFunc("GET /relationships", handleRelationships(idx))
-	mux.HandleFunc("GET /flows", handleFlows(idx))
-	mux.HandleFunc("PUT /cursor", handleCursorPut(cs))
-	mux.HandleFunc("GET /cursor/stream", handleCursorStream(cs))
+	mux.HandleFunc("GET /version", handleVersion(st))
+	mux.HandleFunc("GET /graph", handleGraph(st))
+	mux.HandleFunc("GET /context", handleContext(st))
+	mux.HandleFunc("POST /context/batch", handleContextBatch(st))
+	mux.HandleFunc("GET /components", handleComponents(st))
+	mux.HandleFunc("GET /archetypes/{category}", handleArchetypes(st))
+	mux.HandleFunc("GET /relationships", handleRelationships(st))
+	mux.HandleFunc("GET /flows", handleFlows(st))
+	mux.HandleFunc("GET /search", handleSearch(st))
+	mux.HandleFunc("GET /routes/resolve", handleRouteResolve(st))
+	mux.HandleFunc("GET /topics/{name...}", handleTopic(st))
+	mux.HandleFunc("GET /path", handlePath(st))
+	mux.HandleFunc("GET /impact", handleImpact(st))
+	mux.HandleFunc("GET /metrics/architecture", handleArchitectureMetrics(st))
+	mux.HandleFunc("PUT /cursor", handleCursorPut(st, bus))
+	mux.HandleFunc("GET /cursor/stream", handleCursorStream(bus))
+	mux.HandleFunc("PUT /selection", handleSelectionPut(st, bus))
+	mux.HandleFunc("GET /events", handleEvents(bus))
+
+	if !st.Editable() {
+		return
+	}
+	ed := NewEditor(st, bus)
+	mux.HandleFunc("PATCH /components/{id}", handleComponentPatch(ed))
+	mux.HandleFunc("POST /relationships", handleRelationshipPost(ed))
+	mux.HandleFunc("DELETE /relationships", handleRelationshipDelete(ed))
+	mux.HandleFunc("PUT /flows/{id}", handleFlowPut(ed))
+	mux.HandleFunc("PUT /archetypes/{id}/category", handleArchetypeMove(ed))
+	mux.HandleFunc("POST /undo", handleUndo(ed))
+	mux.HandleFunc("POST /redo", handleRedo(ed))
+	mux.HandleFunc("GET /edits", handleEdits(ed))
 }
 
-func handleGraph(idx *ArchiveIndex) http.HandlerFunc {
+func handleGraph(st *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		writeJSON(w, http.StatusOK, idx.BuildGraphPayload())
+		writeJSON(w, http.StatusOK, st.Index().BuildGraphPayload())
 	}
 }
 
@@ -57,73 +139,185 @@ func handleHealth(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
-func handleContext(idx *ArchiveIndex) http.HandlerFunc {
+// storeCapabilities drops "edit" for an index built in memory, which has
+// no edit endpoints.
+func storeCapabilities(st *Store) []string {
+	if st.Editable() {
+		return capabilities
+	}
+	return slices.DeleteFunc(slices.Clone(capabilities), func(c string) bool { return c == "edit" })
+}
+
+func handleVersion(st *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idx := st.Index()
+		writeJSON(w, http.StatusOK, VersionResponse{
+			Version:            Version,
+			SchemaVersion:      schema.CurrentSchemaVersion,
+			IndexSchemaVersion: idx.Raw.SourceSchemaVersion,
+			Capabilities:       storeCapabilities(st),
+		})
+	}
+}
+
+func handleContext(st *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		file := r.URL.Query().Get("file")
-		if file == "" {
+		q := ContextQuery{File: r.URL.Query().Get("file"), Symbol: r.URL.Query().Get("symbol")}
+		if q.File == "" {
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "file parameter is required"})
 			return
 		}
+		if s := r.URL.Query().Get("line"); s != "" {
+			line, err := strconv.Atoi(s)
+			if err != nil || line < 1 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "line must be a positive integer"})
+				return
+			}
+			q.Line = line
+		}
+		writeJSON(w, http.StatusOK, fileContext(st.Index(), q))
+	}
+}
 
-		file = NormalizePath(file)
+// ContextQuery asks for the context of a file, optionally at a line or a
+// symbol to pick between archetypes declared in the same file.
+type ContextQuery struct {
+	File   string `json:"file"`
+	Line   int    `json:"line,omitempty"`
+	Symbol string `json:"symbol,omitempty"`
+}
 
-		resp := ContextResponse{}
+// UnmarshalJSON also accepts a bare file path.
+func (q *ContextQuery) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		*q = ContextQuery{}
+		return json.Unmarshal(data, &q.File)
+	}
+	type plain ContextQuery
+	return json.Unmarshal(data, (*plain)(q))
+}
 
-		// Find component
-		comp := idx.FindComponent(file)
-		if comp != nil {
-			resp.Component = &ComponentSummary{ID: comp.ID, Name: comp.Name}
-			resp.Layer = comp.Layer
-			resp.ZoomAvailable = comp.NestedAnalysis != ""
-			resp.ZoomAnalyzed = comp.Analyzed
+// ContextBatchRequest is the body of POST /context/batch.
+type ContextBatchRequest struct {
+	Files []ContextQuery `json:"files"`
+}
 
-			// Find flows through this component
-			flows := idx.FindFlows(comp.ID)
-			for _, f := range flows {
-				resp.Flows = append(resp.Flows, FlowSummary{ID: f.ID, Name: f.Name})
+// ContextBatchResult is one file's context, in request order.
+type ContextBatchResult struct {
+	File    string          `json:"file"`
+	Line    int             `json:"line,omitempty"`
+	Symbol  string          `json:"symbol,omitempty"`
+	Context ContextResponse `json:"context"`
+}
+
+// maxContextBatch bounds the files in one POST /context/batch.
+const maxContextBatch = 1000
+
+func handleContextBatch(st *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ContextBatchRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body: " + err.Error()})
+			return
+		}
+		if len(req.Files) > maxContextBatch {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("at most %d files per batch", maxContextBatch)})
+			return
+		}
+		idx := st.Index()
+		results := make([]ContextBatchResult, len(req.Files))
+		for i, q := range req.Files {
+			switch {
+			case q.File == "":
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("files[%d]: file is required", i)})
+				return
+			case q.Line < 0:
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("files[%d]: line must be a positive integer", i)})
+				return
 			}
+			results[i] = ContextBatchResult{File: q.File, Line: q.Line, Symbol: q.Symbol, Context: fileContext(idx, q)}
 		}
+		writeJSON(w, http.StatusOK, map[string]any{"results": results})
+	}
+}
 
-		// Find archetype
-		arch := idx.FindArchetype(file)
-		if arch != nil {
-			resp.Archetype = &ArchetypeSummary{
-				Category:   arch.Category,
-				ID:         arch.Archetype.ID,
-				Technology: arch.Archetype.Technology,
-			}
+// fileContext assembles the architectural context of one file.
+func fileContext(idx *ArchiveIndex, q ContextQuery) ContextResponse {
+	file := NormalizePath(q.File)
 
-			// Also find flows through this archetype
-			flows := idx.FindFlows(arch.Archetype.ID)
-			for _, f := range flows {
-				// Avoid duplicates
-				found := false
-				for _, existing := range resp.Flows {
-					if existing.ID == f.ID {
-						found = true
-						break
-					}
-				}
-				if !found {
-					resp.Flows = append(resp.Flows, FlowSummary{ID: f.ID, Name: f.Name})
+	resp := ContextResponse{FileOwners: idx.FileOwners(file)}
+
+	// Find component
+	comp := idx.FindComponent(file)
+	if comp != nil {
+		resp.Component = &ComponentSummary{
+			ID:          comp.ID,
+			Name:        comp.Name,
+			Description: comp.Description,
+			Tags:        comp.Tags,
+			Annotations: comp.Annotations,
+			Ownership:   idx.Ownership(comp.ID),
+		}
+		resp.Layer = comp.Layer
+		resp.ZoomAvailable = comp.NestedAnalysis != ""
+		resp.ZoomAnalyzed = comp.Analyzed
+
+		// Find flows through this component
+		flows := idx.FindFlows(comp.ID)
+		for _, f := range flows {
+			resp.Flows = append(resp.Flows, FlowSummary{ID: f.ID, Name: f.Name})
+		}
+	}
+
+	// Find archetype
+	arch := idx.FindArchetypeAt(file, q.Line, q.Symbol)
+	if arch != nil {
+		resp.Archetype = &ArchetypeSummary{
+			Category:    arch.Category,
+			ID:          arch.Archetype.ID,
+			Symbol:      arch.Archetype.Symbol,
+			Technology:  arch.Archetype.Technology,
+			Annotations: arch.Archetype.Annotations,
+		}
+
+		// Also find flows through this archetype
+		flows := idx.FindFlows(arch.Archetype.ID)
+		for _, f := range flows {
+			// Avoid duplicates
+			found := false
+			for _, existing := range resp.Flows {
+				if existing.ID == f.ID {
+					found = true
+					break
 				}
 			}
+			if !found {
+				resp.Flows = append(resp.Flows, FlowSummary{ID: f.ID, Name: f.Name})
+			}
 		}
-
-		writeJSON(w, http.StatusOK, resp)
 	}
+	return resp
 }
 
-func handleComponents(idx *ArchiveIndex) http.HandlerFunc {
+func handleComponents(st *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		idx := st.Index()
+		components := make([]ComponentDetail, 0, len(idx.Raw.Components))
+		for _, comp := range idx.Raw.Components {
+			components = append(components, ComponentDetail{
+				Component: comp,
+				Ownership: idx.Ownership(comp.ID),
+			})
+		}
 		writeJSON(w, http.StatusOK, map[string]interface{}{
-			"components": idx.Raw.Components,
+			"components": components,
 		})
 	}
 }
 
-func handleArchetypes(idx *ArchiveIndex) http.HandlerFunc {
+func handleArchetypes(st *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		idx := st.Index()
 		category := r.PathValue("category")
 		archetypes, ok := idx.Raw.Archetypes[category]
 		if !ok {
@@ -138,8 +332,9 @@ func handleArchetypes(idx *ArchiveIndex) http.HandlerFunc {
 	}
 }
 
-func handleRelationships(idx *ArchiveIndex) http.HandlerFunc {
+func handleRelationships(st *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		idx := st.Index()
 		symbol := r.URL.Query().Get("symbol")
 		direction := r.URL.Query().Get("direction")
 		if direction == "" {
@@ -160,8 +355,9 @@ func handleRelationships(idx *ArchiveIndex) http.HandlerFunc {
 	}
 }
 
-func handleFlows(idx *ArchiveIndex) http.HandlerFunc {
+func handleFlows(st *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		idx := st.Index()
 		through := r.URL.Query().Get("through")
 
 		if through == "" {
@@ -178,6 +374,180 @@ func handleFlows(idx *ArchiveIndex) http.HandlerFunc {
 	}
 }
 
+// handleSearch handles GET /search?q=<text>[&kind=route,topic][&limit=N]
+func handleSearch(st *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		query := q.Get("q")
+		if strings.TrimSpace(query) == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "q parameter is required"})
+			return
+		}
+		var kinds []string
+		if k := q.Get("kind"); k != "" {
+			for _, kind := range strings.Split(k, ",") {
+				if !slices.Contains(SearchKinds, kind) {
+					writeJSON(w, http.StatusBadRequest, map[string]string{
+						"error": fmt.Sprintf("unknown kind %q (want %s)", kind, strings.Join(SearchKinds, ", ")),
+					})
+					return
+				}
+				kinds = append(kinds, kind)
+			}
+		}
+		limit := 20
+		if l := q.Get("limit"); l != "" {
+			n, err := strconv.Atoi(l)
+			if err != nil || n < 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be a non-negative integer"})
+				return
+			}
+			limit = n
+		}
+
+		results := st.Index().Search(query, kinds, limit)
+		if results == nil {
+			results = []SearchResult{}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"query":   query,
+			"results": results,
+		})
+	}
+}
+
+// handleRouteResolve handles GET /routes/resolve?method=GET&path=/v1/orders/42
+func handleRouteResolve(st *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		method := r.URL.Query().Get("method")
+		path := r.URL.Query().Get("path")
+		if path == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "path parameter is required"})
+			return
+		}
+		matches := st.Index().ResolveRoute(method, path)
+		if matches == nil {
+			matches = []RouteMatch{}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"method":  strings.ToUpper(method),
+			"path":    path,
+			"matches": matches,
+		})
+	}
+}
+
+// handleTopic handles GET /topics/{name}
+func handleTopic(st *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		handlers := st.Index().TopicHandlers(name)
+		if len(handlers) == 0 {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "no archetype declares topic: " + name})
+			return
+		}
+		resp := TopicResponse{
+			Topic:     name,
+			Producers: []TopicHandler{},
+			Consumers: []TopicHandler{},
+		}
+		for _, h := range handlers {
+			switch h.Role {
+			case TopicProducer:
+				resp.Producers = append(resp.Producers, h)
+			case TopicConsumer:
+				resp.Consumers = append(resp.Consumers, h)
+			default:
+				resp.Unknown = append(resp.Unknown, h)
+			}
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// handlePath handles GET /path?from=a&to=b&limit=20&depth=8
+func handlePath(st *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		from, to := q.Get("from"), q.Get("to")
+		if from == "" || to == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "from and to parameters are required"})
+			return
+		}
+		limit, depth := DefaultPathLimit, DefaultPathDepth
+		for name, dst := range map[string]*int{"limit": &limit, "depth": &depth} {
+			if v := q.Get(name); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 1 {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": name + " must be a positive integer"})
+					return
+				}
+				*dst = n
+			}
+		}
+
+		res, err := st.Index().Paths(from, to, limit, depth)
+		switch {
+		case errors.Is(err, ErrUnknownElement):
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		case err != nil:
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+// handleImpact handles GET /impact?file=a&file=b or ?id=x, with an
+// optional depth limit.
+func handleImpact(st *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		files, ids := q["file"], q["id"]
+		if len(files) == 0 && len(ids) == 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "file or id parameter is required"})
+			return
+		}
+		depth := 0
+		if v := q.Get("depth"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "depth must be a non-negative integer"})
+				return
+			}
+			depth = n
+		}
+
+		idx := st.Index()
+		if len(ids) == 0 {
+			writeJSON(w, http.StatusOK, idx.ImpactOfFiles(files, depth))
+			return
+		}
+		if len(files) > 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "use either file or id, not both"})
+			return
+		}
+		res, err := idx.Impact(ids, depth)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+// handleArchitectureMetrics handles GET /metrics/architecture?layers=core,adapters
+func handleArchitectureMetrics(st *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var layers []string
+		if l := r.URL.Query().Get("layers"); l != "" {
+			layers = strings.Split(l, ",")
+		}
+		writeJSON(w, http.StatusOK, st.Index().ArchitectureMetrics(layers))
+	}
+}
+
 func writeJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
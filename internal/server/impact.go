@@ -0,0 +1,221 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// ChangedElement is an element a change starts from.
+type ChangedElement struct {
+	ID        string `json:"id"`
+	Kind      string `json:"kind"` // "archetype", "component" or "element"
+	Component string `json:"component,omitempty"`
+	File      string `json:"file,omitempty"` // the changed file it was found by
+}
+
+// Dependent is an element upstream of a change. Via is the element one
+// step closer to the change that it depends on.
+type Dependent struct {
+	ID        string `json:"id"`
+	Component string `json:"component,omitempty"`
+	Depth     int    `json:"depth"`
+	Via       string `json:"via"`
+	Type      string `json:"type"` // relationship type to Via
+}
+
+// ImpactEntryPoint is a route or topic entry point that reaches a change.
+type ImpactEntryPoint struct {
+	Handler   HandlerRef `json:"handler"`
+	Component string     `json:"component,omitempty"`
+	Type      string     `json:"type,omitempty"` // the archetype's entry_point_type
+	Routes    []string   `json:"routes,omitempty"`
+	Topics    []string   `json:"topics,omitempty"`
+	Depth     int        `json:"depth"` // 0 when the entry point itself changed
+}
+
+// ImpactResult is everything that could break when elements change.
+type ImpactResult struct {
+	Changed     []ChangedElement   `json:"changed"`
+	Unmatched   []string           `json:"unmatched,omitempty"` // files outside every component
+	Dependents  []Dependent        `json:"dependents"`
+	Components  []string           `json:"components"` // components of changed and dependent elements
+	Flows       []FlowSummary      `json:"flows"`
+	EntryPoints []ImpactEntryPoint `json:"entry_points"`
+	// Truncated is set when the depth limit stopped the walk before it
+	// ran out of dependents.
+	Truncated bool `json:"truncated"`
+}
+
+// ImpactOfFiles resolves changed files to elements and computes their
+// impact. A file that is an archetype changes that archetype; any other
+// file inside a component changes the component and so all its
+// archetypes. Files in no component are listed as unmatched.
+func (idx *ArchiveIndex) ImpactOfFiles(files []string, maxDepth int) *ImpactResult {
+	var changed []ChangedElement
+	var unmatched []string
+	for _, file := range files {
+		file = NormalizePath(file)
+		if entries := idx.archetypeByFile[file]; len(entries) > 0 {
+			for _, e := range entries {
+				changed = append(changed, ChangedElement{
+					ID:        e.Archetype.ID,
+					Kind:      "archetype",
+					Component: idx.ComponentOf(e.Archetype.ID),
+					File:      file,
+				})
+			}
+			continue
+		}
+		if comp := idx.FindComponent(file); comp != nil {
+			changed = append(changed, ChangedElement{ID: comp.ID, Kind: "component", Component: comp.ID, File: file})
+			continue
+		}
+		unmatched = append(unmatched, file)
+	}
+	res := idx.impact(changed, maxDepth)
+	res.Unmatched = unmatched
+	return res
+}
+
+// Impact computes the impact of changing elements by ID: components,
+// archetypes, or other relationship ends such as external systems.
+func (idx *ArchiveIndex) Impact(ids []string, maxDepth int) (*ImpactResult, error) {
+	var changed []ChangedElement
+	for _, id := range ids {
+		switch {
+		case idx.componentByID[id] != nil:
+			changed = append(changed, ChangedElement{ID: id, Kind: "component", Component: id})
+		case idx.archetypeByID[id] != nil:
+			changed = append(changed, ChangedElement{ID: id, Kind: "archetype", Component: idx.ComponentOf(id)})
+		case len(idx.relsByFrom[id]) > 0 || len(idx.relsByTo[id]) > 0:
+			changed = append(changed, ChangedElement{ID: id, Kind: "element"})
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnknownElement, id)
+		}
+	}
+	return idx.impact(changed, maxDepth), nil
+}
+
+// impact walks relationships upstream from the changed elements, breadth
+// first, up to maxDepth hops (no limit when maxDepth <= 0).
+func (idx *ArchiveIndex) impact(changed []ChangedElement, maxDepth int) *ImpactResult {
+	if changed == nil {
+		changed = []ChangedElement{}
+	}
+	res := &ImpactResult{
+		Changed:     changed,
+		Dependents:  []Dependent{},
+		Components:  []string{},
+		Flows:       []FlowSummary{},
+		EntryPoints: []ImpactEntryPoint{},
+	}
+
+	// A changed component changes all its archetypes, and relationships may
+	// also point at the component itself.
+	depth := make(map[string]int)
+	var frontier []string
+	seed := func(id string) {
+		if _, ok := depth[id]; !ok {
+			depth[id] = 0
+			frontier = append(frontier, id)
+		}
+	}
+	for _, c := range changed {
+		seed(c.ID)
+		if c.Kind == "component" {
+			for arch, comp := range idx.archetypeToComponent {
+				if comp == c.ID {
+					seed(arch)
+				}
+			}
+		}
+	}
+	slices.Sort(frontier)
+
+	for d := 1; len(frontier) > 0; d++ {
+		var next []string
+		for _, id := range frontier {
+			for _, rel := range idx.relsByTo[id] {
+				if _, ok := depth[rel.From]; ok {
+					continue
+				}
+				if maxDepth > 0 && d > maxDepth {
+					res.Truncated = true
+					continue
+				}
+				depth[rel.From] = d
+				next = append(next, rel.From)
+				res.Dependents = append(res.Dependents, Dependent{
+					ID:        rel.From,
+					Component: idx.elementComponent(rel.From),
+					Depth:     d,
+					Via:       id,
+					Type:      rel.Type,
+				})
+			}
+		}
+		slices.Sort(next)
+		frontier = next
+	}
+	sort.SliceStable(res.Dependents, func(i, j int) bool {
+		a, b := res.Dependents[i], res.Dependents[j]
+		if a.Depth != b.Depth {
+			return a.Depth < b.Depth
+		}
+		return a.ID < b.ID
+	})
+
+	ids := make([]string, 0, len(depth))
+	for id := range depth {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	comps := make(map[string]bool)
+	seenFlow := make(map[string]bool)
+	for _, id := range ids {
+		comp := idx.elementComponent(id)
+		if comp != "" {
+			comps[comp] = true
+		}
+		for _, f := range idx.FindFlows(id) {
+			if !seenFlow[f.ID] {
+				seenFlow[f.ID] = true
+				res.Flows = append(res.Flows, FlowSummary{ID: f.ID, Name: f.Name})
+			}
+		}
+
+		entry := idx.archetypeByID[id]
+		if entry == nil || !idx.isEntryPoint(entry) {
+			continue
+		}
+		arch := entry.Archetype
+		res.EntryPoints = append(res.EntryPoints, ImpactEntryPoint{
+			Handler:   handlerRef(entry),
+			Component: comp,
+			Type:      arch.EntryPointType,
+			Routes:    arch.Routes,
+			Topics:    arch.Topics,
+			Depth:     depth[id],
+		})
+	}
+	for comp := range comps {
+		res.Components = append(res.Components, comp)
+	}
+	slices.Sort(res.Components)
+	sort.Slice(res.Flows, func(i, j int) bool { return res.Flows[i].ID < res.Flows[j].ID })
+	sort.SliceStable(res.EntryPoints, func(i, j int) bool { return res.EntryPoints[i].Depth < res.EntryPoints[j].Depth })
+	return res
+}
+
+// isEntryPoint reports whether requests or messages enter through an
+// archetype: it declares routes, is marked as an entry point, or consumes
+// topics. Producers only send, so they are not entry points.
+func (idx *ArchiveIndex) isEntryPoint(e *archetypeEntry) bool {
+	arch := e.Archetype
+	if len(arch.Routes) > 0 || arch.EntryPointType != "" {
+		return true
+	}
+	return len(arch.Topics) > 0 && idx.topicRole(e.Category, arch.ID, arch.EntryPointType) == TopicConsumer
+}
@@ -1,10 +1,13 @@
 package server
 
 import (
-	"encoding/json"
-	"fmt"
-	"os"
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 
+	"github.com/nhomble/canopy/internal/owners"
 	"github.com/nhomble/canopy/internal/schema"
 )
 
@@ -13,14 +16,32 @@ import (
 type ArchiveIndex struct {
 	Raw *schema.ArchIndex
 
+	// repoRoot is where archetype files are read from to find symbols;
+	// empty for an index built in memory.
+	repoRoot string
+	// canopyDir holds the config.json whose ignore patterns limit the walk
+	// that resolves CODEOWNERS ownership; empty for an index built in memory.
+	canopyDir string
+
 	componentByID        map[string]*schema.Component
 	archetypeByID        map[string]*archetypeEntry
 	archetypeByFile      map[string][]*archetypeEntry
 	archetypeToComponent map[string]string // archetype ID → component ID
 	codeRefEntries       []codeRefEntry
+	codeRefs             *codeRefMatcher
+	componentCache       *lruCache[string, *schema.Component] // file → FindComponent result
 	relsByFrom           map[string][]schema.Relationship
 	relsByTo             map[string][]schema.Relationship
 	flowsByStep          map[string][]schema.Flow
+
+	ownersOnce sync.Once
+	codeOwners *owners.Ruleset
+	ownership  map[string]*owners.Ownership // component ID → owners
+
+	searchOnce sync.Once
+	searchDocs []searchDoc
+	routesOnce sync.Once
+	routes     []*compiledRoute
 }
 
 type codeRefEntry struct {
@@ -34,19 +55,24 @@ type archetypeEntry struct {
 	Archetype *schema.Archetype
 }
 
-// LoadIndex reads an index.json file and builds the in-memory index.
+// LoadIndex reads an index.json file, merges the overlay.json next to it,
+// and builds the in-memory index.
 func LoadIndex(indexPath string) (*ArchiveIndex, error) {
-	data, err := os.ReadFile(indexPath)
+	overlayPath := filepath.Join(filepath.Dir(indexPath), "overlay.json")
+	raw, results, err := schema.LoadIndexWithOverlay(indexPath, overlayPath)
 	if err != nil {
-		return nil, fmt.Errorf("reading index file: %w", err)
+		return nil, err
 	}
-
-	var raw schema.ArchIndex
-	if err := json.Unmarshal(data, &raw); err != nil {
-		return nil, fmt.Errorf("parsing index JSON: %w", err)
+	for _, res := range results {
+		if res.Warning != "" {
+			log.Printf("overlay: %s: %s", res.Key, res.Warning)
+		}
 	}
 
-	return NewIndex(&raw), nil
+	idx := NewIndex(raw)
+	idx.canopyDir = filepath.Dir(indexPath)
+	idx.repoRoot = filepath.Dir(idx.canopyDir)
+	return idx, nil
 }
 
 // NewIndex builds an ArchiveIndex from a raw ArchIndex struct.
@@ -73,6 +99,8 @@ func NewIndex(raw *schema.ArchIndex) *ArchiveIndex {
 			})
 		}
 	}
+	idx.codeRefs = newCodeRefMatcher(idx.codeRefEntries)
+	idx.componentCache = newLRUCache[string, *schema.Component](componentCacheSize)
 
 	for category, archetypes := range raw.Archetypes {
 		for i := range archetypes {
@@ -101,8 +129,8 @@ func NewIndex(raw *schema.ArchIndex) *ArchiveIndex {
 	}
 
 	for _, flow := range raw.Flows {
-		for _, step := range flow.Steps {
-			idx.flowsByStep[step] = append(idx.flowsByStep[step], flow)
+		for _, ref := range flow.Refs() {
+			idx.flowsByStep[ref] = append(idx.flowsByStep[ref], flow)
 		}
 	}
 
@@ -112,35 +140,43 @@ func NewIndex(raw *schema.ArchIndex) *ArchiveIndex {
 // Graph payload types for the /graph endpoint.
 
 type GraphPayload struct {
-	RepoID         string           `json:"repo_id"`
-	Patterns       []string         `json:"patterns"`
-	Components     []GraphComponent `json:"components"`
+	RepoID         string                `json:"repo_id"`
+	Patterns       []string              `json:"patterns"`
+	Components     []GraphComponent      `json:"components"`
 	Relationships  []schema.Relationship `json:"relationships"`
-	ComponentEdges []ComponentEdge  `json:"component_edges"`
-	Flows          []schema.Flow    `json:"flows"`
+	ComponentEdges []ComponentEdge       `json:"component_edges"`
+	Flows          []schema.Flow         `json:"flows"`
 }
 
 type GraphComponent struct {
-	ID         string           `json:"id"`
-	Name       string           `json:"name"`
-	Layer      string           `json:"layer"`
-	Archetypes []GraphArchetype `json:"archetypes"`
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Layer       string            `json:"layer"`
+	Description string            `json:"description,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Links       []schema.Link     `json:"links,omitempty"`
+	Archetypes  []GraphArchetype  `json:"archetypes"`
+	Annotations map[string]any    `json:"annotations,omitempty"`
+	Ownership   *owners.Ownership `json:"ownership,omitempty"`
 }
 
 type GraphArchetype struct {
-	ID         string `json:"id"`
-	Category   string `json:"category"`
-	Symbol     string `json:"symbol,omitempty"`
-	Technology string `json:"technology,omitempty"`
-	File       string `json:"file"`
-	Purpose    string `json:"purpose,omitempty"`
+	ID          string         `json:"id"`
+	Category    string         `json:"category"`
+	Symbol      string         `json:"symbol,omitempty"`
+	Technology  string         `json:"technology,omitempty"`
+	File        string         `json:"file"`
+	Purpose     string         `json:"purpose,omitempty"`
+	Annotations map[string]any `json:"annotations,omitempty"`
 }
 
 type ComponentEdge struct {
-	From  string   `json:"from"`
-	To    string   `json:"to"`
-	Count int      `json:"count"`
-	Types []string `json:"types"`
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+	Count     int      `json:"count"`
+	Types     []string `json:"types"`
+	Protocols []string `json:"protocols,omitempty"`
+	Modes     []string `json:"modes,omitempty"`
 }
 
 // BuildGraphPayload returns pre-computed data for the web UI graph view.
@@ -150,12 +186,13 @@ func (idx *ArchiveIndex) BuildGraphPayload() *GraphPayload {
 	for category, archetypes := range idx.Raw.Archetypes {
 		for _, arch := range archetypes {
 			ga := GraphArchetype{
-				ID:         arch.ID,
-				Category:   category,
-				Symbol:     arch.Symbol,
-				Technology: arch.Technology,
-				File:       arch.File,
-				Purpose:    arch.Purpose,
+				ID:          arch.ID,
+				Category:    category,
+				Symbol:      arch.Symbol,
+				Technology:  arch.Technology,
+				File:        arch.File,
+				Purpose:     arch.Purpose,
+				Annotations: arch.Annotations,
 			}
 			compID := idx.archetypeToComponent[arch.ID]
 			if compID != "" {
@@ -167,19 +204,37 @@ func (idx *ArchiveIndex) BuildGraphPayload() *GraphPayload {
 	components := make([]GraphComponent, 0, len(idx.Raw.Components))
 	for _, comp := range idx.Raw.Components {
 		components = append(components, GraphComponent{
-			ID:         comp.ID,
-			Name:       comp.Name,
-			Layer:      comp.Layer,
-			Archetypes: compArchetypes[comp.ID],
+			ID:          comp.ID,
+			Name:        comp.Name,
+			Layer:       comp.Layer,
+			Description: comp.Description,
+			Tags:        comp.Tags,
+			Links:       comp.Links,
+			Archetypes:  compArchetypes[comp.ID],
+			Annotations: comp.Annotations,
+			Ownership:   idx.Ownership(comp.ID),
 		})
 	}
 
-	// Build aggregated component-level edges.
+	return &GraphPayload{
+		RepoID:         idx.Raw.RepoID,
+		Patterns:       idx.Raw.Patterns,
+		Components:     components,
+		Relationships:  idx.Raw.Relationships,
+		ComponentEdges: idx.componentEdges(),
+		Flows:          idx.Raw.Flows,
+	}
+}
+
+// componentEdges aggregates relationships between elements of different
+// components into one edge per pair of components, sorted by endpoints.
+// Either end may be an archetype or the component itself.
+func (idx *ArchiveIndex) componentEdges() []ComponentEdge {
 	type edgeKey struct{ from, to string }
 	edgeMap := make(map[edgeKey]*ComponentEdge)
 	for _, rel := range idx.Raw.Relationships {
-		fromComp := idx.archetypeToComponent[rel.From]
-		toComp := idx.archetypeToComponent[rel.To]
+		fromComp := idx.elementComponent(rel.From)
+		toComp := idx.elementComponent(rel.To)
 		if fromComp == "" || toComp == "" || fromComp == toComp {
 			continue
 		}
@@ -190,29 +245,27 @@ func (idx *ArchiveIndex) BuildGraphPayload() *GraphPayload {
 			edgeMap[key] = edge
 		}
 		edge.Count++
-		// Add type if not already present.
-		found := false
-		for _, t := range edge.Types {
-			if t == rel.Type {
-				found = true
-				break
-			}
-		}
-		if !found {
-			edge.Types = append(edge.Types, rel.Type)
-		}
+		edge.Types = appendUnique(edge.Types, rel.Type)
+		edge.Protocols = appendUnique(edge.Protocols, rel.Protocol)
+		edge.Modes = appendUnique(edge.Modes, rel.Mode)
 	}
-	componentEdges := make([]ComponentEdge, 0, len(edgeMap))
+	edges := make([]ComponentEdge, 0, len(edgeMap))
 	for _, edge := range edgeMap {
-		componentEdges = append(componentEdges, *edge)
+		edges = append(edges, *edge)
 	}
+	slices.SortFunc(edges, func(a, b ComponentEdge) int {
+		if c := strings.Compare(a.From, b.From); c != 0 {
+			return c
+		}
+		return strings.Compare(a.To, b.To)
+	})
+	return edges
+}
 
-	return &GraphPayload{
-		RepoID:         idx.Raw.RepoID,
-		Patterns:       idx.Raw.Patterns,
-		Components:     components,
-		Relationships:  idx.Raw.Relationships,
-		ComponentEdges: componentEdges,
-		Flows:          idx.Raw.Flows,
+// appendUnique appends s to list unless it is empty or already present.
+func appendUnique(list []string, s string) []string {
+	if s == "" || slices.Contains(list, s) {
+		return list
 	}
+	return append(list, s)
 }
@@ -4,31 +4,20 @@ import (
 	"path/filepath"
 	"strings"
 
-	"github.com/bmatcuk/doublestar/v4"
 	"github.com/nhomble/canopy/internal/schema"
 )
 
 // FindComponent returns the component whose code_refs match the given file path.
-// When multiple patterns match, the most specific one wins.
+// When multiple patterns match, the most specific one wins. Results are
+// cached, as editors and the index build ask for the same files repeatedly.
 func (idx *ArchiveIndex) FindComponent(filePath string) *schema.Component {
 	filePath = filepath.ToSlash(filePath)
-
-	var bestMatch *schema.Component
-	bestSpecificity := -1
-
-	for _, entry := range idx.codeRefEntries {
-		matched, err := doublestar.Match(entry.Pattern, filePath)
-		if err != nil || !matched {
-			continue
-		}
-		specificity := nonGlobPrefixLen(entry.Pattern)
-		if specificity > bestSpecificity {
-			bestSpecificity = specificity
-			bestMatch = entry.Component
-		}
+	if comp, ok := idx.componentCache.Get(filePath); ok {
+		return comp
 	}
-
-	return bestMatch
+	comp := idx.findComponentUncached(filePath)
+	idx.componentCache.Add(filePath, comp)
+	return comp
 }
 
 // FindArchetype returns the archetype entry for an exact file path match.
@@ -41,6 +30,21 @@ func (idx *ArchiveIndex) FindArchetype(filePath string) *archetypeEntry {
 	return nil
 }
 
+// ComponentOf returns the ID of the component that owns the given archetype,
+// or "" if its file matches no component.
+func (idx *ArchiveIndex) ComponentOf(archetypeID string) string {
+	return idx.archetypeToComponent[archetypeID]
+}
+
+// elementComponent returns the component an element belongs to: itself
+// for a component ID, else the component owning the archetype.
+func (idx *ArchiveIndex) elementComponent(id string) string {
+	if _, ok := idx.componentByID[id]; ok {
+		return id
+	}
+	return idx.ComponentOf(id)
+}
+
 // FindFlows returns all flows that include the given ID as a step.
 func (idx *ArchiveIndex) FindFlows(id string) []schema.Flow {
 	return idx.flowsByStep[id]
@@ -61,16 +65,6 @@ func (idx *ArchiveIndex) FindRelationships(symbol, direction string) []schema.Re
 	}
 }
 
-// nonGlobPrefixLen returns the length of the path prefix before any glob chars.
-func nonGlobPrefixLen(pattern string) int {
-	for i, c := range pattern {
-		if c == '*' || c == '?' || c == '[' || c == '{' {
-			return i
-		}
-	}
-	return len(pattern)
-}
-
 // NormalizePath converts a file path to forward slashes and strips leading ./ or /
 func NormalizePath(p string) string {
 	p = filepath.ToSlash(p)
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/nhomble/canopy/internal/patterns"
+)
+
+// DefaultLayerOrder lists layers from the innermost outwards. A component
+// may depend on its own layer or inner ones; a dependency on an outer
+// layer is a violation. Layers not in the order are never in violation.
+// It applies when none of the index's patterns defines layers.
+var DefaultLayerOrder = []string{"core", "ports", "application", "adapters", "infrastructure"}
+
+// ComponentMetrics are the coupling metrics of one component, counted over
+// the aggregated component edges.
+type ComponentMetrics struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Layer string `json:"layer"`
+
+	FanIn  int `json:"fan_in"`  // components that depend on this one (Ca)
+	FanOut int `json:"fan_out"` // components this one depends on (Ce)
+	// Instability is Ce / (Ca + Ce): 0 for a component everything depends
+	// on, 1 for one that depends on others and nothing depends on. Nil for
+	// an isolated component.
+	Instability *float64 `json:"instability"`
+	// Abstractness is the share of the component's archetypes that are
+	// abstractions, such as ports and an undeclared provided interface.
+	// Nil for components that declare no provided interface.
+	Abstractness *float64 `json:"abstractness"`
+	// Distance from the main sequence, |A + I - 1|, when both are known.
+	Distance *float64 `json:"distance"`
+
+	LayerViolations int `json:"layer_violations"` // outgoing edges into outer layers
+	Cycle           int `json:"cycle,omitempty"`  // 1-based index into Cycles, 0 if acyclic
+}
+
+// DependencyCycle is a strongly connected set of components: each can
+// reach every other through dependencies.
+type DependencyCycle struct {
+	Components []string        `json:"components"`
+	Edges      []ComponentEdge `json:"edges"`
+}
+
+// LayerViolation is a dependency from an inner layer to an outer one.
+type LayerViolation struct {
+	ComponentEdge
+	FromLayer string `json:"from_layer"`
+	ToLayer   string `json:"to_layer"`
+}
+
+// ArchitectureMetrics summarises coupling across the whole index.
+type ArchitectureMetrics struct {
+	LayerOrder      []string           `json:"layer_order"`
+	Components      []ComponentMetrics `json:"components"`
+	Cycles          []DependencyCycle  `json:"cycles"`
+	LayerViolations []LayerViolation   `json:"layer_violations"`
+}
+
+// abstractCategories are archetype categories that hold abstractions.
+var abstractCategories = []string{"port", "interface", "contract", "usecase", "use-case", "api"}
+
+// LayerOrder returns the layers of the index's patterns, innermost first,
+// as the pattern definitions list them, or DefaultLayerOrder when none of
+// its patterns defines layers.
+func (idx *ArchiveIndex) LayerOrder() []string {
+	order, err := patterns.LayerOrder(idx.Raw.Patterns)
+	if err != nil || len(order) == 0 {
+		return DefaultLayerOrder
+	}
+	return order
+}
+
+// ArchitectureMetrics computes per-component coupling metrics, dependency
+// cycles and layer violations. layerOrder lists layers innermost first;
+// nil selects the index's LayerOrder.
+func (idx *ArchiveIndex) ArchitectureMetrics(layerOrder []string) *ArchitectureMetrics {
+	if layerOrder == nil {
+		layerOrder = idx.LayerOrder()
+	}
+	rank := make(map[string]int, len(layerOrder))
+	for i, layer := range layerOrder {
+		rank[layer] = i
+	}
+
+	edges := idx.componentEdges()
+	m := &ArchitectureMetrics{
+		LayerOrder:      layerOrder,
+		Components:      make([]ComponentMetrics, 0, len(idx.Raw.Components)),
+		Cycles:          []DependencyCycle{},
+		LayerViolations: []LayerViolation{},
+	}
+	byID := make(map[string]*ComponentMetrics, len(idx.Raw.Components))
+	for _, comp := range idx.Raw.Components {
+		m.Components = append(m.Components, ComponentMetrics{ID: comp.ID, Name: comp.Name, Layer: comp.Layer})
+	}
+	for i := range m.Components {
+		byID[m.Components[i].ID] = &m.Components[i]
+	}
+
+	for _, e := range edges {
+		from, to := byID[e.From], byID[e.To]
+		if from == nil || to == nil {
+			continue
+		}
+		from.FanOut++
+		to.FanIn++
+		fr, fok := rank[from.Layer]
+		tr, tok := rank[to.Layer]
+		if fok && tok && fr < tr {
+			from.LayerViolations++
+			m.LayerViolations = append(m.LayerViolations, LayerViolation{ComponentEdge: e, FromLayer: from.Layer, ToLayer: to.Layer})
+		}
+	}
+
+	abstract := idx.abstractArchetypes()
+	for i := range m.Components {
+		c := &m.Components[i]
+		if total := c.FanIn + c.FanOut; total > 0 {
+			c.Instability = ratio(c.FanOut, total)
+		}
+		if a, n, ok := abstract(c.ID); ok {
+			c.Abstractness = ratio(a, n)
+		}
+		if c.Instability != nil && c.Abstractness != nil {
+			d := round3(math.Abs(*c.Abstractness + *c.Instability - 1))
+			c.Distance = &d
+		}
+	}
+
+	for _, scc := range stronglyConnected(m.Components, edges) {
+		if len(scc) < 2 {
+			continue
+		}
+		cycle := DependencyCycle{Components: scc}
+		for _, e := range edges {
+			if slices.Contains(scc, e.From) && slices.Contains(scc, e.To) {
+				cycle.Edges = append(cycle.Edges, e)
+			}
+		}
+		m.Cycles = append(m.Cycles, cycle)
+		for _, id := range scc {
+			byID[id].Cycle = len(m.Cycles)
+		}
+	}
+	return m
+}
+
+// abstractArchetypes returns a function counting a component's abstract
+// archetypes and all its archetypes. A provided interface that no archetype
+// declares counts as one more abstract archetype; one declared by, say, a
+// controller counts as that archetype does. ok is false for components
+// without a provided interface.
+func (idx *ArchiveIndex) abstractArchetypes() func(compID string) (abstract, total int, ok bool) {
+	type counts struct {
+		abstract, total int
+		symbols         map[string]bool // declared archetype symbols
+	}
+	byComp := make(map[string]*counts)
+	for _, category := range sortedCategories(idx.Raw.Archetypes) {
+		isAbstract := hasAnyWord(category, abstractCategories)
+		for _, arch := range idx.Raw.Archetypes[category] {
+			comp := idx.ComponentOf(arch.ID)
+			if comp == "" {
+				continue
+			}
+			c := byComp[comp]
+			if c == nil {
+				c = &counts{symbols: make(map[string]bool)}
+				byComp[comp] = c
+			}
+			c.total++
+			if isAbstract {
+				c.abstract++
+			}
+			c.symbols[arch.Symbol] = true
+		}
+	}
+	return func(compID string) (int, int, bool) {
+		comp := idx.componentByID[compID]
+		if comp == nil || comp.Provides == nil || comp.Provides.Interface == "" {
+			return 0, 0, false
+		}
+		c := byComp[compID]
+		if c == nil {
+			return 1, 1, true
+		}
+		if !c.symbols[comp.Provides.Interface] {
+			return c.abstract + 1, c.total + 1, true
+		}
+		return c.abstract, c.total, true
+	}
+}
+
+// stronglyConnected returns the strongly connected components of the
+// component graph (Tarjan's algorithm), each sorted, in order of their
+// first member.
+func stronglyConnected(comps []ComponentMetrics, edges []ComponentEdge) [][]string {
+	next := make(map[string][]string)
+	for _, e := range edges {
+		next[e.From] = append(next[e.From], e.To)
+	}
+
+	var (
+		index   = make(map[string]int)
+		low     = make(map[string]int)
+		onStack = make(map[string]bool)
+		stack   []string
+		sccs    [][]string
+	)
+	var visit func(v string)
+	visit = func(v string) {
+		index[v] = len(index)
+		low[v] = index[v]
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, w := range next[v] {
+			if _, seen := index[w]; !seen {
+				visit(w)
+				low[v] = min(low[v], low[w])
+			} else if onStack[w] {
+				low[v] = min(low[v], index[w])
+			}
+		}
+		if low[v] != index[v] {
+			return
+		}
+		var scc []string
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[w] = false
+			scc = append(scc, w)
+			if w == v {
+				break
+			}
+		}
+		slices.Sort(scc)
+		sccs = append(sccs, scc)
+	}
+	for _, c := range comps {
+		if _, seen := index[c.ID]; !seen {
+			visit(c.ID)
+		}
+	}
+	slices.SortFunc(sccs, func(a, b []string) int { return strings.Compare(a[0], b[0]) })
+	return sccs
+}
+
+func ratio(n, total int) *float64 {
+	r := round3(float64(n) / float64(total))
+	return &r
+}
+
+func round3(f float64) float64 {
+	return math.Round(f*1000) / 1000
+}
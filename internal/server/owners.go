@@ -0,0 +1,97 @@
+package server
+
+import (
+	"log"
+	"path/filepath"
+
+	"github.com/nhomble/canopy/internal/canopydir"
+	"github.com/nhomble/canopy/internal/owners"
+	"github.com/nhomble/canopy/internal/scanner"
+	"github.com/nhomble/canopy/internal/schema"
+)
+
+// loadOwners attaches CODEOWNERS data from the repository containing the
+// .canopy directory the first time ownership is asked for, since resolving
+// it walks the whole repository. Repositories without a CODEOWNERS file, and
+// indexes built in memory, are left as is.
+func (idx *ArchiveIndex) loadOwners() {
+	idx.ownersOnce.Do(func() {
+		if idx.canopyDir == "" || idx.codeOwners != nil {
+			return
+		}
+		if err := idx.attachRepoOwners(); err != nil {
+			log.Printf("codeowners: %v", err)
+		}
+	})
+}
+
+func (idx *ArchiveIndex) attachRepoOwners() error {
+	ad := &canopydir.CanopyDir{Root: idx.canopyDir}
+	rs, err := owners.Load(ad.RepoRoot())
+	if err != nil || rs == nil {
+		return err
+	}
+	ignore := schema.DefaultConfig("").IgnorePatterns
+	if cfg, err := ad.LoadConfig(); err == nil {
+		ignore = cfg.IgnorePatterns
+	}
+	walk, err := scanner.Walk(ad.RepoRoot(), ignore, 0)
+	if err != nil {
+		return err
+	}
+	files := make([]string, 0, len(walk.Files))
+	for _, f := range walk.Files {
+		files = append(files, filepath.ToSlash(f.RelPath))
+	}
+	idx.AttachOwners(rs, files)
+	return nil
+}
+
+// AttachOwners resolves per-component ownership by assigning each file to
+// its component and evaluating the CODEOWNERS rules. Components with no
+// files on disk fall back to the literal prefix of their code_refs.
+func (idx *ArchiveIndex) AttachOwners(rs *owners.Ruleset, files []string) {
+	idx.codeOwners = rs
+	idx.ownership = make(map[string]*owners.Ownership, len(idx.Raw.Components))
+
+	filesByComponent := make(map[string][]string)
+	for _, f := range files {
+		if comp := idx.FindComponent(f); comp != nil {
+			filesByComponent[comp.ID] = append(filesByComponent[comp.ID], f)
+		}
+	}
+
+	for _, comp := range idx.Raw.Components {
+		compFiles := filesByComponent[comp.ID]
+		if len(compFiles) == 0 {
+			for _, ref := range comp.CodeRefs {
+				if prefix := ref[:schema.CodeRefSpecificity(ref)]; prefix != "" {
+					compFiles = append(compFiles, prefix)
+				}
+			}
+		}
+		idx.ownership[comp.ID] = rs.Resolve(compFiles)
+	}
+}
+
+// HasOwners reports whether a CODEOWNERS file was attached.
+func (idx *ArchiveIndex) HasOwners() bool {
+	idx.loadOwners()
+	return idx.codeOwners != nil
+}
+
+// Ownership returns the resolved owners of a component, or nil when the
+// repository has no CODEOWNERS file.
+func (idx *ArchiveIndex) Ownership(componentID string) *owners.Ownership {
+	idx.loadOwners()
+	return idx.ownership[componentID]
+}
+
+// FileOwners returns the CODEOWNERS owners of a single file.
+func (idx *ArchiveIndex) FileOwners(file string) []string {
+	idx.loadOwners()
+	if idx.codeOwners == nil {
+		return nil
+	}
+	return idx.codeOwners.Match(file)
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/nhomble/canopy/internal/schema"
+)
+
+// Path query defaults.
+const (
+	DefaultPathLimit = 20
+	DefaultPathDepth = 8
+)
+
+// ErrUnknownElement is returned for a path endpoint that is neither a
+// component, an archetype, nor the end of a relationship.
+var ErrUnknownElement = errors.New("unknown element")
+
+// DependencyPath is a chain of relationships between two elements.
+// Components holds the component of each node, or "" for nodes outside
+// any component.
+type DependencyPath struct {
+	Nodes         []string              `json:"nodes"`
+	Components    []string              `json:"components"`
+	Relationships []schema.Relationship `json:"relationships"`
+}
+
+// PathResult answers how one element comes to depend on another.
+type PathResult struct {
+	From      string           `json:"from"`
+	To        string           `json:"to"`
+	Shortest  *DependencyPath  `json:"shortest"`
+	Paths     []DependencyPath `json:"paths"`
+	Truncated bool             `json:"truncated"` // more paths exist than were returned
+}
+
+// Paths finds the dependency paths from one element to another over the
+// relationship graph. Components stand for their archetypes, so a path
+// from a component starts at any of its archetypes and ends at the first
+// archetype of the target reached. Shortest is the shortest path of any
+// length; Paths lists simple paths of at most maxDepth relationships,
+// shortest first, up to limit of them. Zero limit or maxDepth selects the
+// default.
+func (idx *ArchiveIndex) Paths(from, to string, limit, maxDepth int) (*PathResult, error) {
+	if limit <= 0 {
+		limit = DefaultPathLimit
+	}
+	if maxDepth <= 0 {
+		maxDepth = DefaultPathDepth
+	}
+	sources, err := idx.pathEndpoints(from)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := idx.pathEndpoints(to)
+	if err != nil {
+		return nil, err
+	}
+	isSource := make(map[string]bool, len(sources))
+	for _, s := range sources {
+		isSource[s] = true
+	}
+	isTarget := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		if !isSource[t] {
+			isTarget[t] = true
+		}
+	}
+	if len(isTarget) == 0 {
+		return nil, fmt.Errorf("%s and %s are the same elements", from, to)
+	}
+
+	g := idx.pathGraph()
+	res := &PathResult{From: from, To: to, Paths: []DependencyPath{}}
+	shortest := g.shortest(sources, isTarget)
+	if shortest == nil {
+		return res, nil
+	}
+	p := idx.dependencyPath(g, shortest)
+	res.Shortest = &p
+
+	// Enumerate by increasing length so the limit keeps the shortest paths.
+	// dist prunes branches that cannot reach a target in the hops left.
+	dist := g.distanceTo(isTarget)
+	var nodes []string
+	onPath := make(map[string]bool)
+	var walk func(node string, left int) bool
+	walk = func(node string, left int) bool {
+		if left == 0 {
+			if isTarget[node] {
+				if len(res.Paths) == limit {
+					res.Truncated = true
+					return false
+				}
+				res.Paths = append(res.Paths, idx.dependencyPath(g, slices.Clone(nodes)))
+			}
+			return true
+		}
+		if isTarget[node] {
+			return true
+		}
+		for _, next := range g.next[node] {
+			d, ok := dist[next]
+			if !ok || d > left-1 || onPath[next] || isSource[next] {
+				continue
+			}
+			nodes = append(nodes, next)
+			onPath[next] = true
+			more := walk(next, left-1)
+			onPath[next] = false
+			nodes = nodes[:len(nodes)-1]
+			if !more {
+				return false
+			}
+		}
+		return true
+	}
+	for length := len(shortest) - 1; length <= maxDepth; length++ {
+		for _, s := range sources {
+			nodes = append(nodes[:0], s)
+			onPath[s] = true
+			more := walk(s, length)
+			onPath[s] = false
+			if !more {
+				return res, nil
+			}
+		}
+	}
+	return res, nil
+}
+
+// pathEndpoints lifts an element ID to the graph nodes it stands for.
+func (idx *ArchiveIndex) pathEndpoints(id string) ([]string, error) {
+	var nodes []string
+	if _, ok := idx.componentByID[id]; ok {
+		for arch, comp := range idx.archetypeToComponent {
+			if comp == id {
+				nodes = append(nodes, arch)
+			}
+		}
+	}
+	if _, ok := idx.archetypeByID[id]; ok || len(idx.relsByFrom[id]) > 0 || len(idx.relsByTo[id]) > 0 {
+		nodes = append(nodes, id)
+	}
+	if len(nodes) == 0 {
+		if _, ok := idx.componentByID[id]; ok {
+			return nil, fmt.Errorf("component %s has no archetypes", id)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrUnknownElement, id)
+	}
+	slices.Sort(nodes)
+	return nodes, nil
+}
+
+// pathGraph is the relationship graph with one edge per pair of nodes,
+// neighbours sorted so results are deterministic.
+type pathGraph struct {
+	next map[string][]string
+	prev map[string][]string
+	rel  map[[2]string]schema.Relationship // first relationship per edge
+}
+
+func (idx *ArchiveIndex) pathGraph() *pathGraph {
+	g := &pathGraph{
+		next: make(map[string][]string),
+		prev: make(map[string][]string),
+		rel:  make(map[[2]string]schema.Relationship),
+	}
+	for from, rels := range idx.relsByFrom {
+		for _, rel := range rels {
+			key := [2]string{from, rel.To}
+			if _, ok := g.rel[key]; ok || rel.To == from {
+				continue
+			}
+			g.rel[key] = rel
+			g.next[from] = append(g.next[from], rel.To)
+			g.prev[rel.To] = append(g.prev[rel.To], from)
+		}
+	}
+	for _, m := range []map[string][]string{g.next, g.prev} {
+		for _, ids := range m {
+			slices.Sort(ids)
+		}
+	}
+	return g
+}
+
+// shortest runs a breadth-first search from all sources at once and
+// returns the nodes of the first path to reach a target.
+func (g *pathGraph) shortest(sources []string, isTarget map[string]bool) []string {
+	parent := make(map[string]string)
+	seen := make(map[string]bool)
+	queue := slices.Clone(sources)
+	for _, s := range sources {
+		seen[s] = true
+	}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, next := range g.next[node] {
+			if seen[next] {
+				continue
+			}
+			seen[next] = true
+			parent[next] = node
+			if isTarget[next] {
+				nodes := []string{next}
+				for n := next; parent[n] != ""; n = parent[n] {
+					nodes = append(nodes, parent[n])
+				}
+				slices.Reverse(nodes)
+				return nodes
+			}
+			queue = append(queue, next)
+		}
+	}
+	return nil
+}
+
+// distanceTo returns each node's distance in hops to the nearest target.
+func (g *pathGraph) distanceTo(isTarget map[string]bool) map[string]int {
+	dist := make(map[string]int, len(isTarget))
+	var queue []string
+	for t := range isTarget {
+		dist[t] = 0
+		queue = append(queue, t)
+	}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, prev := range g.prev[node] {
+			if _, ok := dist[prev]; !ok {
+				dist[prev] = dist[node] + 1
+				queue = append(queue, prev)
+			}
+		}
+	}
+	return dist
+}
+
+// dependencyPath fills in the components and relationships along nodes.
+func (idx *ArchiveIndex) dependencyPath(g *pathGraph, nodes []string) DependencyPath {
+	p := DependencyPath{Nodes: nodes, Components: make([]string, len(nodes))}
+	for i, n := range nodes {
+		p.Components[i] = idx.elementComponent(n)
+		if i > 0 {
+			p.Relationships = append(p.Relationships, g.rel[[2]string{nodes[i-1], n}])
+		}
+	}
+	return p
+}
+
+// String renders a path as "a -> b -> c".
+func (p DependencyPath) String() string {
+	return strings.Join(p.Nodes, " -> ")
+}
@@ -0,0 +1,385 @@
+package server
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// HandlerRef identifies the archetype that handles a route or topic.
+type HandlerRef struct {
+	Category   string `json:"category"`
+	ID         string `json:"id"`
+	Symbol     string `json:"symbol,omitempty"`
+	File       string `json:"file"`
+	Technology string `json:"technology,omitempty"`
+}
+
+// ComponentRef names a component.
+type ComponentRef struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Layer string `json:"layer"`
+}
+
+// RouteMatch is a route template that matches a concrete request.
+type RouteMatch struct {
+	Route     string            `json:"route"`            // as declared on the archetype
+	Method    string            `json:"method,omitempty"` // empty if the route accepts any method
+	Params    map[string]string `json:"params,omitempty"`
+	Handler   HandlerRef        `json:"handler"`
+	Component *ComponentRef     `json:"component,omitempty"`
+	Flows     []FlowSummary     `json:"flows,omitempty"`
+}
+
+// TopicHandler is an archetype that produces or consumes a topic.
+type TopicHandler struct {
+	Topic     string        `json:"topic"` // as declared, possibly a pattern
+	Role      string        `json:"role"`  // TopicProducer, TopicConsumer or TopicUnknown
+	Handler   HandlerRef    `json:"handler"`
+	Component *ComponentRef `json:"component,omitempty"`
+	Flows     []FlowSummary `json:"flows,omitempty"`
+}
+
+// Topic roles. The index does not record direction, so it is inferred from
+// relationship types and archetype categories.
+const (
+	TopicProducer = "producer"
+	TopicConsumer = "consumer"
+	TopicUnknown  = "unknown"
+)
+
+// compiledRoute is a route template turned into a regular expression.
+type compiledRoute struct {
+	route    string
+	method   string
+	re       *regexp.Regexp
+	params   []string // capture group i+1 → parameter name
+	literals int      // literal segments, for ranking
+	rest     bool     // ends in a catch-all wildcard
+	arch     *archetypeEntry
+}
+
+var (
+	routeMethodPrefix = regexp.MustCompile(`^([A-Za-z]+)\s+(\S+)$`)
+	routeParam        = regexp.MustCompile(`\{([^{}:]+)(?::([^{}]+))?\}|:([A-Za-z_][A-Za-z0-9_]*)`)
+)
+
+// ResolveRoute finds the route templates that match a request, most
+// specific first. Templates may use {id}, {id:regex}, :id, * (one segment,
+// or the rest when last) and ** or {id...} (the rest of the path). An empty
+// method matches any; so does a template declared without a method.
+func (idx *ArchiveIndex) ResolveRoute(method, requestPath string) []RouteMatch {
+	idx.routesOnce.Do(idx.compileRoutes)
+
+	method = strings.ToUpper(method)
+	requestPath = normalizeRequestPath(requestPath)
+
+	var matches []RouteMatch
+	var ranked []*compiledRoute
+	for _, cr := range idx.routes {
+		if method != "" && cr.method != "" && cr.method != method {
+			continue
+		}
+		m := cr.re.FindStringSubmatch(requestPath)
+		if m == nil {
+			continue
+		}
+		match := RouteMatch{
+			Route:   cr.route,
+			Method:  cr.method,
+			Handler: handlerRef(cr.arch),
+		}
+		for i, name := range cr.params {
+			if v := strings.TrimPrefix(m[i+1], "/"); v != "" {
+				if match.Params == nil {
+					match.Params = make(map[string]string)
+				}
+				match.Params[name] = v
+			}
+		}
+		match.Component, match.Flows = idx.handlerContext(cr.arch.Archetype.ID)
+		matches = append(matches, match)
+		ranked = append(ranked, cr)
+	}
+
+	order := make([]int, len(matches))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ra, rb := ranked[order[a]], ranked[order[b]]
+		if ra.literals != rb.literals {
+			return ra.literals > rb.literals
+		}
+		if ra.rest != rb.rest {
+			return !ra.rest
+		}
+		if (ra.method != "") != (rb.method != "") {
+			return ra.method != ""
+		}
+		return len(ra.params) < len(rb.params)
+	})
+	sorted := make([]RouteMatch, len(matches))
+	for i, o := range order {
+		sorted[i] = matches[o]
+	}
+	return sorted
+}
+
+// TopicHandlers lists the archetypes that declare a topic, by exact name or
+// by a declared pattern such as orders.* that matches it.
+func (idx *ArchiveIndex) TopicHandlers(name string) []TopicHandler {
+	var handlers []TopicHandler
+	for _, category := range sortedCategories(idx.Raw.Archetypes) {
+		for i := range idx.Raw.Archetypes[category] {
+			arch := &idx.Raw.Archetypes[category][i]
+			for _, topic := range arch.Topics {
+				if !topicMatches(topic, name) {
+					continue
+				}
+				entry := idx.archetypeByID[arch.ID]
+				h := TopicHandler{
+					Topic:   topic,
+					Role:    idx.topicRole(category, arch.ID, arch.EntryPointType),
+					Handler: handlerRef(entry),
+				}
+				h.Component, h.Flows = idx.handlerContext(arch.ID)
+				handlers = append(handlers, h)
+			}
+		}
+	}
+	sort.SliceStable(handlers, func(i, j int) bool {
+		return roleOrder(handlers[i].Role) < roleOrder(handlers[j].Role)
+	})
+	return handlers
+}
+
+func (idx *ArchiveIndex) compileRoutes() {
+	for _, category := range sortedCategories(idx.Raw.Archetypes) {
+		for _, arch := range idx.Raw.Archetypes[category] {
+			for _, route := range arch.Routes {
+				if cr := compileRoute(route); cr != nil {
+					cr.arch = idx.archetypeByID[arch.ID]
+					idx.routes = append(idx.routes, cr)
+				}
+			}
+		}
+	}
+}
+
+// compileRoute parses "GET /users/{id}" or "/users/:id" into a matcher.
+// It returns nil for routes that are not paths.
+func compileRoute(route string) *compiledRoute {
+	cr := &compiledRoute{route: route}
+	tmpl := strings.TrimSpace(route)
+	if m := routeMethodPrefix.FindStringSubmatch(tmpl); m != nil {
+		cr.method, tmpl = strings.ToUpper(m[1]), m[2]
+	}
+	if !strings.HasPrefix(tmpl, "/") {
+		return nil
+	}
+
+	segments := strings.Split(strings.Trim(tmpl, "/"), "/")
+	var re strings.Builder
+	re.WriteString("^")
+	for i, seg := range segments {
+		last := i == len(segments)-1
+		switch {
+		case seg == "":
+			continue
+		case seg == "**" || (seg == "*" && last) || isRestParam(seg):
+			name := "*"
+			if isRestParam(seg) {
+				name = strings.Trim(seg, "{}*.")
+			}
+			cr.params = append(cr.params, name)
+			cr.rest = true
+			re.WriteString("(/.*)?")
+			continue
+		case seg == "*":
+			cr.params = append(cr.params, "*")
+			re.WriteString("/([^/]+)")
+			continue
+		}
+
+		re.WriteString("/")
+		literal := true
+		pos := 0
+		for _, loc := range routeParam.FindAllStringSubmatchIndex(seg, -1) {
+			if loc[6] >= 0 && loc[0] > 0 && isWordByte(seg[loc[0]-1]) {
+				continue // a colon inside a word, as in /v1/things:batchGet
+			}
+			literal = false
+			re.WriteString(regexp.QuoteMeta(seg[pos:loc[0]]))
+			pattern := "[^/]+"
+			if loc[2] >= 0 {
+				cr.params = append(cr.params, strings.TrimSpace(seg[loc[2]:loc[3]]))
+				if loc[4] >= 0 {
+					pattern = seg[loc[4]:loc[5]]
+				}
+			} else {
+				cr.params = append(cr.params, seg[loc[6]:loc[7]])
+			}
+			// Inner groups in a custom pattern would shift our indexes.
+			if sub, err := regexp.Compile(pattern); err != nil || sub.NumSubexp() > 0 {
+				pattern = "[^/]+"
+			}
+			re.WriteString("(" + pattern + ")")
+			pos = loc[1]
+		}
+		re.WriteString(regexp.QuoteMeta(seg[pos:]))
+		if literal {
+			cr.literals++
+		}
+	}
+	if len(segments) == 1 && segments[0] == "" {
+		re.WriteString("/")
+	}
+	re.WriteString("$")
+
+	compiled, err := regexp.Compile(re.String())
+	if err != nil {
+		return nil
+	}
+	cr.re = compiled
+	return cr
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+// isRestParam reports whether a segment captures the rest of the path:
+// {path...} (Go) or {*path} (ASP.NET, Spring).
+func isRestParam(seg string) bool {
+	return strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") &&
+		(strings.HasSuffix(seg, "...}") || strings.HasPrefix(seg, "{*"))
+}
+
+// normalizeRequestPath strips the query string, fragment, scheme and host,
+// and any trailing slash.
+func normalizeRequestPath(p string) string {
+	if i := strings.IndexAny(p, "?#"); i >= 0 {
+		p = p[:i]
+	}
+	if i := strings.Index(p, "://"); i >= 0 {
+		p = p[i+3:]
+		if j := strings.Index(p, "/"); j >= 0 {
+			p = p[j:]
+		} else {
+			p = "/"
+		}
+	}
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	if len(p) > 1 {
+		p = strings.TrimRight(p, "/")
+	}
+	return p
+}
+
+// topicMatches compares a declared topic to a concrete name. Declared
+// topics may be glob patterns (orders.*) or use MQTT/AMQP wildcards
+// (orders/+, orders/#, orders.#).
+func topicMatches(declared, name string) bool {
+	if declared == name {
+		return true
+	}
+	if strings.HasSuffix(declared, "#") {
+		return strings.HasPrefix(name, strings.TrimSuffix(declared, "#"))
+	}
+	pattern := strings.ReplaceAll(declared, "+", "*")
+	if !strings.Contains(declared, "/") {
+		// path.Match stops * at "/" but not at "."; a wildcard in a dotted
+		// topic matches one word, as in AMQP.
+		pattern = strings.ReplaceAll(pattern, ".", "/")
+		name = strings.ReplaceAll(name, ".", "/")
+	}
+	ok, err := path.Match(pattern, name)
+	return err == nil && ok
+}
+
+var (
+	producerWords = []string{"produce", "publish", "emit", "send", "dispatch"}
+	consumerWords = []string{"consume", "subscribe", "listen", "receive", "handle", "worker"}
+)
+
+// topicRole infers whether an archetype produces or consumes its topics:
+// first from relationship types on the archetype, then from its category,
+// then from being an entry point (messages arrive through it).
+func (idx *ArchiveIndex) topicRole(category, archID, entryPointType string) string {
+	for _, rel := range idx.relsByFrom[archID] {
+		if hasAnyWord(rel.Type, producerWords) {
+			return TopicProducer
+		}
+		if hasAnyWord(rel.Type, consumerWords) {
+			return TopicConsumer
+		}
+	}
+	if hasAnyWord(category, producerWords) {
+		return TopicProducer
+	}
+	if hasAnyWord(category, consumerWords) {
+		return TopicConsumer
+	}
+	if entryPointType != "" {
+		return TopicConsumer
+	}
+	return TopicUnknown
+}
+
+func hasAnyWord(s string, words []string) bool {
+	s = strings.ToLower(s)
+	for _, w := range words {
+		if strings.Contains(s, w) {
+			return true
+		}
+	}
+	return false
+}
+
+func roleOrder(role string) int {
+	switch role {
+	case TopicProducer:
+		return 0
+	case TopicConsumer:
+		return 1
+	}
+	return 2
+}
+
+func handlerRef(e *archetypeEntry) HandlerRef {
+	return HandlerRef{
+		Category:   e.Category,
+		ID:         e.Archetype.ID,
+		Symbol:     e.Archetype.Symbol,
+		File:       e.Archetype.File,
+		Technology: e.Archetype.Technology,
+	}
+}
+
+// handlerContext returns the component owning an archetype and the flows
+// passing through it.
+func (idx *ArchiveIndex) handlerContext(archID string) (*ComponentRef, []FlowSummary) {
+	var comp *ComponentRef
+	if c := idx.componentByID[idx.ComponentOf(archID)]; c != nil {
+		comp = &ComponentRef{ID: c.ID, Name: c.Name, Layer: c.Layer}
+	}
+	var flows []FlowSummary
+	for _, f := range idx.FindFlows(archID) {
+		flows = append(flows, FlowSummary{ID: f.ID, Name: f.Name})
+	}
+	return comp, flows
+}
+
+func sortedCategories[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,322 @@
+package server
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Search result kinds.
+const (
+	KindComponent = "component"
+	KindArchetype = "archetype"
+	KindRoute     = "route"
+	KindTopic     = "topic"
+	KindEntity    = "entity"
+	KindSymbol    = "symbol"
+	KindFlow      = "flow"
+)
+
+// SearchKinds lists the result kinds in the order ties are broken.
+var SearchKinds = []string{KindComponent, KindArchetype, KindRoute, KindTopic, KindEntity, KindSymbol, KindFlow}
+
+// SearchResult is one match. ID is the element to navigate to: the
+// component, archetype or flow itself, or for routes, topics, entities and
+// symbols the archetype or component that declares them.
+type SearchResult struct {
+	Kind      string  `json:"kind"`
+	ID        string  `json:"id"`
+	Label     string  `json:"label"`
+	Component string  `json:"component,omitempty"`
+	File      string  `json:"file,omitempty"`
+	Field     string  `json:"field"` // field that matched best
+	Score     float64 `json:"score"`
+}
+
+// searchDoc is a searchable element with weighted fields.
+type searchDoc struct {
+	result SearchResult
+	fields []searchField
+}
+
+type searchField struct {
+	name   string
+	text   string // lowercased
+	words  []string
+	weight float64
+}
+
+// searchStopWords are dropped from queries like "where is login handled".
+var searchStopWords = map[string]bool{
+	"a": true, "an": true, "the": true, "is": true, "are": true, "where": true, "what": true,
+	"which": true, "how": true, "does": true, "do": true, "of": true, "to": true, "for": true, "in": true,
+}
+
+// Search ranks index elements against a free-text query. Each query word
+// is matched against every field, exactly, by prefix, as a substring, with
+// one or two typos, or as a subsequence, in falling order of score. Results
+// matching more of the query words rank higher. kinds, if given, restricts
+// the result kinds; limit <= 0 means no limit.
+func (idx *ArchiveIndex) Search(query string, kinds []string, limit int) []SearchResult {
+	var tokens []string
+	for _, tok := range strings.Fields(strings.ToLower(query)) {
+		tok = strings.TrimFunc(tok, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '/' })
+		if tok != "" && !searchStopWords[tok] {
+			tokens = append(tokens, tok)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil
+	}
+	allowed := make(map[string]bool, len(kinds))
+	for _, k := range kinds {
+		allowed[k] = true
+	}
+
+	idx.searchOnce.Do(idx.buildSearchDocs)
+	var results []SearchResult
+	for _, doc := range idx.searchDocs {
+		if len(allowed) > 0 && !allowed[doc.result.Kind] {
+			continue
+		}
+		score, field, matched := 0.0, "", 0
+		best := 0.0
+		for _, tok := range tokens {
+			tokBest, tokField := 0.0, ""
+			for _, f := range doc.fields {
+				if s := f.weight * matchScore(tok, f); s > tokBest {
+					tokBest, tokField = s, f.name
+				}
+			}
+			if tokBest > 0 {
+				matched++
+				score += tokBest
+				if tokBest > best {
+					best, field = tokBest, tokField
+				}
+			}
+		}
+		if matched == 0 {
+			continue
+		}
+		r := doc.result
+		r.Field = field
+		// Partial matches keep half their score at worst, so one exact hit
+		// can outrank several typo-level hits.
+		coverage := 0.5 + 0.5*float64(matched)/float64(len(tokens))
+		r.Score = float64(int(score*coverage*1000)) / 1000
+		results = append(results, r)
+	}
+
+	kindOrder := make(map[string]int, len(SearchKinds))
+	for i, k := range SearchKinds {
+		kindOrder[k] = i
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Kind != b.Kind {
+			return kindOrder[a.Kind] < kindOrder[b.Kind]
+		}
+		if a.Label != b.Label {
+			return a.Label < b.Label
+		}
+		return a.ID < b.ID
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// matchScore rates how well a query word matches a field, from 0 to 1.
+func matchScore(tok string, f searchField) float64 {
+	switch {
+	case f.text == "":
+		return 0
+	case f.text == tok:
+		return 1
+	}
+	score := 0.0
+	for _, w := range f.words {
+		switch {
+		case w == tok:
+			return 0.9
+		case len(tok) >= 2 && strings.HasPrefix(w, tok):
+			score = max(score, 0.75)
+		case len(tok) >= 4 && editDistance(tok, w, 2) <= typoBudget(tok):
+			score = max(score, 0.35)
+		}
+	}
+	if score < 0.6 && strings.Contains(f.text, tok) {
+		score = 0.6
+	}
+	if score == 0 && len(tok) >= 3 && len(f.text) <= 4*len(tok) && isSubsequence(tok, f.text) {
+		score = 0.25
+	}
+	return score
+}
+
+// typoBudget allows one typo in short words and two in long ones.
+func typoBudget(tok string) int {
+	if len(tok) >= 8 {
+		return 2
+	}
+	return 1
+}
+
+// editDistance returns the Levenshtein distance between a and b, or
+// limit+1 once it is known to exceed limit.
+func editDistance(a, b string, limit int) int {
+	if d := len(a) - len(b); d > limit || -d > limit {
+		return limit + 1
+	}
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func isSubsequence(tok, text string) bool {
+	i := 0
+	for j := 0; j < len(text) && i < len(tok); j++ {
+		if text[j] == tok[i] {
+			i++
+		}
+	}
+	return i == len(tok)
+}
+
+// searchWords splits text into lowercase words at punctuation and
+// camelCase boundaries: "CreateOrderService" → create, order, service.
+func searchWords(text string) []string {
+	var words []string
+	var cur []rune
+	runes := []rune(text)
+	flush := func() {
+		if len(cur) > 0 {
+			words = append(words, strings.ToLower(string(cur)))
+			cur = cur[:0]
+		}
+	}
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush()
+			continue
+		}
+		if unicode.IsUpper(r) && len(cur) > 0 {
+			prevLower := unicode.IsLower(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || (unicode.IsUpper(runes[i-1]) && nextLower) {
+				flush()
+			}
+		}
+		cur = append(cur, r)
+	}
+	flush()
+	return words
+}
+
+func newSearchField(name, text string, weight float64) searchField {
+	return searchField{name: name, text: strings.ToLower(text), words: searchWords(text), weight: weight}
+}
+
+// buildSearchDocs flattens the index into searchable documents. It runs
+// once per index, on the first search.
+func (idx *ArchiveIndex) buildSearchDocs() {
+	var docs []searchDoc
+	for _, comp := range idx.Raw.Components {
+		fields := []searchField{
+			newSearchField("name", comp.Name, 1),
+			newSearchField("id", comp.ID, 0.9),
+			newSearchField("description", comp.Description, 0.4),
+			newSearchField("layer", comp.Layer, 0.3),
+		}
+		for _, tag := range comp.Tags {
+			fields = append(fields, newSearchField("tags", tag, 0.6))
+		}
+		docs = append(docs, searchDoc{
+			result: SearchResult{Kind: KindComponent, ID: comp.ID, Label: comp.Name, Component: comp.ID},
+			fields: fields,
+		})
+		if comp.Provides != nil {
+			symbols := comp.Provides.Symbols
+			if comp.Provides.Interface != "" {
+				symbols = append([]string{comp.Provides.Interface}, symbols...)
+			}
+			for _, symbol := range symbols {
+				docs = append(docs, searchDoc{
+					result: SearchResult{Kind: KindSymbol, ID: comp.ID, Label: symbol, Component: comp.ID},
+					fields: []searchField{newSearchField("provides", symbol, 1)},
+				})
+			}
+		}
+	}
+
+	for _, category := range sortedCategories(idx.Raw.Archetypes) {
+		for _, arch := range idx.Raw.Archetypes[category] {
+			base := SearchResult{ID: arch.ID, Component: idx.ComponentOf(arch.ID), File: arch.File}
+			label := arch.Symbol
+			if label == "" {
+				label = arch.ID
+			}
+			r := base
+			r.Kind, r.Label = KindArchetype, label
+			docs = append(docs, searchDoc{result: r, fields: []searchField{
+				newSearchField("symbol", arch.Symbol, 1),
+				newSearchField("id", arch.ID, 0.9),
+				newSearchField("purpose", arch.Purpose, 0.5),
+				newSearchField("technology", arch.Technology, 0.6),
+				newSearchField("category", category, 0.4),
+				newSearchField("file", arch.File, 0.5),
+			}})
+			for _, route := range arch.Routes {
+				r := base
+				r.Kind, r.Label = KindRoute, route
+				docs = append(docs, searchDoc{result: r, fields: []searchField{newSearchField("route", route, 1)}})
+			}
+			for _, topic := range arch.Topics {
+				r := base
+				r.Kind, r.Label = KindTopic, topic
+				docs = append(docs, searchDoc{result: r, fields: []searchField{newSearchField("topic", topic, 1)}})
+			}
+			if arch.Entity != "" {
+				r := base
+				r.Kind, r.Label = KindEntity, arch.Entity
+				docs = append(docs, searchDoc{result: r, fields: []searchField{newSearchField("entity", arch.Entity, 1)}})
+			}
+		}
+	}
+
+	for _, flow := range idx.Raw.Flows {
+		docs = append(docs, searchDoc{
+			result: SearchResult{Kind: KindFlow, ID: flow.ID, Label: flow.Name},
+			fields: []searchField{
+				newSearchField("name", flow.Name, 1),
+				newSearchField("id", flow.ID, 0.9),
+				newSearchField("pattern", flow.Pattern, 0.3),
+			},
+		})
+	}
+	idx.searchDocs = docs
+}
@@ -7,28 +7,100 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
+
+	"github.com/nhomble/canopy/internal/canopydir"
 )
 
-// Run loads the index and starts the HTTP server. It blocks until shutdown.
-func Run(indexPath string, host string, port int) error {
-	idx, err := LoadIndex(indexPath)
+// reloadInterval is how often the server checks the index files for changes.
+const reloadInterval = time.Second
+
+// Run loads the index and starts the HTTP server. Edits and event streams
+// need the repository's access token; browsers may call the server from
+// its own pages and from allowOrigins or the origins in config.json. It
+// blocks until shutdown.
+func Run(indexPath string, host string, port int, allowOrigins []string) error {
+	st, err := OpenStore(indexPath)
 	if err != nil {
 		return fmt.Errorf("loading index: %w", err)
 	}
+	idx := st.Index()
+	token, origins, err := repoAccess(indexPath)
+	if err != nil {
+		return err
+	}
 
-	cs := NewCursorState(idx)
+	bus := NewEventBus()
 
 	mux := http.NewServeMux()
-	SetupRoutes(mux, idx, cs)
+	SetupRoutes(mux, st, bus)
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go st.Watch(ctx, reloadInterval, func(prev, idx *ArchiveIndex) {
+		PublishReload(bus, prev, idx)
+	})
 
-	handler := corsMiddleware(mux)
+	archetypeCount := 0
+	for _, a := range idx.Raw.Archetypes {
+		archetypeCount += len(a)
+	}
 
 	addr := fmt.Sprintf("%s:%d", host, port)
-	srv := &http.Server{Addr: addr, Handler: handler}
+	log.Printf("canopy server listening on http://%s (open in browser for graph UI)", addr)
+	log.Printf("Loaded: %d components, %d archetypes, %d relationships, %d flows",
+		len(idx.Raw.Components), archetypeCount,
+		len(idx.Raw.Relationships), len(idx.Raw.Flows))
+	logAccess(addr, host, (&canopydir.CanopyDir{Root: filepath.Dir(indexPath)}).TokenPath())
+
+	handler := corsMiddleware(append(origins, allowOrigins...), requireToken([]string{token}, mux))
+	return listen(&http.Server{Addr: addr, Handler: handler})
+}
+
+// RunWorkspace loads every repository of a workspace file and serves the
+// merged system index at the root and each repository's own endpoints
+// under /repos/{id}/. It blocks until shutdown.
+func RunWorkspace(workspacePath string, host string, port int, allowOrigins []string) error {
+	ws, err := LoadWorkspace(workspacePath)
+	if err != nil {
+		return err
+	}
+	wsrv, err := newWorkspaceServer(ws)
+	if err != nil {
+		return err
+	}
 
-	// Graceful shutdown
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	wsrv.watch(ctx, reloadInterval)
+
+	addr := fmt.Sprintf("%s:%d", host, port)
+	log.Printf("canopy workspace %s listening on http://%s", ws.Name, addr)
+	for _, r := range ws.Repos {
+		log.Printf("  %s: http://%s/repos/%s/ (%s)", r.ID, addr, r.ID, r.Index)
+	}
+	resolved, unresolved := wsrv.linkCounts()
+	log.Printf("Cross-repo links: %d resolved, %d unresolved (see /repos)", resolved, unresolved)
+
+	logAccess(addr, host, "each repository's .canopy/token")
+
+	origins := append(wsrv.origins, allowOrigins...)
+	return listen(&http.Server{Addr: addr, Handler: corsMiddleware(origins, wsrv.routes())})
+}
+
+// logAccess tells the user where the access token is, and how to open the
+// UI from another machine when the server is reachable from one.
+func logAccess(addr, host, tokenPath string) {
+	log.Printf("Edits and event streams need the access token in %s", tokenPath)
+	if host != "localhost" && !isLoopback(host) {
+		log.Printf("From another machine, open http://%s/?token=<token>", addr)
+	}
+}
+
+// listen runs srv until SIGINT or SIGTERM, then shuts it down gracefully.
+func listen(srv *http.Server) error {
 	go func() {
 		sigCh := make(chan os.Signal, 1)
 		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
@@ -39,31 +111,8 @@ func Run(indexPath string, host string, port int) error {
 		srv.Shutdown(ctx)
 	}()
 
-	archetypeCount := 0
-	for _, a := range idx.Raw.Archetypes {
-		archetypeCount += len(a)
-	}
-
-	log.Printf("canopy server listening on http://%s (open in browser for graph UI)", addr)
-	log.Printf("Loaded: %d components, %d archetypes, %d relationships, %d flows",
-		len(idx.Raw.Components), archetypeCount,
-		len(idx.Raw.Relationships), len(idx.Raw.Flows))
-
 	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
 		return err
 	}
 	return nil
 }
-
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
-}
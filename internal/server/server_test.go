@@ -1,11 +1,25 @@
 package server
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/nhomble/canopy/internal/history"
+	"github.com/nhomble/canopy/internal/owners"
 	"github.com/nhomble/canopy/internal/schema"
 )
 
@@ -64,7 +78,8 @@ func testIndex() *ArchiveIndex {
 func TestHealthEndpoint(t *testing.T) {
 	idx := testIndex()
 	mux := http.NewServeMux()
-	SetupRoutes(mux, idx, NewCursorState(idx))
+	st := NewStore(idx)
+	SetupRoutes(mux, st, NewEventBus())
 
 	req := httptest.NewRequest("GET", "/health", nil)
 	w := httptest.NewRecorder()
@@ -81,10 +96,35 @@ func TestHealthEndpoint(t *testing.T) {
 	}
 }
 
+func TestVersionEndpoint(t *testing.T) {
+	idx := testIndex()
+	mux := http.NewServeMux()
+	st := NewStore(idx)
+	SetupRoutes(mux, st, NewEventBus())
+
+	req := httptest.NewRequest("GET", "/version", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	var resp VersionResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.SchemaVersion != schema.CurrentSchemaVersion {
+		t.Fatalf("expected schema_version %d, got %d", schema.CurrentSchemaVersion, resp.SchemaVersion)
+	}
+	if len(resp.Capabilities) == 0 {
+		t.Fatal("expected capabilities")
+	}
+}
+
 func TestContextEndpoint(t *testing.T) {
 	idx := testIndex()
 	mux := http.NewServeMux()
-	SetupRoutes(mux, idx, NewCursorState(idx))
+	st := NewStore(idx)
+	SetupRoutes(mux, st, NewEventBus())
 
 	// Test with a file inside Customer component
 	req := httptest.NewRequest("GET", "/context?file=Customer/src/main/java/com/jmendoza/swa/hexagonal/customer/application/rest/controller/CustomerController.java", nil)
@@ -124,7 +164,8 @@ func TestContextEndpoint(t *testing.T) {
 func TestContextEndpointMissingFile(t *testing.T) {
 	idx := testIndex()
 	mux := http.NewServeMux()
-	SetupRoutes(mux, idx, NewCursorState(idx))
+	st := NewStore(idx)
+	SetupRoutes(mux, st, NewEventBus())
 
 	req := httptest.NewRequest("GET", "/context", nil)
 	w := httptest.NewRecorder()
@@ -138,7 +179,8 @@ func TestContextEndpointMissingFile(t *testing.T) {
 func TestComponentsEndpoint(t *testing.T) {
 	idx := testIndex()
 	mux := http.NewServeMux()
-	SetupRoutes(mux, idx, NewCursorState(idx))
+	st := NewStore(idx)
+	SetupRoutes(mux, st, NewEventBus())
 
 	req := httptest.NewRequest("GET", "/components", nil)
 	w := httptest.NewRecorder()
@@ -158,7 +200,8 @@ func TestComponentsEndpoint(t *testing.T) {
 func TestArchetypesEndpoint(t *testing.T) {
 	idx := testIndex()
 	mux := http.NewServeMux()
-	SetupRoutes(mux, idx, NewCursorState(idx))
+	st := NewStore(idx)
+	SetupRoutes(mux, st, NewEventBus())
 
 	req := httptest.NewRequest("GET", "/archetypes/controllers", nil)
 	w := httptest.NewRecorder()
@@ -181,7 +224,8 @@ func TestArchetypesEndpoint(t *testing.T) {
 func TestRelationshipsEndpoint(t *testing.T) {
 	idx := testIndex()
 	mux := http.NewServeMux()
-	SetupRoutes(mux, idx, NewCursorState(idx))
+	st := NewStore(idx)
+	SetupRoutes(mux, st, NewEventBus())
 
 	req := httptest.NewRequest("GET", "/relationships?symbol=customer-controller&direction=downstream", nil)
 	w := httptest.NewRecorder()
@@ -205,7 +249,8 @@ func TestRelationshipsEndpoint(t *testing.T) {
 func TestFlowsEndpoint(t *testing.T) {
 	idx := testIndex()
 	mux := http.NewServeMux()
-	SetupRoutes(mux, idx, NewCursorState(idx))
+	st := NewStore(idx)
+	SetupRoutes(mux, st, NewEventBus())
 
 	req := httptest.NewRequest("GET", "/flows?through=customer-controller", nil)
 	w := httptest.NewRecorder()
@@ -229,7 +274,8 @@ func TestFlowsEndpoint(t *testing.T) {
 func TestGraphEndpoint(t *testing.T) {
 	idx := testIndex()
 	mux := http.NewServeMux()
-	SetupRoutes(mux, idx, NewCursorState(idx))
+	st := NewStore(idx)
+	SetupRoutes(mux, st, NewEventBus())
 
 	req := httptest.NewRequest("GET", "/graph", nil)
 	w := httptest.NewRecorder()
@@ -282,10 +328,65 @@ func TestGraphEndpoint(t *testing.T) {
 	}
 }
 
+func TestGraphEndpointMetadata(t *testing.T) {
+	idx := testIndex()
+	idx.Raw.Components[0].Description = "Customer lifecycle"
+	idx.Raw.Components[0].Links = []schema.Link{{Type: "runbook", URL: "https://runbooks/customer"}}
+	idx.Raw.Relationships = append(idx.Raw.Relationships,
+		schema.Relationship{From: "customer-controller", To: "order-controller", Type: "calls", Protocol: "http", Mode: schema.ModeAsync},
+		schema.Relationship{From: "create-customer-service", To: "order-controller", Type: "produces", Protocol: "kafka", Mode: schema.ModeAsync},
+	)
+	idx = NewIndex(idx.Raw)
+
+	payload := idx.BuildGraphPayload()
+	comp := payload.Components[0]
+	if comp.Description != "Customer lifecycle" || len(comp.Links) != 1 {
+		t.Fatalf("expected description and links on customer-service, got %+v", comp)
+	}
+	if len(payload.ComponentEdges) != 1 {
+		t.Fatalf("expected 1 component edge, got %d", len(payload.ComponentEdges))
+	}
+	edge := payload.ComponentEdges[0]
+	if strings.Join(edge.Protocols, ",") != "http,kafka" {
+		t.Errorf("expected protocols http,kafka, got %v", edge.Protocols)
+	}
+	if strings.Join(edge.Modes, ",") != "async" {
+		t.Errorf("expected modes async, got %v", edge.Modes)
+	}
+}
+
+func TestFlowGraphIndexedByStep(t *testing.T) {
+	raw := testIndex().Raw
+	raw.Flows = append(raw.Flows, schema.Flow{
+		ID: "create-customer-errors", Name: "Create Customer (errors)",
+		Graph: &schema.FlowGraph{
+			Steps: []schema.FlowStep{
+				{ID: "in", Ref: "customer-controller"},
+				{ID: "create", Ref: "create-customer-service"},
+				{ID: "fail", Ref: "customer-controller", Label: "return 409"},
+			},
+			Transitions: []schema.FlowTransition{
+				{From: "in", To: "create"},
+				{From: "create", To: "fail", Condition: "duplicate email"},
+			},
+		},
+	})
+	idx := NewIndex(raw)
+
+	flows := idx.FindFlows("customer-controller")
+	if len(flows) != 2 {
+		t.Fatalf("expected 2 flows through customer-controller, got %d", len(flows))
+	}
+	if flows[1].Graph == nil {
+		t.Fatal("expected graph form to be preserved")
+	}
+}
+
 func TestUIEndpoint(t *testing.T) {
 	idx := testIndex()
 	mux := http.NewServeMux()
-	SetupRoutes(mux, idx, NewCursorState(idx))
+	st := NewStore(idx)
+	SetupRoutes(mux, st, NewEventBus())
 
 	req := httptest.NewRequest("GET", "/", nil)
 	w := httptest.NewRecorder()
@@ -308,9 +409,10 @@ func TestUIEndpoint(t *testing.T) {
 
 func TestCursorPutEndpoint(t *testing.T) {
 	idx := testIndex()
-	cs := NewCursorState(idx)
+	st := NewStore(idx)
+	bus := NewEventBus()
 	mux := http.NewServeMux()
-	SetupRoutes(mux, idx, cs)
+	SetupRoutes(mux, st, bus)
 
 	// PUT with a known file
 	req := httptest.NewRequest("PUT", "/cursor?file=Customer/src/main/java/com/jmendoza/swa/hexagonal/customer/application/rest/controller/CustomerController.java", nil)
@@ -320,6 +422,9 @@ func TestCursorPutEndpoint(t *testing.T) {
 	if w.Code != 204 {
 		t.Fatalf("expected 204, got %d", w.Code)
 	}
+	if ev, ok := bus.Last(TopicCursor.Name); !ok || !strings.Contains(string(ev.Data), `"component_id":"customer-service"`) {
+		t.Fatalf("expected the cursor to be published, got %+v", ev)
+	}
 
 	// PUT without file param → 400
 	req = httptest.NewRequest("PUT", "/cursor", nil)
@@ -331,27 +436,114 @@ func TestCursorPutEndpoint(t *testing.T) {
 	}
 }
 
-func TestCursorSSEBroadcast(t *testing.T) {
+func TestEventBusTopicsAndReplay(t *testing.T) {
 	idx := testIndex()
-	cs := NewCursorState(idx)
+	bus := NewEventBus()
 
-	// Subscribe before setting
-	ch := cs.Subscribe()
-	defer cs.Unsubscribe(ch)
+	sub := bus.Subscribe([]string{TopicCursor.Name}, 0, false)
+	defer bus.Unsubscribe(sub)
 
-	cs.Set("Customer/src/main/java/com/jmendoza/swa/hexagonal/customer/application/rest/controller/CustomerController.java")
+	Publish(bus, TopicCursor, resolveCursor(idx, "Customer/src/main/java/com/jmendoza/swa/hexagonal/customer/application/rest/controller/CustomerController.java"))
+	Publish(bus, TopicIndexReloaded, IndexReloadedEvent{Components: 2})
 
-	msg := <-ch
-	var ev CursorEvent
-	if err := json.Unmarshal([]byte(msg), &ev); err != nil {
+	ev := <-sub.C
+	var cursor CursorEvent
+	if err := json.Unmarshal(ev.Data, &cursor); err != nil {
 		t.Fatalf("failed to unmarshal cursor event: %v", err)
 	}
+	if cursor.ComponentID != "customer-service" {
+		t.Fatalf("expected component_id customer-service, got %s", cursor.ComponentID)
+	}
+	if cursor.ArchetypeID != "customer-controller" {
+		t.Fatalf("expected archetype_id customer-controller, got %s", cursor.ArchetypeID)
+	}
+	select {
+	case ev := <-sub.C:
+		t.Fatalf("expected no event for an unsubscribed topic, got %s", ev.Topic)
+	default:
+	}
+
+	// A new subscriber starts from the last cursor; reloads are not sticky.
+	late := bus.Subscribe(nil, 0, false)
+	defer bus.Unsubscribe(late)
+	if ev := <-late.C; ev.Topic != TopicCursor.Name || ev.ID != 1 {
+		t.Fatalf("expected the last cursor replayed, got %+v", ev)
+	}
+	if len(late.C) != 0 {
+		t.Fatalf("expected only sticky topics replayed, %d more queued", len(late.C))
+	}
+
+	// Resuming replays everything after Last-Event-ID.
+	resumed := bus.Subscribe(nil, 1, true)
+	defer bus.Unsubscribe(resumed)
+	if ev := <-resumed.C; ev.Topic != TopicIndexReloaded.Name || ev.ID != 2 {
+		t.Fatalf("expected to resume at event 2, got %+v", ev)
+	}
+}
+
+func TestEventBusDropsSlowSubscriber(t *testing.T) {
+	bus := NewEventBus()
+	sub := bus.Subscribe([]string{TopicSelection.Name}, 0, false)
+	for i := 0; i < cap(sub.C)+1; i++ {
+		Publish(bus, TopicSelection, SelectionEvent{ID: "x"})
+	}
+	for range sub.C {
+	}
+	// The channel was closed; unsubscribing again must not panic.
+	bus.Unsubscribe(sub)
+
+	// History no longer reaches event 1, so resuming falls back to the
+	// sticky replay.
+	resumed := bus.Subscribe(nil, 1, true)
+	defer bus.Unsubscribe(resumed)
+	if len(resumed.C) != 1 {
+		t.Fatalf("expected the sticky selection only, got %d events", len(resumed.C))
+	}
+}
+
+func TestEventsEndpoint(t *testing.T) {
+	idx := testIndex()
+	st := NewStore(idx)
+	bus := NewEventBus()
+	mux := http.NewServeMux()
+	SetupRoutes(mux, st, bus)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("PUT", "/selection?id=customer-controller", nil))
+	if w.Code != 204 {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("PUT", "/selection?id=nope", nil))
+	if w.Code != 404 {
+		t.Fatalf("expected 404 for an unknown element, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/events?topics=bogus", nil))
+	if w.Code != 400 {
+		t.Fatalf("expected 400 for an unknown topic, got %d", w.Code)
+	}
 
-	if ev.ComponentID != "customer-service" {
-		t.Fatalf("expected component_id customer-service, got %s", ev.ComponentID)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest("GET", "/events?topics=selection", nil).WithContext(ctx)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	want := "id: 1\nevent: selection\ndata: {\"id\":\"customer-controller\",\"kind\":\"archetype\""
+	if !strings.Contains(w.Body.String(), want) {
+		t.Fatalf("expected replayed selection %q, got %q", want, w.Body.String())
 	}
-	if ev.ArchetypeID != "customer-controller" {
-		t.Fatalf("expected archetype_id customer-controller, got %s", ev.ArchetypeID)
+}
+
+func TestAnnotationChanges(t *testing.T) {
+	prev := testIndex()
+	raw := *prev.Raw
+	raw.Components = slices.Clone(raw.Components)
+	raw.Components[1].Annotations = map[string]any{"tier": 1}
+	changes := annotationChanges(prev, NewIndex(&raw))
+	if len(changes) != 1 || changes[0].ID != "order-service" || changes[0].Kind != "component" {
+		t.Fatalf("expected one component change, got %+v", changes)
 	}
 }
 
@@ -382,3 +574,1404 @@ func TestGlobMatching(t *testing.T) {
 		t.Fatal("expected nil for unknown file")
 	}
 }
+
+func TestLoadIndexMergesOverlay(t *testing.T) {
+	dir := t.TempDir()
+	if err := schema.SaveIndex(dir+"/index.json", testIndex().Raw); err != nil {
+		t.Fatalf("save index: %v", err)
+	}
+	overlay := `{"elements": {"customer-service": {"override": {"layer": "core"}, "annotations": {"owner": "team-crm"}}}}`
+	if err := os.WriteFile(dir+"/overlay.json", []byte(overlay), 0o644); err != nil {
+		t.Fatalf("write overlay: %v", err)
+	}
+
+	idx, err := LoadIndex(dir + "/index.json")
+	if err != nil {
+		t.Fatalf("LoadIndex: %v", err)
+	}
+	mux := http.NewServeMux()
+	st := NewStore(idx)
+	SetupRoutes(mux, st, NewEventBus())
+
+	req := httptest.NewRequest("GET", "/context?file=Customer/src/Foo.java", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	var resp ContextResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Layer != "core" {
+		t.Fatalf("expected overlay layer core, got %s", resp.Layer)
+	}
+	if resp.Component == nil || resp.Component.Annotations["owner"] != "team-crm" {
+		t.Fatalf("expected owner annotation, got %+v", resp.Component)
+	}
+}
+
+func TestOwnershipFromCodeowners(t *testing.T) {
+	idx := testIndex()
+	rs, err := owners.Parse(strings.NewReader("Customer/ @team-crm\nCustomer/src/main/**/rest/ @team-api\nOrder/ @team-orders\n"))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	idx.AttachOwners(rs, []string{
+		"Customer/src/main/java/com/jmendoza/swa/hexagonal/customer/application/rest/controller/CustomerController.java",
+		"Customer/src/main/java/com/jmendoza/swa/hexagonal/customer/domain/services/CreateCustomerService.java",
+	})
+
+	mux := http.NewServeMux()
+	st := NewStore(idx)
+	SetupRoutes(mux, st, NewEventBus())
+
+	req := httptest.NewRequest("GET", "/context?file=Customer/src/main/java/com/jmendoza/swa/hexagonal/customer/domain/services/CreateCustomerService.java", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	var resp ContextResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.FileOwners) != 1 || resp.FileOwners[0] != "@team-crm" {
+		t.Errorf("expected file owner @team-crm, got %v", resp.FileOwners)
+	}
+	if resp.Component == nil || resp.Component.Ownership == nil || !resp.Component.Ownership.MultiTeam {
+		t.Fatalf("expected multi-team customer-service, got %+v", resp.Component)
+	}
+
+	// order-service has no files on disk, so its code_refs prefix is used.
+	if o := idx.Ownership("order-service"); o == nil || len(o.Owners) != 1 || o.Owners[0] != "@team-orders" {
+		t.Errorf("expected order-service owned by @team-orders, got %+v", o)
+	}
+}
+
+func TestOwnershipLoadsLazilyWithConfiguredIgnores(t *testing.T) {
+	repo := t.TempDir()
+	canopy := filepath.Join(repo, ".canopy")
+	base := "Customer/src/main/java/com/jmendoza/swa/hexagonal/customer/"
+	for path, data := range map[string]string{
+		"CODEOWNERS":                       "Customer/ @team-crm\nCustomer/src/main/**/rest/ @team-api\n",
+		".canopy/config.json":              `{"ignore_patterns": ["rest"]}`,
+		base + "application/rest/Api.java": "class Api {}",
+		base + "domain/Service.java":       "class Service {}",
+	} {
+		path = filepath.Join(repo, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := schema.SaveIndex(filepath.Join(canopy, "index.json"), testIndex().Raw); err != nil {
+		t.Fatalf("save index: %v", err)
+	}
+
+	idx, err := LoadIndex(filepath.Join(canopy, "index.json"))
+	if err != nil {
+		t.Fatalf("LoadIndex: %v", err)
+	}
+	if idx.codeOwners != nil {
+		t.Fatal("expected CODEOWNERS to be left unread until ownership is needed")
+	}
+	// The rest/ directory is ignored by config.json, so only @team-crm owns
+	// files of customer-service.
+	o := idx.Ownership("customer-service")
+	if o == nil || o.MultiTeam || len(o.Owners) != 1 || o.Owners[0] != "@team-crm" {
+		t.Errorf("expected customer-service owned by @team-crm alone, got %+v", o)
+	}
+}
+
+// TestVendorScriptsEmbedded keeps the pinned libraries in the tree, so the
+// UI and HTML exports work offline instead of falling back to unpkg.com.
+func TestVendorScriptsEmbedded(t *testing.T) {
+	if missing := MissingVendorScripts(); len(missing) > 0 {
+		t.Errorf("web/vendor is missing %v; run make vendor-js and commit the files", missing)
+	}
+}
+
+func TestVendorEndpoint(t *testing.T) {
+	defer func(orig fs.FS) { vendorFiles = orig }(vendorFiles)
+	vendorFiles = fstest.MapFS{
+		"web/vendor/dagre.min.js": {Data: []byte("var dagre = {};")},
+	}
+
+	idx := testIndex()
+	mux := http.NewServeMux()
+	st := NewStore(idx)
+	SetupRoutes(mux, st, NewEventBus())
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := get("/vendor/dagre.min.js")
+	if w.Code != 200 || w.Body.String() != "var dagre = {};" {
+		t.Fatalf("expected embedded script, got %d %q", w.Code, w.Body.String())
+	}
+
+	w = get("/vendor/cytoscape.min.js")
+	if w.Code != http.StatusFound || !strings.HasPrefix(w.Header().Get("Location"), "https://unpkg.com/cytoscape@") {
+		t.Fatalf("expected redirect to upstream for a missing script, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+
+	if w = get("/vendor/README.md"); w.Code != 404 {
+		t.Fatalf("expected 404 for a non-script file, got %d", w.Code)
+	}
+}
+
+func TestStandaloneHTML(t *testing.T) {
+	defer func(orig fs.FS) { vendorFiles = orig }(vendorFiles)
+	vendorFiles = fstest.MapFS{
+		"web/vendor/cytoscape.min.js":   {Data: []byte(`var s = "</script>";`)},
+		"web/vendor/dagre.min.js":       {Data: []byte("var dagre = {};")},
+		"web/vendor/cytoscape-dagre.js": {Data: []byte("var cyDagre = {};")},
+	}
+
+	payload := testIndex().BuildGraphPayload()
+	payload.RepoID = "</script><b>"
+	html, missing, err := StandaloneHTML(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 0 {
+		t.Fatalf("expected all scripts inlined, missing %v", missing)
+	}
+	body := string(html)
+	for _, want := range []string{
+		`var s = "<\/script>";`,
+		"var dagre = {};",
+		`window.CANOPY_GRAPH = {"repo_id":"\u003c/script\u003e\u003cb\u003e"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected standalone page to contain %q", want)
+		}
+	}
+	for _, unwanted := range []string{`src="vendor/`, "unpkg.com", "favicon.png"} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("standalone page still references %q", unwanted)
+		}
+	}
+	if strings.Index(body, "window.CANOPY_GRAPH") > strings.Index(body, "const STATIC_GRAPH") {
+		t.Error("expected the payload before the UI script")
+	}
+}
+
+func TestStoreWatchReloads(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), ".canopy")
+	os.MkdirAll(dir, 0755)
+	indexPath := filepath.Join(dir, "index.json")
+	raw := testIndex().Raw
+	raw.SchemaVersion = schema.CurrentSchemaVersion
+	writeIndex := func() {
+		t.Helper()
+		data, _ := json.Marshal(raw)
+		if err := os.WriteFile(indexPath, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeIndex()
+
+	st, err := OpenStore(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := st.Index()
+	bus := NewEventBus()
+	sub := bus.Subscribe([]string{TopicIndexReloaded.Name}, 0, false)
+	defer bus.Unsubscribe(sub)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go st.Watch(ctx, 10*time.Millisecond, func(prev, idx *ArchiveIndex) {
+		PublishReload(bus, prev, idx)
+	})
+
+	// A broken write keeps the previous index.
+	os.WriteFile(indexPath, []byte("{"), 0644)
+	time.Sleep(50 * time.Millisecond)
+	if st.Index() != before {
+		t.Fatal("expected a failed reload to keep the previous index")
+	}
+
+	raw.Components = append(raw.Components, schema.Component{ID: "billing", Name: "Billing", Layer: "core", CodeRefs: []string{"Billing/**"}})
+	writeIndex()
+
+	select {
+	case ev := <-sub.C:
+		if ev.Topic != TopicIndexReloaded.Name {
+			t.Fatalf("expected %s event, got %+v", TopicIndexReloaded.Name, ev)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for reload")
+	}
+	if st.Index().FindComponent("Billing/x.go") == nil {
+		t.Fatal("expected the reloaded index to be served")
+	}
+	if before.FindComponent("Billing/x.go") != nil {
+		t.Fatal("expected the previous index to be left untouched")
+	}
+}
+
+func searchIndex() *ArchiveIndex {
+	raw := testIndex().Raw
+	raw.Components[0].Provides = &schema.Provides{Symbols: []string{"login"}}
+	raw.Archetypes["controllers"][0].Routes = []string{"POST /v1/customers/login"}
+	raw.Archetypes["services"] = []schema.Archetype{
+		{ID: "customer-login-service", File: "Customer/CustomerLoginService.java", Symbol: "CustomerLoginService", Purpose: "Checks credentials"},
+		{ID: "create-customer-service", File: "Customer/CreateCustomerService.java", Symbol: "CreateCustomerService", Purpose: "Handles creation logic", Entity: "Customer"},
+	}
+	raw.Archetypes["consumers"] = []schema.Archetype{
+		{ID: "order-events", File: "Order/OrderEvents.java", Topics: []string{"orders.created"}},
+	}
+	return NewIndex(raw)
+}
+
+func TestSearchRanking(t *testing.T) {
+	idx := searchIndex()
+
+	// Exact hits on "login" outrank typo-level hits ("logic", "handles").
+	results := idx.Search("where is login handled", nil, 0)
+	if len(results) != 4 || results[3].ID != "create-customer-service" {
+		t.Fatalf("expected three login matches before the typo match, got %+v", results)
+	}
+	kinds := map[string]bool{}
+	for _, r := range results[:3] {
+		kinds[r.Kind] = true
+	}
+	if !kinds[KindSymbol] || !kinds[KindRoute] || !kinds[KindArchetype] {
+		t.Errorf("expected the login symbol, route and service on top, got %+v", results[:3])
+	}
+
+	// Typos and abbreviations still find the element.
+	if r := idx.Search("custmer", []string{KindComponent}, 1); len(r) != 1 || r[0].ID != "customer-service" {
+		t.Errorf("expected a typo match, got %+v", r)
+	}
+	if r := idx.Search("orders.created", nil, 1); len(r) != 1 || r[0].Kind != KindTopic || r[0].Component != "order-service" {
+		t.Errorf("expected the topic, got %+v", r)
+	}
+	if r := idx.Search("customer", []string{KindEntity}, 0); len(r) != 1 || r[0].ID != "create-customer-service" {
+		t.Errorf("expected the entity, got %+v", r)
+	}
+	if r := idx.Search("the", nil, 0); r != nil {
+		t.Errorf("expected no results for a stop word, got %+v", r)
+	}
+}
+
+func TestSearchWords(t *testing.T) {
+	got := strings.Join(searchWords("HTTPServer createOrder_v2 /v1/orders/{id}"), " ")
+	if want := "http server create order v2 v1 orders id"; got != want {
+		t.Errorf("searchWords = %q, want %q", got, want)
+	}
+}
+
+func TestSearchEndpoint(t *testing.T) {
+	st := NewStore(searchIndex())
+	mux := http.NewServeMux()
+	SetupRoutes(mux, st, NewEventBus())
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/search?q=login&kind=route&limit=5", nil))
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var resp struct {
+		Results []SearchResult `json:"results"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if len(resp.Results) != 1 || resp.Results[0].Label != "POST /v1/customers/login" {
+		t.Fatalf("expected the login route, got %+v", resp.Results)
+	}
+
+	for _, path := range []string{"/search", "/search?q=x&kind=bogus", "/search?q=x&limit=-1"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != 400 {
+			t.Errorf("%s: expected 400, got %d", path, w.Code)
+		}
+	}
+}
+
+func resolveIndex() *ArchiveIndex {
+	raw := testIndex().Raw
+	raw.Archetypes["controllers"][0].Routes = []string{"POST /v1/customers", "GET /v1/customers/:id", "/v1/things:batchGet"}
+	raw.Archetypes["controllers"][1].Routes = []string{"GET /v1/orders/{id}", "GET /v1/orders/summary", "/v1/orders/{rest...}", "GET /static/**"}
+	raw.Archetypes["publishers"] = []schema.Archetype{
+		{ID: "order-publisher", File: "Order/OrderPublisher.java", Topics: []string{"orders.created"}},
+	}
+	raw.Archetypes["listeners"] = []schema.Archetype{
+		{ID: "order-listener", File: "Customer/OrderListener.java", Topics: []string{"orders.*"}},
+	}
+	return NewIndex(raw)
+}
+
+func TestResolveRoute(t *testing.T) {
+	idx := resolveIndex()
+
+	tests := []struct {
+		method, path string
+		want         []string // routes, most specific first
+	}{
+		{"GET", "/v1/orders/42", []string{"GET /v1/orders/{id}", "/v1/orders/{rest...}"}},
+		{"GET", "/v1/orders/summary", []string{"GET /v1/orders/summary", "GET /v1/orders/{id}", "/v1/orders/{rest...}"}},
+		{"DELETE", "/v1/orders/42", []string{"/v1/orders/{rest...}"}},
+		{"get", "/v1/customers/7/", []string{"GET /v1/customers/:id"}},
+		{"POST", "/v1/customers/7", nil},
+		{"", "/v1/things:batchGet", []string{"/v1/things:batchGet"}},
+		{"GET", "/static/css/site.css?v=2", []string{"GET /static/**"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, m := range idx.ResolveRoute(tt.method, tt.path) {
+			got = append(got, m.Route)
+		}
+		if strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+			t.Errorf("%s %s: got %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+
+	m := idx.ResolveRoute("GET", "/v1/customers/7")[0]
+	if m.Params["id"] != "7" || m.Handler.ID != "customer-controller" {
+		t.Errorf("unexpected match %+v", m)
+	}
+	if m.Component == nil || m.Component.ID != "customer-service" || len(m.Flows) != 1 || m.Flows[0].ID != "create-customer" {
+		t.Errorf("expected the owning component and flow, got %+v", m)
+	}
+	if rest := idx.ResolveRoute("PUT", "/v1/orders/42/lines/1")[0]; rest.Params["rest"] != "42/lines/1" {
+		t.Errorf("expected the rest of the path captured, got %+v", rest.Params)
+	}
+}
+
+func TestTopicHandlers(t *testing.T) {
+	idx := resolveIndex()
+
+	handlers := idx.TopicHandlers("orders.created")
+	if len(handlers) != 2 {
+		t.Fatalf("expected a producer and a consumer, got %+v", handlers)
+	}
+	if h := handlers[0]; h.Role != TopicProducer || h.Handler.ID != "order-publisher" || h.Component.ID != "order-service" {
+		t.Errorf("expected the publisher first, got %+v", h)
+	}
+	if h := handlers[1]; h.Role != TopicConsumer || h.Topic != "orders.*" || h.Component.ID != "customer-service" {
+		t.Errorf("expected the pattern listener, got %+v", h)
+	}
+	if h := idx.TopicHandlers("orders.cancelled"); len(h) != 1 || h[0].Handler.ID != "order-listener" {
+		t.Errorf("expected only the pattern to match, got %+v", h)
+	}
+
+	for _, tt := range []struct {
+		declared, name string
+		want           bool
+	}{
+		{"orders/+/created", "orders/eu/created", true},
+		{"orders/+/created", "orders/eu/x/created", false},
+		{"orders.#", "orders.eu.created", true},
+		{"orders.*", "orders.eu.created", false},
+		{"orders.created", "orders.createdx", false},
+	} {
+		if got := topicMatches(tt.declared, tt.name); got != tt.want {
+			t.Errorf("topicMatches(%q, %q) = %v, want %v", tt.declared, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestResolveEndpoints(t *testing.T) {
+	mux := http.NewServeMux()
+	SetupRoutes(mux, NewStore(resolveIndex()), NewEventBus())
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/routes/resolve?method=GET&path=/v1/orders/42", nil))
+	var resolved struct {
+		Method  string       `json:"method"`
+		Matches []RouteMatch `json:"matches"`
+	}
+	json.NewDecoder(w.Body).Decode(&resolved)
+	if w.Code != 200 || len(resolved.Matches) != 2 || resolved.Matches[0].Handler.ID != "order-controller" {
+		t.Fatalf("expected the order controller, got %d %+v", w.Code, resolved)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/routes/resolve?method=GET", nil))
+	if w.Code != 400 {
+		t.Errorf("expected 400 without a path, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/topics/orders.created", nil))
+	var topic TopicResponse
+	json.NewDecoder(w.Body).Decode(&topic)
+	if w.Code != 200 || len(topic.Producers) != 1 || len(topic.Consumers) != 1 {
+		t.Fatalf("expected a producer and a consumer, got %d %+v", w.Code, topic)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/topics/payments.settled", nil))
+	if w.Code != 404 {
+		t.Errorf("expected 404 for an unknown topic, got %d", w.Code)
+	}
+}
+
+func pathIndex() *ArchiveIndex {
+	raw := testIndex().Raw
+	raw.Archetypes["adapters"] = []schema.Archetype{
+		{ID: "customer-client", File: "Gateway/CustomerClient.java"},
+		{ID: "customer-events", File: "Gateway/CustomerEvents.java"},
+	}
+	raw.Relationships = append(raw.Relationships,
+		schema.Relationship{From: "order-controller", To: "customer-client", Type: "calls"},
+		schema.Relationship{From: "order-controller", To: "customer-events", Type: "calls"},
+		schema.Relationship{From: "customer-client", To: "customer-controller", Type: "http"},
+		schema.Relationship{From: "customer-events", To: "customer-client", Type: "calls"},
+		schema.Relationship{From: "customer-events", To: "create-customer-service", Type: "publishes"},
+		schema.Relationship{From: "create-customer-service", To: "customer-controller", Type: "calls"}, // cycle
+	)
+	return NewIndex(raw)
+}
+
+func TestPaths(t *testing.T) {
+	idx := pathIndex()
+
+	res, err := idx.Paths("order-service", "customer-service", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Paths stop at the first customer-service archetype they reach.
+	want := []string{
+		"order-controller -> customer-client -> customer-controller",
+		"order-controller -> customer-events -> create-customer-service",
+		"order-controller -> customer-events -> customer-client -> customer-controller",
+	}
+	var got []string
+	for _, p := range res.Paths {
+		got = append(got, p.String())
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("paths:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if res.Shortest == nil || res.Shortest.String() != want[0] || res.Truncated {
+		t.Errorf("unexpected shortest %+v (truncated %v)", res.Shortest, res.Truncated)
+	}
+	if c := res.Shortest.Components; c[0] != "order-service" || c[2] != "customer-service" {
+		t.Errorf("expected components along the path, got %v", c)
+	}
+	if r := res.Shortest.Relationships; len(r) != 2 || r[1].Type != "http" {
+		t.Errorf("expected the relationships along the path, got %+v", r)
+	}
+
+	res, _ = idx.Paths("order-service", "customer-service", 2, 0)
+	if len(res.Paths) != 2 || !res.Truncated {
+		t.Errorf("expected two paths and truncation, got %d %v", len(res.Paths), res.Truncated)
+	}
+	res, _ = idx.Paths("order-service", "customer-service", 0, 2)
+	if len(res.Paths) != 2 || res.Truncated {
+		t.Errorf("expected the depth to exclude the 3-hop path, got %d", len(res.Paths))
+	}
+
+	res, _ = idx.Paths("customer-service", "order-service", 0, 0)
+	if res.Shortest != nil || len(res.Paths) != 0 {
+		t.Errorf("expected no path back, got %+v", res)
+	}
+	if _, err := idx.Paths("nope", "order-service", 0, 0); !errors.Is(err, ErrUnknownElement) {
+		t.Errorf("expected ErrUnknownElement, got %v", err)
+	}
+	if _, err := idx.Paths("customer-service", "customer-controller", 0, 0); err == nil {
+		t.Error("expected an error for overlapping ends")
+	}
+}
+
+func TestPathEndpoint(t *testing.T) {
+	mux := http.NewServeMux()
+	SetupRoutes(mux, NewStore(pathIndex()), NewEventBus())
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/path?from=order-controller&to=create-customer-service&limit=1", nil))
+	var res PathResult
+	json.NewDecoder(w.Body).Decode(&res)
+	if w.Code != 200 || res.Shortest == nil || len(res.Paths) != 1 || !res.Truncated {
+		t.Fatalf("unexpected response %d %+v", w.Code, res)
+	}
+
+	for path, code := range map[string]int{
+		"/path?from=order-service":                  400,
+		"/path?from=order-service&to=x&limit=0":     400,
+		"/path?from=order-service&to=missing":       404,
+		"/path?from=order-service&to=order-service": 400,
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != code {
+			t.Errorf("%s: expected %d, got %d", path, code, w.Code)
+		}
+	}
+}
+
+func TestImpact(t *testing.T) {
+	idx := pathIndex()
+	idx.Raw.Archetypes["controllers"][1].Routes = []string{"POST /v1/orders"}
+
+	res := idx.ImpactOfFiles([]string{"./Customer/src/main/java/com/jmendoza/swa/hexagonal/customer/domain/services/CreateCustomerService.java", "README.md"}, 0)
+	if len(res.Changed) != 1 || res.Changed[0].ID != "create-customer-service" {
+		t.Fatalf("expected the service to change, got %+v", res.Changed)
+	}
+	if len(res.Unmatched) != 1 || res.Unmatched[0] != "README.md" {
+		t.Errorf("expected README.md unmatched, got %v", res.Unmatched)
+	}
+	var got []string
+	for _, d := range res.Dependents {
+		got = append(got, fmt.Sprintf("%d:%s<-%s", d.Depth, d.Via, d.ID))
+	}
+	want := "1:create-customer-service<-customer-controller 1:create-customer-service<-customer-events 2:customer-controller<-customer-client 2:customer-events<-order-controller"
+	if strings.Join(got, " ") != want {
+		t.Errorf("dependents = %v, want %s", got, want)
+	}
+	if len(res.EntryPoints) != 1 || res.EntryPoints[0].Handler.ID != "order-controller" || res.EntryPoints[0].Depth != 2 {
+		t.Errorf("expected the order controller as entry point, got %+v", res.EntryPoints)
+	}
+	if strings.Join(res.Components, ",") != "customer-service,order-service" {
+		t.Errorf("unexpected components %v", res.Components)
+	}
+	if len(res.Flows) != 1 || res.Flows[0].ID != "create-customer" || res.Truncated {
+		t.Errorf("unexpected flows %+v (truncated %v)", res.Flows, res.Truncated)
+	}
+
+	res, err := idx.Impact([]string{"create-customer-service"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Dependents) != 2 || !res.Truncated || len(res.EntryPoints) != 0 {
+		t.Errorf("expected the depth limit to stop after one hop, got %+v", res)
+	}
+
+	// A file inside a component but not an archetype changes the component.
+	res = idx.ImpactOfFiles([]string{"Customer/pom.xml"}, 0)
+	if len(res.Changed) != 1 || res.Changed[0].Kind != "component" || len(res.Dependents) != 3 {
+		t.Errorf("expected the component's dependents, got %+v", res)
+	}
+	if _, err := idx.Impact([]string{"nope"}, 0); !errors.Is(err, ErrUnknownElement) {
+		t.Errorf("expected ErrUnknownElement, got %v", err)
+	}
+}
+
+func TestImpactEndpoint(t *testing.T) {
+	mux := http.NewServeMux()
+	SetupRoutes(mux, NewStore(pathIndex()), NewEventBus())
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/impact?id=customer-controller&depth=1", nil))
+	var res ImpactResult
+	json.NewDecoder(w.Body).Decode(&res)
+	if w.Code != 200 || len(res.Dependents) != 2 || !res.Truncated {
+		t.Fatalf("unexpected response %d %+v", w.Code, res)
+	}
+
+	for path, code := range map[string]int{
+		"/impact":                       400,
+		"/impact?id=x&file=y":           400,
+		"/impact?id=nope":               404,
+		"/impact?file=Order/x&depth=-1": 400,
+		"/impact?file=Order/x":          200,
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != code {
+			t.Errorf("%s: expected %d, got %d", path, code, w.Code)
+		}
+	}
+}
+
+func TestArchitectureMetrics(t *testing.T) {
+	idx := NewIndex(&schema.ArchIndex{
+		Components: []schema.Component{
+			{ID: "domain", Layer: "core", CodeRefs: []string{"domain/**"}, Provides: &schema.Provides{Interface: "OrderApi"}},
+			{ID: "web", Layer: "adapters", CodeRefs: []string{"web/**"}},
+			{ID: "db", Layer: "adapters", CodeRefs: []string{"db/**"}},
+		},
+		Archetypes: map[string][]schema.Archetype{
+			"ports":    {{ID: "order-port", File: "domain/OrderPort.go", Symbol: "OrderPort"}},
+			"services": {{ID: "order-service", File: "domain/OrderService.go", Symbol: "OrderService"}},
+			"adapters": {
+				{ID: "handler", File: "web/Handler.go"},
+				{ID: "repo", File: "db/Repo.go"},
+			},
+		},
+		Relationships: []schema.Relationship{
+			{From: "order-service", To: "repo", Type: "calls"}, // core → adapters
+			{From: "handler", To: "order-port", Type: "calls"},
+			{From: "repo", To: "handler", Type: "notifies"},
+			{From: "order-port", To: "order-service", Type: "implements"},
+		},
+	})
+
+	m := idx.ArchitectureMetrics(nil)
+	byID := map[string]ComponentMetrics{}
+	for _, c := range m.Components {
+		byID[c.ID] = c
+	}
+
+	domain := byID["domain"]
+	if domain.FanIn != 1 || domain.FanOut != 1 || *domain.Instability != 0.5 {
+		t.Errorf("unexpected domain coupling %+v", domain)
+	}
+	// One port of two archetypes, plus the undeclared OrderApi.
+	if domain.Abstractness == nil || *domain.Abstractness != 0.667 || *domain.Distance != 0.167 {
+		t.Errorf("unexpected domain abstractness %v", domain.Abstractness)
+	}
+	if byID["web"].Abstractness != nil || byID["web"].Distance != nil {
+		t.Errorf("expected no abstractness without a provided interface, got %+v", byID["web"])
+	}
+
+	if len(m.Cycles) != 1 || strings.Join(m.Cycles[0].Components, ",") != "db,domain,web" || len(m.Cycles[0].Edges) != 3 {
+		t.Fatalf("expected one cycle through all three, got %+v", m.Cycles)
+	}
+	if domain.Cycle != 1 {
+		t.Errorf("expected the component to point at its cycle, got %d", domain.Cycle)
+	}
+
+	if len(m.LayerViolations) != 1 || m.LayerViolations[0].From != "domain" || m.LayerViolations[0].To != "db" || domain.LayerViolations != 1 {
+		t.Errorf("expected the core → adapters violation, got %+v", m.LayerViolations)
+	}
+	if m := idx.ArchitectureMetrics([]string{"adapters", "core"}); len(m.LayerViolations) != 1 || m.LayerViolations[0].From != "web" {
+		t.Errorf("expected a custom layer order to flip the violations, got %+v", m.LayerViolations)
+	}
+}
+
+func TestLayerOrderFromPatterns(t *testing.T) {
+	idx := NewIndex(&schema.ArchIndex{
+		Patterns: []string{"MVC"},
+		Components: []schema.Component{
+			{ID: "orders", Layer: "models", CodeRefs: []string{"models/**"}},
+			{ID: "pages", Layer: "views", CodeRefs: []string{"views/**"}},
+		},
+		Archetypes: map[string][]schema.Archetype{
+			"models": {{ID: "order", File: "models/order.rb"}},
+			"views":  {{ID: "order-page", File: "views/order.erb"}},
+		},
+		Relationships: []schema.Relationship{
+			{From: "order", To: "order-page", Type: "calls"}, // models → views
+		},
+	})
+	m := idx.ArchitectureMetrics(nil)
+	if got := strings.Join(m.LayerOrder, ","); got != "models,views,controllers" {
+		t.Errorf("expected the MVC layers, got %s", got)
+	}
+	if len(m.LayerViolations) != 1 || m.LayerViolations[0].From != "orders" {
+		t.Errorf("expected the models → views violation, got %+v", m.LayerViolations)
+	}
+
+	idx.Raw.Patterns = []string{"Event Sourcing"}
+	if got := idx.LayerOrder(); !slices.Equal(got, DefaultLayerOrder) {
+		t.Errorf("expected DefaultLayerOrder for a pattern without layers, got %v", got)
+	}
+}
+
+func TestStronglyConnected(t *testing.T) {
+	comps := []ComponentMetrics{{ID: "a"}, {ID: "b"}, {ID: "c"}, {ID: "d"}}
+	edges := []ComponentEdge{{From: "a", To: "b"}, {From: "b", To: "a"}, {From: "b", To: "c"}, {From: "c", To: "d"}, {From: "d", To: "c"}}
+	got := fmt.Sprint(stronglyConnected(comps, edges))
+	if got != "[[a b] [c d]]" {
+		t.Errorf("stronglyConnected = %s", got)
+	}
+}
+
+func TestLoadWorkspace(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shop.yaml")
+	os.WriteFile(path, []byte("repos:\n  - id: orders\n    path: orders\n  - id: customers\n    path: /srv/customers\n    index: custom.json\n"), 0o644)
+
+	ws, err := LoadWorkspace(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws.Name != "shop" {
+		t.Errorf("expected the name to default to the file name, got %q", ws.Name)
+	}
+	if got := ws.Repos[0].Index; got != filepath.Join(dir, "orders", ".canopy", "index.json") {
+		t.Errorf("unexpected default index %s", got)
+	}
+	if ws.Repos[1].Path != "/srv/customers" || ws.Repos[1].Index != filepath.Join(dir, "custom.json") {
+		t.Errorf("unexpected repo %+v", ws.Repos[1])
+	}
+
+	for _, bad := range []string{"repos: []\n", "repos:\n  - path: a\n", "repos:\n  - id: a:b\n    path: a\n", "repos:\n  - id: a\n    path: a\n  - id: a\n    path: b\n"} {
+		os.WriteFile(path, []byte(bad), 0o644)
+		if _, err := LoadWorkspace(path); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func testWorkspace() (*Workspace, []*ArchiveIndex) {
+	ws := &Workspace{Name: "shop", Repos: []WorkspaceRepo{
+		{ID: "orders"},
+		{ID: "customers", Services: []string{"Customer-API"}},
+	}}
+	orders := NewIndex(&schema.ArchIndex{
+		Components: []schema.Component{{ID: "ordering", Name: "Ordering", CodeRefs: []string{"src/**"}}},
+		Archetypes: map[string][]schema.Archetype{
+			"adapters": {{ID: "customer-client", File: "src/CustomerClient.java", TargetService: "customer-api"}},
+			"services": {{ID: "order-service", File: "src/OrderService.java"}},
+		},
+		Relationships: []schema.Relationship{
+			{From: "order-service", To: "customer-client", Type: "calls"},
+			{From: "order-service", To: "customers:customer-repo", Type: "reads"},
+			{From: "order-service", To: "billing:invoice", Type: "calls"},
+		},
+		Flows: []schema.Flow{{ID: "place-order", Steps: []string{"order-service", "customer-controller"}}},
+	})
+	customers := NewIndex(&schema.ArchIndex{
+		Components: []schema.Component{{ID: "customer-service", CodeRefs: []string{"src/**"}}},
+		Archetypes: map[string][]schema.Archetype{
+			"controllers": {{ID: "customer-controller", File: "src/CustomerController.java"}},
+			"adapters":    {{ID: "customer-repo", File: "src/CustomerRepo.java"}},
+		},
+		Relationships: []schema.Relationship{{From: "customer-controller", To: "customer-repo", Type: "calls"}},
+	})
+	return ws, []*ArchiveIndex{orders, customers}
+}
+
+func TestBuildSystemIndex(t *testing.T) {
+	idx, links := BuildSystemIndex(testWorkspace())
+
+	if comp := idx.FindComponent("customers/src/CustomerRepo.java"); comp == nil || comp.ID != "customers:customer-service" {
+		t.Fatalf("expected files to resolve under the repo directory, got %+v", comp)
+	}
+	if got := idx.ComponentOf("orders:customer-client"); got != "orders:ordering" {
+		t.Errorf("expected namespaced archetypes, got %q", got)
+	}
+	if flow := idx.FindFlows("customers:customer-controller"); len(flow) != 1 || flow[0].ID != "orders:place-order" {
+		t.Errorf("expected a flow step in another repo to resolve, got %+v", flow)
+	}
+
+	want := []string{
+		"orders target_service customer-api -> customers:customer-service",
+		"orders relationship customers:customer-repo -> customers:customer-repo",
+		"orders relationship billing:invoice -> ",
+	}
+	var got []string
+	for _, l := range links {
+		got = append(got, fmt.Sprintf("%s %s %s -> %s", l.Repo, l.Kind, l.Target, l.To))
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("links:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	var edges []string
+	for _, e := range idx.componentEdges() {
+		edges = append(edges, e.From+" -> "+e.To)
+	}
+	if fmt.Sprint(edges) != "[orders:ordering -> customers:customer-service]" {
+		t.Errorf("expected one cross-repo component edge, got %v", edges)
+	}
+}
+
+func TestWorkspaceRoutes(t *testing.T) {
+	ws, indexes := testWorkspace()
+	w := &workspaceServer{ws: ws, bus: NewEventBus(), tokens: []string{"orders-token", "customers-token"}}
+	for _, idx := range indexes {
+		w.stores = append(w.stores, NewStore(idx))
+		w.buses = append(w.buses, NewEventBus())
+	}
+	w.system = NewStore(w.build())
+	mux := w.routes()
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	var graph GraphPayload
+	if rec := get("/graph"); rec.Code != http.StatusOK {
+		t.Fatalf("system graph: %d", rec.Code)
+	} else {
+		json.NewDecoder(rec.Body).Decode(&graph)
+	}
+	if graph.RepoID != "shop" || len(graph.Components) != 2 || len(graph.ComponentEdges) != 1 {
+		t.Errorf("expected both repos' components in the system graph, got %+v", graph)
+	}
+
+	rec := get("/repos/customers/context?file=src/CustomerRepo.java")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"id":"customer-repo"`) {
+		t.Errorf("expected repo-local context, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := get("/repos/billing/graph"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown repo, got %d", rec.Code)
+	}
+
+	var repos WorkspaceResponse
+	json.NewDecoder(get("/repos").Body).Decode(&repos)
+	if len(repos.Repos) != 2 || repos.Repos[1].URL != "/repos/customers/" || len(repos.Links) != 3 {
+		t.Errorf("unexpected /repos response %+v", repos)
+	}
+
+	// Each repository takes its own token; the root takes any.
+	for _, tc := range []struct {
+		path, token string
+		want        int
+	}{
+		{"/repos/customers/cursor?file=src/CustomerRepo.java", "customers-token", http.StatusNoContent},
+		{"/repos/customers/cursor?file=src/CustomerRepo.java", "orders-token", http.StatusUnauthorized},
+		{"/cursor?file=x", "customers-token", http.StatusNoContent},
+	} {
+		req := httptest.NewRequest("PUT", tc.path, nil)
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("PUT %s with %s: expected %d, got %d", tc.path, tc.token, tc.want, rec.Code)
+		}
+	}
+}
+
+func TestSymbolRanges(t *testing.T) {
+	cases := []struct {
+		file, src string
+		want      string
+	}{
+		{"Orders.java", `package shop;
+
+/* class Commented { */
+public class Orders {
+    String s = "}";
+    static class Line {
+        void add() { }
+    }
+}
+
+interface OrderPort
+{
+    void place(); // {
+}
+`, "[{Orders 4 9} {Line 6 8} {OrderPort 11 14}]"},
+		{"orders.go", "package orders\n\ntype Store interface {\n\tGet() string\n}\n\nfunc (s *store) Get() string {\n\treturn `{`\n}\n", "[{Store 3 5} {Get 7 9}]"},
+		{"orders.py", "import os\n\nclass Orders:\n    def place(self):\n        pass\n\n    x = 1\n\ndef helper():\n    return 1\n", "[{Orders 3 7} {place 4 5} {helper 9 10}]"},
+		{"orders.rb", "module Shop\n  class Orders\n    def place\n    end\n  end\nend\n", "[{Shop 1 6} {Orders 2 5} {place 3 4}]"},
+		{"orders.ts", "export const handler = (req) => {\n  return 1;\n};\nexport class Orders {\n}\n", "[{handler 1 3} {Orders 4 5}]"},
+		{"README.md", "class Nothing {\n}\n", "[]"},
+	}
+	for _, c := range cases {
+		if got := fmt.Sprint(SymbolRanges(c.file, []byte(c.src))); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.file, got, c.want)
+		}
+	}
+}
+
+func symbolIndex(t *testing.T) *ArchiveIndex {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "Order"), 0o755)
+	os.WriteFile(filepath.Join(dir, "Order", "Api.java"), []byte(`package order;
+
+public class OrderController {
+    void place() {}
+}
+
+class OrderClient {
+    class Retry {
+    }
+}
+`), 0o644)
+	raw := testIndex().Raw
+	raw.Archetypes["clients"] = []schema.Archetype{
+		{ID: "order-api", File: "Order/Api.java", Symbol: "order.OrderController"},
+		{ID: "order-client", File: "Order/Api.java", Symbol: "OrderClient"},
+		{ID: "order-retry", File: "Order/Api.java", Symbol: "OrderClient.Retry"},
+	}
+	idx := NewIndex(raw)
+	idx.repoRoot = dir
+	return idx
+}
+
+func TestFindArchetypeAt(t *testing.T) {
+	idx := symbolIndex(t)
+	for _, c := range []struct {
+		line   int
+		symbol string
+		want   string
+	}{
+		{0, "", "order-api"},
+		{4, "", "order-api"},
+		{7, "", "order-client"},
+		{9, "", "order-retry"}, // innermost
+		{1, "", "order-api"},   // outside every symbol
+		{4, "OrderClient", "order-client"},
+		{0, "Retry", "order-retry"},
+	} {
+		if got := idx.FindArchetypeAt("Order/Api.java", c.line, c.symbol); got == nil || got.Archetype.ID != c.want {
+			t.Errorf("line %d symbol %q: got %+v, want %s", c.line, c.symbol, got, c.want)
+		}
+	}
+}
+
+func TestContextLineAndBatch(t *testing.T) {
+	st := NewStore(symbolIndex(t))
+	mux := http.NewServeMux()
+	SetupRoutes(mux, st, NewEventBus())
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/context?file=Order/Api.java&line=7", nil))
+	if !strings.Contains(w.Body.String(), `"id":"order-client"`) {
+		t.Errorf("expected the archetype at line 7, got %s", w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/context?file=Order/Api.java&line=0", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for line 0, got %d", w.Code)
+	}
+
+	body := `{"files": ["Order/application/src/main/java/com/jmendoza/swa/hexagonal/order/application/rest/controller/OrderController.java", {"file": "Order/Api.java", "symbol": "Retry"}, "nowhere.txt"]}`
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/context/batch", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("batch: %d %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Results []ContextBatchResult `json:"results"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if len(resp.Results) != 3 {
+		t.Fatalf("expected a result per file, got %+v", resp.Results)
+	}
+	if r := resp.Results[0]; !strings.HasSuffix(r.File, "/OrderController.java") || r.Context.Archetype == nil || r.Context.Archetype.ID != "order-controller" {
+		t.Errorf("unexpected first result %+v", r)
+	}
+	if r := resp.Results[1]; r.Context.Archetype == nil || r.Context.Archetype.ID != "order-retry" || r.Context.Component == nil {
+		t.Errorf("unexpected second result %+v", r)
+	}
+	if r := resp.Results[2]; r.Context.Component != nil || r.Context.Archetype != nil {
+		t.Errorf("expected no context outside the index, got %+v", r)
+	}
+
+	for _, bad := range []string{`{"files": [{"line": 3}]}`, `not json`} {
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("POST", "/context/batch", strings.NewReader(bad)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", bad, w.Code)
+		}
+	}
+}
+
+// findComponentLinear is the reference FindComponent: every code_ref tried
+// with doublestar.Match, most specific first, earliest among equals.
+func findComponentLinear(idx *ArchiveIndex, filePath string) *schema.Component {
+	var best *schema.Component
+	bestSpecificity := -1
+	for _, entry := range idx.codeRefEntries {
+		if ok, err := doublestar.Match(entry.Pattern, filePath); err != nil || !ok {
+			continue
+		}
+		if s := schema.CodeRefSpecificity(entry.Pattern); s > bestSpecificity {
+			best, bestSpecificity = entry.Component, s
+		}
+	}
+	return best
+}
+
+func TestCodeRefMatcherMatchesDoublestar(t *testing.T) {
+	patterns := []string{
+		"src/**", "src/orders/**", "src/orders/*.go", "src/{orders,billing}/api/**",
+		"src/orders/api/[a-m]*.go", "**/*_test.go", "**/testdata/**", "lib/?.go",
+		"lib/x**/y", `docs/\*`, "a//b", "web/**/", "{cmd,tools}/**/{main,root}.go",
+		"pkg/[!z]*/**", "bad/[x", "deep/{a,{b,c}}/*", "src/orders", "*.md", "*",
+		"x/{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}", "src/order*/**", "**",
+	}
+	raw := &schema.ArchIndex{}
+	for i, p := range patterns {
+		raw.Components = append(raw.Components, schema.Component{ID: fmt.Sprintf("c%d:%s", i, p), CodeRefs: []string{p}})
+	}
+	idx := NewIndex(raw)
+	var fallback []string
+	for _, r := range idx.codeRefs.fallback {
+		fallback = append(fallback, r.entry.Pattern)
+	}
+	// Only patterns whose doublestar quirks the compiled form does not model.
+	if want := []string{"lib/x**/y", "a//b", "web/**/", "pkg/[!z]*/**", "x/{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}"}; !slices.Equal(fallback, want) {
+		t.Errorf("fallback patterns %q, want %q", fallback, want)
+	}
+
+	paths := []string{
+		"src/orders/main.go", "src/orders/api/handler.go", "src/orders/api/zeta.go",
+		"src/billing/api/x.go", "src/orders/api/x_test.go", "src/orders", "src/ordersx/a",
+		"src/a/testdata/f", "lib/a.go", "lib/ab.go", "lib/xyz/y", "lib/x/z/y", "docs/*",
+		"docs/a", "a//b", "web/a/", "web/a", "cmd/main.go", "tools/a/b/root.go",
+		"pkg/a/b", "pkg/z/b", "bad/[x", "deep/c/f", "deep/d/f", "README.md", "x/ababababa",
+		"x/abababab", "", "/abs", "src/", "main.go", "src/order", "src/orderx/a",
+		"src/order/a",
+	}
+	for _, p := range paths {
+		want, got := findComponentLinear(idx, p), idx.FindComponent(p)
+		if want != got {
+			t.Errorf("%q: got %v, want %v", p, componentID(got), componentID(want))
+		}
+	}
+}
+
+func componentID(c *schema.Component) string {
+	if c == nil {
+		return "<nil>"
+	}
+	return c.ID
+}
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache[string, int](2)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Get("a")
+	c.Add("c", 3) // evicts b, the least recently used
+	if _, ok := c.Get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("expected a to stay, got %d %v", v, ok)
+	}
+	c.Add("a", 4)
+	if v, _ := c.Get("a"); v != 4 {
+		t.Errorf("expected Add to update, got %d", v)
+	}
+}
+
+// benchIndex is a large monorepo-like index: n services, each with a few
+// code_refs, and paths spread over them.
+func benchIndex(n int) (*ArchiveIndex, []string) {
+	raw := &schema.ArchIndex{}
+	var paths []string
+	for i := 0; i < n; i++ {
+		raw.Components = append(raw.Components, schema.Component{
+			ID: fmt.Sprintf("svc%d", i),
+			CodeRefs: []string{
+				fmt.Sprintf("services/svc%d/**", i),
+				fmt.Sprintf("services/svc%d/api/*.proto", i),
+				fmt.Sprintf("libs/{svc%d,shared%d}/**", i, i),
+			},
+		})
+		paths = append(paths,
+			fmt.Sprintf("services/svc%d/internal/handler/orders.go", i),
+			fmt.Sprintf("services/svc%d/api/orders.proto", i),
+			fmt.Sprintf("libs/shared%d/util.go", i),
+		)
+	}
+	raw.Components = append(raw.Components, schema.Component{ID: "docs", CodeRefs: []string{"**/*.md"}})
+	paths = append(paths, "services/svc0/README.md", "unowned/file.go")
+	return NewIndex(raw), paths
+}
+
+func BenchmarkFindComponentLinear(b *testing.B) {
+	idx, paths := benchIndex(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		findComponentLinear(idx, paths[i%len(paths)])
+	}
+}
+
+func BenchmarkFindComponentCompiled(b *testing.B) {
+	idx, paths := benchIndex(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		idx.findComponentUncached(paths[i%len(paths)])
+	}
+}
+
+func BenchmarkFindComponentCached(b *testing.B) {
+	idx, paths := benchIndex(1000)
+	for _, p := range paths {
+		idx.FindComponent(p)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		idx.FindComponent(paths[i%len(paths)])
+	}
+}
+
+// editServer serves testIndex from index.json in a temp .canopy directory.
+func editServer(t *testing.T) (*http.ServeMux, *Store, *EventBus, string) {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), ".canopy")
+	os.MkdirAll(dir, 0755)
+	indexPath := filepath.Join(dir, "index.json")
+	if err := schema.SaveIndex(indexPath, testIndex().Raw); err != nil {
+		t.Fatal(err)
+	}
+	st, err := OpenStore(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := NewEventBus()
+	mux := http.NewServeMux()
+	SetupRoutes(mux, st, bus)
+	return mux, st, bus, indexPath
+}
+
+func TestEditEndpoints(t *testing.T) {
+	mux, st, bus, indexPath := editServer(t)
+	sub := bus.Subscribe([]string{TopicIndexEdited.Name, TopicIndexReloaded.Name}, 0, false)
+	defer bus.Unsubscribe(sub)
+
+	do := func(method, url, body string, want int) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(method, url, strings.NewReader(body)))
+		if w.Code != want {
+			t.Fatalf("%s %s: expected %d, got %d: %s", method, url, want, w.Code, w.Body.String())
+		}
+		return w
+	}
+
+	do("PATCH", "/components/order-service", `{"layer":"core","tags":["orders"]}`, 200)
+	if comp := st.Index().componentByID["order-service"]; comp.Layer != "core" || comp.Name != "Order Microservice" {
+		t.Fatalf("expected the layer patched and the name kept, got %+v", comp)
+	}
+	for _, topic := range []string{TopicIndexEdited.Name, TopicIndexReloaded.Name} {
+		if ev := <-sub.C; ev.Topic != topic {
+			t.Fatalf("expected %s event, got %+v", topic, ev)
+		}
+	}
+	do("PATCH", "/components/nope", `{"layer":"core"}`, 404)
+	do("PATCH", "/components/order-service", `{"colour":"red"}`, 400)
+	do("PATCH", "/components/order-service", `{"name":""}`, 422)
+
+	do("POST", "/relationships", `{"from":"order-controller","to":"create-customer-service","type":"calls"}`, 201)
+	do("POST", "/relationships", `{"from":"order-controller","to":"create-customer-service","type":"calls"}`, 409)
+	do("POST", "/relationships", `{"from":"order-controller","to":"nope","type":"calls"}`, 404)
+	do("POST", "/relationships", `{"from":"order-controller","to":"create-customer-service","type":"uses","mode":"sometimes"}`, 422)
+	if len(st.Index().Raw.Relationships) != 2 {
+		t.Fatalf("expected 2 relationships, got %d", len(st.Index().Raw.Relationships))
+	}
+	do("DELETE", "/relationships?from=customer-controller&to=create-customer-service", "", 200)
+	do("DELETE", "/relationships?from=customer-controller&to=create-customer-service", "", 404)
+
+	do("PUT", "/flows/place-order", `{"name":"Place Order","steps":["order-controller","create-customer-service"]}`, 200)
+	do("PUT", "/flows/place-order", `{"id":"other","name":"Place Order","steps":["order-controller"]}`, 400)
+	do("PUT", "/archetypes/create-customer-service/category", `{"category":"domain-services"}`, 200)
+	raw := st.Index().Raw
+	if len(raw.Flows) != 2 || raw.Archetypes["services"] != nil || len(raw.Archetypes["domain-services"]) != 1 {
+		t.Fatalf("expected the flow added and the archetype moved, got %+v %+v", raw.Flows, raw.Archetypes)
+	}
+
+	// The saved file has every edit, without anything from the overlay.
+	saved, err := schema.LoadIndex(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Flows) != 2 || saved.Components[1].Layer != "core" {
+		t.Fatalf("expected the edits saved to index.json, got %+v", saved)
+	}
+	metas, err := os.ReadDir(filepath.Join(filepath.Dir(indexPath), "history"))
+	if err != nil || len(metas) == 0 {
+		t.Fatalf("expected history snapshots, got %v %v", metas, err)
+	}
+
+	var state EditState
+	json.Unmarshal(do("GET", "/edits", "", 200).Body.Bytes(), &state)
+	if len(state.Undo) != 5 || state.Undo[0] != "move archetype create-customer-service to domain-services" {
+		t.Fatalf("unexpected undo list %v", state.Undo)
+	}
+
+	do("POST", "/undo", "", 200)
+	if st.Index().Raw.Archetypes["services"] == nil {
+		t.Fatal("expected undo to move the archetype back")
+	}
+	do("POST", "/redo", "", 200)
+	if st.Index().Raw.Archetypes["services"] != nil {
+		t.Fatal("expected redo to move the archetype again")
+	}
+	do("POST", "/redo", "", 409)
+
+	// A change made outside the API invalidates the undo history.
+	saved.RepoID = "renamed"
+	schema.SaveIndex(indexPath, saved)
+	do("POST", "/undo", "", 409)
+	do("POST", "/undo", "", 409)
+}
+
+func TestEditShadowedByOverlay(t *testing.T) {
+	mux, st, _, indexPath := editServer(t)
+	overlay := `{"elements": {"order-service": {"override": {"layer": "core"}}}}`
+	if err := os.WriteFile(filepath.Join(filepath.Dir(indexPath), "overlay.json"), []byte(overlay), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	patch := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("PATCH", "/components/order-service", strings.NewReader(body)))
+		return w
+	}
+
+	before, _ := os.ReadFile(indexPath)
+	if w := patch(`{"layer":"adapters"}`); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "component order-service: layer") {
+		t.Fatalf("expected 409 naming the overridden field, got %d: %s", w.Code, w.Body.String())
+	}
+	if after, _ := os.ReadFile(indexPath); !bytes.Equal(before, after) {
+		t.Error("expected a rejected edit to leave index.json alone")
+	}
+
+	w := patch(`{"layer":"adapters","name":"Orders"}`)
+	var res EditResult
+	json.NewDecoder(w.Body).Decode(&res)
+	if w.Code != http.StatusOK || !strings.Contains(res.Warning, "component order-service: layer") || strings.Contains(res.Warning, "name") {
+		t.Fatalf("expected the edit to apply with a warning about layer, got %d %+v", w.Code, res)
+	}
+	if comp := st.Index().componentByID["order-service"]; comp.Name != "Orders" || comp.Layer != "core" {
+		t.Errorf("expected the new name and the overlay's layer, got %+v", comp)
+	}
+}
+
+func TestEditSnapshotsOncePerEdit(t *testing.T) {
+	mux, _, _, indexPath := editServer(t)
+	hist := history.NewStore(filepath.Join(filepath.Dir(indexPath), "history"), history.Retention{})
+	if _, err := hist.Snapshot(indexPath, "import", ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, layer := range []string{"core", "edge", "core"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("PATCH", "/components/order-service", strings.NewReader(`{"layer":"`+layer+`"}`)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("PATCH: expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	metas, err := hist.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metas) != 4 {
+		t.Fatalf("expected the import and one snapshot per edit, got %+v", metas)
+	}
+	for i, m := range metas {
+		if wantEdit := i < 3; m.Edit != wantEdit {
+			t.Errorf("snapshot %s (%s): expected Edit %v", m.ID, m.Source, wantEdit)
+		}
+	}
+}
+
+func TestEditReadOnly(t *testing.T) {
+	mux := http.NewServeMux()
+	SetupRoutes(mux, NewStore(testIndex()), NewEventBus())
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("PATCH", "/components/order-service", strings.NewReader(`{"layer":"core"}`)))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected no edit routes for an index without a file, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/version", nil))
+	var v VersionResponse
+	json.NewDecoder(w.Body).Decode(&v)
+	if slices.Contains(v.Capabilities, "edit") {
+		t.Errorf("expected no edit capability, got %v", v.Capabilities)
+	}
+}
+
+func TestWorkspaceRebuildsAfterEdit(t *testing.T) {
+	ws, indexes := testWorkspace()
+	root := t.TempDir()
+	for i := range ws.Repos {
+		ws.Repos[i].Path = filepath.Join(root, ws.Repos[i].ID)
+		ws.Repos[i].Index = filepath.Join(ws.Repos[i].Path, ".canopy", "index.json")
+		os.MkdirAll(filepath.Dir(ws.Repos[i].Index), 0755)
+		if err := schema.SaveIndex(ws.Repos[i].Index, indexes[i].Raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w, err := newWorkspaceServer(ws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := w.routes()
+
+	req := httptest.NewRequest("PATCH", "/repos/orders/components/ordering", strings.NewReader(`{"name":"Order Taking"}`))
+	req.Header.Set("Authorization", "Bearer "+w.tokens[0])
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("edit: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var graph GraphPayload
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/graph", nil))
+	json.NewDecoder(rec.Body).Decode(&graph)
+	names := map[string]string{}
+	for _, c := range graph.Components {
+		names[c.ID] = c.Name
+	}
+	if names["orders:ordering"] != "Order Taking" {
+		t.Errorf("expected the system graph to show the edit, got %v", names)
+	}
+
+	req = httptest.NewRequest("PATCH", "/components/orders:ordering", strings.NewReader(`{"name":"x"}`))
+	req.Header.Set("Authorization", "Bearer "+w.tokens[0])
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected no edit routes on the system index, got %d", rec.Code)
+	}
+}
+
+func TestAccessControl(t *testing.T) {
+	mux := http.NewServeMux()
+	SetupRoutes(mux, NewStore(testIndex()), NewEventBus())
+	handler := corsMiddleware([]string{"http://localhost:3000/"}, requireToken([]string{"secret"}, mux))
+
+	do := func(method, url string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		req.RemoteAddr = "192.0.2.1:4321"
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, tc := range []struct {
+		name, method, url string
+		header            map[string]string
+		want              int
+	}{
+		{"read without token", "GET", "/graph", nil, 200},
+		{"write without token", "PUT", "/cursor?file=x", nil, 401},
+		{"stream without token", "GET", "/events", nil, 401},
+		{"wrong token", "PUT", "/cursor?file=x", map[string]string{"Authorization": "Bearer nope"}, 401},
+		{"bearer token", "PUT", "/cursor?file=x", map[string]string{"Authorization": "Bearer secret"}, 204},
+		{"cookie", "PUT", "/cursor?file=x", map[string]string{"Cookie": TokenCookie + "=secret"}, 204},
+		{"own origin", "PUT", "/cursor?file=x", map[string]string{"Authorization": "Bearer secret", "Origin": "http://example.com"}, 204},
+		{"allowed origin", "PUT", "/cursor?file=x", map[string]string{"Authorization": "Bearer secret", "Origin": "http://localhost:3000"}, 204},
+		{"foreign origin", "PUT", "/cursor?file=x", map[string]string{"Cookie": TokenCookie + "=secret", "Origin": "http://evil.test"}, 403},
+		{"foreign read", "GET", "/graph", map[string]string{"Origin": "http://evil.test"}, 200},
+		{"preflight", "OPTIONS", "/cursor", map[string]string{"Origin": "http://localhost:3000"}, 204},
+	} {
+		if rec := do(tc.method, tc.url, tc.header); rec.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, rec.Code)
+		}
+	}
+
+	if rec := do("GET", "/graph", map[string]string{"Origin": "http://evil.test"}); rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("expected no CORS headers for a foreign origin")
+	}
+	if rec := do("GET", "/graph", map[string]string{"Origin": "http://localhost:3000"}); rec.Header().Get("Access-Control-Allow-Origin") != "http://localhost:3000" {
+		t.Error("expected CORS headers for an allowed origin")
+	}
+
+	// The UI gets the cookie when opened on this machine, or with ?token=
+	// from elsewhere; never from a remote request without it.
+	if rec := do("GET", "/", nil); len(rec.Result().Cookies()) != 0 {
+		t.Error("expected no cookie for a remote request")
+	}
+	if rec := do("GET", "/?token=secret", nil); rec.Code != http.StatusSeeOther || len(rec.Result().Cookies()) != 1 {
+		t.Errorf("expected a redirect setting the cookie, got %d %v", rec.Code, rec.Result().Cookies())
+	}
+	req := httptest.NewRequest("GET", "http://127.0.0.1:8080/", nil)
+	req.RemoteAddr = "127.0.0.1:4321"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if cookies := rec.Result().Cookies(); len(cookies) != 1 || cookies[0].Value != "secret" {
+		t.Errorf("expected the token cookie for a local browser, got %v", cookies)
+	}
+	req = httptest.NewRequest("GET", "http://rebound.test:8080/", nil)
+	req.RemoteAddr = "127.0.0.1:4321"
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if len(rec.Result().Cookies()) != 0 {
+		t.Error("expected no cookie for a non-local host name")
+	}
+}
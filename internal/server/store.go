@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nhomble/canopy/internal/owners"
+)
+
+// Store holds the index the server answers from. Reload builds a new
+// ArchiveIndex off to the side and swaps it in, so each request sees the
+// old or the new index in full, never a mix.
+type Store struct {
+	path string // index.json; empty for an index built in memory
+	cur  atomic.Pointer[ArchiveIndex]
+
+	mu       sync.Mutex // serialises reloads and edits
+	seen     string     // fingerprint of the files last loaded
+	onReload func(prev, idx *ArchiveIndex)
+}
+
+// NewStore wraps an already built index. It cannot reload.
+func NewStore(idx *ArchiveIndex) *Store {
+	s := &Store{}
+	s.cur.Store(idx)
+	return s
+}
+
+// OpenStore loads the index at indexPath.
+func OpenStore(indexPath string) (*Store, error) {
+	idx, err := LoadIndex(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	s := NewStore(idx)
+	s.path = indexPath
+	s.seen = s.fingerprint()
+	return s, nil
+}
+
+// Editable reports whether the index was loaded from a file that edits
+// can be saved to.
+func (s *Store) Editable() bool {
+	return s.path != ""
+}
+
+// OnReload registers fn to run after every reload that swaps in a new
+// index: one Watch noticed on disk, one requested with Reload, or one
+// following an Edit. It runs with reloads held off, so it sees the index it
+// is given as the current one.
+func (s *Store) OnReload(fn func(prev, idx *ArchiveIndex)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onReload = fn
+}
+
+// Index returns the current index. Handlers call it once per request and
+// use the result throughout.
+func (s *Store) Index() *ArchiveIndex {
+	return s.cur.Load()
+}
+
+// Swap replaces the current index with one built elsewhere and returns
+// the replaced one.
+func (s *Store) Swap(idx *ArchiveIndex) *ArchiveIndex {
+	return s.cur.Swap(idx)
+}
+
+// Reload rereads the index from disk and swaps it in. On error the
+// current index stays in place.
+func (s *Store) Reload() (*ArchiveIndex, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reload()
+}
+
+func (s *Store) reload() (*ArchiveIndex, error) {
+	if s.path == "" {
+		return nil, fmt.Errorf("index was not loaded from a file")
+	}
+	fingerprint := s.fingerprint()
+	idx, err := LoadIndex(s.path)
+	if err != nil {
+		return nil, err
+	}
+	s.seen = fingerprint
+	prev := s.cur.Swap(idx)
+	if s.onReload != nil {
+		s.onReload(prev, idx)
+	}
+	return idx, nil
+}
+
+// Edit runs write, which changes the files the index is read from, and
+// reloads. Watch does not report the change as an outside one. On error
+// from write nothing is reloaded.
+func (s *Store) Edit(write func(indexPath string) error) (prev, idx *ArchiveIndex, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.path == "" {
+		return nil, nil, ErrReadOnly
+	}
+	if err := write(s.path); err != nil {
+		return nil, nil, err
+	}
+	prev = s.Index()
+	idx, err = s.reload()
+	return prev, idx, err
+}
+
+// Watch polls the files the index is built from (index.json, overlay.json,
+// component sub-indexes and CODEOWNERS) and reloads when any of them
+// changes. onReload runs after each successful swap with the replaced and
+// the new index. Watch returns when ctx is done.
+func (s *Store) Watch(ctx context.Context, interval time.Duration, onReload func(prev, idx *ArchiveIndex)) {
+	if s.path == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.mu.Lock()
+		current := s.fingerprint()
+		if current == s.seen {
+			s.mu.Unlock()
+			continue
+		}
+		// Remember the new state even if loading fails, so a broken file
+		// is reported once rather than on every tick.
+		s.seen = current
+		prev := s.Index()
+		idx, err := s.reload()
+		s.mu.Unlock()
+		if err != nil {
+			log.Printf("reload: %v (keeping the previous index)", err)
+			continue
+		}
+		log.Printf("Reloaded index: %d components, %d relationships, %d flows",
+			len(idx.Raw.Components), len(idx.Raw.Relationships), len(idx.Raw.Flows))
+		if onReload != nil {
+			onReload(prev, idx)
+		}
+	}
+}
+
+// watchedFiles lists the files LoadIndex reads, including ones that do not
+// exist yet.
+func (s *Store) watchedFiles() []string {
+	dir := filepath.Dir(s.path)
+	files := []string{s.path, filepath.Join(dir, "overlay.json")}
+	subIndexes, _ := filepath.Glob(filepath.Join(dir, "components", "*.json"))
+	sort.Strings(subIndexes)
+	files = append(files, subIndexes...)
+	repoRoot := filepath.Dir(dir)
+	for _, loc := range owners.Locations {
+		files = append(files, filepath.Join(repoRoot, loc))
+	}
+	return files
+}
+
+// fingerprint summarizes the size and modification time of the watched
+// files; any write, creation or removal changes it.
+func (s *Store) fingerprint() string {
+	var b strings.Builder
+	for _, path := range s.watchedFiles() {
+		b.WriteString(path)
+		if info, err := os.Stat(path); err == nil {
+			fmt.Fprintf(&b, ":%d:%d", info.Size(), info.ModTime().UnixNano())
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
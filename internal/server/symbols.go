@@ -0,0 +1,277 @@
+package server
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// SymbolRange is the lines a declaration spans, 1-based and inclusive.
+type SymbolRange struct {
+	Name  string
+	Start int
+	End   int
+}
+
+// symbolLanguage finds declarations in one family of languages and the
+// lines their bodies span.
+type symbolLanguage struct {
+	decl *regexp.Regexp // the last group is the declared name
+	// blocks is how a body is delimited: "brace", "indent", or "end" for
+	// indented bodies closed by an end keyword on the declaration's indent.
+	blocks string
+	// hashComments marks # as a line comment in brace languages.
+	hashComments bool
+}
+
+var (
+	braceDecl = regexp.MustCompile(`\b(?:class|interface|enum|record|struct|object|trait|protocol|extension|fn|fun|func|function|namespace)\s+(?:\([^)]*\)\s*)?([A-Za-z_$][\w$]*)`)
+	goDecl    = regexp.MustCompile(`^\s*(?:func\s+(?:\([^)]*\)\s*)?|type\s+)([A-Za-z_]\w*)`)
+	rustDecl  = regexp.MustCompile(`\b(?:fn|struct|enum|trait|mod|impl(?:<[^>]*>)?\s+(?:[\w:<>]+\s+for\s+)?)\s*([A-Za-z_]\w*)`)
+	jsDecl    = regexp.MustCompile(`(?:\b(?:class|interface|enum|function\*?|namespace|type)\s+([A-Za-z_$][\w$]*)|^\s*(?:export\s+)?(?:const|let|var)\s+([A-Za-z_$][\w$]*)\s*=)`)
+	pyDecl    = regexp.MustCompile(`^\s*(?:async\s+)?(?:def|class)\s+([A-Za-z_]\w*)`)
+	rubyDecl  = regexp.MustCompile(`^\s*(?:class|module|def)\s+(?:self\.)?([A-Za-z_][\w:]*[?!]?)`)
+)
+
+// symbolLanguages maps file extensions to how their declarations are found.
+var symbolLanguages = map[string]symbolLanguage{
+	".go":     {decl: goDecl, blocks: "brace"},
+	".java":   {decl: braceDecl, blocks: "brace"},
+	".kt":     {decl: braceDecl, blocks: "brace"},
+	".kts":    {decl: braceDecl, blocks: "brace"},
+	".scala":  {decl: braceDecl, blocks: "brace"},
+	".groovy": {decl: braceDecl, blocks: "brace"},
+	".cs":     {decl: braceDecl, blocks: "brace"},
+	".swift":  {decl: braceDecl, blocks: "brace"},
+	".dart":   {decl: braceDecl, blocks: "brace"},
+	".php":    {decl: braceDecl, blocks: "brace", hashComments: true},
+	".c":      {decl: braceDecl, blocks: "brace"},
+	".h":      {decl: braceDecl, blocks: "brace"},
+	".cc":     {decl: braceDecl, blocks: "brace"},
+	".cpp":    {decl: braceDecl, blocks: "brace"},
+	".hpp":    {decl: braceDecl, blocks: "brace"},
+	".rs":     {decl: rustDecl, blocks: "brace"},
+	".js":     {decl: jsDecl, blocks: "brace"},
+	".jsx":    {decl: jsDecl, blocks: "brace"},
+	".mjs":    {decl: jsDecl, blocks: "brace"},
+	".cjs":    {decl: jsDecl, blocks: "brace"},
+	".ts":     {decl: jsDecl, blocks: "brace"},
+	".tsx":    {decl: jsDecl, blocks: "brace"},
+	".py":     {decl: pyDecl, blocks: "indent"},
+	".rb":     {decl: rubyDecl, blocks: "end"},
+}
+
+// SymbolRanges finds the declarations in a source file and the lines each
+// spans, in order of their first line. The file's extension selects the
+// language; files in other languages have no ranges.
+func SymbolRanges(file string, src []byte) []SymbolRange {
+	lang, ok := symbolLanguages[strings.ToLower(path.Ext(file))]
+	if !ok {
+		return nil
+	}
+	text := string(src)
+	if lang.blocks == "brace" {
+		// Braces and keywords in strings and comments must not count.
+		text = blankStringsAndComments(text, lang.hashComments)
+	}
+	lines := strings.Split(text, "\n")
+
+	var ranges []SymbolRange
+	for i, line := range lines {
+		m := lang.decl.FindStringSubmatchIndex(line)
+		if m == nil {
+			continue
+		}
+		name := ""
+		for g := len(m)/2 - 1; g > 0 && name == ""; g-- {
+			if m[2*g] >= 0 {
+				name = line[m[2*g]:m[2*g+1]]
+			}
+		}
+		var end int
+		switch lang.blocks {
+		case "brace":
+			end = braceEnd(lines, i, m[1])
+		default:
+			end = indentEnd(lines, i, lang.blocks == "end")
+		}
+		ranges = append(ranges, SymbolRange{Name: name, Start: i + 1, End: end + 1})
+	}
+	return ranges
+}
+
+// braceEnd returns the line closing the body that opens at the first brace
+// after col on line start. A declaration reaching ';' or '=' before any
+// brace has no body and ends on its own line.
+func braceEnd(lines []string, start, col int) int {
+	depth := 0
+	for i := start; i < len(lines); i++ {
+		line := lines[i]
+		if i == start {
+			line = line[col:]
+		}
+		for _, c := range line {
+			switch c {
+			case '{':
+				depth++
+			case '}':
+				depth--
+				if depth == 0 {
+					return i
+				}
+			case ';':
+				if depth == 0 {
+					return start
+				}
+			}
+		}
+	}
+	if depth == 0 {
+		return start
+	}
+	return len(lines) - 1
+}
+
+// indentEnd returns the last line of an indented body: the line before the
+// next non-blank line indented no deeper than the declaration, or that line
+// itself when it closes the body with end.
+func indentEnd(lines []string, start int, endKeyword bool) int {
+	indent := indentOf(lines[start])
+	last := start
+	for i := start + 1; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if indentOf(lines[i]) <= indent {
+			if endKeyword && (trimmed == "end" || strings.HasPrefix(trimmed, "end ")) {
+				return i
+			}
+			break
+		}
+		last = i
+	}
+	return last
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
+
+// blankStringsAndComments replaces string literals and comments with
+// spaces, keeping newlines so line numbers stay put.
+func blankStringsAndComments(src string, hashComments bool) string {
+	out := []byte(src)
+	blank := func(from, to int) {
+		for i := from; i < to && i < len(out); i++ {
+			if out[i] != '\n' {
+				out[i] = ' '
+			}
+		}
+	}
+	for i := 0; i < len(src); i++ {
+		switch c := src[i]; {
+		case c == '/' && i+1 < len(src) && src[i+1] == '/', hashComments && c == '#':
+			end := strings.IndexByte(src[i:], '\n')
+			if end < 0 {
+				end = len(src) - i
+			}
+			blank(i, i+end)
+			i += end - 1
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				end = len(src) - i - 4
+			}
+			blank(i, i+end+4)
+			i += end + 3
+		case c == '"' || c == '`':
+			end := closingQuote(src, i, c)
+			blank(i, end+1)
+			i = end
+		case c == '\'':
+			// A char literal is short; anything else, such as a Rust
+			// lifetime, is left alone.
+			if end := closingQuote(src, i, c); end-i <= 3 {
+				blank(i, end+1)
+				i = end
+			}
+		}
+	}
+	return string(out)
+}
+
+// closingQuote returns the index of the quote closing the literal opened at
+// src[open], honouring backslash escapes. Only backquoted literals span
+// lines; others end at the line's end if unterminated.
+func closingQuote(src string, open int, quote byte) int {
+	for i := open + 1; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case quote:
+			return i
+		case '\n':
+			if quote != '`' {
+				return i - 1
+			}
+		}
+	}
+	return len(src) - 1
+}
+
+// FindArchetypeAt returns the archetype for a position in a file. When
+// several archetypes share the file, symbol selects the one declaring it;
+// otherwise line selects the one whose symbol's declaration encloses the
+// line, innermost first. Without a match it falls back to FindArchetype.
+func (idx *ArchiveIndex) FindArchetypeAt(filePath string, line int, symbol string) *archetypeEntry {
+	filePath = filepath.ToSlash(filePath)
+	entries := idx.archetypeByFile[filePath]
+	if len(entries) < 2 {
+		return idx.FindArchetype(filePath)
+	}
+	if symbol != "" {
+		for _, e := range entries {
+			if symbolMatches(e.Archetype.Symbol, symbol) {
+				return e
+			}
+		}
+	}
+	if line > 0 && idx.repoRoot != "" {
+		if src, err := os.ReadFile(filepath.Join(idx.repoRoot, filepath.FromSlash(filePath))); err == nil {
+			var best *archetypeEntry
+			bestSpan := -1
+			for _, r := range SymbolRanges(filePath, src) {
+				if line < r.Start || line > r.End || (bestSpan >= 0 && r.End-r.Start >= bestSpan) {
+					continue
+				}
+				for _, e := range entries {
+					if symbolMatches(e.Archetype.Symbol, r.Name) {
+						best, bestSpan = e, r.End-r.Start
+						break
+					}
+				}
+			}
+			if best != nil {
+				return best
+			}
+		}
+	}
+	return entries[0]
+}
+
+// symbolMatches reports whether an archetype's symbol names a declaration,
+// allowing the symbol to be qualified (orders.OrderController,
+// OrderController#create, Orders::Controller).
+func symbolMatches(archSymbol, name string) bool {
+	if archSymbol == "" || name == "" {
+		return false
+	}
+	if archSymbol == name {
+		return true
+	}
+	last := archSymbol[strings.LastIndexAny(archSymbol, ".#:")+1:]
+	return last == name || last == name[strings.LastIndexAny(name, ".#:")+1:]
+}
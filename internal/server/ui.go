@@ -1,7 +1,11 @@
 package server
 
 import (
-	_ "embed"
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
 	"net/http"
 )
 
@@ -11,6 +15,47 @@ var indexHTML []byte
 //go:embed web/favicon.png
 var faviconPNG []byte
 
+//go:embed web/vendor
+var vendorFS embed.FS
+
+// VendorScript is a third-party library the UI loads from vendor/.
+type VendorScript struct {
+	File string // name under web/vendor
+	URL  string // pinned upstream copy, used when the file is not vendored
+}
+
+// VendorScripts lists the UI's libraries in load order. scripts/vendor-js.sh
+// downloads them into web/vendor so they are embedded in the binary.
+var VendorScripts = []VendorScript{
+	{"cytoscape.min.js", "https://unpkg.com/cytoscape@3.28.1/dist/cytoscape.min.js"},
+	{"dagre.min.js", "https://unpkg.com/dagre@0.8.5/dist/dagre.min.js"},
+	{"cytoscape-dagre.js", "https://unpkg.com/cytoscape-dagre@2.5.0/cytoscape-dagre.js"},
+}
+
+// vendorFiles is the embedded vendor directory; tests substitute their own.
+var vendorFiles fs.FS = vendorFS
+
+func vendorScript(name string) (VendorScript, []byte, bool) {
+	for _, s := range VendorScripts {
+		if s.File == name {
+			data, err := fs.ReadFile(vendorFiles, "web/vendor/"+name)
+			return s, data, err == nil
+		}
+	}
+	return VendorScript{}, nil, false
+}
+
+// MissingVendorScripts returns the libraries not embedded in this build.
+func MissingVendorScripts() []string {
+	var missing []string
+	for _, s := range VendorScripts {
+		if _, _, ok := vendorScript(s.File); !ok {
+			missing = append(missing, s.File)
+		}
+	}
+	return missing
+}
+
 func handleUI() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -25,3 +70,67 @@ func handleFavicon() http.HandlerFunc {
 		w.Write(faviconPNG)
 	}
 }
+
+// handleVendor serves embedded UI libraries. A build without them redirects
+// to the pinned upstream copy, so the UI still works when online.
+func handleVendor() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, data, ok := vendorScript(r.PathValue("file"))
+		if s.File == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if !ok {
+			http.Redirect(w, r, s.URL, http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		w.Write(data)
+	}
+}
+
+// StandaloneHTML renders the UI as a single file that needs no server: the
+// payload is inlined and vendored libraries are embedded in script tags.
+// Libraries missing from this build are loaded from their upstream URL and
+// reported in missing.
+func StandaloneHTML(payload *GraphPayload) (html []byte, missing []string, err error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encoding graph: %w", err)
+	}
+
+	// The UI's own script is the only inline one; the payload goes just
+	// before it. json.Marshal escapes <, > and &, so it cannot end the tag.
+	graph := append([]byte("<script>window.CANOPY_GRAPH = "), data...)
+	graph = append(graph, ";</script>\n<script>\n"...)
+	html = bytes.Replace(indexHTML, []byte("<script>\n"), graph, 1)
+
+	for _, s := range VendorScripts {
+		tag := []byte(`<script src="vendor/` + s.File + `"></script>`)
+		if !bytes.Contains(html, tag) {
+			return nil, nil, fmt.Errorf("UI does not load %s", s.File)
+		}
+		_, js, ok := vendorScript(s.File)
+		var repl []byte
+		if ok {
+			repl = inlineScript(js)
+		} else {
+			missing = append(missing, s.File)
+			repl = []byte(`<script src="` + s.URL + `"></script>`)
+		}
+		html = bytes.Replace(html, tag, repl, 1)
+	}
+
+	// The favicon is large enough to dwarf the rest of the page; leave it out.
+	html = bytes.Replace(html, []byte(`<link rel="icon" type="image/png" href="favicon.png">`+"\n"), nil, 1)
+	return html, missing, nil
+}
+
+// inlineScript wraps JavaScript in a script tag, escaping any "</script"
+// inside it so the browser does not end the element early.
+func inlineScript(js []byte) []byte {
+	js = bytes.ReplaceAll(js, []byte("</script"), []byte(`<\/script`))
+	out := append([]byte("<script>\n"), js...)
+	return append(out, "\n</script>"...)
+}
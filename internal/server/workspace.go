@@ -0,0 +1,431 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nhomble/canopy/internal/schema"
+	"gopkg.in/yaml.v3"
+)
+
+// Workspace lists repositories that canopy serve --workspace serves
+// together, read from a YAML file:
+//
+//	name: shop
+//	repos:
+//	  - id: orders
+//	    path: ../orders
+//	  - id: customers
+//	    path: ../customers
+//	    services: [customer-api]
+//	    component: customer-service
+type Workspace struct {
+	Name  string          `yaml:"name"`
+	Repos []WorkspaceRepo `yaml:"repos"`
+}
+
+// WorkspaceRepo is one repository of a workspace.
+type WorkspaceRepo struct {
+	ID    string `yaml:"id"`    // namespace for the repo's elements and URL path
+	Path  string `yaml:"path"`  // repository root, relative to the workspace file
+	Index string `yaml:"index"` // defaults to <path>/.canopy/index.json
+	// Services are names other repositories use for this one in an
+	// archetype's target_service, besides its ID and component IDs.
+	Services []string `yaml:"services"`
+	// Component receives calls addressed to the repository as a whole;
+	// needed only when the repository has more than one component.
+	Component string `yaml:"component"`
+}
+
+// LoadWorkspace reads a workspace file and resolves repository paths
+// against its directory.
+func LoadWorkspace(path string) (*Workspace, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading workspace: %w", err)
+	}
+	var ws Workspace
+	if err := yaml.Unmarshal(data, &ws); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if len(ws.Repos) == 0 {
+		return nil, fmt.Errorf("%s lists no repos", path)
+	}
+	if ws.Name == "" {
+		ws.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	dir := filepath.Dir(path)
+	seen := make(map[string]bool)
+	for i := range ws.Repos {
+		r := &ws.Repos[i]
+		switch {
+		case r.ID == "":
+			return nil, fmt.Errorf("%s: repo %d has no id", path, i+1)
+		case strings.ContainsAny(r.ID, ":/ "):
+			return nil, fmt.Errorf("%s: repo id %q may not contain ':', '/' or spaces", path, r.ID)
+		case seen[r.ID]:
+			return nil, fmt.Errorf("%s: duplicate repo id %q", path, r.ID)
+		case r.Path == "" && r.Index == "":
+			return nil, fmt.Errorf("%s: repo %s has no path", path, r.ID)
+		}
+		seen[r.ID] = true
+		if r.Path != "" && !filepath.IsAbs(r.Path) {
+			r.Path = filepath.Join(dir, r.Path)
+		}
+		if r.Index == "" {
+			r.Index = filepath.Join(r.Path, ".canopy", "index.json")
+		} else if !filepath.IsAbs(r.Index) {
+			r.Index = filepath.Join(dir, r.Index)
+		}
+	}
+	return &ws, nil
+}
+
+// CrossRepoLink is a reference from one repository to another: an
+// archetype's target_service, or a relationship end not found in its own
+// repository. To is empty when it could not be resolved.
+type CrossRepoLink struct {
+	Repo   string `json:"repo"`
+	From   string `json:"from,omitempty"` // the archetype, for target_service
+	Kind   string `json:"kind"`           // "target_service" or "relationship"
+	Target string `json:"target"`         // as written in the index
+	To     string `json:"to,omitempty"`
+}
+
+// systemRef separates a repository ID from an element ID in the system
+// index, as in orders:order-controller.
+const systemRef = ":"
+
+// BuildSystemIndex merges the indexes of a workspace's repositories, in
+// the order of ws.Repos, into one system index. Element IDs are prefixed
+// with the repository ID (orders:order-controller) and files with the ID as
+// a directory (orders/src/...), so the merged
+// index answers the same queries as a single repository. Archetypes'
+// target_service and relationships to elements of other repositories become
+// cross-repository relationships; links are every such reference, resolved
+// or not.
+func BuildSystemIndex(ws *Workspace, indexes []*ArchiveIndex) (*ArchiveIndex, []CrossRepoLink) {
+	b := &systemBuilder{ws: ws, indexes: indexes}
+	raw := &schema.ArchIndex{
+		RepoID:     ws.Name,
+		Archetypes: make(map[string][]schema.Archetype),
+	}
+	for i, repo := range ws.Repos {
+		src := indexes[i].Raw
+		for _, p := range src.Patterns {
+			if !slices.Contains(raw.Patterns, p) {
+				raw.Patterns = append(raw.Patterns, p)
+			}
+		}
+
+		for _, comp := range src.Components {
+			comp.ID = b.ns(i, comp.ID)
+			comp.CodeRefs = prefixAll(repo.ID+"/", comp.CodeRefs)
+			comp.Tags = append(slices.Clone(comp.Tags), "repo:"+repo.ID)
+			comp.NestedAnalysis = ""
+			raw.Components = append(raw.Components, comp)
+		}
+
+		for _, category := range sortedCategories(src.Archetypes) {
+			for _, arch := range src.Archetypes[category] {
+				if arch.TargetService != "" {
+					b.linkService(i, arch.ID, arch.TargetService, &raw.Relationships)
+				}
+				arch.ID = b.ns(i, arch.ID)
+				arch.File = repo.ID + "/" + arch.File
+				raw.Archetypes[category] = append(raw.Archetypes[category], arch)
+			}
+		}
+
+		for _, rel := range src.Relationships {
+			rel.From = b.ref(i, rel.From)
+			rel.To = b.ref(i, rel.To)
+			raw.Relationships = append(raw.Relationships, rel)
+		}
+
+		for _, flow := range src.Flows {
+			flow.ID = b.ns(i, flow.ID)
+			steps := make([]string, len(flow.Steps))
+			for j, ref := range flow.Steps {
+				steps[j], _ = b.resolve(i, ref)
+			}
+			flow.Steps = steps
+			if flow.Graph != nil {
+				g := *flow.Graph
+				g.Steps = slices.Clone(g.Steps)
+				for j := range g.Steps {
+					g.Steps[j].Ref, _ = b.resolve(i, g.Steps[j].Ref)
+				}
+				flow.Graph = &g
+			}
+			raw.Flows = append(raw.Flows, flow)
+		}
+	}
+	return NewIndex(raw), b.links
+}
+
+type systemBuilder struct {
+	ws      *Workspace
+	indexes []*ArchiveIndex
+	links   []CrossRepoLink
+}
+
+func (b *systemBuilder) ns(repo int, id string) string {
+	return b.ws.Repos[repo].ID + systemRef + id
+}
+
+func (b *systemBuilder) has(repo int, id string) bool {
+	idx := b.indexes[repo]
+	return idx.componentByID[id] != nil || idx.archetypeByID[id] != nil
+}
+
+// ref namespaces an element ID from repo's index, recording a link when
+// it refers to another repository.
+func (b *systemBuilder) ref(repo int, id string) string {
+	if b.has(repo, id) {
+		return b.ns(repo, id)
+	}
+	to, ok := b.resolve(repo, id)
+	link := CrossRepoLink{Repo: b.ws.Repos[repo].ID, Kind: "relationship", Target: id}
+	if ok {
+		link.To = to
+	}
+	b.links = append(b.links, link)
+	return to
+}
+
+// resolve namespaces an element ID from repo's index. IDs the repository
+// does not define are looked up in the others, either written as repo:id
+// or bare when exactly one other repository defines them; ok is false if
+// that fails too, and the ID stays in repo's namespace.
+func (b *systemBuilder) resolve(repo int, id string) (ref string, ok bool) {
+	if b.has(repo, id) {
+		return b.ns(repo, id), true
+	}
+	if other, local, found := strings.Cut(id, systemRef); found {
+		for j, r := range b.ws.Repos {
+			if r.ID == other && b.has(j, local) {
+				return id, true
+			}
+		}
+		return b.ns(repo, id), false
+	}
+	var found []string
+	for j := range b.ws.Repos {
+		if j != repo && b.has(j, id) {
+			found = append(found, b.ns(j, id))
+		}
+	}
+	if len(found) == 1 {
+		return found[0], true
+	}
+	return b.ns(repo, id), false
+}
+
+// linkService adds a relationship from an archetype to the service its
+// target_service names: a component of another repository with that ID or
+// name, or a repository known by that ID or service name.
+func (b *systemBuilder) linkService(repo int, archID, service string, rels *[]schema.Relationship) {
+	link := CrossRepoLink{Repo: b.ws.Repos[repo].ID, From: b.ns(repo, archID), Kind: "target_service", Target: service}
+	name := strings.ToLower(service)
+
+	var found []string
+	for j, idx := range b.indexes {
+		if j == repo {
+			continue
+		}
+		for _, comp := range idx.Raw.Components {
+			if strings.ToLower(comp.ID) == name || strings.ToLower(comp.Name) == name {
+				found = append(found, b.ns(j, comp.ID))
+			}
+		}
+	}
+	if len(found) == 0 {
+		for j, r := range b.ws.Repos {
+			if j == repo || (strings.ToLower(r.ID) != name && !slices.Contains(lowerAll(r.Services), name)) {
+				continue
+			}
+			switch comps := b.indexes[j].Raw.Components; {
+			case r.Component != "" && b.indexes[j].componentByID[r.Component] != nil:
+				found = append(found, b.ns(j, r.Component))
+			case r.Component == "" && len(comps) == 1:
+				found = append(found, b.ns(j, comps[0].ID))
+			}
+		}
+	}
+	if len(found) == 1 {
+		link.To = found[0]
+		*rels = append(*rels, schema.Relationship{
+			From:        link.From,
+			To:          link.To,
+			Type:        "calls",
+			Description: "target_service: " + service,
+		})
+	}
+	b.links = append(b.links, link)
+}
+
+func prefixAll(prefix string, list []string) []string {
+	out := make([]string, len(list))
+	for i, s := range list {
+		out[i] = prefix + strings.TrimPrefix(s, "./")
+	}
+	return out
+}
+
+func lowerAll(list []string) []string {
+	out := make([]string, len(list))
+	for i, s := range list {
+		out[i] = strings.ToLower(s)
+	}
+	return out
+}
+
+// workspaceServer serves each repository's own store under /repos/{id}/
+// and the system index built from all of them at the root.
+type workspaceServer struct {
+	ws     *Workspace
+	stores []*Store
+	buses  []*EventBus
+	system *Store
+	bus    *EventBus
+	// tokens are the repositories' access tokens. Each repository's
+	// endpoints take its own; the root takes any.
+	tokens  []string
+	origins []string // allowed by the repositories' config.json
+
+	mu    sync.Mutex // serialises rebuilds
+	links []CrossRepoLink
+}
+
+func newWorkspaceServer(ws *Workspace) (*workspaceServer, error) {
+	w := &workspaceServer{ws: ws, bus: NewEventBus()}
+	for _, r := range ws.Repos {
+		st, err := OpenStore(r.Index)
+		if err != nil {
+			return nil, fmt.Errorf("loading index of %s: %w", r.ID, err)
+		}
+		token, origins, err := repoAccess(r.Index)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", r.ID, err)
+		}
+		w.stores = append(w.stores, st)
+		w.buses = append(w.buses, NewEventBus())
+		w.tokens = append(w.tokens, token)
+		w.origins = append(w.origins, origins...)
+	}
+	w.system = NewStore(w.build())
+	// Rebuild after edits as well as after changes on disk: an edit
+	// reloads its repository without Watch seeing a change.
+	for _, st := range w.stores {
+		st.OnReload(func(prev, idx *ArchiveIndex) { w.rebuild() })
+	}
+	return w, nil
+}
+
+// build merges the repositories' current indexes.
+func (w *workspaceServer) build() *ArchiveIndex {
+	indexes := make([]*ArchiveIndex, len(w.stores))
+	for i, st := range w.stores {
+		indexes[i] = st.Index()
+	}
+	idx, links := BuildSystemIndex(w.ws, indexes)
+	w.links = links
+	for _, l := range links {
+		if l.To == "" {
+			log.Printf("workspace: %s: unresolved %s %q", l.Repo, l.Kind, l.Target)
+		}
+	}
+	return idx
+}
+
+// rebuild swaps in a new system index after a repository reloaded.
+func (w *workspaceServer) rebuild() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	idx := w.build()
+	PublishReload(w.bus, w.system.Swap(idx), idx)
+}
+
+// watch reloads each repository when its files change; the system index
+// is rebuilt by the stores' OnReload hook.
+func (w *workspaceServer) watch(ctx context.Context, interval time.Duration) {
+	for i, st := range w.stores {
+		bus := w.buses[i]
+		go st.Watch(ctx, interval, func(prev, idx *ArchiveIndex) {
+			PublishReload(bus, prev, idx)
+		})
+	}
+}
+
+func (w *workspaceServer) linkCounts() (resolved, unresolved int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, l := range w.links {
+		if l.To == "" {
+			unresolved++
+		} else {
+			resolved++
+		}
+	}
+	return resolved, unresolved
+}
+
+// routes registers the system index at the root, every repository under
+// /repos/{id}/ with the same endpoints, and GET /repos.
+func (w *workspaceServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	SetupRoutes(mux, w.system, w.bus)
+	mux.HandleFunc("GET /repos", w.handleRepos)
+	for i, r := range w.ws.Repos {
+		repoMux := http.NewServeMux()
+		SetupRoutes(repoMux, w.stores[i], w.buses[i])
+		prefix := "/repos/" + r.ID
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, requireToken(w.tokens[i:i+1], repoMux)))
+	}
+	return requireToken(w.tokens, mux)
+}
+
+// WorkspaceRepoSummary describes one repository in GET /repos.
+type WorkspaceRepoSummary struct {
+	ID            string `json:"id"`
+	Path          string `json:"path"`
+	URL           string `json:"url"`
+	Components    int    `json:"components"`
+	Relationships int    `json:"relationships"`
+	Flows         int    `json:"flows"`
+}
+
+// WorkspaceResponse is the body of GET /repos.
+type WorkspaceResponse struct {
+	Name  string                 `json:"name"`
+	Repos []WorkspaceRepoSummary `json:"repos"`
+	Links []CrossRepoLink        `json:"links"`
+}
+
+func (w *workspaceServer) handleRepos(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	resp := WorkspaceResponse{Name: w.ws.Name, Links: append([]CrossRepoLink{}, w.links...)}
+	w.mu.Unlock()
+	for i, repo := range w.ws.Repos {
+		idx := w.stores[i].Index()
+		resp.Repos = append(resp.Repos, WorkspaceRepoSummary{
+			ID:            repo.ID,
+			Path:          repo.Path,
+			URL:           "/repos/" + repo.ID + "/",
+			Components:    len(idx.Raw.Components),
+			Relationships: len(idx.Raw.Relationships),
+			Flows:         len(idx.Raw.Flows),
+		})
+	}
+	writeJSON(rw, http.StatusOK, resp)
+}
@@ -49,6 +49,27 @@ function M.base_url_for(bufnr, override)
   return "http://127.0.0.1:" .. p
 end
 
+--- Read the access token the server for this buffer's project requires for
+--- event streams and anything but GET. It is read on each call, as the
+--- server generates it on first start.
+--- @param bufnr number
+--- @param override string|nil If set, return this token instead of reading it
+--- @return string|nil token
+function M.token_for(bufnr, override)
+  if override and override ~= "" then
+    return override
+  end
+  local root = M.find_project_root(bufnr)
+  if not root then
+    return nil
+  end
+  local ok, lines = pcall(vim.fn.readfile, root .. "/.canopy/token", "", 1)
+  if not ok or not lines[1] or lines[1] == "" then
+    return nil
+  end
+  return vim.trim(lines[1])
+end
+
 --- Compute the relative path the server expects (forward slashes, no leading ./ or /).
 --- @param bufnr number
 --- @return string|nil relpath
@@ -98,6 +119,55 @@ function M.get(bufnr, base_url)
   return data
 end
 
+--- Fetch context for a buffer and every other listed buffer of its project
+--- that is not cached yet, in one request. Falls back to fetching just the
+--- buffer from servers without /context/batch.
+--- @param bufnr number
+--- @param base_url string
+--- @param token string|nil Access token
+function M.prefetch(bufnr, base_url, token)
+  local root = M.find_project_root(bufnr)
+  local bufs, files = {}, {}
+  for _, b in ipairs(vim.api.nvim_list_bufs()) do
+    if (b == bufnr or vim.bo[b].buflisted) and M.find_project_root(b) == root then
+      local rel = M.relative_path(b)
+      local cached = buf_cache[b]
+      if rel and not (cached and cached.path == rel) then
+        table.insert(bufs, { bufnr = b, path = rel })
+        table.insert(files, rel)
+      end
+    end
+  end
+  if #files == 0 then
+    return
+  end
+
+  local data = client.post(base_url .. "/context/batch", { files = files }, token)
+  if not data or not data.results then
+    M.get(bufnr, base_url)
+    return
+  end
+  for i, result in ipairs(data.results) do
+    if bufs[i] then
+      buf_cache[bufs[i].bufnr] = { path = bufs[i].path, context = result.context }
+    end
+  end
+end
+
+--- Get context at a line of a buffer, picking the archetype whose symbol
+--- encloses it when several share the file. Not cached.
+--- @param bufnr number
+--- @param base_url string
+--- @param line number 1-based
+--- @return table|nil context
+function M.get_at(bufnr, base_url, line)
+  local rel = M.relative_path(bufnr)
+  if not rel then
+    return nil
+  end
+  return client.get(base_url .. "/context?file=" .. vim.uri_encode(rel, "rfc2396") .. "&line=" .. line)
+end
+
 --- Invalidate cache for a buffer (called on BufEnter when file changes).
 --- @param bufnr number
 function M.invalidate(bufnr)
@@ -108,6 +178,12 @@ function M.invalidate(bufnr)
   end
 end
 
+--- Drop every cached response, e.g. after the server reloads its index.
+function M.clear()
+  buf_cache = {}
+  archetype_files = nil
+end
+
 --- Get the archetype_id → file_path map, fetching /graph once per session.
 --- @param base_url string
 --- @return table<string, string> map
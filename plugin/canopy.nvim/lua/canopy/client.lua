@@ -1,5 +1,17 @@
 local M = {}
 
+--- Add the header carrying the server's access token to a curl command.
+--- @param cmd string[] curl command line
+--- @param token string|nil Access token; nil sends none
+--- @return string[] cmd
+local function with_token(cmd, token)
+  if token and token ~= "" then
+    table.insert(cmd, 2, "Authorization: Bearer " .. token)
+    table.insert(cmd, 2, "-H")
+  end
+  return cmd
+end
+
 --- Perform a synchronous GET request to the canopy server.
 --- @param url string Full URL to fetch
 --- @return table|nil data Parsed JSON response
@@ -18,10 +30,66 @@ function M.get(url)
   return decoded, nil
 end
 
+--- Perform a synchronous POST request with a JSON body.
+--- @param url string Full URL to post to
+--- @param body table Encoded as JSON
+--- @param token string|nil Access token
+--- @return table|nil data Parsed JSON response
+--- @return string|nil err Error message on failure
+function M.post(url, body, token)
+  local output = vim.fn.system(with_token({
+    "curl", "-s", "--max-time", "2", "-X", "POST",
+    "-H", "Content-Type: application/json", "--data-binary", "@-", url,
+  }, token), vim.json.encode(body))
+  if vim.v.shell_error ~= 0 then
+    return nil, "curl failed (exit " .. vim.v.shell_error .. "): " .. output
+  end
+
+  local ok, decoded = pcall(vim.json.decode, output)
+  if not ok then
+    return nil, "JSON decode failed: " .. tostring(decoded)
+  end
+
+  return decoded, nil
+end
+
 --- Fire-and-forget async PUT request (non-blocking).
 --- @param url string Full URL to PUT
-function M.put_async(url)
-  vim.fn.jobstart({ "curl", "-s", "-X", "PUT", "--max-time", "1", url }, { detach = true })
+--- @param token string|nil Access token
+function M.put_async(url, token)
+  vim.fn.jobstart(with_token({ "curl", "-s", "-X", "PUT", "--max-time", "1", url }, token), { detach = true })
+end
+
+--- Follow a server-sent event stream in the background.
+--- @param url string Full URL of the stream
+--- @param on_event fun(event: string, data: string) Called per event; unnamed events are "message"
+--- @param on_exit fun()|nil Called when the connection ends
+--- @param token string|nil Access token
+--- @return number job_id
+function M.stream(url, on_event, on_exit, token)
+  local event = "message"
+  local partial = ""
+  return vim.fn.jobstart(with_token({ "curl", "-s", "-N", url }, token), {
+    on_stdout = function(_, lines)
+      -- The last element is an unfinished line, completed by the next chunk.
+      lines[1] = partial .. lines[1]
+      partial = table.remove(lines)
+      for _, line in ipairs(lines) do
+        line = line:gsub("\r$", "")
+        if line:sub(1, 6) == "event:" then
+          event = vim.trim(line:sub(7))
+        elseif line:sub(1, 5) == "data:" then
+          on_event(event, vim.trim(line:sub(6)))
+          event = "message"
+        end
+      end
+    end,
+    on_exit = function()
+      if on_exit then
+        on_exit()
+      end
+    end,
+  })
 end
 
 return M
@@ -7,11 +7,35 @@ local M = {}
 M.config = {
   base_url = nil, -- nil = auto-detect per repo; set to override for all repos
   binary = "canopy", -- path to canopy binary (if not on PATH, use absolute path)
+  token = nil, -- nil = read each repo's .canopy/token; set to override with base_url
 }
 
 local setup_done = false
 local last_cursor_file = nil
 
+-- Event streams being followed: base_url → job_id
+local streams = {}
+
+--- Follow the server's event stream so cached context is dropped when the
+--- index is reloaded. A failed or closed stream is retried on the next BufEnter.
+--- @param url string
+--- @param token string|nil Access token the stream requires
+local function watch(url, token)
+  if streams[url] then
+    return
+  end
+  local job = client.stream(url .. "/events?topics=index-reloaded", function(event)
+    if event == "index-reloaded" then
+      cache.clear()
+    end
+  end, function()
+    streams[url] = nil
+  end, token)
+  if job > 0 then
+    streams[url] = job
+  end
+end
+
 --- Merge user options and register commands + autocmds.
 --- @param opts table|nil
 function M.setup(opts)
@@ -40,13 +64,13 @@ function M.setup(opts)
       vim.notify("canopy: not in a canopy project", vim.log.levels.WARN)
       return
     end
-    local ctx = cache.get(bufnr, url)
+    local ctx = cache.get_at(bufnr, url, vim.api.nvim_win_get_cursor(0)[1])
     if not ctx then
       vim.notify("canopy: no context for this file", vim.log.levels.WARN)
       return
     end
     require("canopy.ui").show_context(ctx)
-  end, { desc = "Show architectural context for current file" })
+  end, { desc = "Show architectural context for current file and line" })
 
   vim.api.nvim_create_user_command("CanopyFlow", function()
     local bufnr = vim.api.nvim_get_current_buf()
@@ -120,15 +144,17 @@ function M.setup(opts)
         return
       end
 
+      local token = cache.token_for(ev.buf, M.config.token)
+      watch(url, token)
       cache.invalidate(ev.buf)
-      -- Prefetch silently (ignore errors)
-      cache.get(ev.buf, url)
+      -- Prefetch silently (ignore errors), for all open buffers at once
+      cache.prefetch(ev.buf, url, token)
 
       -- Report cursor position to server for live web UI sync
       local rel = cache.relative_path(ev.buf)
       if rel and rel ~= last_cursor_file then
         last_cursor_file = rel
-        client.put_async(url .. "/cursor?file=" .. vim.uri_encode(rel, "rfc2396"))
+        client.put_async(url .. "/cursor?file=" .. vim.uri_encode(rel, "rfc2396"), token)
       end
     end,
   })
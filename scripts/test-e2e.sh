@@ -29,6 +29,9 @@ echo "3. Testing import..."
 $BINARY import --force "$GOLDEN"
 [ -f .canopy/index.json ] || { echo "FAIL: index.json not created"; exit 1; }
 echo "   PASS: imported golden fixture"
+[ -n "$(ls .canopy/history)" ] || { echo "FAIL: import did not snapshot history"; exit 1; }
+$BINARY history list | grep -q "golden" || { echo "FAIL: history list"; exit 1; }
+echo "   PASS: import snapshotted into history"
 
 # Validate
 echo "4. Testing validate..."
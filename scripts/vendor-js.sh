@@ -0,0 +1,20 @@
+#!/usr/bin/env bash
+# Download the web UI's JavaScript libraries into internal/server/web/vendor
+# so they are embedded in the binary. Versions are pinned in
+# VendorScripts (internal/server/ui.go); keep the two lists in sync.
+set -euo pipefail
+
+SCRIPT_DIR="$(cd "$(dirname "$0")" && pwd)"
+VENDOR_DIR="$SCRIPT_DIR/../internal/server/web/vendor"
+
+fetch() {
+	local file="$1" url="$2"
+	echo "Fetching $url"
+	curl -fsSL "$url" -o "$VENDOR_DIR/$file.tmp"
+	mv "$VENDOR_DIR/$file.tmp" "$VENDOR_DIR/$file"
+}
+
+mkdir -p "$VENDOR_DIR"
+fetch cytoscape.min.js https://unpkg.com/cytoscape@3.28.1/dist/cytoscape.min.js
+fetch dagre.min.js https://unpkg.com/dagre@0.8.5/dist/dagre.min.js
+fetch cytoscape-dagre.js https://unpkg.com/cytoscape-dagre@2.5.0/cytoscape-dagre.js